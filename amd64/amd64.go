@@ -0,0 +1,522 @@
+// Package amd64 emits GNU assembler code for x86-64 Linux from the IR.
+// Functions follow the System V calling convention, so they can be
+// called from C, and a program with main can be linked with ld alone.
+package amd64
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/rabierre/compiler/ir"
+)
+
+var (
+	intArgs    = []string{"%edi", "%esi", "%edx", "%ecx", "%r8d", "%r9d"}
+	doubleArgs = []string{"%xmm0", "%xmm1", "%xmm2", "%xmm3", "%xmm4", "%xmm5", "%xmm6", "%xmm7"}
+)
+
+// Emit returns assembly of constants and functions of prog. Live
+// intervals of each function are dumped to intervals if not nil.
+func Emit(prog *ir.Program, intervals io.Writer) string {
+	var buf bytes.Buffer
+	if len(prog.Consts) > 0 {
+		buf.WriteString("\t.section .rodata\n")
+		for _, c := range prog.Consts {
+			emitConst(&buf, c)
+		}
+	}
+
+	buf.WriteString("\t.text\n")
+	for _, f := range prog.Funcs {
+		g := &gen{buf: &buf, f: f, alloc: Allocate(f)}
+		if intervals != nil {
+			io.WriteString(intervals, g.alloc.String())
+		}
+		g.function()
+		if f.Name == "main" {
+			emitStart(&buf, f)
+		}
+	}
+	// Stack is not executable
+	buf.WriteString("\t.section .note.GNU-stack,\"\",@progbits\n")
+	return buf.String()
+}
+
+func emitConst(buf *bytes.Buffer, c *ir.Constant) {
+	fmt.Fprintf(buf, "\t.globl %s\n", c.Name)
+	if c.Value.Typ == ir.Double {
+		fmt.Fprintf(buf, "\t.p2align 3\n%s:\n\t.quad %#x\n", c.Name, math.Float64bits(c.Value.F))
+		return
+	}
+	fmt.Fprintf(buf, "\t.p2align 2\n%s:\n\t.long %d\n", c.Name, c.Value.I)
+}
+
+// emitStart makes the process entry call main and exit with its result
+func emitStart(buf *bytes.Buffer, main *ir.Func) {
+	buf.WriteString("\t.globl _start\n_start:\n")
+	buf.WriteString("\txorl %ebp, %ebp\n")
+	buf.WriteString("\tcall main\n")
+	if main.Result == ir.Int {
+		buf.WriteString("\tmovl %eax, %edi\n")
+	} else {
+		buf.WriteString("\txorl %edi, %edi\n")
+	}
+	buf.WriteString("\tmovl $60, %eax\n")
+	buf.WriteString("\tsyscall\n")
+}
+
+type gen struct {
+	buf   *bytes.Buffer
+	f     *ir.Func
+	alloc *Allocation
+}
+
+func (g *gen) emit(format string, args ...interface{}) {
+	g.buf.WriteByte('\t')
+	fmt.Fprintf(g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+func (g *gen) label(b *ir.Block) string {
+	return ".L" + g.f.Name + "." + b.Name()
+}
+
+func (g *gen) function() {
+	fmt.Fprintf(g.buf, "\t.globl %s\n\t.type %s, @function\n%s:\n", g.f.Name, g.f.Name, g.f.Name)
+	g.emit("pushq %%rbp")
+	g.emit("movq %%rsp, %%rbp")
+	if g.alloc.Frame > 0 {
+		g.emit("subq $%d, %%rsp", g.alloc.Frame)
+	}
+	for k, reg := range g.alloc.Saved {
+		g.emit("movq %s, %d(%%rbp)", wide[reg], -8*(k+1))
+	}
+	g.params()
+
+	labels := jumpTargets(g.f)
+	for i, b := range g.f.Blocks {
+		var next *ir.Block
+		if i+1 < len(g.f.Blocks) {
+			next = g.f.Blocks[i+1]
+		}
+		if labels[b] {
+			fmt.Fprintf(g.buf, "%s:\n", g.label(b))
+		}
+		for _, instr := range b.Instrs {
+			g.instr(instr, next)
+		}
+	}
+	fmt.Fprintf(g.buf, "\t.size %s, .-%s\n", g.f.Name, g.f.Name)
+}
+
+// params moves parameters from where the caller passed them to their
+// locations
+func (g *gen) params() {
+	var srcs, dsts []string
+	var types []ir.Type
+	var stack []*ir.Var
+	ints, doubles := 0, 0
+	for _, p := range g.f.Params {
+		src := ""
+		switch {
+		case p.Typ == ir.Double && doubles < len(doubleArgs):
+			src = doubleArgs[doubles]
+			doubles++
+		case p.Typ == ir.Int && ints < len(intArgs):
+			src = intArgs[ints]
+			ints++
+		default:
+			stack = append(stack, p)
+			continue
+		}
+		if dst := g.loc(p); dst != src {
+			srcs = append(srcs, src)
+			dsts = append(dsts, dst)
+			types = append(types, p.Typ)
+		}
+	}
+	g.parallelMove(srcs, dsts, types)
+
+	// Spilled stack parameters stay where the caller put them
+	for k, p := range stack {
+		if dst := g.loc(p); isReg(dst) {
+			g.emit("%s %d(%%rbp), %s", move(p.Typ), 16+8*k, dst)
+		}
+	}
+}
+
+// parallelMove copies registers srcs to dsts as if all at once. Moves
+// are done in order unless a destination is the source of another
+// move, then all sources go through the stack.
+func (g *gen) parallelMove(srcs, dsts []string, types []ir.Type) {
+	if !overlaps(srcs, dsts) {
+		for j := range srcs {
+			g.emit("%s %s, %s", move(types[j]), srcs[j], dsts[j])
+		}
+		return
+	}
+	for j, src := range srcs {
+		g.push(src, types[j])
+	}
+	for j := len(srcs) - 1; j >= 0; j-- {
+		reg := result(types[j])
+		g.pop(reg, types[j])
+		g.moveTo(reg, dsts[j], types[j])
+	}
+}
+
+// overlaps reports whether a destination is the source of another move
+func overlaps(srcs, dsts []string) bool {
+	for j, dst := range dsts {
+		for k, src := range srcs {
+			if j != k && dst == src {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *gen) push(reg string, typ ir.Type) {
+	if typ == ir.Double {
+		g.emit("subq $8, %%rsp")
+		g.emit("movsd %s, (%%rsp)", reg)
+	} else {
+		g.emit("pushq %s", wide[reg])
+	}
+}
+
+func (g *gen) pop(reg string, typ ir.Type) {
+	if typ == ir.Double {
+		g.emit("movsd (%%rsp), %s", reg)
+		g.emit("addq $8, %%rsp")
+	} else {
+		g.emit("popq %s", wide[reg])
+	}
+}
+
+// loc returns the register or memory operand of v
+func (g *gen) loc(v ir.Value) string {
+	if gl, ok := v.(*ir.Global); ok {
+		return gl.Name + "(%rip)"
+	}
+	loc, ok := g.alloc.Location(v)
+	if !ok {
+		panic(fmt.Sprintf("No location for %s in %s", v, g.f.Name))
+	}
+	return loc
+}
+
+// operand returns v as a source operand of an int instruction
+func (g *gen) operand(v ir.Value) string {
+	if c, ok := v.(*ir.Const); ok {
+		return fmt.Sprintf("$%d", c.I)
+	}
+	return g.loc(v)
+}
+
+func isReg(loc string) bool {
+	return strings.HasPrefix(loc, "%")
+}
+
+// move instruction of a type
+func move(typ ir.Type) string {
+	if typ == ir.Double {
+		return "movsd"
+	}
+	return "movl"
+}
+
+// load puts v in reg, a 32 bit register for ints or an xmm register
+func (g *gen) load(v ir.Value, reg string) {
+	c, isConst := v.(*ir.Const)
+	switch {
+	case isConst && c.Typ == ir.Double:
+		g.emit("movabsq $%#x, %%r11", math.Float64bits(c.F))
+		g.emit("movq %%r11, %s", reg)
+	case isConst:
+		g.emit("movl $%d, %s", c.I, reg)
+	default:
+		if loc := g.loc(v); loc != reg {
+			g.emit("%s %s, %s", move(v.Type()), loc, reg)
+		}
+	}
+}
+
+func (g *gen) store(reg string, v ir.Value) {
+	g.moveTo(reg, g.loc(v), v.Type())
+}
+
+// moveTo moves reg to loc, unless it is there already
+func (g *gen) moveTo(reg, loc string, typ ir.Type) {
+	if loc != reg {
+		g.emit("%s %s, %s", move(typ), reg, loc)
+	}
+}
+
+// result register of a type
+func result(typ ir.Type) string {
+	if typ == ir.Double {
+		return "%xmm0"
+	}
+	return "%eax"
+}
+
+var intOps = map[ir.Op]string{ir.Add: "addl", ir.Sub: "subl", ir.Mul: "imull"}
+var doubleOps = map[ir.Op]string{ir.Add: "addsd", ir.Sub: "subsd", ir.Mul: "mulsd", ir.Div: "divsd"}
+var intSets = map[ir.Op]string{ir.Eq: "sete", ir.Ne: "setne", ir.Lt: "setl", ir.Le: "setle", ir.Gt: "setg", ir.Ge: "setge"}
+
+func (g *gen) instr(instr ir.Instr, next *ir.Block) {
+	switch i := instr.(type) {
+	case *ir.Copy:
+		if dst := g.loc(i.Dst); isReg(dst) {
+			g.load(i.Src, dst)
+			break
+		}
+		reg := result(i.Dst.Type())
+		g.load(i.Src, reg)
+		g.store(reg, i.Dst)
+	case *ir.BinOp:
+		if i.X.Type() == ir.Double {
+			g.doubleBinOp(i)
+		} else {
+			g.intBinOp(i)
+		}
+	case *ir.UnOp:
+		if i.X.Type() == ir.Double {
+			// Flip the sign bit, so -0.0 comes out right
+			g.load(i.X, "%xmm0")
+			g.emit("movq %%xmm0, %%rax")
+			g.emit("btcq $63, %%rax")
+			g.emit("movq %%rax, %%xmm0")
+		} else {
+			g.load(i.X, "%eax")
+			g.emit("negl %%eax")
+		}
+		g.store(result(i.Dst.Type()), i.Dst)
+	case *ir.Convert:
+		switch {
+		case i.X.Type() == i.Dst.Type():
+			g.load(i.X, result(i.Dst.Type()))
+		case i.Dst.Type() == ir.Double:
+			g.load(i.X, "%eax")
+			g.emit("cvtsi2sdl %%eax, %%xmm0")
+		default:
+			g.load(i.X, "%xmm0")
+			g.emit("cvttsd2si %%xmm0, %%eax")
+		}
+		g.store(result(i.Dst.Type()), i.Dst)
+	case *ir.Call:
+		g.call(i)
+	case *ir.Jump:
+		if i.Target != next {
+			g.emit("jmp %s", g.label(i.Target))
+		}
+	case *ir.Branch:
+		reg := "%eax"
+		if _, ok := i.Cond.(*ir.Const); ok {
+			g.load(i.Cond, reg)
+		} else if loc := g.loc(i.Cond); isReg(loc) {
+			reg = loc
+		} else {
+			g.load(i.Cond, reg)
+		}
+		g.emit("testl %s, %s", reg, reg)
+		switch {
+		case i.Else == next:
+			g.emit("jne %s", g.label(i.Then))
+		case i.Then == next:
+			g.emit("je %s", g.label(i.Else))
+		default:
+			g.emit("jne %s", g.label(i.Then))
+			g.emit("jmp %s", g.label(i.Else))
+		}
+	case *ir.Return:
+		if i.Value != nil {
+			g.load(i.Value, result(i.Value.Type()))
+		}
+		for k, reg := range g.alloc.Saved {
+			g.emit("movq %d(%%rbp), %s", -8*(k+1), wide[reg])
+		}
+		g.emit("leave")
+		g.emit("ret")
+	default:
+		panic(fmt.Sprintf("Unexpected instruction: %s", instr))
+	}
+}
+
+func (g *gen) intBinOp(i *ir.BinOp) {
+	// Add, sub and mul compute in a register destination, unless it
+	// holds y
+	if op, ok := intOps[i.Op]; ok {
+		if dst := g.loc(i.Dst); isReg(dst) && g.operand(i.Y) != dst {
+			g.load(i.X, dst)
+			g.emit("%s %s, %s", op, g.operand(i.Y), dst)
+			return
+		}
+	}
+
+	g.load(i.X, "%eax")
+	g.load(i.Y, "%ecx")
+	switch {
+	case i.Op == ir.Div:
+		g.emit("cltd")
+		g.emit("idivl %%ecx")
+	case i.Op.IsCompare():
+		g.emit("cmpl %%ecx, %%eax")
+		g.emit("%s %%al", intSets[i.Op])
+		g.emit("movzbl %%al, %%eax")
+	default:
+		g.emit("%s %%ecx, %%eax", intOps[i.Op])
+	}
+	g.store("%eax", i.Dst)
+}
+
+func (g *gen) doubleBinOp(i *ir.BinOp) {
+	g.load(i.X, "%xmm0")
+	g.load(i.Y, "%xmm1")
+	if !i.Op.IsCompare() {
+		g.emit("%s %%xmm1, %%xmm0", doubleOps[i.Op])
+		g.store("%xmm0", i.Dst)
+		return
+	}
+
+	// ucomisd sets flags like an unsigned compare, and parity if
+	// either operand is NaN, for which only != is true
+	if i.Op == ir.Lt || i.Op == ir.Le {
+		g.emit("ucomisd %%xmm0, %%xmm1")
+	} else {
+		g.emit("ucomisd %%xmm1, %%xmm0")
+	}
+	switch i.Op {
+	case ir.Gt, ir.Lt:
+		g.emit("seta %%al")
+	case ir.Ge, ir.Le:
+		g.emit("setae %%al")
+	case ir.Eq:
+		g.emit("sete %%al")
+		g.emit("setnp %%cl")
+		g.emit("andb %%cl, %%al")
+	case ir.Ne:
+		g.emit("setne %%al")
+		g.emit("setp %%cl")
+		g.emit("orb %%cl, %%al")
+	}
+	g.emit("movzbl %%al, %%eax")
+	g.store("%eax", i.Dst)
+}
+
+// call passes the first six ints and eight doubles in registers and
+// the rest on the stack, pushed right to left. The stack stays 16 byte
+// aligned at the call.
+func (g *gen) call(i *ir.Call) {
+	var regs []string
+	var stack []ir.Value
+	ints, doubles := 0, 0
+	for _, arg := range i.Args {
+		switch {
+		case arg.Type() == ir.Double && doubles < len(doubleArgs):
+			regs = append(regs, doubleArgs[doubles])
+			doubles++
+		case arg.Type() == ir.Int && ints < len(intArgs):
+			regs = append(regs, intArgs[ints])
+			ints++
+		default:
+			regs = append(regs, "")
+			stack = append(stack, arg)
+		}
+	}
+
+	size := 8 * len(stack)
+	if len(stack)%2 == 1 {
+		g.emit("subq $8, %%rsp")
+		size += 8
+	}
+	for j := len(stack) - 1; j >= 0; j-- {
+		if stack[j].Type() == ir.Double {
+			g.load(stack[j], "%xmm0")
+			g.emit("subq $8, %%rsp")
+			g.emit("movsd %%xmm0, (%%rsp)")
+		} else {
+			g.load(stack[j], "%eax")
+			g.emit("pushq %%rax")
+		}
+	}
+	g.argRegs(i.Args, regs)
+
+	g.emit("call %s", i.Func)
+	if size > 0 {
+		g.emit("addq $%d, %%rsp", size)
+	}
+	if i.Dst != nil {
+		g.store(result(i.Dst.Type()), i.Dst)
+	}
+}
+
+// argRegs loads args to their registers regs, empty for arguments on
+// the stack. If an argument lives in the register of another, all of
+// them go through the stack.
+func (g *gen) argRegs(args []ir.Value, regs []string) {
+	var srcs, dsts []string
+	var values []ir.Value
+	for j, arg := range args {
+		if regs[j] == "" {
+			continue
+		}
+		src := ""
+		if _, ok := arg.(*ir.Const); !ok {
+			src = g.loc(arg)
+		}
+		srcs = append(srcs, src)
+		dsts = append(dsts, regs[j])
+		values = append(values, arg)
+	}
+
+	if !overlaps(srcs, dsts) {
+		for j, arg := range values {
+			g.load(arg, dsts[j])
+		}
+		return
+	}
+	for _, arg := range values {
+		reg := result(arg.Type())
+		g.load(arg, reg)
+		g.push(reg, arg.Type())
+	}
+	for j := len(values) - 1; j >= 0; j-- {
+		g.pop(dsts[j], values[j].Type())
+	}
+}
+
+// jumpTargets returns blocks an emitted jump goes to, others are only
+// entered by falling through and need no label
+func jumpTargets(f *ir.Func) map[*ir.Block]bool {
+	labels := map[*ir.Block]bool{}
+	for i, b := range f.Blocks {
+		var next *ir.Block
+		if i+1 < len(f.Blocks) {
+			next = f.Blocks[i+1]
+		}
+
+		switch t := b.Terminator().(type) {
+		case *ir.Jump:
+			if t.Target != next {
+				labels[t.Target] = true
+			}
+		case *ir.Branch:
+			switch {
+			case t.Else == next:
+				labels[t.Then] = true
+			case t.Then == next:
+				labels[t.Else] = true
+			default:
+				labels[t.Then] = true
+				labels[t.Else] = true
+			}
+		}
+	}
+	return labels
+}
@@ -0,0 +1,51 @@
+package amd64
+
+import (
+	"testing"
+
+	"github.com/rabierre/compiler/ir"
+	"github.com/stretchr/testify/assert"
+)
+
+// inc builds func inc(int a) int { a = a + 1 return a }
+func inc() *ir.Func {
+	a := &ir.Var{Name: "a", Typ: ir.Int}
+	f := &ir.Func{Name: "inc", Result: ir.Int, Params: []*ir.Var{a}}
+	b := f.NewBlock()
+	b.Instrs = []ir.Instr{
+		&ir.BinOp{Dst: a, Op: ir.Add, X: a, Y: ir.IntConst(1)},
+		&ir.Return{Value: a},
+	}
+	f.ComputeCFG()
+	return f
+}
+
+func TestEmit(t *testing.T) {
+	prog := &ir.Program{
+		Funcs:  []*ir.Func{inc()},
+		Consts: []*ir.Constant{{Name: "N", Value: ir.IntConst(10)}, {Name: "PI", Value: ir.DoubleConst(0.5)}},
+	}
+	expect := "\t.section .rodata\n" +
+		"\t.globl N\n\t.p2align 2\nN:\n\t.long 10\n" +
+		"\t.globl PI\n\t.p2align 3\nPI:\n\t.quad 0x3fe0000000000000\n" +
+		"\t.text\n" +
+		"\t.globl inc\n\t.type inc, @function\ninc:\n" +
+		"\tpushq %rbp\n" +
+		"\tmovq %rsp, %rbp\n" +
+		"\taddl $1, %edi\n" +
+		"\tmovl %edi, %eax\n" +
+		"\tleave\n" +
+		"\tret\n" +
+		"\t.size inc, .-inc\n" +
+		"\t.section .note.GNU-stack,\"\",@progbits\n"
+	assert.Equal(t, expect, Emit(prog, nil))
+}
+
+func TestStart(t *testing.T) {
+	f := &ir.Func{Name: "main", Result: ir.Int}
+	f.NewBlock().Instrs = []ir.Instr{&ir.Return{Value: ir.IntConst(3)}}
+	f.ComputeCFG()
+
+	out := Emit(&ir.Program{Funcs: []*ir.Func{f}}, nil)
+	assert.Contains(t, out, "_start:\n\txorl %ebp, %ebp\n\tcall main\n\tmovl %eax, %edi\n\tmovl $60, %eax\n\tsyscall\n")
+}
@@ -0,0 +1,326 @@
+package amd64
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/rabierre/compiler/ir"
+)
+
+// Registers given to values. %eax, %ecx, %edx, %r11, %xmm0 and %xmm1
+// are left out, instructions use them as scratch registers to compute
+// in and to load spilled operands.
+var (
+	callerSaved = []string{"%esi", "%edi", "%r8d", "%r9d", "%r10d"}
+	calleeSaved = []string{"%ebx", "%r12d", "%r13d", "%r14d", "%r15d"}
+	sseRegs     = []string{"%xmm2", "%xmm3", "%xmm4", "%xmm5", "%xmm6", "%xmm7",
+		"%xmm8", "%xmm9", "%xmm10", "%xmm11", "%xmm12", "%xmm13", "%xmm14", "%xmm15"}
+
+	intRegs = append(append([]string{}, callerSaved...), calleeSaved...)
+)
+
+// 64 bit names, to save registers and to push arguments
+var wide = map[string]string{
+	"%eax": "%rax", "%ebx": "%rbx", "%ecx": "%rcx", "%edx": "%rdx",
+	"%esi": "%rsi", "%edi": "%rdi", "%r8d": "%r8", "%r9d": "%r9", "%r10d": "%r10",
+	"%r12d": "%r12", "%r13d": "%r13", "%r14d": "%r14", "%r15d": "%r15",
+}
+
+// Interval is the range of positions a value is live in. The n-th
+// instruction in block order reads its operands at 2n and writes its
+// result at 2n+1, from n = 1. Parameters are defined at 0.
+// A value live in a part of a loop is live over the whole loop, as
+// every block between the first and last position is covered.
+type Interval struct {
+	Value      ir.Value
+	Start, End int
+	Calls      bool // a call is made strictly inside, clobbering caller-saved registers
+
+	Reg  string // register, empty if spilled
+	Slot int    // offset from %rbp if spilled
+
+	hint string // register taken if free, where a parameter is passed
+}
+
+func (iv *Interval) String() string {
+	loc := iv.Reg
+	if loc == "" {
+		loc = fmt.Sprintf("spill %d(%%rbp)", iv.Slot)
+	}
+	calls := ""
+	if iv.Calls {
+		calls = " calls"
+	}
+	return fmt.Sprintf("%s %s [%d, %d]%s %s", iv.Value.Type(), iv.Value, iv.Start, iv.End, calls, loc)
+}
+
+// Allocation is where values of a function live
+type Allocation struct {
+	Func      *ir.Func
+	Intervals []*Interval // by start
+	Saved     []string    // callee-saved registers used, to restore on return
+	Frame     int         // bytes below %rbp for spills and saved registers
+
+	byValue map[ir.Value]*Interval
+}
+
+// String dumps intervals in order of start
+func (a *Allocation) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "intervals %s:\n", a.Func.Name)
+	for _, iv := range a.Intervals {
+		fmt.Fprintf(&buf, "\t%s\n", iv)
+	}
+	return buf.String()
+}
+
+// Allocate assigns registers to values of f by linear scan of Poletto
+// and Sarkar. Values live across a call only get callee-saved registers,
+// there is none for doubles. When registers run out, the value whose
+// interval ends last is spilled to the stack.
+func Allocate(f *ir.Func) *Allocation {
+	a := &Allocation{Func: f, byValue: map[ir.Value]*Interval{}}
+	calls := a.buildIntervals(ir.ComputeLiveness(f))
+	for _, iv := range a.Intervals {
+		for _, c := range calls {
+			if iv.Start < c && c < iv.End {
+				iv.Calls = true
+				break
+			}
+		}
+	}
+	ints, doubles := 0, 0
+	for _, p := range f.Params {
+		iv := a.byValue[p]
+		if p.Typ == ir.Double && doubles < len(doubleArgs) {
+			iv.hint = doubleArgs[doubles]
+			doubles++
+		} else if p.Typ == ir.Int && ints < len(intArgs) {
+			iv.hint = intArgs[ints]
+			ints++
+		}
+	}
+	sort.SliceStable(a.Intervals, func(i, j int) bool {
+		return a.Intervals[i].Start < a.Intervals[j].Start
+	})
+
+	var intIntervals, doubleIntervals []*Interval
+	for _, iv := range a.Intervals {
+		if iv.Value.Type() == ir.Double {
+			doubleIntervals = append(doubleIntervals, iv)
+		} else {
+			intIntervals = append(intIntervals, iv)
+		}
+	}
+	saved := map[string]bool{}
+	a.scan(intIntervals, func(iv *Interval) []string {
+		if iv.Calls {
+			return calleeSaved
+		}
+		return intRegs
+	}, saved)
+	a.scan(doubleIntervals, func(iv *Interval) []string {
+		if iv.Calls {
+			return nil
+		}
+		return sseRegs
+	}, saved)
+
+	for _, reg := range calleeSaved {
+		if saved[reg] {
+			a.Saved = append(a.Saved, reg)
+		}
+	}
+	a.layout()
+	return a
+}
+
+// buildIntervals numbers instructions and covers each block a value is
+// live in. Returns positions of calls.
+func (a *Allocation) buildIntervals(live *ir.Liveness) []int {
+	// Intervals are made in order of appearance first, so that the
+	// allocation doesn't depend on the order of liveness sets
+	add := func(v ir.Value) {
+		switch v.(type) {
+		case *ir.Var, *ir.Temp:
+			if a.byValue[v] == nil {
+				iv := &Interval{Value: v, Start: -1}
+				a.byValue[v] = iv
+				a.Intervals = append(a.Intervals, iv)
+			}
+		}
+	}
+	for _, p := range a.Func.Params {
+		add(p)
+	}
+	for _, b := range a.Func.Blocks {
+		for _, instr := range b.Instrs {
+			for _, use := range ir.Uses(instr) {
+				add(*use)
+			}
+			if d := ir.Defs(instr); d != nil {
+				add(d)
+			}
+		}
+	}
+
+	cover := func(v ir.Value, pos int) {
+		iv := a.byValue[v]
+		if iv == nil {
+			return
+		}
+		if iv.Start < 0 || pos < iv.Start {
+			iv.Start = pos
+		}
+		if pos > iv.End {
+			iv.End = pos
+		}
+	}
+	for _, p := range a.Func.Params {
+		cover(p, 0)
+	}
+	var calls []int
+	pos := 2
+	for _, b := range a.Func.Blocks {
+		for v := range live.In[b] {
+			cover(v, pos)
+		}
+		for _, instr := range b.Instrs {
+			for _, use := range ir.Uses(instr) {
+				cover(*use, pos)
+			}
+			if d := ir.Defs(instr); d != nil {
+				cover(d, pos+1)
+			}
+			if _, ok := instr.(*ir.Call); ok {
+				calls = append(calls, pos)
+			}
+			pos += 2
+		}
+		for v := range live.Out[b] {
+			cover(v, pos-1)
+		}
+	}
+	return calls
+}
+
+// scan allocates intervals of one register class, sorted by start.
+// pool gives registers an interval may take.
+func (a *Allocation) scan(intervals []*Interval, pool func(*Interval) []string, saved map[string]bool) {
+	var active []*Interval // sorted by end
+	used := map[string]bool{}
+
+	for _, iv := range intervals {
+		// Operands are read before the result is written, so an operand
+		// dying in the instruction defining iv frees its register for it
+		n := 0
+		for _, x := range active {
+			if x.End >= iv.Start {
+				active[n] = x
+				n++
+			} else {
+				used[x.Reg] = false
+			}
+		}
+		active = active[:n]
+
+		regs := pool(iv)
+		if containsReg(regs, iv.hint) && !used[iv.hint] {
+			iv.Reg = iv.hint
+		}
+		for _, reg := range regs {
+			if iv.Reg == "" && !used[reg] {
+				iv.Reg = reg
+			}
+		}
+		if iv.Reg == "" {
+			// Spill the interval ending last, if it ends after iv
+			// and holds a register iv may take
+			var victim *Interval
+			for _, x := range active {
+				if x.End > iv.End && containsReg(regs, x.Reg) && (victim == nil || x.End > victim.End) {
+					victim = x
+				}
+			}
+			if victim == nil {
+				continue
+			}
+			iv.Reg, victim.Reg = victim.Reg, ""
+			active = removeInterval(active, victim)
+		}
+
+		used[iv.Reg] = true
+		if containsReg(calleeSaved, iv.Reg) {
+			saved[iv.Reg] = true
+		}
+		i := sort.Search(len(active), func(i int) bool { return active[i].End > iv.End })
+		active = append(active, nil)
+		copy(active[i+1:], active[i:])
+		active[i] = iv
+	}
+}
+
+// layout gives slots to spilled values and saved registers. Parameters
+// passed on the stack spill to where the caller put them.
+func (a *Allocation) layout() {
+	stack := map[*ir.Var]int{}
+	ints, doubles, n := 0, 0, 0
+	for _, p := range a.Func.Params {
+		if p.Typ == ir.Double && doubles < len(doubleArgs) {
+			doubles++
+		} else if p.Typ == ir.Int && ints < len(intArgs) {
+			ints++
+		} else {
+			stack[p] = 16 + 8*n
+			n++
+		}
+	}
+
+	offset := 8 * len(a.Saved)
+	for _, iv := range a.Intervals {
+		if iv.Reg != "" {
+			continue
+		}
+		if p, ok := iv.Value.(*ir.Var); ok {
+			if slot, ok := stack[p]; ok {
+				iv.Slot = slot
+				continue
+			}
+		}
+		offset += 8
+		iv.Slot = -offset
+	}
+	a.Frame = (offset + 15) &^ 15
+}
+
+// Location returns the register or memory operand of v. Values the
+// function never uses have none.
+func (a *Allocation) Location(v ir.Value) (string, bool) {
+	iv := a.byValue[v]
+	if iv == nil {
+		return "", false
+	}
+	if iv.Reg != "" {
+		return iv.Reg, true
+	}
+	return fmt.Sprintf("%d(%%rbp)", iv.Slot), true
+}
+
+func containsReg(regs []string, reg string) bool {
+	for _, r := range regs {
+		if r == reg {
+			return true
+		}
+	}
+	return false
+}
+
+func removeInterval(list []*Interval, iv *Interval) []*Interval {
+	for i, x := range list {
+		if x == iv {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
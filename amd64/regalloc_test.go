@@ -0,0 +1,73 @@
+package amd64
+
+import (
+	"testing"
+
+	"github.com/rabierre/compiler/ir"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocateCalls(t *testing.T) {
+	// func f(int a, double d) int { int x = g() return a + x + int(d) }
+	a := &ir.Var{Name: "a", Typ: ir.Int}
+	d := &ir.Var{Name: "d", Typ: ir.Double}
+	f := &ir.Func{Name: "f", Result: ir.Int, Params: []*ir.Var{a, d}}
+	x, y, z, r := f.NewTemp(ir.Int), f.NewTemp(ir.Int), f.NewTemp(ir.Int), f.NewTemp(ir.Int)
+	f.NewBlock().Instrs = []ir.Instr{
+		&ir.Call{Dst: x, Func: "g"},
+		&ir.BinOp{Dst: y, Op: ir.Add, X: a, Y: x},
+		&ir.Convert{Dst: z, X: d},
+		&ir.BinOp{Dst: r, Op: ir.Add, X: y, Y: z},
+		&ir.Return{Value: r},
+	}
+	f.ComputeCFG()
+
+	alloc := Allocate(f)
+	// Values live across the call take callee-saved registers, there
+	// is none for doubles
+	expect := "intervals f:\n" +
+		"\tint a [0, 4] calls %ebx\n" +
+		"\tdouble d [0, 6] calls spill -16(%rbp)\n" +
+		"\tint %1 [3, 4] %esi\n" +
+		"\tint %2 [5, 8] %esi\n" +
+		"\tint %3 [7, 8] %edi\n" +
+		"\tint %4 [9, 10] %esi\n"
+	assert.Equal(t, expect, alloc.String())
+	assert.Equal(t, []string{"%ebx"}, alloc.Saved)
+	assert.Equal(t, 16, alloc.Frame)
+
+	out := Emit(&ir.Program{Funcs: []*ir.Func{f}}, nil)
+	assert.Contains(t, out, "\tmovq %rbx, -8(%rbp)\n\tmovl %edi, %ebx\n\tmovsd %xmm0, -16(%rbp)\n\tcall g\n")
+	assert.Contains(t, out, "\tmovq -8(%rbp), %rbx\n\tleave\n\tret\n")
+}
+
+func TestAllocateSpill(t *testing.T) {
+	// More values live at once than registers: those ending last spill
+	f := &ir.Func{Name: "f", Result: ir.Int}
+	b := f.NewBlock()
+	var temps []*ir.Temp
+	for k := 0; k < len(intRegs)+2; k++ {
+		temp := f.NewTemp(ir.Int)
+		temps = append(temps, temp)
+		b.Instrs = append(b.Instrs, &ir.Copy{Dst: temp, Src: ir.IntConst(int64(k))})
+	}
+	var total ir.Value = ir.IntConst(0)
+	for _, temp := range temps {
+		next := f.NewTemp(ir.Int)
+		b.Instrs = append(b.Instrs, &ir.BinOp{Dst: next, Op: ir.Add, X: total, Y: temp})
+		total = next
+	}
+	b.Instrs = append(b.Instrs, &ir.Return{Value: total})
+	f.ComputeCFG()
+
+	alloc := Allocate(f)
+	spilled := 0
+	for _, iv := range alloc.Intervals {
+		if iv.Reg == "" {
+			spilled++
+			assert.True(t, iv.Slot < 0)
+		}
+	}
+	assert.Equal(t, 2, spilled)
+	assert.Equal(t, 16*((8*(len(alloc.Saved)+spilled)+15)/16), alloc.Frame)
+}
@@ -1,6 +1,9 @@
 package ast
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/rabierre/compiler/token"
 )
 
@@ -54,6 +57,7 @@ type ShortExpr struct {
 type Ident struct {
 	Pos  int
 	Name string
+	Obj  *Object // what the name denotes, nil if it is not declared in the file
 }
 
 type CallExpr struct {
@@ -92,16 +96,51 @@ type Decl interface {
 }
 
 type FuncDecl struct {
-	// TODO Pos
-	Name   *Ident
-	Type   token.Type
-	Params *StmtList
-	Body   *CompoundStmt
+	Doc      *CommentGroup // comments on the lines right above, nil if none
+	Pos      int
+	Name     *Ident
+	Type     token.Type
+	TypeName *Ident // named result type, Type is token.IDENT
+	Params   *StmtList
+	Body     *CompoundStmt
+}
+
+// Constant declaration, at top level or in a function body.
+// Value is replaced by its folded BasicLit after constant folding.
+type ConstDecl struct {
+	Pos   int
+	Type  token.Type
+	Name  *Ident
+	Value Expr
+}
+
+// enum Color { RED, GREEN = 5, BLUE }
+type EnumDecl struct {
+	Pos       int
+	Name      *Ident
+	Members   []*EnumMember
+	RBracePos int
+}
+
+type EnumMember struct {
+	Name  *Ident
+	Value Expr // nil if not given, BasicLit after constant folding
 }
 
 func (*FuncDecl) declNode()    {}
+func (*EnumDecl) declNode()    {}
+func (*ConstDecl) declNode()   {}
 func (*VarDeclStmt) declNode() {}
 
+// TypeName returns how a declared type is written, name is set for named
+// types
+func TypeName(typ token.Type, name *Ident) string {
+	if typ == token.IDENT {
+		return name.Name
+	}
+	return typ.String()
+}
+
 //--------------------------------------------------------------------------------------
 // Statement
 //
@@ -137,10 +176,11 @@ type ForStmt struct {
 }
 
 type VarDeclStmt struct {
-	Pos    int
-	Type   token.Type
-	Name   *Ident
-	RValue Expr
+	Pos      int
+	Type     token.Type
+	TypeName *Ident // named type like enum, Type is token.IDENT
+	Name     *Ident
+	RValue   Expr
 }
 
 type ReturnStmt struct {
@@ -148,6 +188,25 @@ type ReturnStmt struct {
 	Value Expr
 }
 
+// switch (Tag) { case 1, 2: ... default: ... }
+type SwitchStmt struct {
+	Pos       int
+	Tag       Expr
+	Body      []*CaseClause
+	RBracePos int
+}
+
+type CaseClause struct {
+	Pos  int
+	List []Expr // nil for default
+	Body []Stmt
+}
+
+// Only the last statement of a case clause but the final one
+type FallthroughStmt struct {
+	Pos int
+}
+
 type ExprStmt struct {
 	Val Expr
 }
@@ -159,15 +218,19 @@ type BadStmt struct {
 	From int
 }
 
-func (*StmtList) stmtNode()     {}
-func (*CompoundStmt) stmtNode() {}
-func (*ForStmt) stmtNode()      {}
-func (*IfStmt) stmtNode()       {}
-func (*VarDeclStmt) stmtNode()  {}
-func (*ReturnStmt) stmtNode()   {}
-func (*ExprStmt) stmtNode()     {}
-func (*EmptyStmt) stmtNode()    {}
-func (*BadStmt) stmtNode()      {}
+func (*StmtList) stmtNode()        {}
+func (*CompoundStmt) stmtNode()    {}
+func (*ForStmt) stmtNode()         {}
+func (*IfStmt) stmtNode()          {}
+func (*VarDeclStmt) stmtNode()     {}
+func (*ConstDecl) stmtNode()       {}
+func (*ReturnStmt) stmtNode()      {}
+func (*SwitchStmt) stmtNode()      {}
+func (*CaseClause) stmtNode()      {}
+func (*FallthroughStmt) stmtNode() {}
+func (*ExprStmt) stmtNode()        {}
+func (*EmptyStmt) stmtNode()       {}
+func (*BadStmt) stmtNode()         {}
 
 //--------------------------------------------------------------------------------------
 // Comment
@@ -180,17 +243,91 @@ func (c *CommentList) Insert(comment *Comment) {
 	c.List = append(c.List, comment)
 }
 
+// // to the end of line or /* */, Text has the markers, Pos is of the
+// first /
 type Comment struct {
 	Pos  int
 	Text string
 }
 
+func (c *Comment) End() int {
+	return c.Pos + len(c.Text)
+}
+
+// Comments on lines one after another with nothing else between. One
+// after code on its line is a group alone.
+type CommentGroup struct {
+	List []*Comment
+}
+
+func (g *CommentGroup) End() int {
+	return g.List[len(g.List)-1].End()
+}
+
+// Text returns the comments without markers, a line each
+func (g *CommentGroup) Text() string {
+	var text string
+	for _, c := range g.List {
+		if strings.HasPrefix(c.Text, "//") {
+			text += strings.TrimPrefix(c.Text[2:], " ") + "\n"
+			continue
+		}
+		// /* */ a line each, the stars of a box like this one are no text
+		lines := strings.Split(c.Text[2:len(c.Text)-2], "\n")
+		if len(lines) > 1 && strings.TrimSpace(lines[0]) == "" {
+			lines = lines[1:]
+		}
+		if len(lines) > 1 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+			lines = lines[:len(lines)-1]
+		}
+		box := strings.Contains(c.Text, "\n")
+		for _, line := range lines {
+			box = box && strings.HasPrefix(strings.TrimSpace(line), "*")
+		}
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			if box {
+				line = strings.TrimSpace(line[1:])
+			}
+			text += line + "\n"
+		}
+	}
+	return text
+}
+
+//--------------------------------------------------------------------------------------
+// File
+//
+type File struct {
+	Name       string
+	Decls      []Decl
+	Scope      *Scope   // top scope of the file
+	UnResolved []*Ident // idents not declared in this file
+	Comments   *CommentList
+	Groups     []*CommentGroup // the comments grouped
+}
+
 //--------------------------------------------------------------------------------------
 // Scope
 //
+
+// Scopes of a file make a tree under File.Scope. A scope covers the
+// source from Pos to End, the offset of the token closing it.
 type Scope struct {
-	Outer   *Scope
-	Objects map[string]*Object
+	Outer    *Scope
+	Objects  map[string]*Object
+	Children []*Scope
+	Pos      int
+	End      int
+}
+
+// NewScope returns a scope nested in outer, nil for a top scope
+func NewScope(outer *Scope) *Scope {
+	s := &Scope{Outer: outer, Objects: map[string]*Object{}}
+	if outer != nil {
+		outer.Children = append(outer.Children, s)
+	}
+	return s
 }
 
 func (s *Scope) Insert(obj *Object, name string) {
@@ -201,18 +338,61 @@ func (s *Scope) Insert(obj *Object, name string) {
 	s.Objects[name] = obj
 }
 
+// Lookup returns the object of name in s or the scopes around it, nil if
+// there is none
+func (s *Scope) Lookup(name string) *Object {
+	for ; s != nil; s = s.Outer {
+		if obj, exist := s.Objects[name]; exist {
+			return obj
+		}
+	}
+	return nil
+}
+
+// Innermost returns the deepest scope under s covering pos, nil if s does
+// not
+func (s *Scope) Innermost(pos int) *Scope {
+	if pos < s.Pos || s.End < pos {
+		return nil
+	}
+	for _, child := range s.Children {
+		if inner := child.Innermost(pos); inner != nil {
+			return inner
+		}
+	}
+	return s
+}
+
 type ObjectType int
 
 const (
-	FUNC = iota
+	FUNC ObjectType = iota
 	VAR
+	CONST
+	TYPE
 )
 
+func (t ObjectType) String() string {
+	switch t {
+	case FUNC:
+		return "func"
+	case VAR:
+		return "var"
+	case CONST:
+		return "const"
+	case TYPE:
+		return "type"
+	}
+	return "ObjectType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Object is what a name is declared as. Decl is the *FuncDecl,
+// *VarDeclStmt, *ConstDecl, *EnumDecl or *EnumMember declaring it.
 type Object struct {
-	kind ObjectType
-	decl interface{}
+	Kind ObjectType
+	Decl interface{}
 }
 
 func NewObject(decl interface{}, kind ObjectType) *Object {
-	return &Object{decl: decl, kind: kind}
+	return &Object{Decl: decl, Kind: kind}
 }
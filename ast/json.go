@@ -0,0 +1,265 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rabierre/compiler/token"
+)
+
+//--------------------------------------------------------------------------------------
+// JSON
+//
+
+// A node is encoded as an object, "Node" naming its type first, then its
+// fields in the order they are declared. Token types and operators are
+// strings as Fprint prints them, nil is null. What the parser resolves,
+// Ident.Obj and the Scope, UnResolved and Groups of a File, is left out.
+// A node under several others, like the comments of a doc comment, is
+// encoded under each and decoded into copies.
+//
+//	{"Node":"Ident","Pos":5,"Name":"main"}
+
+// EncodeJSON returns the encoding of the tree under node
+func EncodeJSON(node Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, reflect.ValueOf(node)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeJSON returns the tree encoded in data
+func DecodeJSON(data []byte) (Node, error) {
+	v, err := decodeJSON(data, nodeType)
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+var (
+	nodeType  = reflect.TypeOf((*Node)(nil)).Elem()
+	nodeTypes = map[string]reflect.Type{}
+
+	// fields left out by node type
+	resolved = map[reflect.Type]map[string]bool{
+		reflect.TypeOf(Ident{}): {"Obj": true},
+		reflect.TypeOf(File{}):  {"Scope": true, "UnResolved": true, "Groups": true},
+	}
+)
+
+func init() {
+	for _, n := range []Node{
+		(*BasicLit)(nil), (*Ident)(nil), (*BinaryExpr)(nil), (*UnaryExpr)(nil), (*ShortExpr)(nil),
+		(*CallExpr)(nil), (*AssignExpr)(nil), (*BadExpr)(nil), (*ExprList)(nil),
+		(*FuncDecl)(nil), (*ConstDecl)(nil), (*EnumDecl)(nil), (*EnumMember)(nil),
+		(*StmtList)(nil), (*CompoundStmt)(nil), (*IfStmt)(nil), (*ForStmt)(nil), (*VarDeclStmt)(nil),
+		(*ReturnStmt)(nil), (*SwitchStmt)(nil), (*CaseClause)(nil), (*FallthroughStmt)(nil),
+		(*ExprStmt)(nil), (*EmptyStmt)(nil), (*BadStmt)(nil),
+		(*Comment)(nil), (*CommentGroup)(nil), (*CommentList)(nil), (*File)(nil),
+	} {
+		t := reflect.TypeOf(n)
+		nodeTypes[t.Elem().Name()] = t
+	}
+}
+
+func encodeJSON(buf *bytes.Buffer, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Invalid:
+		buf.WriteString("null")
+
+	case reflect.Interface:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return encodeJSON(buf, v.Elem())
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		t := v.Elem().Type()
+		if nodeTypes[t.Name()] != v.Type() {
+			return fmt.Errorf("ast: %s is no node", v.Type())
+		}
+		fmt.Fprintf(buf, `{"Node":%q`, t.Name())
+		for i := 0; i < t.NumField(); i++ {
+			name := t.Field(i).Name
+			if resolved[t][name] {
+				continue
+			}
+			fmt.Fprintf(buf, ",%q:", name)
+			if err := encodeJSON(buf, v.Elem().Field(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+
+	case reflect.Slice:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		buf.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+
+	case reflect.Struct:
+		if v.Type() != operatorType {
+			return fmt.Errorf("ast: %s is no node", v.Type())
+		}
+		fmt.Fprintf(buf, "%q", tokenName(v.Interface().(Operator).Type))
+
+	case reflect.Int:
+		if v.Type() == tokenType {
+			fmt.Fprintf(buf, "%q", tokenName(token.Type(v.Int())))
+			return nil
+		}
+		buf.WriteString(strconv.FormatInt(v.Int(), 10))
+
+	case reflect.String:
+		data, _ := json.Marshal(v.String())
+		buf.Write(data)
+
+	default:
+		return fmt.Errorf("ast: %s is no node", v.Type())
+	}
+	return nil
+}
+
+// decodeJSON decodes data as a value of type t
+func decodeJSON(data []byte, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	if string(bytes.TrimSpace(data)) == "null" {
+		return v, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return v, fmt.Errorf("ast: %s is no node", data)
+		}
+		var name string
+		if err := json.Unmarshal(fields["Node"], &name); err != nil {
+			return v, fmt.Errorf("ast: node without type: %s", data)
+		}
+		nt, exist := nodeTypes[name]
+		if !exist {
+			return v, fmt.Errorf("ast: unknown node %s", name)
+		}
+		if !nt.AssignableTo(t) {
+			return v, fmt.Errorf("ast: %s where %s is expected", name, strings.TrimPrefix(t.String(), "*"))
+		}
+		delete(fields, "Node")
+
+		n := reflect.New(nt.Elem())
+		st := nt.Elem()
+		for i := 0; i < st.NumField(); i++ {
+			f := st.Field(i)
+			raw, exist := fields[f.Name]
+			if !exist || resolved[st][f.Name] {
+				continue
+			}
+			delete(fields, f.Name)
+			fv, err := decodeJSON(raw, f.Type)
+			if err != nil {
+				return v, err
+			}
+			n.Elem().Field(i).Set(fv)
+		}
+		if len(fields) > 0 {
+			var names []string
+			for field := range fields {
+				names = append(names, field)
+			}
+			sort.Strings(names)
+			return v, fmt.Errorf("ast: unknown field %s of %s", names[0], name)
+		}
+		v.Set(n)
+
+	case reflect.Slice:
+		var list []json.RawMessage
+		if err := json.Unmarshal(data, &list); err != nil {
+			return v, fmt.Errorf("ast: %s is no list", data)
+		}
+		v.Set(reflect.MakeSlice(t, len(list), len(list)))
+		for i, raw := range list {
+			ev, err := decodeJSON(raw, t.Elem())
+			if err != nil {
+				return v, err
+			}
+			v.Index(i).Set(ev)
+		}
+
+	case reflect.Struct:
+		if t != operatorType {
+			return v, fmt.Errorf("ast: %s is no node", t)
+		}
+		typ, err := decodeToken(data)
+		if err != nil {
+			return v, err
+		}
+		v.Set(reflect.ValueOf(Operator{Type: typ}))
+
+	case reflect.Int:
+		if t == tokenType {
+			typ, err := decodeToken(data)
+			if err != nil {
+				return v, err
+			}
+			v.Set(reflect.ValueOf(typ))
+			return v, nil
+		}
+		var i int64
+		if err := json.Unmarshal(data, &i); err != nil {
+			return v, fmt.Errorf("ast: %s is no number", data)
+		}
+		v.SetInt(i)
+
+	case reflect.String:
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return v, fmt.Errorf("ast: %s is no string", data)
+		}
+		v.SetString(s)
+	}
+	return v, nil
+}
+
+// decodeToken returns the token type data names as tokenName does
+func decodeToken(data []byte) (token.Type, error) {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return 0, fmt.Errorf("ast: %s is no token", data)
+	}
+	for _, t := range []token.Type{token.INT_LIT, token.DOUBLE_LIT, token.IDENT, token.EOF} {
+		if name == tokenName(t) {
+			return t, nil
+		}
+	}
+	if t, exist := token.Tokens[name]; exist {
+		return t, nil
+	}
+	if strings.HasPrefix(name, "Token: ") {
+		if n, err := strconv.Atoi(name[len("Token: "):]); err == nil {
+			return token.Type(n), nil
+		}
+	}
+	return 0, fmt.Errorf("ast: unknown token %q", name)
+}
@@ -0,0 +1,69 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rabierre/compiler/token"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	data, err := EncodeJSON(&ReturnStmt{Pos: 2, Value: &BinaryExpr{Pos: 11, LValue: &Ident{Pos: 9, Name: "a"}, RValue: &BasicLit{Pos: 13, Value: "1", Type: token.INT_LIT}, Op: Operator{Type: token.PLUS}}})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"Node":"ReturnStmt","Pos":2,"Value":{"Node":"BinaryExpr","Pos":11,`+
+		`"LValue":{"Node":"Ident","Pos":9,"Name":"a"},"RValue":{"Node":"BasicLit","Pos":13,"Value":"1","Type":"INT_LIT"},"Op":"+"}}`, string(data))
+
+	// What the parser resolves is left out
+	id := &Ident{Name: "a"}
+	id.Obj = NewObject(id, VAR)
+	data, err = EncodeJSON(&File{Name: "a", Decls: []Decl{}, Scope: NewScope(nil), UnResolved: []*Ident{id}, Groups: []*CommentGroup{{}}})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"Node":"File","Name":"a","Decls":[],"Comments":null}`, string(data))
+
+	data, err = EncodeJSON(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "null", string(data))
+
+	_, err = EncodeJSON(NewScope(nil))
+	assert.EqualError(t, err, "ast: *ast.Scope is no node")
+}
+
+func TestDecodeJSON(t *testing.T) {
+	// Every node a file may have, nil and empty lists are kept apart
+	file := testFile()
+	file.Decls = append(file.Decls,
+		&ConstDecl{Pos: 90, Type: token.DOUBLE, Name: &Ident{Name: "N"}, Value: &BasicLit{Value: "1.5", Type: token.DOUBLE_LIT}},
+		&EnumDecl{Name: &Ident{Name: "Color"}, Members: []*EnumMember{{Name: &Ident{Name: "RED"}}, {Name: &Ident{Name: "BLUE"}, Value: &BasicLit{Value: "5", Type: token.INT_LIT}}}},
+		&FuncDecl{Name: &Ident{Name: "h"}, Type: token.VOID, Params: &StmtList{List: []Stmt{}}, Body: &CompoundStmt{List: []Stmt{
+			&EmptyStmt{}, &BadStmt{From: 3}, &ExprStmt{Val: &BadExpr{From: 4, To: 5}},
+			&ExprStmt{Val: &CallExpr{Name: &Ident{Name: "h"}, Params: &ExprList{List: []Expr{}}, LParenPos: 6, RParenPos: 7}},
+		}}},
+	)
+	file.Comments = &CommentList{List: []*Comment{{Pos: 1, Text: "/* \"x\" */"}}}
+
+	data, err := EncodeJSON(file)
+	assert.Nil(t, err)
+	node, err := DecodeJSON(data)
+	assert.Nil(t, err)
+	assert.Equal(t, file, node)
+
+	node, err = DecodeJSON([]byte(` null `))
+	assert.Nil(t, err)
+	assert.Nil(t, node)
+
+	for data, msg := range map[string]string{
+		`{"Node":"Scope"}`:            "ast: unknown node Scope",
+		`{"Pos":1}`:                   `ast: node without type: {"Pos":1}`,
+		`[1]`:                         "ast: [1] is no node",
+		`{"Node":"Ident","Pos":"1"}`:  `ast: "1" is no number`,
+		`{"Node":"Ident","Obj":null}`: "ast: unknown field Obj of Ident",
+		`{"Node":"ExprStmt","Val":{"Node":"EmptyStmt"}}`:     "ast: EmptyStmt where ast.Expr is expected",
+		`{"Node":"FuncDecl","Name":{"Node":"BasicLit"}}`:     "ast: BasicLit where ast.Ident is expected",
+		`{"Node":"BasicLit","Type":"while"}`:                 `ast: unknown token "while"`,
+		`{"Node":"CompoundStmt","List":{"Node":"StmtList"}}`: `ast: {"Node":"StmtList"} is no list`,
+	} {
+		_, err := DecodeJSON([]byte(data))
+		assert.EqualError(t, err, msg, data)
+	}
+}
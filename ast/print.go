@@ -0,0 +1,226 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/rabierre/compiler/token"
+)
+
+//--------------------------------------------------------------------------------------
+// Print
+//
+
+// Fprint writes the tree under node to w, a field a line indented under
+// the node it belongs to, each line numbered. Nil fields are left out.
+// Positions are offsets, followed by line:column if src is the source of
+// the tree. A node met again, like the declaration an Object refers to,
+// is printed as the line it was printed at.
+func Fprint(w io.Writer, src []byte, node Node) (err error) {
+	p := &printer{output: w, ptrmap: map[interface{}]int{}, last: '\n'}
+	if src != nil {
+		p.lines = []int{0}
+		for i, ch := range src {
+			if ch == '\n' {
+				p.lines = append(p.lines, i+1)
+			}
+		}
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			err = e.(printError).err // re-panics other panics
+		}
+	}()
+	if node == nil {
+		p.printf("nil\n")
+		return
+	}
+	p.print(reflect.ValueOf(node))
+	p.printf("\n")
+	return
+}
+
+// Print prints the tree under node to standard output
+func Print(src []byte, node Node) error {
+	return Fprint(os.Stdout, src, node)
+}
+
+type printer struct {
+	output io.Writer
+	lines  []int               // offsets lines start at, nil without source
+	ptrmap map[interface{}]int // line a pointer is printed at
+	indent int
+	last   byte // last byte written
+	line   int
+}
+
+type printError struct {
+	err error
+}
+
+// Write numbers and indents the lines of data
+func (p *printer) Write(data []byte) (n int, err error) {
+	var m int
+	for i, b := range data {
+		if b == '\n' {
+			m, err = p.output.Write(data[n : i+1])
+			n += m
+			if err != nil {
+				return
+			}
+			p.line++
+		} else if p.last == '\n' {
+			if _, err = fmt.Fprintf(p.output, "%6d  %s", p.line, strings.Repeat(".  ", p.indent)); err != nil {
+				return
+			}
+		}
+		p.last = b
+	}
+	if len(data) > n {
+		m, err = p.output.Write(data[n:])
+		n += m
+	}
+	return
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	if _, err := fmt.Fprintf(p, format, args...); err != nil {
+		panic(printError{err})
+	}
+}
+
+var (
+	tokenType    = reflect.TypeOf(token.Type(0))
+	operatorType = reflect.TypeOf(Operator{})
+)
+
+func (p *printer) print(x reflect.Value) {
+	switch x.Kind() {
+	case reflect.Interface:
+		p.print(x.Elem())
+
+	case reflect.Map:
+		p.printf("%s (len = %d) {", x.Type(), x.Len())
+		if x.Len() > 0 {
+			keys := x.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+			p.indent++
+			p.printf("\n")
+			for _, key := range keys {
+				p.print(key)
+				p.printf(": ")
+				p.print(x.MapIndex(key))
+				p.printf("\n")
+			}
+			p.indent--
+		}
+		p.printf("}")
+
+	case reflect.Ptr:
+		p.printf("*")
+		ptr := x.Interface()
+		if line, exist := p.ptrmap[ptr]; exist {
+			p.printf("(obj @ %d)", line)
+			return
+		}
+		p.ptrmap[ptr] = p.line
+		p.print(x.Elem())
+
+	case reflect.Slice:
+		p.printf("%s (len = %d) {", x.Type(), x.Len())
+		if x.Len() > 0 {
+			p.indent++
+			p.printf("\n")
+			for i := 0; i < x.Len(); i++ {
+				p.printf("%d: ", i)
+				p.print(x.Index(i))
+				p.printf("\n")
+			}
+			p.indent--
+		}
+		p.printf("}")
+
+	case reflect.Struct:
+		if x.Type() == operatorType {
+			p.printf("%s", tokenName(x.Interface().(Operator).Type))
+			return
+		}
+		t := x.Type()
+		p.printf("%s {", t)
+		p.indent++
+		first := true
+		for i := 0; i < t.NumField(); i++ {
+			field, value := t.Field(i), x.Field(i)
+			if field.PkgPath != "" || isNil(value) {
+				continue
+			}
+			if first {
+				p.printf("\n")
+				first = false
+			}
+			p.printf("%s: ", field.Name)
+			if isPos(field.Name) && value.Kind() == reflect.Int {
+				p.printPos(int(value.Int()))
+			} else {
+				p.print(value)
+			}
+			p.printf("\n")
+		}
+		p.indent--
+		p.printf("}")
+
+	default:
+		switch v := x.Interface().(type) {
+		case string:
+			p.printf("%q", v)
+		case token.Type:
+			p.printf("%s", tokenName(v))
+		default:
+			p.printf("%v", v)
+		}
+	}
+}
+
+// printPos prints an offset and its line:column, counted from 1
+func (p *printer) printPos(offset int) {
+	p.printf("%d", offset)
+	if p.lines == nil || offset < 0 {
+		return
+	}
+	line := sort.SearchInts(p.lines, offset+1) - 1
+	p.printf(" (%d:%d)", line+1, offset-p.lines[line]+1)
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isPos reports whether a field of the name is a position
+func isPos(name string) bool {
+	return name == "From" || name == "To" || name == "End" || strings.HasSuffix(name, "Pos")
+}
+
+// tokenName returns how a token type is printed, its keyword or operator
+// if it has one
+func tokenName(t token.Type) string {
+	switch t {
+	case token.INT_LIT:
+		return "INT_LIT"
+	case token.DOUBLE_LIT:
+		return "DOUBLE_LIT"
+	case token.IDENT:
+		return "IDENT"
+	case token.EOF:
+		return "EOF"
+	}
+	return t.String()
+}
@@ -0,0 +1,99 @@
+package ast
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rabierre/compiler/token"
+)
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) { return 0, errors.New("closed") }
+
+func TestFprint(t *testing.T) {
+	// int a = -1
+	// a = a + 2
+	src := []byte("int a = -1\na = a + 2\n")
+	decl := &VarDeclStmt{Pos: 0, Type: token.INT, Name: &Ident{Pos: 4, Name: "a"}, RValue: &UnaryExpr{Pos: 8, RValue: &BasicLit{Pos: 9, Value: "1", Type: token.INT_LIT}, Op: Operator{Type: token.MINUS}}}
+	decl.Name.Obj = NewObject(decl, VAR)
+	assign := &AssignExpr{Pos: 13, LValue: &Ident{Pos: 11, Name: "a", Obj: decl.Name.Obj}, RValue: &BinaryExpr{Pos: 17, LValue: &Ident{Pos: 15, Name: "a", Obj: decl.Name.Obj}, RValue: &BasicLit{Pos: 19, Value: "2", Type: token.INT_LIT}, Op: Operator{Type: token.PLUS}}}
+	list := &StmtList{List: []Stmt{decl, &ExprStmt{Val: assign}}}
+
+	var buf bytes.Buffer
+	assert.Nil(t, Fprint(&buf, src, list))
+	assert.Equal(t, `     0  *ast.StmtList {
+     1  .  List: []ast.Stmt (len = 2) {
+     2  .  .  0: *ast.VarDeclStmt {
+     3  .  .  .  Pos: 0 (1:1)
+     4  .  .  .  Type: int
+     5  .  .  .  Name: *ast.Ident {
+     6  .  .  .  .  Pos: 4 (1:5)
+     7  .  .  .  .  Name: "a"
+     8  .  .  .  .  Obj: *ast.Object {
+     9  .  .  .  .  .  Kind: var
+    10  .  .  .  .  .  Decl: *(obj @ 2)
+    11  .  .  .  .  }
+    12  .  .  .  }
+    13  .  .  .  RValue: *ast.UnaryExpr {
+    14  .  .  .  .  Pos: 8 (1:9)
+    15  .  .  .  .  RValue: *ast.BasicLit {
+    16  .  .  .  .  .  Pos: 9 (1:10)
+    17  .  .  .  .  .  Value: "1"
+    18  .  .  .  .  .  Type: INT_LIT
+    19  .  .  .  .  }
+    20  .  .  .  .  Op: -
+    21  .  .  .  }
+    22  .  .  }
+    23  .  .  1: *ast.ExprStmt {
+    24  .  .  .  Val: *ast.AssignExpr {
+    25  .  .  .  .  Pos: 13 (2:3)
+    26  .  .  .  .  LValue: *ast.Ident {
+    27  .  .  .  .  .  Pos: 11 (2:1)
+    28  .  .  .  .  .  Name: "a"
+    29  .  .  .  .  .  Obj: *(obj @ 8)
+    30  .  .  .  .  }
+    31  .  .  .  .  RValue: *ast.BinaryExpr {
+    32  .  .  .  .  .  Pos: 17 (2:7)
+    33  .  .  .  .  .  LValue: *ast.Ident {
+    34  .  .  .  .  .  .  Pos: 15 (2:5)
+    35  .  .  .  .  .  .  Name: "a"
+    36  .  .  .  .  .  .  Obj: *(obj @ 8)
+    37  .  .  .  .  .  }
+    38  .  .  .  .  .  RValue: *ast.BasicLit {
+    39  .  .  .  .  .  .  Pos: 19 (2:9)
+    40  .  .  .  .  .  .  Value: "2"
+    41  .  .  .  .  .  .  Type: INT_LIT
+    42  .  .  .  .  .  }
+    43  .  .  .  .  .  Op: +
+    44  .  .  .  .  }
+    45  .  .  .  }
+    46  .  .  }
+    47  .  }
+    48  }
+`, buf.String())
+
+	// Offsets only without source, empty lists and maps are printed
+	buf.Reset()
+	scope := NewScope(nil)
+	assert.Nil(t, Fprint(&buf, nil, &File{Name: "a", Decls: []Decl{}, Scope: scope}))
+	assert.Equal(t, `     0  *ast.File {
+     1  .  Name: "a"
+     2  .  Decls: []ast.Decl (len = 0) {}
+     3  .  Scope: *ast.Scope {
+     4  .  .  Objects: map[string]*ast.Object (len = 0) {}
+     5  .  .  Pos: 0
+     6  .  .  End: 0
+     7  .  }
+     8  }
+`, buf.String())
+
+	buf.Reset()
+	assert.Nil(t, Fprint(&buf, nil, nil))
+	assert.Equal(t, "     0  nil\n", buf.String())
+
+	assert.EqualError(t, Fprint(failWriter{}, nil, &EmptyStmt{}), "closed")
+}
@@ -0,0 +1,294 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+)
+
+//--------------------------------------------------------------------------------------
+// Rewrite
+//
+
+// ApplyFunc is called by Apply on each node, nil ones included, with a
+// Cursor at the node. Its result controls the traversal, see Apply.
+type ApplyFunc func(*Cursor) bool
+
+// Apply traverses the tree under root as Walk does and returns it,
+// changed by pre and post through the Cursor.
+//
+// pre is called on a node before its children, if it returns false the
+// children are skipped and post is not called on the node. post is
+// called after the children, if it returns false the traversal stops.
+// Either may be nil. Unlike Walk, Apply also calls them on absent
+// children, where Cursor.Node is nil, so they can fill them in.
+func Apply(root Node, pre, post ApplyFunc) (result Node) {
+	parent := &struct{ Node }{root}
+	defer func() {
+		if r := recover(); r != nil && r != abort {
+			panic(r)
+		}
+		result = parent.Node
+	}()
+	a := &application{pre: pre, post: post}
+	a.apply(parent, "Node", nil, root)
+	return
+}
+
+var abort = new(int) // stops Apply
+
+// Cursor is a node met by Apply, in the field Name of Parent, at Index
+// if the field is a slice. With p the parent and f the field:
+//
+//	p.f            == c.Node() if c.Index() < 0
+//	p.f[c.Index()] == c.Node() otherwise
+//
+// Replace, Delete, InsertBefore and InsertAfter change the tree without
+// disturbing Apply.
+type Cursor struct {
+	parent Node
+	name   string
+	iter   *iterator // nil if the field is no slice
+	node   Node
+}
+
+func (c *Cursor) Node() Node   { return c.node }
+func (c *Cursor) Parent() Node { return c.parent }
+func (c *Cursor) Name() string { return c.name }
+
+// Index returns the index of the node in the slice of its parent field,
+// -1 if the field is no slice. It changes when nodes are inserted
+// before it.
+func (c *Cursor) Index() int {
+	if c.iter != nil {
+		return c.iter.index
+	}
+	return -1
+}
+
+func (c *Cursor) field() reflect.Value {
+	return reflect.Indirect(reflect.ValueOf(c.parent)).FieldByName(c.name)
+}
+
+// Replace puts n in place of the node, nil clears the field. Apply does
+// not walk n.
+func (c *Cursor) Replace(n Node) {
+	v := c.field()
+	if i := c.Index(); i >= 0 {
+		v = v.Index(i)
+	}
+	if n == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	v.Set(reflect.ValueOf(n))
+}
+
+// Delete removes the node from the slice of its parent field, it panics
+// if the field is no slice
+func (c *Cursor) Delete() {
+	i := c.Index()
+	if i < 0 {
+		panic("ast.Cursor: Delete of a node not in a slice")
+	}
+	v := c.field()
+	l := v.Len()
+	reflect.Copy(v.Slice(i, l), v.Slice(i+1, l))
+	v.Index(l - 1).Set(reflect.Zero(v.Type().Elem()))
+	v.SetLen(l - 1)
+	c.iter.step--
+}
+
+// InsertAfter inserts n after the node in the slice of its parent field,
+// it panics if the field is no slice. Apply does not walk n.
+func (c *Cursor) InsertAfter(n Node) {
+	i := c.Index()
+	if i < 0 {
+		panic("ast.Cursor: InsertAfter of a node not in a slice")
+	}
+	v := c.field()
+	v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+	l := v.Len()
+	reflect.Copy(v.Slice(i+2, l), v.Slice(i+1, l))
+	v.Index(i + 1).Set(reflect.ValueOf(n))
+	c.iter.step++
+}
+
+// InsertBefore inserts n before the node in the slice of its parent
+// field, it panics if the field is no slice. Apply does not walk n.
+func (c *Cursor) InsertBefore(n Node) {
+	i := c.Index()
+	if i < 0 {
+		panic("ast.Cursor: InsertBefore of a node not in a slice")
+	}
+	v := c.field()
+	v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+	l := v.Len()
+	reflect.Copy(v.Slice(i+1, l), v.Slice(i, l))
+	v.Index(i).Set(reflect.ValueOf(n))
+	c.iter.index++
+}
+
+type application struct {
+	pre, post ApplyFunc
+	cursor    Cursor
+	iter      iterator
+}
+
+// iterator is the position of Apply in a slice of nodes
+type iterator struct {
+	index, step int
+}
+
+func (a *application) apply(parent Node, name string, iter *iterator, n Node) {
+	// a nil pointer is no node
+	if v := reflect.ValueOf(n); v.Kind() == reflect.Ptr && v.IsNil() {
+		n = nil
+	}
+
+	saved := a.cursor
+	a.cursor.parent = parent
+	a.cursor.name = name
+	a.cursor.iter = iter
+	a.cursor.node = n
+
+	if a.pre != nil && !a.pre(&a.cursor) {
+		a.cursor = saved
+		return
+	}
+
+	// the children of the node as it was, a replacement is not walked
+	switch n := n.(type) {
+	case nil:
+		// nothing to do
+
+	// Expressions
+	case *BasicLit, *Ident, *BadExpr:
+		// no children
+
+	case *BinaryExpr:
+		a.apply(n, "LValue", nil, n.LValue)
+		a.apply(n, "RValue", nil, n.RValue)
+
+	case *UnaryExpr:
+		a.apply(n, "RValue", nil, n.RValue)
+
+	case *ShortExpr:
+		a.apply(n, "RValue", nil, n.RValue)
+
+	case *CallExpr:
+		a.apply(n, "Name", nil, n.Name)
+		a.apply(n, "Params", nil, n.Params)
+
+	case *AssignExpr:
+		a.apply(n, "LValue", nil, n.LValue)
+		a.apply(n, "RValue", nil, n.RValue)
+
+	case *ExprList:
+		a.applyList(n, "List")
+
+	// Declarations
+	case *FuncDecl:
+		a.apply(n, "Doc", nil, n.Doc)
+		a.apply(n, "Name", nil, n.Name)
+		a.apply(n, "Params", nil, n.Params)
+		a.apply(n, "TypeName", nil, n.TypeName)
+		a.apply(n, "Body", nil, n.Body)
+
+	case *ConstDecl:
+		a.apply(n, "Name", nil, n.Name)
+		a.apply(n, "Value", nil, n.Value)
+
+	case *EnumDecl:
+		a.apply(n, "Name", nil, n.Name)
+		a.applyList(n, "Members")
+
+	case *EnumMember:
+		a.apply(n, "Name", nil, n.Name)
+		a.apply(n, "Value", nil, n.Value)
+
+	// Statements
+	case *StmtList:
+		a.applyList(n, "List")
+
+	case *CompoundStmt:
+		a.applyList(n, "List")
+
+	case *IfStmt:
+		a.apply(n, "Cond", nil, n.Cond)
+		a.apply(n, "Body", nil, n.Body)
+		a.apply(n, "ElseBody", nil, n.ElseBody)
+
+	case *ForStmt:
+		a.apply(n, "Init", nil, n.Init)
+		a.apply(n, "Cond", nil, n.Cond)
+		a.apply(n, "Post", nil, n.Post)
+		a.apply(n, "Body", nil, n.Body)
+
+	case *VarDeclStmt:
+		a.apply(n, "TypeName", nil, n.TypeName)
+		a.apply(n, "Name", nil, n.Name)
+		a.apply(n, "RValue", nil, n.RValue)
+
+	case *ReturnStmt:
+		a.apply(n, "Value", nil, n.Value)
+
+	case *SwitchStmt:
+		a.apply(n, "Tag", nil, n.Tag)
+		a.applyList(n, "Body")
+
+	case *CaseClause:
+		a.applyList(n, "List")
+		a.applyList(n, "Body")
+
+	case *ExprStmt:
+		a.apply(n, "Val", nil, n.Val)
+
+	case *FallthroughStmt, *EmptyStmt, *BadStmt:
+		// no children
+
+	// Comments
+	case *Comment:
+		// no children
+
+	case *CommentGroup:
+		a.applyList(n, "List")
+
+	case *CommentList:
+		a.applyList(n, "List")
+
+	// File
+	case *File:
+		a.applyList(n, "Decls")
+
+	default:
+		panic(fmt.Sprintf("ast.Apply: unexpected node type %T", n))
+	}
+
+	if a.post != nil && !a.post(&a.cursor) {
+		panic(abort)
+	}
+
+	a.cursor = saved
+}
+
+func (a *application) applyList(parent Node, name string) {
+	saved := a.iter
+	a.iter.index = 0
+	for {
+		// the cursor may have changed the slice
+		v := reflect.Indirect(reflect.ValueOf(parent)).FieldByName(name)
+		if a.iter.index >= v.Len() {
+			break
+		}
+
+		var x Node
+		if e := v.Index(a.iter.index); e.IsValid() {
+			x = e.Interface()
+		}
+
+		a.iter.step = 1
+		a.apply(parent, name, &a.iter, x)
+		a.iter.index += a.iter.step
+	}
+	a.iter = saved
+}
@@ -0,0 +1,205 @@
+package ast
+
+import "fmt"
+
+//--------------------------------------------------------------------------------------
+// Walk
+//
+
+// Walk calls Visit of a Visitor on each node it reaches. If the Visitor
+// w it returns is not nil, Walk walks the children of the node with w,
+// then calls w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree under node depth first, children in source
+// order. Nil children are skipped. Positions, operators, types and the
+// scopes and comments of a File are no children, doc comments of
+// functions are.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	// Expressions
+	case *BasicLit, *Ident, *BadExpr:
+		// no children
+
+	case *BinaryExpr:
+		walkExpr(v, n.LValue)
+		walkExpr(v, n.RValue)
+
+	case *UnaryExpr:
+		walkExpr(v, n.RValue)
+
+	case *ShortExpr:
+		walkExpr(v, n.RValue)
+
+	case *CallExpr:
+		walkExpr(v, n.Name)
+		if n.Params != nil {
+			Walk(v, n.Params)
+		}
+
+	case *AssignExpr:
+		walkExpr(v, n.LValue)
+		walkExpr(v, n.RValue)
+
+	case *ExprList:
+		walkExprList(v, n.List)
+
+	// Declarations
+	case *FuncDecl:
+		if n.Doc != nil {
+			Walk(v, n.Doc)
+		}
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		if n.Params != nil {
+			Walk(v, n.Params)
+		}
+		if n.TypeName != nil {
+			Walk(v, n.TypeName)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+
+	case *ConstDecl:
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		walkExpr(v, n.Value)
+
+	case *EnumDecl:
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		for _, m := range n.Members {
+			Walk(v, m)
+		}
+
+	case *EnumMember:
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		walkExpr(v, n.Value)
+
+	// Statements
+	case *StmtList:
+		walkStmtList(v, n.List)
+
+	case *CompoundStmt:
+		walkStmtList(v, n.List)
+
+	case *IfStmt:
+		walkExpr(v, n.Cond)
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+		walkStmt(v, n.ElseBody)
+
+	case *ForStmt:
+		walkStmt(v, n.Init)
+		walkExpr(v, n.Cond)
+		walkExpr(v, n.Post)
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+
+	case *VarDeclStmt:
+		if n.TypeName != nil {
+			Walk(v, n.TypeName)
+		}
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		walkExpr(v, n.RValue)
+
+	case *ReturnStmt:
+		walkExpr(v, n.Value)
+
+	case *SwitchStmt:
+		walkExpr(v, n.Tag)
+		for _, clause := range n.Body {
+			Walk(v, clause)
+		}
+
+	case *CaseClause:
+		walkExprList(v, n.List)
+		walkStmtList(v, n.Body)
+
+	case *ExprStmt:
+		walkExpr(v, n.Val)
+
+	case *FallthroughStmt, *EmptyStmt, *BadStmt:
+		// no children
+
+	// Comments
+	case *Comment:
+		// no children
+
+	case *CommentGroup:
+		for _, c := range n.List {
+			Walk(v, c)
+		}
+
+	case *CommentList:
+		for _, c := range n.List {
+			Walk(v, c)
+		}
+
+	// File, its comments are walked as doc comments or explicitly
+	case *File:
+		for _, decl := range n.Decls {
+			Walk(v, decl)
+		}
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+func walkExpr(v Visitor, x Expr) {
+	if x != nil {
+		Walk(v, x)
+	}
+}
+
+func walkStmt(v Visitor, s Stmt) {
+	if s != nil {
+		Walk(v, s)
+	}
+}
+
+func walkExprList(v Visitor, list []Expr) {
+	for _, x := range list {
+		walkExpr(v, x)
+	}
+}
+
+func walkStmtList(v Visitor, list []Stmt) {
+	for _, s := range list {
+		walkStmt(v, s)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect walks the tree under node calling f on each node. If f returns
+// true, Inspect walks the children of the node, then calls f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,191 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rabierre/compiler/token"
+)
+
+// testFile is
+//
+//	// f
+//	func f(int a) Color {
+//		if (a) { return g(a, 1) } else { }
+//		for (int i = 0; i < a; i++) { }
+//		switch (a) { case 1: fallthrough default: a = -a }
+//	}
+func testFile() *File {
+	id := func(name string) *Ident { return &Ident{Name: name} }
+	lit := func(value string) *BasicLit { return &BasicLit{Value: value, Type: token.INT_LIT} }
+	return &File{Decls: []Decl{&FuncDecl{
+		Doc:      &CommentGroup{List: []*Comment{{Text: "// f"}}},
+		Name:     id("f"),
+		Params:   &StmtList{List: []Stmt{&VarDeclStmt{Type: token.INT, Name: id("a")}}},
+		Type:     token.IDENT,
+		TypeName: id("Color"),
+		Body: &CompoundStmt{List: []Stmt{
+			&IfStmt{
+				Cond:     id("a"),
+				Body:     &CompoundStmt{List: []Stmt{&ReturnStmt{Value: &CallExpr{Name: id("g"), Params: &ExprList{List: []Expr{id("a"), lit("1")}}}}}},
+				ElseBody: &CompoundStmt{},
+			},
+			&ForStmt{
+				Init: &VarDeclStmt{Type: token.INT, Name: id("i"), RValue: lit("0")},
+				Cond: &BinaryExpr{LValue: id("i"), RValue: id("a"), Op: Operator{Type: token.LESS}},
+				Post: &ShortExpr{RValue: id("i"), Op: Operator{Type: token.INC}},
+				Body: &CompoundStmt{},
+			},
+			&SwitchStmt{Tag: id("a"), Body: []*CaseClause{
+				{List: []Expr{lit("1")}, Body: []Stmt{&FallthroughStmt{}}},
+				{Body: []Stmt{&ExprStmt{Val: &AssignExpr{LValue: id("a"), RValue: &UnaryExpr{RValue: id("a"), Op: Operator{Type: token.MINUS}}}}}},
+			}},
+		}},
+	}}}
+}
+
+// nodes lists the nodes under n as Inspect meets them, ) when it is done
+// with the children of one
+func nodes(n Node) string {
+	var list []string
+	Inspect(n, func(n Node) bool {
+		switch x := n.(type) {
+		case nil:
+			list = append(list, ")")
+		case *Ident:
+			list = append(list, x.Name)
+		case *BasicLit:
+			list = append(list, x.Value)
+		default:
+			list = append(list, strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast."))
+		}
+		return true
+	})
+	return strings.Join(list, " ")
+}
+
+func TestWalk(t *testing.T) {
+	assert.Equal(t, "File "+
+		"FuncDecl CommentGroup Comment ) ) f ) StmtList VarDeclStmt a ) ) ) Color ) CompoundStmt "+
+		"IfStmt a ) CompoundStmt ReturnStmt CallExpr g ) ExprList a ) 1 ) ) ) ) ) CompoundStmt ) ) "+
+		"ForStmt VarDeclStmt i ) 0 ) ) BinaryExpr i ) a ) ) ShortExpr i ) ) CompoundStmt ) ) "+
+		"SwitchStmt a ) CaseClause 1 ) FallthroughStmt ) ) CaseClause ExprStmt AssignExpr a ) UnaryExpr a ) ) ) ) ) ) "+
+		") ) )", nodes(testFile()))
+
+	// nil children are skipped, lists may be walked alone
+	assert.Equal(t, "IfStmt a ) CompoundStmt ) )", nodes(&IfStmt{Cond: &Ident{Name: "a"}, Body: &CompoundStmt{}}))
+	assert.Equal(t, "ExprList 1 ) )", nodes(&ExprList{List: []Expr{&BasicLit{Value: "1"}}}))
+
+	// Children of a node are skipped if the visitor says so
+	var names []string
+	Inspect(testFile(), func(n Node) bool {
+		if id, ok := n.(*Ident); ok {
+			names = append(names, id.Name)
+		}
+		_, isFor := n.(*ForStmt)
+		return !isFor
+	})
+	assert.Equal(t, []string{"f", "a", "Color", "a", "g", "a", "a", "a", "a"}, names)
+
+	assert.Panics(t, func() { Walk(inspector(func(Node) bool { return true }), 1) })
+}
+
+func TestApply(t *testing.T) {
+	// Rename idents, Replace in place and in a slice
+	file := testFile()
+	Apply(file, func(c *Cursor) bool {
+		if id, ok := c.Node().(*Ident); ok && id.Name == "a" {
+			c.Replace(&Ident{Name: "b"})
+		}
+		return true
+	}, nil)
+	assert.Equal(t, strings.Replace(nodes(testFile()), " a ", " b ", -1), nodes(file))
+
+	// Parent, Name and Index of the cursor
+	var at []string
+	Apply(testFile(), func(c *Cursor) bool {
+		if id, ok := c.Node().(*Ident); ok && id.Name == "a" {
+			at = append(at, fmt.Sprintf("%T.%s[%d]", c.Parent(), c.Name(), c.Index()))
+		}
+		return true
+	}, nil)
+	assert.Equal(t, []string{
+		"*ast.VarDeclStmt.Name[-1]", "*ast.IfStmt.Cond[-1]", "*ast.ExprList.List[0]", "*ast.BinaryExpr.RValue[-1]",
+		"*ast.SwitchStmt.Tag[-1]", "*ast.AssignExpr.LValue[-1]", "*ast.UnaryExpr.RValue[-1]",
+	}, at)
+
+	// Insert around the params of a call, delete statements, fill an
+	// absent child and clear one
+	file = testFile()
+	Apply(file, func(c *Cursor) bool {
+		switch x := c.Node().(type) {
+		case *BasicLit:
+			if _, ok := c.Parent().(*ExprList); ok {
+				c.InsertBefore(&BasicLit{Value: "0"})
+				c.InsertAfter(&BasicLit{Value: "2"})
+			}
+		case *ForStmt:
+			c.Delete()
+		case *CaseClause:
+			if x.List == nil {
+				c.Delete()
+			}
+		case nil:
+			if c.Name() == "Tag" {
+				t.Error("Tag is not absent")
+			}
+			if _, ok := c.Parent().(*ReturnStmt); !ok && c.Name() == "RValue" {
+				c.Replace(&BasicLit{Value: "7"})
+			}
+		case *CompoundStmt:
+			if c.Name() == "ElseBody" {
+				c.Replace(nil)
+			}
+		}
+		return true
+	}, nil)
+	body := file.Decls[0].(*FuncDecl).Body
+	require.Len(t, body.List, 2)
+	assert.Equal(t, "a ) CompoundStmt ReturnStmt CallExpr g ) ExprList a ) 0 ) 1 ) 2 ) ) ) ) ) )", strings.TrimPrefix(nodes(body.List[0]), "IfStmt "))
+	assert.Equal(t, "VarDeclStmt a ) 7 ) )", nodes(file.Decls[0].(*FuncDecl).Params.List[0]))
+	assert.Len(t, body.List[1].(*SwitchStmt).Body, 1)
+
+	// pre returning false skips children, post returning false stops
+	var list []string
+	Apply(testFile(), func(c *Cursor) bool {
+		_, isIf := c.Node().(*IfStmt)
+		return !isIf
+	}, func(c *Cursor) bool {
+		if id, ok := c.Node().(*Ident); ok {
+			list = append(list, id.Name)
+			return id.Name != "i"
+		}
+		return true
+	})
+	assert.Equal(t, []string{"f", "a", "Color", "i"}, list)
+
+	// The root may be replaced, a replacement is not walked
+	var seen []Node
+	root := Apply(&ExprStmt{Val: &Ident{Name: "x"}}, func(c *Cursor) bool {
+		seen = append(seen, c.Node())
+		if _, ok := c.Node().(*ExprStmt); ok {
+			c.Replace(&EmptyStmt{})
+		}
+		return true
+	}, nil)
+	assert.Equal(t, &EmptyStmt{}, root)
+	assert.Len(t, seen, 2)
+
+	assert.Panics(t, func() {
+		Apply(&ReturnStmt{Value: &Ident{}}, func(c *Cursor) bool {
+			if c.Name() == "Value" {
+				c.Delete()
+			}
+			return true
+		}, nil)
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/rabierre/compiler/ast"
+)
+
+// astCommand prints the syntax trees of files, or of standard input if
+// there are none, as indented trees or with -json as JSON. It returns the
+// exit status.
+func astCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ast", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "print JSON instead of indented trees")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	print := func(name string, src []byte) error {
+		file, err := parseTree(name, src)
+		if err != nil {
+			return &Error{File: name, Msg: err.Error()}
+		}
+		if !*asJSON {
+			return ast.Fprint(stdout, src, file)
+		}
+		data, err := ast.EncodeJSON(file)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		json.Indent(&buf, data, "", "  ")
+		buf.WriteByte('\n')
+		_, err = stdout.Write(buf.Bytes())
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		src, err := ioutil.ReadAll(stdin)
+		if err == nil {
+			err = print("<standard input>", src)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	status := 0
+	for _, name := range flags.Args() {
+		src, err := ioutil.ReadFile(name)
+		if err == nil {
+			err = print(name, src)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			status = 1
+		}
+	}
+	return status
+}
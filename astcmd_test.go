@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rabierre/compiler/ast"
+)
+
+func TestASTCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, astCommand(nil, strings.NewReader("const int N = 1 + 2"), &stdout, &stderr))
+	assert.Equal(t, `     0  *ast.File {
+     1  .  Name: "<standard input>"
+     2  .  Decls: []ast.Decl (len = 1) {
+     3  .  .  0: *ast.ConstDecl {
+     4  .  .  .  Pos: 0 (1:1)
+     5  .  .  .  Type: int
+     6  .  .  .  Name: *ast.Ident {
+     7  .  .  .  .  Pos: 10 (1:11)
+     8  .  .  .  .  Name: "N"
+     9  .  .  .  .  Obj: *ast.Object {
+    10  .  .  .  .  .  Kind: const
+    11  .  .  .  .  .  Decl: *(obj @ 3)
+    12  .  .  .  .  }
+    13  .  .  .  }
+`, strings.Join(strings.SplitAfter(stdout.String(), "\n")[:14], ""), "constants are not folded")
+	assert.Contains(t, stdout.String(), "Op: +\n")
+
+	dir, err := ioutil.TempDir("", "ast")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "a.txt")
+	assert.Nil(t, ioutil.WriteFile(name, []byte(unformatted), 0644))
+
+	// The JSON decodes to the tree parsed but what the parser resolves
+	stdout.Reset()
+	assert.Equal(t, 0, astCommand([]string{"-json", name}, nil, &stdout, &stderr))
+	assert.True(t, json.Valid(stdout.Bytes()))
+	assert.True(t, strings.HasPrefix(stdout.String(), "{\n  \"Node\": \"File\",\n  \"Name\": "))
+	node, err := ast.DecodeJSON(stdout.Bytes())
+	assert.Nil(t, err)
+	file, err := parseTree(name, []byte(unformatted))
+	assert.Nil(t, err)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			id.Obj = nil
+		}
+		return true
+	})
+	file.Scope, file.UnResolved, file.Groups = nil, nil, nil
+	assert.Equal(t, file, node)
+
+	stderr.Reset()
+	assert.Equal(t, 1, astCommand([]string{name, filepath.Join(dir, "none.txt")}, nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "none.txt")
+	stderr.Reset()
+	assert.Equal(t, 1, astCommand(nil, strings.NewReader("func f( {"), &stdout, &stderr))
+	assert.True(t, strings.HasPrefix(stderr.String(), "<standard input>: "))
+	assert.Equal(t, 2, astCommand([]string{"-x"}, nil, &stdout, &stderr))
+}
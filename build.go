@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/rabierre/compiler/cache"
+	"github.com/rabierre/compiler/ir"
+)
+
+// CompileFiles reads and parses files concurrently then emits them
+// as one program in the order files are given. If c.Cache is set,
+// files compiled before with the same content and options are not
+// parsed again unless a declaration they use has changed.
+func (c *Compiler) CompileFiles(files []string) error {
+	srcs := make([]Source, len(files))
+	for i, name := range files {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		srcs[i] = Source{Name: name, Src: src}
+	}
+
+	keys := make([]string, len(srcs))
+	entries := make([]*cache.Entry, len(srcs))
+	var missed []int
+	for i, src := range srcs {
+		keys[i] = c.cacheKey(src)
+		if c.Cache != nil {
+			if entry, ok := c.Cache.Get(keys[i]); ok {
+				entries[i] = entry
+				continue
+			}
+		}
+		missed = append(missed, i)
+	}
+	parsed := make([]*ast.File, len(srcs))
+	c.parse(srcs, entries, parsed, missed)
+
+	// A hit is stale if a declaration it imports has changed since it was built
+	table, _ := link(entries)
+	var stale []int
+	for i, entry := range entries {
+		if contains(missed, i) {
+			continue
+		}
+		for _, sym := range entry.Imports {
+			if table[sym.Name].Sig != sym.Sig {
+				stale = append(stale, i)
+				break
+			}
+		}
+	}
+	c.parse(srcs, entries, parsed, stale)
+
+	c.CacheStats.Misses += len(missed)
+	c.CacheStats.Invalidated += len(stale)
+	c.CacheStats.Hits += len(srcs) - len(missed) - len(stale)
+
+	table, _ = link(entries)
+	rebuilt := append(missed, stale...)
+	for _, i := range rebuilt {
+		for j, sym := range entries[i].Imports {
+			entries[i].Imports[j].Sig = table[sym.Name].Sig
+		}
+		c.generate(entries[i], parsed[i], table)
+	}
+
+	_, errs := link(entries)
+	for _, entry := range entries {
+		for _, msg := range entry.Warnings {
+			c.Warnings.Add(entry.Name, msg)
+		}
+	}
+	if c.Cache != nil {
+		for _, i := range rebuilt {
+			if err := c.Cache.Put(keys[i], entries[i]); err != nil {
+				return err
+			}
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return err
+	}
+
+	var types, header, body strings.Builder
+	for _, entry := range entries {
+		types.WriteString(entry.Types)
+		header.WriteString(entry.Header)
+		body.WriteString(entry.Body)
+	}
+	return c.writeOutput(types.String()+header.String(), body.String())
+}
+
+// parse parses srcs at indexes and replaces their entries
+func (c *Compiler) parse(srcs []Source, entries []*cache.Entry, files []*ast.File, indexes []int) {
+	if len(indexes) == 0 {
+		return
+	}
+
+	subset := make([]Source, len(indexes))
+	for i, index := range indexes {
+		subset[i] = srcs[index]
+	}
+
+	parsed, warnings, errs := parseAll(subset, c.Workers, c.Debug)
+	for i, index := range indexes {
+		entries[index] = newEntry(subset[i].Name, parsed[i], warnings[i], errs[i])
+		files[index] = parsed[i]
+	}
+}
+
+// generate emits code of a parsed file into its entry. Files using
+// undefined names are left empty, link reports them.
+func (c *Compiler) generate(entry *cache.Entry, file *ast.File, table map[string]cache.Symbol) {
+	if entry.Err != "" {
+		return
+	}
+
+	globals := map[string]ir.Signature{}
+	for _, sym := range entry.Imports {
+		def, exist := table[sym.Name]
+		if !exist {
+			return
+		}
+		globals[sym.Name] = signatureOf(def)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			entry.Err = fmt.Sprint(r)
+			entry.Types, entry.Header, entry.Body = "", "", ""
+		}
+	}()
+	entry.Types, entry.Header, entry.Body = c.emitFile(file.Decls, globals)
+}
+
+func signatureOf(sym cache.Symbol) ir.Signature {
+	sig := ir.Signature{Func: sym.Kind == "func", Result: ir.ParseType(sym.Type)}
+	for _, param := range sym.Params {
+		sig.Params = append(sig.Params, ir.ParseType(param))
+	}
+	return sig
+}
+
+func (c *Compiler) cacheKey(src Source) string {
+	parts := append([]string{version, src.Name, string(src.Src)}, c.options()...)
+	return cache.Key(parts...)
+}
+
+// options lists settings which change generated code.
+// They are part of cache keys.
+func (c *Compiler) options() []string {
+	var opts []string
+	if c.SSA {
+		opts = append(opts, "ssa")
+	}
+	if c.OptLevel > 0 {
+		opts = append(opts, "O"+strconv.Itoa(c.OptLevel))
+	}
+	if c.Target != "" && c.Target != "c" {
+		opts = append(opts, c.Target)
+	}
+	if c.Comments && (c.Target == "" || c.Target == "c") {
+		opts = append(opts, "comments")
+	}
+	return opts
+}
+
+func contains(list []int, x int) bool {
+	for _, v := range list {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
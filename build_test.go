@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rabierre/compiler/cache"
+	"github.com/rabierre/compiler/vm"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeSources(t *testing.T, dir string, srcs map[string]string) []string {
+	var names []string
+	for _, name := range []string{"a.txt", "b.txt"} {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, ioutil.WriteFile(path, []byte(srcs[name]), 0666))
+		names = append(names, path)
+	}
+	return names
+}
+
+func TestCompileFilesCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cdir := filepath.Join(dir, "cache")
+	compile := func(files []string) (cache.Stats, string) {
+		cache, err := cache.Open(cdir)
+		assert.Nil(t, err)
+
+		c := Compiler{Cache: cache}
+		c.Init("", dir)
+		assert.Nil(t, c.CompileFiles(files))
+		out, err := ioutil.ReadFile(filepath.Join(dir, "mid.c"))
+		assert.Nil(t, err)
+		return c.CacheStats, string(out)
+	}
+	uncached := func(files []string) string {
+		odir := filepath.Join(dir, "uncached")
+		os.MkdirAll(odir, 0777)
+		c := Compiler{}
+		c.Init("", odir)
+		assert.Nil(t, c.CompileFiles(files))
+		out, _ := ioutil.ReadFile(filepath.Join(odir, "mid.c"))
+		return string(out)
+	}
+
+	files := writeSources(t, dir, map[string]string{
+		"a.txt": "func a() {\n b(1)\n}\n",
+		"b.txt": "func b(int x) int {\n return x\n}\n",
+	})
+	stats, out := compile(files)
+	assert.Equal(t, cache.Stats{Misses: 2}, stats)
+	assert.Equal(t, uncached(files), out)
+
+	stats, out = compile(files)
+	assert.Equal(t, cache.Stats{Hits: 2}, stats)
+	assert.Equal(t, uncached(files), out)
+
+	// Body changed only, a.txt is still valid
+	files = writeSources(t, dir, map[string]string{
+		"a.txt": "func a() {\n b(1)\n}\n",
+		"b.txt": "func b(int x) int {\n return x + 1\n}\n",
+	})
+	stats, out = compile(files)
+	assert.Equal(t, cache.Stats{Hits: 1, Misses: 1}, stats)
+	assert.Equal(t, uncached(files), out)
+
+	// Signature of b changed, a.txt depends on it
+	files = writeSources(t, dir, map[string]string{
+		"a.txt": "func a() {\n b(1)\n}\n",
+		"b.txt": "func b(double x) int {\n return x\n}\n",
+	})
+	stats, out = compile(files)
+	assert.Equal(t, cache.Stats{Misses: 1, Invalidated: 1}, stats)
+	assert.Equal(t, uncached(files), out)
+}
+
+func TestCompileFilesCacheErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	files := writeSources(t, dir, map[string]string{
+		"a.txt": "func a() {\n c()\n}\n",
+		"b.txt": "func b( {}\n",
+	})
+	cache, err := cache.Open(filepath.Join(dir, "cache"))
+	assert.Nil(t, err)
+
+	var msgs [2]string
+	for i := range msgs {
+		c := Compiler{Cache: cache}
+		c.Init("", dir)
+		msgs[i] = c.CompileFiles(files).Error()
+	}
+	assert.Equal(t, msgs[0], msgs[1])
+}
+
+// Units of files are linked when mid.vm is loaded, also when they come
+// from the cache
+func TestCompileFilesVM(t *testing.T) {
+	dir := t.TempDir()
+	files := writeSources(t, dir, map[string]string{
+		"a.txt": "func main() int {\n return twice(K) + 1\n}\n",
+		"b.txt": "const int K = 20\nfunc twice(int x) int {\n return x * 2\n}\n",
+	})
+
+	store, err := cache.Open(filepath.Join(dir, "cache"))
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		c := Compiler{Target: "vm", Cache: store}
+		c.Init("", dir)
+		assert.Nil(t, c.CompileFiles(files))
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "mid.vm"))
+		assert.Nil(t, err)
+		prog, err := vm.Decode(data)
+		assert.Nil(t, err)
+		result, err := vm.New(prog).Call("main")
+		assert.Nil(t, err)
+		assert.Equal(t, int32(41), result.Int())
+	}
+}
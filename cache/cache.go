@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Symbol is a top level declaration by its name and signature.
+type Symbol struct {
+	Name string
+	Sig  string
+
+	Kind   string   // "func", "const" or "type"
+	Type   string   // result type of functions, type of constants
+	Params []string // parameter types of functions
+}
+
+// Entry is the result of compiling one source file.
+type Entry struct {
+	Name string // source file name
+	Err  string // parse error, generated code is empty if set
+
+	Warnings []string
+
+	Types  string // generated type definitions, they go before every Header
+	Header string // generated prototypes
+	Body   string // generated definitions
+
+	Exports []Symbol // declared by the file
+	Imports []Symbol // used by the file but declared elsewhere, with the signature seen at build time
+}
+
+type Stats struct {
+	Hits        int
+	Misses      int
+	Invalidated int // hits dropped because an imported declaration changed
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("cache: %d hits, %d misses, %d invalidated", s.Hits, s.Misses, s.Invalidated)
+}
+
+type Cache struct {
+	dir string
+}
+
+func Open(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Key hashes parts into a cache key. Every part is length prefixed
+// so moving bytes between parts changes the key.
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		fmt.Fprintf(h, "%d:", len(p))
+		h.Write([]byte(p))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+func (c *Cache) Get(key string) (*Entry, bool) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	entry := &Entry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		// Broken entry is just a miss, it will be overwritten
+		return nil, false
+	}
+	return entry, true
+}
+
+// Put stores entry under key. The file is renamed into place
+// so concurrent builds never read a partially written entry.
+func (c *Cache) Put(key string, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), key+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, Key("a", "b"), Key("a", "b"))
+	assert.NotEqual(t, Key("ab", ""), Key("a", "b"))
+	assert.NotEqual(t, Key("a"), Key("b"))
+}
+
+func TestGetPut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := Open(dir)
+	assert.Nil(t, err)
+
+	key := Key("input.txt", "func f() {}")
+	_, ok := c.Get(key)
+	assert.False(t, ok)
+
+	entry := &Entry{
+		Name:    "input.txt",
+		Header:  "void f();\n",
+		Body:    "void f()\n{\n}\n",
+		Exports: []Symbol{{Name: "f", Sig: "void f()", Kind: "func", Type: "void"}},
+		Imports: []Symbol{{Name: "g", Sig: "int g(int)"}},
+	}
+	assert.Nil(t, c.Put(key, entry))
+
+	got, ok := c.Get(key)
+	assert.True(t, ok)
+	assert.Equal(t, entry, got)
+}
@@ -2,14 +2,27 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"path"
+	"strings"
 
+	"github.com/rabierre/compiler/amd64"
 	"github.com/rabierre/compiler/ast"
-	"github.com/rabierre/compiler/token"
+	"github.com/rabierre/compiler/cache"
+	"github.com/rabierre/compiler/golang"
+	"github.com/rabierre/compiler/ir"
+	"github.com/rabierre/compiler/js"
+	"github.com/rabierre/compiler/llvm"
+	"github.com/rabierre/compiler/vm"
+	"github.com/rabierre/compiler/wasm"
 )
 
+// Bump version whenever generated code changes, it invalidates build caches
+const version = "0.5.0"
+
 type Compiler struct {
 	buf bytes.Buffer
 
@@ -17,6 +30,25 @@ type Compiler struct {
 	output string
 
 	tlevel int
+
+	Workers int // max number of files parsed at once, NumCPU if 0
+	Debug   bool
+	SSA     bool // pass functions through SSA form before emitting
+
+	OptLevel int // 0 to 2, see ir.NewPipeline
+	OptStats ir.Stats
+	Verbose  io.Writer // receives inlining decisions if set
+
+	Target    string    // "c", "amd64", "wasm", "llvm", "go", "js" or "vm", empty means C
+	Triple    string    // target triple of LLVM IR, empty leaves it to LLVM
+	Package   string    // name of the Go package, "mid" if empty
+	Intervals io.Writer // receives live intervals of amd64 functions if set
+	Comments  bool      // carry doc comments of functions into C output
+
+	Warnings ErrorList
+
+	Cache      *cache.Cache // nil disables caching
+	CacheStats cache.Stats
 }
 
 func (c *Compiler) Init(input, output string) {
@@ -25,230 +57,376 @@ func (c *Compiler) Init(input, output string) {
 }
 
 func (c *Compiler) Compile(src []byte) {
-	parser := Parser{}
+	parser := Parser{debug: c.Debug}
 	parser.Init(src)
 	parser.Parse()
-
-	for _, decl := range parser.decls {
-		fn := decl.(*ast.FuncDecl)
-		c.emitType(fn.Type)
-		c.buf.WriteByte(' ')
-		c.buf.WriteString(fn.Name.Name)
-
-		c.emitParamTypes(fn.Params)
-		c.buf.WriteByte(';')
-		c.buf.WriteByte('\n')
+	for _, msg := range foldConstants(parser.decls) {
+		c.Warnings.Add("", msg)
 	}
 
-	ioutil.WriteFile(path.Join(c.output, "mid.h"), c.buf.Bytes(), 0777)
-	c.buf.Truncate(c.buf.Len())
-
-	// function is top scope
-	for _, decl := range parser.decls {
-		fn := decl.(*ast.FuncDecl)
-		c.emitType(fn.Type)
-		c.buf.WriteByte(' ')
-		c.buf.WriteString(fn.Name.Name)
-
-		c.emitParams(fn.Params)
-		c.buf.WriteByte('\n')
-		c.emitBody(fn.Body)
-	}
+	c.emit(parser.decls)
+}
 
-	ioutil.WriteFile(path.Join(c.output, "mid.c"), c.buf.Bytes(), 0777)
+func (c *Compiler) emit(decls []ast.Decl) {
+	types, header, body := c.emitFile(decls, nil)
+	c.writeOutput(types+header, body)
 }
 
-func (c *Compiler) emitBody( /*Don't handle ast directly*/ stnt ast.Stmt) {
-	c.emitCompoundStmt(stnt)
+// lower lowers decls to IR, then inlines and optimizes it as the
+// optimization level says
+func (c *Compiler) lower(decls []ast.Decl, globals map[string]ir.Signature) *ir.Program {
+	prog := ir.Lower(decls, globals)
+	if budget := ir.InlineBudget(c.OptLevel); budget > 0 {
+		inliner := ir.Inliner{Budget: budget}
+		c.OptStats.Add("inline", inliner.Run(prog, ir.NewCallGraph(decls)))
+		if c.Verbose != nil {
+			for _, msg := range inliner.Decisions {
+				fmt.Fprintln(c.Verbose, "inline: "+msg)
+			}
+		}
+	}
 
+	if c.SSA || c.OptLevel > 0 {
+		pipeline := ir.NewPipeline(c.OptLevel)
+		for _, f := range prog.Funcs {
+			if err := pipeline.Run(f); err != nil {
+				panic("Invalid IR after " + err.Error())
+			}
+			ir.FromSSA(f)
+		}
+		for _, name := range pipeline.Stats.Names {
+			c.OptStats.Add(name, pipeline.Stats.Changes[name])
+		}
+	}
+	return prog
 }
 
-func (c *Compiler) emitCompoundStmt( /*Don't handle ast directly*/ stmt ast.Stmt) {
-	c.write("{\n")
-	c.tlevel++
-	cs := stmt.(*ast.CompoundStmt)
-	for _, s := range cs.List {
-		c.emitStmt(s)
+// emitFile returns type definitions, prototypes and definitions of decls.
+// Types go first so prototypes can use types declared later in the source.
+// Functions are lowered to IR first, globals are names of other files.
+// Assembly, WebAssembly, LLVM IR and Go have only definitions.
+// JavaScript and bytecode are compiled from the AST, a bytecode unit is
+// a base64 line as cache entries are text.
+func (c *Compiler) emitFile(decls []ast.Decl, globals map[string]ir.Signature) (string, string, string) {
+	switch c.Target {
+	case "js":
+		return "", "", js.Emit(decls, globals)
+	case "vm":
+		return "", "", base64.StdEncoding.EncodeToString(vm.Encode(vm.Compile(decls, globals))) + "\n"
 	}
-	c.tlevel--
-	c.write("}\n")
-}
+	prog := c.lower(decls, globals)
+	switch c.Target {
+	case "amd64":
+		return "", "", amd64.Emit(prog, c.Intervals)
+	case "wasm":
+		return "", "", wasm.Emit(prog)
+	case "llvm":
+		return "", "", llvm.Emit(prog)
+	case "go":
+		return "", "", golang.Emit(prog)
+	}
+	c.buf.Reset()
+	for _, decl := range decls {
+		if d, ok := decl.(*ast.EnumDecl); ok {
+			c.emitEnumType(d)
+		}
+	}
+	types := c.buf.String()
+	c.buf.Reset()
+
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.ConstDecl:
+			c.emitConstDecl(d)
+		case *ast.EnumDecl:
+			c.buf.WriteString(enumNameFunc(d))
+			c.buf.WriteString(";\n")
+		case *ast.FuncDecl:
+			c.emitPrototype(prog.Func(d.Name.Name), false)
+			c.buf.WriteString(";\n")
+		}
+	}
+	header := c.buf.String()
+	c.buf.Reset()
 
-func (c *Compiler) emitIfStmt( /*Don't handle ast directly*/ stmt ast.Stmt) {
-	s := stmt.(*ast.IfStmt)
-	c.write("if (")
-	c.emitExpr(s.Cond)
-	c.buf.WriteString(")\n")
-	c.emitBody(s.Body)
-	c.write("else\n")
-	c.emitBody(s.ElseBody)
-}
+	// function is top scope
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.EnumDecl:
+			c.emitEnumNameFunc(d)
+		case *ast.FuncDecl:
+			if c.Comments && d.Doc != nil {
+				c.emitDoc(d.Doc)
+			}
+			c.emitFunc(prog.Func(d.Name.Name))
+		}
+	}
 
-func (c *Compiler) emitForStmt( /*Don't handle ast directly*/ stmt ast.Stmt) {
-	s := stmt.(*ast.ForStmt)
-	c.write("for (")
-	c.emitShortDeclStmt(s.Init) // TODO emitShortVarDecl
-	c.buf.WriteRune(';')
-	c.emitExpr(s.Cond)
-	c.buf.WriteRune(';')
-	c.emitExpr(s.Post)
-	c.buf.WriteString(")\n")
-	c.emitBody(s.Body)
+	return types, header, c.buf.String()
 }
 
-func (c *Compiler) emitReturnStmt( /*Don't handle ast directly*/ stmt ast.Stmt) {
-	s := stmt.(*ast.ReturnStmt)
-	c.write("return ")
-	c.emitExpr(s.Value)
-	c.buf.WriteString(";\n")
+// Line comments whatever the source has, they don't nest
+func (c *Compiler) emitDoc(doc *ast.CommentGroup) {
+	for _, line := range strings.Split(strings.TrimSuffix(doc.Text(), "\n"), "\n") {
+		c.buf.WriteString(strings.TrimRight("// "+line, " ") + "\n")
+	}
 }
 
-// for for stmt
-func (c *Compiler) emitShortDeclStmt( /*Don't handle ast directly*/ stmt ast.Stmt) {
-	s := stmt.(*ast.VarDeclStmt)
-	c.buf.WriteString(s.Type.String())
+// static const int a=1;
+func (c *Compiler) emitConstDecl(d *ast.ConstDecl) {
+	c.buf.WriteString("static const ")
+	c.buf.WriteString(d.Type.String())
 	c.buf.WriteRune(' ')
-	c.buf.WriteString(s.Name.Name)
-	if s.RValue != nil {
-		c.buf.WriteRune('=')
-		c.emitExpr(s.RValue)
-	}
+	c.buf.WriteString(d.Name.Name)
+	c.buf.WriteRune('=')
+	c.buf.WriteString(d.Value.(*ast.BasicLit).Value)
+	c.buf.WriteString(";\n")
 }
 
-func (c *Compiler) emitVarDeclStmt( /*Don't handle ast directly*/ stmt ast.Stmt) {
-	s := stmt.(*ast.VarDeclStmt)
-	c.write("")
-	c.buf.WriteString(s.Type.String())
-	c.buf.WriteRune(' ')
-	c.buf.WriteString(s.Name.Name)
-	if s.RValue != nil {
-		c.buf.WriteRune('=')
-		c.emitExpr(s.RValue)
+//	typedef enum {
+//		RED = 0,
+//	} Color;
+func (c *Compiler) emitEnumType(d *ast.EnumDecl) {
+	c.write("typedef enum {\n")
+	c.tlevel++
+	for _, member := range d.Members {
+		c.write(member.Name.Name)
+		c.buf.WriteString(" = ")
+		c.buf.WriteString(member.Value.(*ast.BasicLit).Value)
+		c.buf.WriteString(",\n")
 	}
-	c.buf.WriteRune(';')
-	c.buf.WriteRune('\n')
+	c.tlevel--
+	c.write("} " + d.Name.Name + ";\n")
 }
 
-func (c *Compiler) emitExprStmt( /*Don't handle ast directly*/ stmt ast.Stmt) {
-	c.write("")
-	c.emitExpr(stmt.(*ast.ExprStmt).Val)
-	c.buf.WriteByte(';')
-	c.buf.WriteByte('\n')
+func enumNameFunc(d *ast.EnumDecl) string {
+	return fmt.Sprintf("const char *%s_name(%s v)", d.Name.Name, d.Name.Name)
 }
 
-func (c *Compiler) emitStmt( /*Don't handle ast directly*/ stmt ast.Stmt) {
-	switch typ := stmt.(type) {
-	case (*ast.CompoundStmt):
-		c.emitCompoundStmt(stmt)
-	case (*ast.IfStmt):
-		c.emitIfStmt(stmt)
-	case (*ast.ForStmt):
-		c.emitForStmt(stmt)
-	case (*ast.ReturnStmt):
-		c.emitReturnStmt(stmt)
-	case (*ast.VarDeclStmt):
-		c.emitVarDeclStmt(stmt)
-	case (*ast.ExprStmt):
-		c.emitExprStmt(stmt)
-	default:
-		println("Type: ", typ)
+// Color_name returns name of a member for printing.
+// Members sharing a value are printed by the first name.
+func (c *Compiler) emitEnumNameFunc(d *ast.EnumDecl) {
+	c.write(enumNameFunc(d) + "\n")
+	c.write("{\n")
+	c.tlevel++
+	c.write("switch (v) {\n")
+	seen := map[string]bool{}
+	for _, member := range d.Members {
+		value := member.Value.(*ast.BasicLit).Value
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		c.write(fmt.Sprintf("case %s: return \"%s\";\n", member.Name.Name, member.Name.Name))
 	}
+	c.write("}\n")
+	c.write("return \"?\";\n")
+	c.tlevel--
+	c.write("}\n")
 }
 
-func (c *Compiler) emitLiteracy( /*Don't handle ast directly*/ expr ast.Expr) {
-	ex := expr.(*ast.BasicLit)
-	// TODO is type need?
-	// buf.WriteString(ex.Type.String())
-	c.buf.WriteString(ex.Value)
+// int f(int a, double b)
+func (c *Compiler) emitPrototype(f *ir.Func, names bool) {
+	c.buf.WriteString(cType(f.Result, f.ResultName))
+	c.buf.WriteByte(' ')
+	c.buf.WriteString(f.Name)
+	c.buf.WriteByte('(')
+	for i, p := range f.Params {
+		if i > 0 {
+			c.buf.WriteString(", ")
+		}
+		c.buf.WriteString(cType(p.Typ, p.TypeName))
+		if names {
+			c.buf.WriteString(" " + p.Name)
+		}
+	}
+	c.buf.WriteByte(')')
 }
 
-func (c *Compiler) emitBinaryExpr( /*Don't handle ast directly*/ expr ast.Expr) {
-	e := expr.(*ast.BinaryExpr)
-	c.emitExpr(e.LValue)
-	c.buf.WriteString(e.Op.Type.String())
-	c.emitExpr(e.RValue)
-}
+// Locals and temporaries are declared first, blocks become labels
+// and jumps to the next block are left out.
+func (c *Compiler) emitFunc(f *ir.Func) {
+	c.emitPrototype(f, true)
+	c.buf.WriteByte('\n')
+	c.write("{\n")
+	c.tlevel++
 
-func (c *Compiler) emitIdent( /*Don't handle ast directly*/ expr ast.Expr) {
-	e := expr.(*ast.Ident)
-	c.buf.WriteString(e.Name)
-}
+	for _, v := range f.Locals {
+		c.write(fmt.Sprintf("%s %s;\n", cType(v.Typ, v.TypeName), v.Name))
+	}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if t, ok := ir.Defs(instr).(*ir.Temp); ok {
+				c.write(fmt.Sprintf("%s %s;\n", t.Typ, cValue(t)))
+			}
+		}
+	}
 
-func (c *Compiler) emitCallExpr( /*Don't handle ast directly*/ expr ast.Expr) {
-	e := expr.(*ast.CallExpr)
-	c.emitExpr(e.Name)
-	c.buf.WriteRune('(')
+	labels := jumpTargets(f)
+	for i, b := range f.Blocks {
+		var next *ir.Block
+		if i+1 < len(f.Blocks) {
+			next = f.Blocks[i+1]
+		}
 
-	list := e.Params.List
-	for i := 0; i < len(list); i++ {
-		c.emitExpr(list[i])
-		if i < len(list)-1 {
-			c.buf.WriteString(", ")
+		if labels[b] {
+			c.tlevel--
+			c.write(b.Name() + ":\n")
+			c.tlevel++
+		}
+		for _, instr := range b.Instrs {
+			c.emitInstr(instr, next)
 		}
 	}
 
-	c.buf.WriteRune(')')
+	c.tlevel--
+	c.write("}\n")
 }
 
-func (c *Compiler) emitAssignExpr( /*Don't handle ast directly*/ expr ast.Expr) {
-	e := expr.(*ast.AssignExpr)
-	c.emitExpr(e.LValue)
-	c.buf.WriteRune('=')
-	c.emitExpr(e.RValue)
-}
+// jumpTargets returns blocks which emitInstr writes a goto to
+func jumpTargets(f *ir.Func) map[*ir.Block]bool {
+	labels := map[*ir.Block]bool{}
+	for i, b := range f.Blocks {
+		var next *ir.Block
+		if i+1 < len(f.Blocks) {
+			next = f.Blocks[i+1]
+		}
 
-func (c *Compiler) emitShortExpr( /*Don't handle ast directly*/ expr ast.Expr) {
-	e := expr.(*ast.ShortExpr)
-	c.emitExpr(e.RValue)
-	c.buf.WriteString(e.Op.Type.String())
+		switch t := b.Terminator().(type) {
+		case *ir.Jump:
+			if t.Target != next {
+				labels[t.Target] = true
+			}
+		case *ir.Branch:
+			switch {
+			case t.Else == next:
+				labels[t.Then] = true
+			case t.Then == next:
+				labels[t.Else] = true
+			default:
+				labels[t.Then] = true
+				labels[t.Else] = true
+			}
+		}
+	}
+	return labels
 }
 
-// TODO maybe function chain
-func (c *Compiler) emitExpr( /*Don't handle ast directly*/ expr ast.Expr) {
-	switch typ := expr.(type) {
-	case (*ast.BasicLit):
-		c.emitLiteracy(expr)
-	case (*ast.BinaryExpr):
-		c.emitBinaryExpr(expr)
-	case (*ast.Ident):
-		c.emitIdent(expr)
-	case (*ast.CallExpr):
-		c.emitCallExpr(expr)
-	case (*ast.AssignExpr):
-		c.emitAssignExpr(expr)
-	case (*ast.ShortExpr):
-		c.emitShortExpr(expr)
+func (c *Compiler) emitInstr(instr ir.Instr, next *ir.Block) {
+	switch i := instr.(type) {
+	case *ir.Copy:
+		c.write(fmt.Sprintf("%s = %s;\n", cValue(i.Dst), cValue(i.Src)))
+	case *ir.BinOp:
+		c.write(fmt.Sprintf("%s = %s %s %s;\n", cValue(i.Dst), cValue(i.X), i.Op, cValue(i.Y)))
+	case *ir.UnOp:
+		c.write(fmt.Sprintf("%s = %s%s;\n", cValue(i.Dst), i.Op, cValue(i.X)))
+	case *ir.Convert:
+		c.write(fmt.Sprintf("%s = (%s)%s;\n", cValue(i.Dst), i.Dst.Type(), cValue(i.X)))
+	case *ir.Call:
+		args := make([]string, len(i.Args))
+		for j, arg := range i.Args {
+			args[j] = cValue(arg)
+		}
+		call := fmt.Sprintf("%s(%s);\n", i.Func, strings.Join(args, ", "))
+		if i.Dst != nil {
+			call = cValue(i.Dst) + " = " + call
+		}
+		c.write(call)
+	case *ir.Jump:
+		if i.Target != next {
+			c.write("goto " + i.Target.Name() + ";\n")
+		}
+	case *ir.Branch:
+		cond := cValue(i.Cond)
+		switch {
+		case i.Else == next:
+			c.write(fmt.Sprintf("if (%s) goto %s;\n", cond, i.Then.Name()))
+		case i.Then == next:
+			c.write(fmt.Sprintf("if (!%s) goto %s;\n", cond, i.Else.Name()))
+		default:
+			c.write(fmt.Sprintf("if (%s) goto %s; else goto %s;\n", cond, i.Then.Name(), i.Else.Name()))
+		}
+	case *ir.Return:
+		if i.Value == nil {
+			c.write("return;\n")
+		} else {
+			c.write("return " + cValue(i.Value) + ";\n")
+		}
 	default:
-		fmt.Println("Type: ", typ)
+		panic(fmt.Sprintf("Unexpected instruction: %s", instr))
 	}
 }
 
-func (c *Compiler) emitType( /*Don't handle ast directly*/ typ token.Type) {
-	c.buf.WriteString(typ.String())
+// Temporaries are named with a prefix idents can't have
+func cValue(v ir.Value) string {
+	switch x := v.(type) {
+	case *ir.Temp:
+		return fmt.Sprintf("_t%d", x.ID)
+	case *ir.Global:
+		return x.Name
+	case *ir.Const:
+		if s := x.String(); strings.HasPrefix(s, "-") {
+			return "(" + s + ")"
+		}
+	}
+	return v.String()
 }
 
-func (c *Compiler) emitParamTypes( /*Don't handle ast directly*/ params *ast.StmtList) {
-	c.buf.WriteString("(")
-	for i, p := range params.List {
-		d := p.(*ast.VarDeclStmt)
-		c.buf.WriteString(d.Type.String())
-		if i < len(params.List)-1 {
-			c.buf.WriteString(", ")
+// writeOutput writes mid.h and mid.c. mid.c starts with the prototypes too,
+// so definitions can be in any order. Unchanged files are not rewritten.
+// Assembly goes to mid.s alone, WebAssembly text to mid.wat, LLVM IR
+// to mid.ll, Go to mid.go, JavaScript to mid.mjs and bytecode units to
+// mid.vm.
+func (c *Compiler) writeOutput(header, body string) error {
+	switch c.Target {
+	case "vm":
+		var data []byte
+		for _, line := range strings.Fields(body) {
+			unit, err := base64.StdEncoding.DecodeString(line)
+			if err != nil {
+				return err
+			}
+			data = append(data, unit...)
+		}
+		return writeIfChanged(path.Join(c.output, "mid.vm"), data)
+	case "amd64":
+		return writeIfChanged(path.Join(c.output, "mid.s"), []byte(body))
+	case "wasm":
+		return writeIfChanged(path.Join(c.output, "mid.wat"), []byte(wasm.Module(body)))
+	case "llvm":
+		return writeIfChanged(path.Join(c.output, "mid.ll"), []byte(llvm.Module(body, c.Triple)))
+	case "js":
+		return writeIfChanged(path.Join(c.output, "mid.mjs"), []byte(js.Module(body)))
+	case "go":
+		pkg := c.Package
+		if pkg == "" {
+			pkg = "mid"
 		}
+		src, err := golang.File(pkg, body)
+		if err != nil {
+			return err
+		}
+		return writeIfChanged(path.Join(c.output, "mid.go"), src)
+	}
+	if err := writeIfChanged(path.Join(c.output, "mid.h"), []byte(header)); err != nil {
+		return err
 	}
-	c.buf.WriteString(")")
+	return writeIfChanged(path.Join(c.output, "mid.c"), []byte(header+body))
 }
 
-func (c *Compiler) emitParams( /*Don't handle ast directly*/ params *ast.StmtList) {
-	c.buf.WriteString("(")
-	for i, p := range params.List {
-		d := p.(*ast.VarDeclStmt)
-		c.buf.WriteString(fmt.Sprintf("%s %s", d.Type.String(), d.Name.Name))
-		if i < len(params.List)-1 {
-			c.buf.WriteString(", ")
-		}
+func writeIfChanged(name string, data []byte) error {
+	if old, err := ioutil.ReadFile(name); err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+	return ioutil.WriteFile(name, data, 0777)
+}
+
+// cType returns C type of an IR value, the enum it is declared with if any
+func cType(typ ir.Type, enum string) string {
+	if enum != "" {
+		return enum
 	}
-	c.buf.WriteString(")")
+	return typ.String()
 }
 
 func (c *Compiler) write(s string) {
@@ -2,11 +2,19 @@ package main
 
 import (
 	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/rabierre/compiler/amd64"
+	"github.com/rabierre/compiler/ir"
+	"github.com/rabierre/compiler/vm"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCompile(t *testing.T) {
-	fi := "testdata/input.txt"
+	fi := "testdata/typed.txt"
 	bs, err := ioutil.ReadFile(fi)
 	if err != nil {
 		t.Fatalf("opening %q: %v", fi, err)
@@ -16,4 +24,565 @@ func TestCompile(t *testing.T) {
 	c := Compiler{}
 	c.Init("", "output")
 	c.Compile(bs)
+
+	// increase returns a value without a result type
+	bs, err = ioutil.ReadFile("testdata/input.txt")
+	assert.Nil(t, err)
+	assert.PanicsWithValue(t, "Too many return values in increase", func() {
+		c.Compile(bs)
+	})
+}
+
+func lower(src string) string {
+	return ir.Lower(parseAndFold(src), nil).String()
+}
+
+func TestLower(t *testing.T) {
+	suite := []struct {
+		src    string
+		expect string
+	}{
+		{
+			"func f(int a, double b) double { return a * b + 1 }",
+			"func f(int a, double b) double\n" +
+				"b0:\n" +
+				"\t%1:double = double(a)\n" +
+				"\t%2:double = %1 * b\n" +
+				"\t%3:double = %2 + 1.0\n" +
+				"\tret %3\n",
+		},
+		{
+			"func f(int a) int { if (a < 0) { return -a } return a }",
+			"func f(int a) int\n" +
+				"b0:\n" +
+				"\t%1:int = a < 0\n" +
+				"\tbranch %1, b1, b2\n" +
+				"b1: ; preds b0\n" +
+				"\t%2:int = -a\n" +
+				"\tret %2\n" +
+				"b2: ; preds b0\n" +
+				"\tret a\n",
+		},
+		{
+			"func f() int { int s = 0 for (int i = 0; i < 3; i++) { s = s + i } return s }",
+			"func f() int\n" +
+				"\tvar int s\n" +
+				"\tvar int i\n" +
+				"b0:\n" +
+				"\ts = 0\n" +
+				"\ti = 0\n" +
+				"\tjump b1\n" +
+				"b1: ; preds b0, b3\n" +
+				"\t%1:int = i < 3\n" +
+				"\tbranch %1, b2, b4\n" +
+				"b2: ; preds b1\n" +
+				"\t%2:int = s + i\n" +
+				"\ts = %2\n" +
+				"\tjump b3\n" +
+				"b3: ; preds b2\n" +
+				"\t%3:int = i\n" +
+				"\ti = i + 1\n" +
+				"\tjump b1\n" +
+				"b4: ; preds b1\n" +
+				"\tret s\n",
+		},
+		{
+			"func f(int a) int { switch (a) { case 1: a = 10 fallthrough case 2: a = a * 2 default: a = 0 } return a }",
+			"func f(int a) int\n" +
+				"b0:\n" +
+				"\t%1:int = a == 1\n" +
+				"\tbranch %1, b2, b5\n" +
+				"b1: ; preds b3, b4\n" +
+				"\tret a\n" +
+				"b2: ; preds b0\n" +
+				"\ta = 10\n" +
+				"\tjump b3\n" +
+				"b3: ; preds b2, b5\n" +
+				"\t%3:int = a * 2\n" +
+				"\ta = %3\n" +
+				"\tjump b1\n" +
+				"b4: ; preds b6\n" +
+				"\ta = 0\n" +
+				"\tjump b1\n" +
+				"b5: ; preds b0\n" +
+				"\t%2:int = a == 2\n" +
+				"\tbranch %2, b3, b6\n" +
+				"b6: ; preds b5\n" +
+				"\tjump b4\n",
+		},
+		{
+			"func g() {} func f() double { g() int a = 2 return a }",
+			"func g() void\n" +
+				"b0:\n" +
+				"\tret\n" +
+				"\n" +
+				"func f() double\n" +
+				"\tvar int a\n" +
+				"b0:\n" +
+				"\tcall g()\n" +
+				"\ta = 2\n" +
+				"\t%1:double = double(a)\n" +
+				"\tret %1\n",
+		},
+	}
+
+	for _, s := range suite {
+		assert.Equal(t, s.expect, lower(s.src), s.src)
+	}
+}
+
+func TestSSARoundTrip(t *testing.T) {
+	bs, err := ioutil.ReadFile("testdata/typed.txt")
+	assert.Nil(t, err)
+
+	for _, f := range ir.Lower(parseAndFold(string(bs)), nil).Funcs {
+		ir.ToSSA(f)
+		assert.Nil(t, ir.Verify(f), f.Name)
+		ir.FromSSA(f)
+		assert.Nil(t, ir.Verify(f), f.Name)
+	}
+}
+
+func optimize(src string, level int) string {
+	prog := ir.Lower(parseAndFold(src), nil)
+	pipeline := ir.NewPipeline(level)
+	for _, f := range prog.Funcs {
+		if err := pipeline.Run(f); err != nil {
+			panic(err)
+		}
+	}
+	return prog.String()
+}
+
+func TestOptimize(t *testing.T) {
+	suite := []struct {
+		src    string
+		level  int
+		expect string
+	}{
+		{
+			"func f() int { int a = 2 int b = a * 3 if (b > 5) { return b } return 0 }",
+			1,
+			"func f() int\n" +
+				"b0:\n" +
+				"\tret 6\n",
+		},
+		{
+			"const int DEBUG = 0 func f(int a) int { if (DEBUG == 1) { a = a * 2 } return a }",
+			1,
+			"func f(int a) int\n" +
+				"b0:\n" +
+				"\tret a\n",
+		},
+		{
+			"func f() int { int s = 1 for (int i = 0; i < 10; i++) { s = s * 1 } return s }",
+			1,
+			"func f() int\n" +
+				"b0:\n" +
+				"\tjump b1\n" +
+				"b1: ; preds b0, b2\n" +
+				"\t%5:int = phi 0, %9\n" +
+				"\t%1:int = %5 < 10\n" +
+				"\tbranch %1, b2, b3\n" +
+				"b2: ; preds b1\n" +
+				"\t%9:int = %5 + 1\n" +
+				"\tjump b1\n" +
+				"b3: ; preds b1\n" +
+				"\tret 1\n",
+		},
+		{
+			"func f(int a, int b) int { int x = a + b int y = b + a return x * y }",
+			2,
+			"func f(int a, int b) int\n" +
+				"b0:\n" +
+				"\t%1:int = a + b\n" +
+				"\t%3:int = %1 * %1\n" +
+				"\tret %3\n",
+		},
+		{
+			"func g(int a) int { return a } func f(int a) int { g(a) int x = a / 0 return a }",
+			2,
+			"func g(int a) int\n" +
+				"b0:\n" +
+				"\tret a\n" +
+				"\n" +
+				"func f(int a) int\n" +
+				"b0:\n" +
+				"\tcall g(a)\n" +
+				"\tret a\n",
+		},
+	}
+
+	for _, s := range suite {
+		assert.Equal(t, s.expect, optimize(s.src, s.level), s.src)
+	}
+}
+
+func TestCallGraph(t *testing.T) {
+	src := "func a() int { return b() + c(1) } " +
+		"func b() int { if (c(2) > 0) { return a() } return 0 } " +
+		"func c(int x) int { return x * d(x) } " +
+		"func d(int x) int { return x }"
+	g := ir.NewCallGraph(parseAndFold(src))
+
+	assert.Equal(t, []string{"a", "b", "c", "d"}, g.Funcs)
+	assert.Equal(t, []string{"b", "c"}, g.Calls["a"])
+	assert.Equal(t, []string{"c", "a"}, g.Calls["b"])
+	assert.True(t, g.Recursive("a"))
+	assert.True(t, g.Recursive("b"))
+	assert.False(t, g.Recursive("c"))
+	assert.Equal(t, []string{"d", "c", "b", "a"}, g.Postorder())
+}
+
+// Assembly is linked with as and ld alone and main's result is the exit code
+func TestAmd64(t *testing.T) {
+	for _, tool := range []string{"as", "ld"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip(tool + " not found")
+		}
+	}
+	src := "const double HALF = 0.5\n" +
+		"func many(int a, int b, int c, int d, int e, int f, int g, int h) int { return a - b + c - d + e - f + g * h }\n" +
+		"func scale(double x, int n) double { double s = 0 for (int i = 0; i < n ; i++) { s = s + x * HALF } return s }\n" +
+		"func fib(int n) int { if (n < 2) { return n } return fib(n - 1) + fib(n - 2) }\n" +
+		"func main() int { int r = many(1, 2, 3, 4, 5, 6, 7, 8) double d = scale(3, 4) if (d > 5.5) { r = r + 1 } return r + fib(10) }\n"
+
+	run := func(src string, level int) int {
+		dir := t.TempDir()
+		c := Compiler{OptLevel: level, Target: "amd64"}
+		c.Init("", dir)
+		c.Compile([]byte(src))
+
+		obj, prog := filepath.Join(dir, "mid.o"), filepath.Join(dir, "prog")
+		if out, err := exec.Command("as", filepath.Join(dir, "mid.s"), "-o", obj).CombinedOutput(); err != nil {
+			t.Fatalf("as: %v\n%s", err, out)
+		}
+		if out, err := exec.Command("ld", obj, "-o", prog).CombinedOutput(); err != nil {
+			t.Fatalf("ld: %v\n%s", err, out)
+		}
+		err := exec.Command(prog).Run()
+		exit, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatalf("O%d: expected exit code, got %v", level, err)
+		}
+		return exit.ExitCode()
+	}
+	for level := 0; level <= 2; level++ {
+		assert.Equal(t, 53+1+55, run(src, level), "O%d", level)
+	}
+
+	// Constant conditions have no location, only -O0 leaves them to branch on
+	assert.Equal(t, 7, run("func main() int { if (1) { return 7 } return 0 }\n", 0))
+}
+
+func TestAllocate(t *testing.T) {
+	f := ir.Lower(parseAndFold(sumSrc), nil).Funcs[0]
+	// Loop variables stay live over the whole loop
+	expect := "intervals sum:\n" +
+		"\tint n [0, 23] %edi\n" +
+		"\tint s [3, 24] %esi\n" +
+		"\tint i [5, 23] %r8d\n" +
+		"\tint %1 [9, 10] %r9d\n" +
+		"\tint %2 [13, 14] %r9d\n" +
+		"\tint %3 [19, 19] %r9d\n"
+	a := amd64.Allocate(f)
+	assert.Equal(t, expect, a.String())
+	assert.Empty(t, a.Saved)
+	assert.Equal(t, 0, a.Frame)
+}
+
+// sumSrc is a loop the backends are checked on
+const sumSrc = "const double HALF = 0.5\n" +
+	"func sum(int n) int { int s = 0 for (int i = 0; i < n ; i++) { s = s + i } return s }\n"
+
+func TestWasm(t *testing.T) {
+	compile := func(src string) string {
+		dir := t.TempDir()
+		c := Compiler{Target: "wasm"}
+		c.Init("", dir)
+		c.Compile([]byte(src))
+
+		out, err := ioutil.ReadFile(filepath.Join(dir, "mid.wat"))
+		assert.Nil(t, err)
+		return string(out)
+	}
+
+	assert.Equal(t, "(module\n"+
+		"  (global $K i32 (i32.const 2))\n"+
+		"  (func $f (export \"f\") (param $x i32) (result i32)\n"+
+		"    (local $_t1 i32)\n"+
+		"    local.get $x\n"+
+		"    i32.const 2\n"+
+		"    i32.mul\n"+
+		"    local.set $_t1\n"+
+		"    local.get $_t1\n"+
+		"    return\n"+
+		"    unreachable\n"+
+		"  )\n"+
+		")\n", compile("const int K = 2\nfunc f(int x) int { return x * K }\n"))
+
+	// The loop body and the increment after it are nested in the loop
+	assert.Equal(t, "(module\n"+
+		"  (global $HALF f64 (f64.const 0.5))\n"+
+		"  (func $sum (export \"sum\") (param $n i32) (result i32)\n"+
+		"    (local $s i32)\n"+
+		"    (local $i i32)\n"+
+		"    (local $_t1 i32)\n"+
+		"    (local $_t2 i32)\n"+
+		"    (local $_t3 i32)\n"+
+		"    i32.const 0\n"+
+		"    local.set $s\n"+
+		"    i32.const 0\n"+
+		"    local.set $i\n"+
+		"    loop $loop1\n"+
+		"      local.get $i\n"+
+		"      local.get $n\n"+
+		"      i32.lt_s\n"+
+		"      local.set $_t1\n"+
+		"      local.get $_t1\n"+
+		"      if\n"+
+		"        local.get $s\n"+
+		"        local.get $i\n"+
+		"        i32.add\n"+
+		"        local.set $_t2\n"+
+		"        local.get $_t2\n"+
+		"        local.set $s\n"+
+		"        local.get $i\n"+
+		"        local.set $_t3\n"+
+		"        local.get $i\n"+
+		"        i32.const 1\n"+
+		"        i32.add\n"+
+		"        local.set $i\n"+
+		"        br $loop1\n"+
+		"      else\n"+
+		"        local.get $s\n"+
+		"        return\n"+
+		"      end\n"+
+		"    end\n"+
+		"    unreachable\n"+
+		"  )\n"+
+		")\n", compile(sumSrc))
+}
+
+// LLVM IR is checked by llvm-as and main run by lli for its exit code
+func TestLLVM(t *testing.T) {
+	dir := t.TempDir()
+	c := Compiler{Target: "llvm"}
+	c.Init("", dir)
+	c.Compile([]byte(sumSrc))
+	out, err := ioutil.ReadFile(filepath.Join(dir, "mid.ll"))
+	assert.Nil(t, err)
+	// Registers are numbered in reverse postorder, the exit block first
+	assert.Equal(t, "@HALF = constant double 0x3FE0000000000000\n"+
+		"\n"+
+		"define i32 @sum(i32 %n) {\n"+
+		"entry:\n"+
+		"  %n.addr = alloca i32\n"+
+		"  %s.addr = alloca i32\n"+
+		"  %i.addr = alloca i32\n"+
+		"  store i32 %n, i32* %n.addr\n"+
+		"  br label %b0\n"+
+		"b0:\n"+
+		"  store i32 0, i32* %s.addr\n"+
+		"  store i32 0, i32* %i.addr\n"+
+		"  br label %b1\n"+
+		"b1:\n"+
+		"  %.1 = load i32, i32* %i.addr\n"+
+		"  %.2 = load i32, i32* %n.addr\n"+
+		"  %.3 = icmp slt i32 %.1, %.2\n"+
+		"  %.4 = zext i1 %.3 to i32\n"+
+		"  %.5 = icmp ne i32 %.4, 0\n"+
+		"  br i1 %.5, label %b2, label %b4\n"+
+		"b2:\n"+
+		"  %.7 = load i32, i32* %s.addr\n"+
+		"  %.8 = load i32, i32* %i.addr\n"+
+		"  %.9 = add i32 %.7, %.8\n"+
+		"  store i32 %.9, i32* %s.addr\n"+
+		"  br label %b3\n"+
+		"b3:\n"+
+		"  %.10 = load i32, i32* %i.addr\n"+
+		"  %.11 = load i32, i32* %i.addr\n"+
+		"  %.12 = add i32 %.11, 1\n"+
+		"  store i32 %.12, i32* %i.addr\n"+
+		"  br label %b1\n"+
+		"b4:\n"+
+		"  %.6 = load i32, i32* %s.addr\n"+
+		"  ret i32 %.6\n"+
+		"}\n", string(out))
+
+	for _, tool := range []string{"llvm-as", "lli"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip(tool + " not found")
+		}
+	}
+	src := "const double HALF = 0.5\n" +
+		"func scale(double x, int n) double { double s = 0 for (int i = 0; i < n ; i++) { s = s + x * HALF } return s }\n" +
+		"func main() int { double d = scale(3, 4) if (d > 5.5) { return 7 } return 1 }\n"
+
+	for level := 0; level <= 2; level++ {
+		dir := t.TempDir()
+		c := Compiler{OptLevel: level, Target: "llvm"}
+		c.Init("", dir)
+		c.Compile([]byte(src))
+
+		ll := filepath.Join(dir, "mid.ll")
+		if out, err := exec.Command("llvm-as", ll, "-o", filepath.Join(dir, "mid.bc")).CombinedOutput(); err != nil {
+			t.Fatalf("llvm-as: %v\n%s", err, out)
+		}
+		err := exec.Command("lli", ll).Run()
+		exit, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatalf("O%d: expected exit code, got %v", level, err)
+		}
+		assert.Equal(t, 7, exit.ExitCode(), "O%d", level)
+	}
+}
+
+// Bytecode is read back from mid.vm and its functions called by the VM
+func TestVM(t *testing.T) {
+	src := "const int N = 10\n" +
+		"func sum(int n) int { int s = 0 for (int i = 0; i < n ; i++) { s = s + i * 2 } return s }\n" +
+		"func mix(int a, double b) double { double x = a * b double y = b * a if (x == y) { return x + y } return 0 }\n" +
+		"func pick(int a) int { switch (a) { case 1: a = 10 fallthrough case 2: a = a + 2 default: a = a * 3 } return a }\n" +
+		"func consts() int { int a = N * 2 int b = a / 3 if (b > 100) { return 1 } return b + sum(N) }\n" +
+		"func fib(int n) int { if (n < 2) { return n } return fib(n - 1) + fib(n - 2) }\n" +
+		"func post(double d) int { int n = 0 for (int i = 0; d ; d--) { n = n + 1 } return n }\n" +
+		"func div(int a, int b) int { return a / b }\n"
+
+	dir := t.TempDir()
+	c := Compiler{Target: "vm"}
+	c.Init("", dir)
+	c.Compile([]byte(src))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "mid.vm"))
+	assert.Nil(t, err)
+	prog, err := vm.Decode(data)
+	assert.Nil(t, err)
+	m := vm.New(prog)
+
+	call := func(name string, args ...vm.Value) vm.Value {
+		v, err := m.Call(name, args...)
+		assert.Nil(t, err, name)
+		return v
+	}
+	assert.Equal(t, int32(9900), call("sum", vm.IntValue(100)).Int())
+	assert.Equal(t, 9.0, call("mix", vm.IntValue(3), vm.DoubleValue(1.5)).Double())
+	assert.Equal(t, int32(12), call("pick", vm.IntValue(1)).Int())
+	assert.Equal(t, int32(4), call("pick", vm.IntValue(2)).Int())
+	assert.Equal(t, int32(21), call("pick", vm.IntValue(7)).Int())
+	assert.Equal(t, int32(96), call("consts").Int())
+	assert.Equal(t, int32(6765), call("fib", vm.IntValue(20)).Int())
+	assert.Equal(t, int32(3), call("post", vm.DoubleValue(3)).Int())
+
+	_, err = m.Call("div", vm.IntValue(1), vm.IntValue(0))
+	assert.EqualError(t, err, "div+6: integer division by zero")
+}
+
+// The Go package is built with a main calling exported functions
+func TestGo(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	src := "const double HALF = 0.5\n" +
+		"func scale(double x, int n) double { double s = 0 for (int i = 0; i < n ; i++) { s = s + x * HALF } return s }\n" +
+		"func pick(int a) int { switch (a) { case 1: a = 10 fallthrough case 2: a = a + 2 default: a = a * 3 } return a }\n" +
+		"func go(int n) int { return n / 2 }\n"
+
+	for level := 0; level <= 2; level++ {
+		dir := t.TempDir()
+		c := Compiler{OptLevel: level, Target: "go", Package: "main"}
+		c.Init("", dir)
+		c.Compile([]byte(src))
+
+		main := "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(Scale(3, 4), Pick(1), Pick(7), Go(-7)) }\n"
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(main), 0666))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module prog\n"), 0666))
+
+		cmd := exec.Command("go", "run", ".")
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		assert.Nil(t, err, "O%d: %s", level, out)
+		assert.Equal(t, "6 12 21 -3\n", string(out), "O%d", level)
+	}
+}
+
+// The module is imported by Node, ints wrap and divide as in C
+func TestJS(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not found")
+	}
+	src := "func wrap(int x) int { return x + 1 }\n" +
+		"func div(int a, int b) int { return a / b }\n" +
+		"func post(int n) int { int old = n++ return old * 10 + n }\n" +
+		"func cond(double d) int { if (d) { return 1 } return 0 }\n" +
+		"func trunc(double d) int { int i = d return i }\n" +
+		"func fall(int n) int { for (int i = 0; i < 3 ; i++) { n = n * 2 } }\n"
+
+	dir := t.TempDir()
+	c := Compiler{Target: "js"}
+	c.Init("", dir)
+	c.Compile([]byte(src))
+
+	main := "import * as m from './mid.mjs';\n" +
+		"console.log(m.wrap(2147483647), m.div(-7, 2), m.post(4), m.cond(0.5), m.cond(-0), m.trunc(-2.5), m.fall(1));\n" +
+		"try { m.div(1, 0) } catch (e) { console.log(e.message) }\n"
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "main.mjs"), []byte(main), 0666))
+	out, err := exec.Command("node", filepath.Join(dir, "main.mjs")).CombinedOutput()
+	assert.Nil(t, err, "%s", out)
+	assert.Equal(t, "-2147483648 -3 45 1 0 -2 0\ninteger division by zero\n", string(out))
+}
+
+func TestComments(t *testing.T) {
+	src := "// Sum adds\n//\n//  numbers below n\nfunc sum(int n) int { return n }\n\n// Not a doc\n\nfunc one() int { return 1 } // not either\n"
+	for _, comments := range []bool{false, true} {
+		c := Compiler{Comments: comments}
+		_, _, body := c.emitFile(parseAndFold(src), nil)
+		assert.Equal(t, comments, strings.Contains(body, "// Sum adds\n//\n//  numbers below n\nint sum(int n)\n"), body)
+		assert.False(t, strings.Contains(body, "Not"), body)
+	}
+}
+
+func TestEnums(t *testing.T) {
+	src := "enum Color { RED, GREEN = 5, BLUE }\n" +
+		"func pick(Color c) Color { Color d = GREEN if (c == RED) { d = BLUE } return d }\n" +
+		"func main() int { return pick(RED) }\n"
+	c := Compiler{}
+	types, header, body := c.emitFile(parseAndFold(src), nil)
+	assert.Equal(t, "typedef enum {\n\tRED = 0,\n\tGREEN = 5,\n\tBLUE = 6,\n} Color;\n", types)
+	assert.Equal(t, "const char *Color_name(Color v);\nColor pick(Color);\nint main();\n", header)
+	assert.Contains(t, body, "const char *Color_name(Color v)\n{\n\tswitch (v) {\n\tcase RED: return \"RED\";\n")
+	assert.Contains(t, body, "Color pick(Color c)\n{\n\tColor d;\n")
+
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc not found")
+	}
+	dir := t.TempDir()
+	name := filepath.Join(dir, "mid.c")
+	assert.Nil(t, ioutil.WriteFile(name, []byte(types+header+body), 0666))
+	out, err := exec.Command("cc", "-Wall", "-Werror", "-c", name, "-o", filepath.Join(dir, "mid.o")).CombinedOutput()
+	assert.Nil(t, err, "%s", out)
+}
+
+// Double division by zero is left to run, C has no literal for its result
+func TestDoubleDivZero(t *testing.T) {
+	src := "func div() double { double x = 1.0 double y = 0.0 return x / y }\n" +
+		"func main() int { if (div() > 1.0) { return 1 } return 0 }\n"
+	c := Compiler{OptLevel: 1}
+	types, header, body := c.emitFile(parseAndFold(src), nil)
+	assert.NotContains(t, body, "Inf")
+
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc not found")
+	}
+	dir := t.TempDir()
+	name, prog := filepath.Join(dir, "mid.c"), filepath.Join(dir, "prog")
+	assert.Nil(t, ioutil.WriteFile(name, []byte(types+header+body), 0666))
+	out, err := exec.Command("cc", "-Wall", "-Werror", name, "-o", prog).CombinedOutput()
+	assert.Nil(t, err, "%s", out)
+	err = exec.Command(prog).Run()
+	exit, ok := err.(*exec.ExitError)
+	assert.True(t, ok, "%v", err)
+	if ok {
+		assert.Equal(t, 1, exit.ExitCode())
+	}
 }
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/rabierre/compiler/token"
+)
+
+var (
+	minInt = big.NewInt(math.MinInt32)
+	maxInt = big.NewInt(math.MaxInt32)
+)
+
+// Value of a constant expression. Arithmetic is exact, the value of a
+// whole int expression has to fit in C int.
+type constant struct {
+	typ token.Type // token.INT or token.DOUBLE
+	i   *big.Int
+	f   *big.Rat
+
+	member bool // enum member, its name is kept in generated code
+}
+
+func intConst(i *big.Int) *constant {
+	return &constant{typ: token.INT, i: i}
+}
+
+// checkRange panics if c is an int out of the range of C int
+func (c *constant) checkRange() *constant {
+	if c.typ == token.INT && (c.i.Cmp(minInt) < 0 || c.i.Cmp(maxInt) > 0) {
+		panic("Constant overflow: " + c.i.String())
+	}
+	return c
+}
+
+func doubleConst(f *big.Rat) *constant {
+	if v, _ := f.Float64(); math.IsInf(v, 0) {
+		panic("Constant overflow: " + f.FloatString(0))
+	}
+	return &constant{typ: token.DOUBLE, f: f}
+}
+
+func boolConst(b bool) *constant {
+	if b {
+		return intConst(big.NewInt(1))
+	}
+	return intConst(big.NewInt(0))
+}
+
+func (c *constant) rat() *big.Rat {
+	if c.typ == token.DOUBLE {
+		return c.f
+	}
+	return new(big.Rat).SetInt(c.i)
+}
+
+// convert c to typ as it is assigned to a constant declared with typ
+func (c *constant) convert(typ token.Type) *constant {
+	switch {
+	case typ == c.typ:
+		return c
+	case typ == token.DOUBLE:
+		return doubleConst(c.rat())
+	case !c.f.IsInt():
+		panic("Constant " + c.String() + " truncated to int")
+	}
+	return intConst(new(big.Int).Set(c.f.Num())).checkRange()
+}
+
+func (c *constant) String() string {
+	if c.typ == token.INT {
+		return c.i.String()
+	}
+
+	v, _ := c.f.Float64()
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eEn") { // keep it a double literal, n for NaN and Inf
+		s += ".0"
+	}
+	return s
+}
+
+func (c *constant) lit(pos int) *ast.BasicLit {
+	typ := token.INT_LIT
+	if c.typ == token.DOUBLE {
+		typ = token.DOUBLE_LIT
+	}
+	return &ast.BasicLit{Pos: pos, Value: c.String(), Type: typ}
+}
+
+func binaryConst(op token.Type, x, y *constant) *constant {
+	switch op {
+	case token.EQ, token.NEQ, token.LESS, token.LEQ, token.GRT, token.GEQ:
+		var cmp int
+		if x.typ == token.INT && y.typ == token.INT {
+			cmp = x.i.Cmp(y.i)
+		} else {
+			cmp = x.rat().Cmp(y.rat())
+		}
+		return boolConst(compare(op, cmp))
+	}
+
+	if x.typ == token.INT && y.typ == token.INT {
+		z := new(big.Int)
+		switch op {
+		case token.PLUS:
+			z.Add(x.i, y.i)
+		case token.MINUS:
+			z.Sub(x.i, y.i)
+		case token.MULTI:
+			z.Mul(x.i, y.i)
+		case token.DIVIDE:
+			if y.i.Sign() == 0 {
+				panic("Division by zero in constant expression")
+			}
+			z.Quo(x.i, y.i) // truncated as C does
+		default:
+			panic("Invalid constant operator: " + op.String())
+		}
+		return intConst(z)
+	}
+
+	z := new(big.Rat)
+	switch op {
+	case token.PLUS:
+		z.Add(x.rat(), y.rat())
+	case token.MINUS:
+		z.Sub(x.rat(), y.rat())
+	case token.MULTI:
+		z.Mul(x.rat(), y.rat())
+	case token.DIVIDE:
+		if y.rat().Sign() == 0 {
+			panic("Division by zero in constant expression")
+		}
+		z.Quo(x.rat(), y.rat())
+	default:
+		panic("Invalid constant operator: " + op.String())
+	}
+	return doubleConst(z)
+}
+
+func compare(op token.Type, cmp int) bool {
+	switch op {
+	case token.EQ:
+		return cmp == 0
+	case token.NEQ:
+		return cmp != 0
+	case token.LESS:
+		return cmp < 0
+	case token.LEQ:
+		return cmp <= 0
+	case token.GRT:
+		return cmp > 0
+	}
+	return cmp >= 0 // token.GEQ
+}
+
+func unaryConst(op token.Type, x *constant) *constant {
+	if op == token.PLUS {
+		return x
+	}
+	if x.typ == token.INT {
+		return intConst(new(big.Int).Neg(x.i))
+	}
+	return doubleConst(new(big.Rat).Neg(x.f))
+}
+
+// folder evaluates constant declarations and replaces constant
+// expressions by literals. It also checks switch statements as their
+// cases are constant. Errors are reported by panic as Parser does.
+type folder struct {
+	scopes   []map[string]*symbol
+	enums    map[string]*ast.EnumDecl
+	warnings []string
+}
+
+type symbol struct {
+	c    *constant     // nil for variables
+	enum *ast.EnumDecl // enum type of variables and members
+}
+
+// foldConstants folds decls of a file in place and returns warnings.
+// Top level constants have to be declared before they are used
+// by other constants.
+func foldConstants(decls []ast.Decl) []string {
+	f := &folder{enums: map[string]*ast.EnumDecl{}}
+	f.openScope()
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.ConstDecl:
+			f.constDecl(d)
+		case *ast.EnumDecl:
+			f.enumDecl(d)
+		}
+	}
+	for _, decl := range decls {
+		if d, ok := decl.(*ast.FuncDecl); ok {
+			f.funcDecl(d)
+		}
+	}
+	f.closeScope()
+
+	return f.warnings
+}
+
+func (f *folder) openScope() {
+	f.scopes = append(f.scopes, map[string]*symbol{})
+}
+
+func (f *folder) closeScope() {
+	f.scopes = f.scopes[:len(f.scopes)-1]
+}
+
+func (f *folder) declare(name string, sym *symbol) {
+	f.scopes[len(f.scopes)-1][name] = sym
+}
+
+// declareVar shadows constants of the same name
+func (f *folder) declareVar(d *ast.VarDeclStmt) {
+	sym := &symbol{}
+	if d.TypeName != nil {
+		sym.enum = f.enums[d.TypeName.Name]
+	}
+	f.declare(d.Name.Name, sym)
+}
+
+func (f *folder) symbol(name string) *symbol {
+	for i := len(f.scopes) - 1; i >= 0; i-- {
+		if sym, exist := f.scopes[i][name]; exist {
+			return sym
+		}
+	}
+	return nil
+}
+
+func (f *folder) lookup(name string) (*constant, bool) {
+	if sym := f.symbol(name); sym != nil && sym.c != nil {
+		return sym.c, true
+	}
+	return nil, false
+}
+
+func (f *folder) constDecl(d *ast.ConstDecl) {
+	c, ok := f.eval(d.Value)
+	if !ok {
+		panic(fmt.Sprintf("Value of %s is not constant", d.Name.Name))
+	}
+	c = c.convert(d.Type)
+	d.Value = c.lit(exprPos(d.Value))
+	f.declare(d.Name.Name, &symbol{c: c})
+}
+
+// enumDecl numbers members from 0 or from the last given value
+func (f *folder) enumDecl(d *ast.EnumDecl) {
+	f.enums[d.Name.Name] = d
+
+	next := big.NewInt(0)
+	for _, member := range d.Members {
+		if member.Value != nil {
+			c, ok := f.eval(member.Value)
+			if !ok || c.typ != token.INT {
+				panic(fmt.Sprintf("Value of %s is not int constant", member.Name.Name))
+			}
+			next = c.i
+		}
+
+		c := intConst(new(big.Int).Set(next)).checkRange()
+		member.Value = c.lit(member.Name.Pos)
+		c.member = true
+		f.declare(member.Name.Name, &symbol{c: c, enum: d})
+
+		next = new(big.Int).Add(next, big.NewInt(1))
+	}
+}
+
+func (f *folder) funcDecl(d *ast.FuncDecl) {
+	f.openScope()
+	for _, param := range d.Params.List {
+		f.declareVar(param.(*ast.VarDeclStmt))
+	}
+	f.stmtList(d.Body.List)
+	f.closeScope()
+}
+
+func (f *folder) stmtList(list []ast.Stmt) {
+	for _, s := range list {
+		f.stmt(s)
+	}
+}
+
+func (f *folder) stmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.ConstDecl:
+		f.constDecl(s)
+	case *ast.VarDeclStmt:
+		if s.RValue != nil {
+			s.RValue = f.fold(s.RValue)
+		}
+		f.declareVar(s)
+	case *ast.ExprStmt:
+		s.Val = f.fold(s.Val)
+	case *ast.ReturnStmt:
+		if s.Value != nil {
+			s.Value = f.fold(s.Value)
+		}
+	case *ast.CompoundStmt:
+		f.openScope()
+		f.stmtList(s.List)
+		f.closeScope()
+	case *ast.IfStmt:
+		s.Cond = f.fold(s.Cond)
+		f.stmt(s.Body)
+		if s.ElseBody != nil {
+			f.stmt(s.ElseBody)
+		}
+	case *ast.ForStmt:
+		f.openScope()
+		if s.Init != nil {
+			f.stmt(s.Init)
+		}
+		if s.Cond != nil {
+			s.Cond = f.fold(s.Cond)
+		}
+		if s.Post != nil {
+			s.Post = f.fold(s.Post)
+		}
+		f.stmt(s.Body)
+		f.closeScope()
+	case *ast.SwitchStmt:
+		f.switchStmt(s)
+	}
+}
+
+// switchStmt checks cases are int constants without duplicates,
+// and warns if a switch over an enum misses members without default.
+func (f *folder) switchStmt(s *ast.SwitchStmt) {
+	s.Tag = f.fold(s.Tag)
+
+	seen := map[string]bool{}
+	hasDefault := false
+	for _, clause := range s.Body {
+		if clause.List == nil {
+			hasDefault = true
+		}
+		for i, x := range clause.List {
+			clause.List[i] = f.fold(x)
+			c, ok := f.eval(clause.List[i])
+			if !ok || c.typ != token.INT {
+				panic("Case must be int constant")
+			}
+			if seen[c.String()] {
+				panic("Duplicate case " + c.String() + " in switch")
+			}
+			seen[c.String()] = true
+		}
+
+		f.openScope()
+		f.stmtList(clause.Body)
+		f.closeScope()
+	}
+
+	var enum *ast.EnumDecl
+	if id, ok := s.Tag.(*ast.Ident); ok {
+		if sym := f.symbol(id.Name); sym != nil {
+			enum = sym.enum
+		}
+	}
+	if enum == nil || hasDefault {
+		return
+	}
+
+	var missing []string
+	for _, member := range enum.Members {
+		value := member.Value.(*ast.BasicLit).Value
+		if !seen[value] {
+			seen[value] = true // aliases are reported once
+			missing = append(missing, member.Name.Name)
+		}
+	}
+	if len(missing) > 0 {
+		f.warnings = append(f.warnings, fmt.Sprintf("switch on %s misses %s", enum.Name.Name, strings.Join(missing, ", ")))
+	}
+}
+
+// fold returns x or a literal if x is constant
+func (f *folder) fold(x ast.Expr) ast.Expr {
+	if c, ok := f.eval(x); ok {
+		switch x.(type) {
+		case *ast.BasicLit:
+			return x
+		case *ast.Ident:
+			if c.member {
+				return x
+			}
+		}
+		return c.lit(exprPos(x))
+	}
+
+	switch e := x.(type) {
+	case *ast.BinaryExpr:
+		e.LValue = f.fold(e.LValue)
+		e.RValue = f.fold(e.RValue)
+	case *ast.UnaryExpr:
+		e.RValue = f.fold(e.RValue)
+	case *ast.CallExpr:
+		for i, param := range e.Params.List {
+			e.Params.List[i] = f.fold(param)
+		}
+	case *ast.AssignExpr:
+		f.checkAssign(e.LValue)
+		e.RValue = f.fold(e.RValue)
+	case *ast.ShortExpr:
+		f.checkAssign(e.RValue)
+	}
+	return x
+}
+
+func (f *folder) checkAssign(x ast.Expr) {
+	if id, ok := x.(*ast.Ident); ok {
+		if _, isConst := f.lookup(id.Name); isConst {
+			panic("Cannot assign to constant " + id.Name)
+		}
+	}
+}
+
+// eval returns value of x if x is a constant expression. Operands may be
+// out of the range of int, like 2147483648 of -2147483648, the value not.
+func (f *folder) eval(x ast.Expr) (*constant, bool) {
+	c, ok := f.exact(x)
+	if ok {
+		c.checkRange()
+	}
+	return c, ok
+}
+
+func (f *folder) exact(x ast.Expr) (*constant, bool) {
+	switch e := x.(type) {
+	case *ast.BasicLit:
+		switch e.Type {
+		case token.INT_LIT:
+			i, ok := new(big.Int).SetString(e.Value, 10)
+			if !ok {
+				panic("Invalid int literal: " + e.Value)
+			}
+			return intConst(i), true
+		case token.DOUBLE_LIT:
+			r, ok := new(big.Rat).SetString(e.Value)
+			if !ok {
+				panic("Invalid double literal: " + e.Value)
+			}
+			return doubleConst(r), true
+		case token.TRUE, token.FALSE:
+			return boolConst(e.Type == token.TRUE), true
+		}
+	case *ast.Ident:
+		return f.lookup(e.Name)
+	case *ast.UnaryExpr:
+		if y, ok := f.exact(e.RValue); ok {
+			return unaryConst(e.Op.Type, y), true
+		}
+	case *ast.BinaryExpr:
+		y, ok := f.exact(e.LValue)
+		if !ok {
+			return nil, false
+		}
+		z, ok := f.exact(e.RValue)
+		if !ok {
+			return nil, false
+		}
+		return binaryConst(e.Op.Type, y, z), true
+	}
+	return nil, false
+}
+
+func exprPos(x ast.Expr) int {
+	switch e := x.(type) {
+	case *ast.BasicLit:
+		return e.Pos
+	case *ast.Ident:
+		return e.Pos
+	case *ast.BinaryExpr:
+		return e.Pos
+	case *ast.UnaryExpr:
+		return e.Pos
+	}
+	return 0
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseAndFold(src string) []ast.Decl {
+	parser := initParser(src)
+	parser.Parse()
+	foldConstants(parser.decls)
+	return parser.decls
+}
+
+func TestFoldConstDecl(t *testing.T) {
+	suite := []struct {
+		src    string
+		expect string
+	}{
+		{"const int a = 2 * 3 + 1", "7"},
+		{"const int a = 1 + 2 * 3", "7"},
+		{"const int a = 7 / 2", "3"},
+		{"const int a = -7 / 2", "-3"},
+		{"const int a = 3 > 2", "1"},
+		{"const double a = 1 / 4.0", "0.25"},
+		{"const double a = 0.1 + 0.2", "0.3"},
+		{"const double a = 2", "2.0"},
+		{"const int a = 4.0 / 2", "2"},
+		{"const int a = 2147483646 + 1", "2147483647"},
+		{"const int a = -2147483648", "-2147483648"},
+		{"const int a = 2147483647 + 1 - 2", "2147483646"},
+	}
+	for _, s := range suite {
+		decls := parseAndFold(s.src)
+		d := decls[0].(*ast.ConstDecl)
+		assert.Equal(t, s.expect, d.Value.(*ast.BasicLit).Value, s.src)
+	}
+}
+
+func TestFoldConstErrors(t *testing.T) {
+	suite := []string{
+		"const int a = 1 / 0",
+		"const double a = 1.0 / 0.0",
+		"const int a = 2147483647 + 1",
+		"const int a = 2147483648",
+		"const int a = -2147483648 - 1",
+		"enum E { A = 2147483647, B }",
+		"const int a = 1.5",
+		`const int a = 1
+		func f() {
+			a = 2
+		}`,
+		`func f() {
+			const int a = 1
+			a++
+		}`,
+		`func f(int b) {
+			const int a = b
+		}`,
+	}
+	for _, src := range suite {
+		assert.Panics(t, func() {
+			parseAndFold(src)
+		}, src)
+	}
+}
+
+func TestFoldFunc(t *testing.T) {
+	decls := parseAndFold(`const int n = 10
+		func f(int b) int {
+			const int m = n * 2
+			int x = m + 1
+			for (int i = 0; i < n - 1; i++) {
+				x = x + n
+			}
+			return b * m
+		}
+		func g(int n) int {
+			return n + 1
+		}
+	`)
+
+	body := decls[1].(*ast.FuncDecl).Body.List
+	assert.Equal(t, "20", body[0].(*ast.ConstDecl).Value.(*ast.BasicLit).Value)
+	assert.Equal(t, "21", body[1].(*ast.VarDeclStmt).RValue.(*ast.BasicLit).Value)
+
+	loop := body[2].(*ast.ForStmt)
+	assert.Equal(t, "9", loop.Cond.(*ast.BinaryExpr).RValue.(*ast.BasicLit).Value)
+	assign := loop.Body.List[0].(*ast.ExprStmt).Val.(*ast.AssignExpr)
+	assert.Equal(t, "10", assign.RValue.(*ast.BinaryExpr).RValue.(*ast.BasicLit).Value)
+
+	// n is shadowed by the parameter
+	ret := decls[2].(*ast.FuncDecl).Body.List[0].(*ast.ReturnStmt)
+	assert.Equal(t, "n", ret.Value.(*ast.BinaryExpr).LValue.(*ast.Ident).Name)
+}
+
+func TestFoldEnum(t *testing.T) {
+	decls := parseAndFold(`const int base = 10
+		enum Color { RED, GREEN = base * 2, BLUE }
+		func f() int {
+			Color c = BLUE
+			return GREEN + 1
+		}
+	`)
+
+	var values []string
+	for _, member := range decls[1].(*ast.EnumDecl).Members {
+		values = append(values, member.Value.(*ast.BasicLit).Value)
+	}
+	assert.Equal(t, []string{"0", "20", "21"}, values)
+
+	// Members keep their name, expressions of them are folded
+	body := decls[2].(*ast.FuncDecl).Body.List
+	assert.Equal(t, "BLUE", body[0].(*ast.VarDeclStmt).RValue.(*ast.Ident).Name)
+	assert.Equal(t, "21", body[1].(*ast.ReturnStmt).Value.(*ast.BasicLit).Value)
+
+	assert.Panics(t, func() {
+		parseAndFold("enum Color { RED = 1.5 }")
+	})
+}
+
+func TestFoldSwitch(t *testing.T) {
+	parser := initParser(`enum Color { RED, GREEN, BLUE, CYAN = 1 }
+		const int two = 2
+		func f(Color c, int n) {
+			switch (c) {
+			case RED:
+			}
+			switch (c) {
+			case RED, GREEN:
+			default:
+			}
+			switch (n) {
+			case two - 1, two:
+			}
+		}
+	`)
+	parser.Parse()
+	warnings := foldConstants(parser.decls)
+	assert.Equal(t, []string{"switch on Color misses GREEN, BLUE"}, warnings)
+
+	body := parser.decls[2].(*ast.FuncDecl).Body.List
+	list := body[2].(*ast.SwitchStmt).Body[0].List
+	assert.Equal(t, "1", list[0].(*ast.BasicLit).Value)
+	assert.Equal(t, "2", list[1].(*ast.BasicLit).Value)
+
+	for _, src := range []string{
+		`func f(int n) {
+			switch (n) {
+			case 1, 2:
+			case 3, 1:
+			}
+		}`,
+		`enum Color { RED, GREEN, CYAN = 1 }
+		func f(Color c) {
+			switch (c) {
+			case GREEN, CYAN:
+			}
+		}`,
+		`func f(int n) {
+			switch (n) {
+			case n:
+			}
+		}`,
+		`func f(int n) {
+			switch (n) {
+			case 1.5:
+			}
+		}`,
+	} {
+		assert.Panics(t, func() {
+			parseAndFold(src)
+		}, src)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/rabierre/compiler/cache"
+)
+
+type Error struct {
+	File string
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return e.File + ": " + e.Msg
+}
+
+type ErrorList []*Error
+
+func (l *ErrorList) Add(file, msg string) {
+	*l = append(*l, &Error{File: file, Msg: msg})
+}
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Err returns nil for an empty list so callers can write `if err := l.Err()`.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+type Source struct {
+	Name string
+	Src  []byte
+}
+
+// parseFiles parses sources with at most workers goroutines.
+// Results are merged in the order of srcs, so declarations and errors
+// don't depend on scheduling.
+func parseFiles(srcs []Source, workers int, debug bool) ([]ast.Decl, ErrorList) {
+	files, _, errs := parseAll(srcs, workers, debug)
+
+	var decls []ast.Decl
+	entries := make([]*cache.Entry, len(srcs))
+	for i, file := range files {
+		entries[i] = newEntry(srcs[i].Name, file, nil, errs[i])
+		if file != nil {
+			decls = append(decls, file.Decls...)
+		}
+	}
+
+	_, list := link(entries)
+	return decls, list
+}
+
+func parseAll(srcs []Source, workers int, debug bool) ([]*ast.File, [][]string, []error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(srcs) {
+		workers = len(srcs)
+	}
+
+	files := make([]*ast.File, len(srcs))
+	warnings := make([][]string, len(srcs))
+	errs := make([]error, len(srcs))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				files[i], warnings[i], errs[i] = parseSource(srcs[i], debug)
+			}
+		}()
+	}
+	for i := range srcs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return files, warnings, errs
+}
+
+// parseSource parses a single file. Parser reports errors by panic,
+// so they are recovered here not to take down the other workers.
+func parseSource(src Source, debug bool) (file *ast.File, warnings []string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			file, warnings = nil, nil
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	p := Parser{debug: debug}
+	p.Init(src.Src)
+	file = p.ParseFile(src.Name)
+	warnings = foldConstants(file.Decls)
+	return file, warnings, nil
+}
+
+// newEntry summarizes what linking needs to know about a parsed file.
+// Signatures of imports are filled in by link.
+func newEntry(name string, file *ast.File, warnings []string, err error) *cache.Entry {
+	entry := &cache.Entry{Name: name, Warnings: warnings}
+	if err != nil {
+		entry.Err = err.Error()
+		return entry
+	}
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			sym := cache.Symbol{Name: d.Name.Name, Sig: signature(d), Kind: "func", Type: ast.TypeName(d.Type, d.TypeName)}
+			for _, p := range d.Params.List {
+				param := p.(*ast.VarDeclStmt)
+				sym.Params = append(sym.Params, ast.TypeName(param.Type, param.TypeName))
+			}
+			entry.Exports = append(entry.Exports, sym)
+		case *ast.ConstDecl:
+			// Value is part of the signature, so users are rebuilt when it changes
+			sig := "const " + d.Type.String() + " " + d.Name.Name + " = " + d.Value.(*ast.BasicLit).Value
+			entry.Exports = append(entry.Exports, cache.Symbol{Name: d.Name.Name, Sig: sig, Kind: "const", Type: d.Type.String()})
+		case *ast.EnumDecl:
+			sig := enumSignature(d)
+			entry.Exports = append(entry.Exports, cache.Symbol{Name: d.Name.Name, Sig: sig, Kind: "type", Type: d.Name.Name})
+			for _, member := range d.Members {
+				entry.Exports = append(entry.Exports, cache.Symbol{Name: member.Name.Name, Sig: sig, Kind: "const", Type: d.Name.Name})
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, id := range file.UnResolved {
+		if !seen[id.Name] {
+			seen[id.Name] = true
+			entry.Imports = append(entry.Imports, cache.Symbol{Name: id.Name})
+		}
+	}
+	return entry
+}
+
+func signature(fn *ast.FuncDecl) string {
+	var buf bytes.Buffer
+	buf.WriteString(ast.TypeName(fn.Type, fn.TypeName))
+	buf.WriteByte(' ')
+	buf.WriteString(fn.Name.Name)
+	buf.WriteByte('(')
+	for i, p := range fn.Params.List {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		d := p.(*ast.VarDeclStmt)
+		buf.WriteString(ast.TypeName(d.Type, d.TypeName))
+	}
+	buf.WriteByte(')')
+	return buf.String()
+}
+
+// enum Color { RED = 0, GREEN = 1 }
+func enumSignature(d *ast.EnumDecl) string {
+	var buf bytes.Buffer
+	buf.WriteString("enum ")
+	buf.WriteString(d.Name.Name)
+	buf.WriteString(" {")
+	for i, member := range d.Members {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(" " + member.Name.Name + " = " + member.Value.(*ast.BasicLit).Value)
+	}
+	buf.WriteString(" }")
+	return buf.String()
+}
+
+// link declares exports of every file in one package scope, then checks
+// what each file imports against it. It returns signatures by name.
+// Errors are reported grouped by file in the order of entries.
+func link(entries []*cache.Entry) (map[string]cache.Symbol, ErrorList) {
+	perFile := make([]ErrorList, len(entries))
+	pkg := map[string]cache.Symbol{}
+
+	// Files failing to parse export nothing, but ones failing later
+	// still declare their names so users don't report them undefined
+	for i, entry := range entries {
+		if entry.Err != "" {
+			perFile[i].Add(entry.Name, entry.Err)
+		}
+		for _, sym := range entry.Exports {
+			if _, exist := pkg[sym.Name]; exist {
+				perFile[i].Add(entry.Name, "redeclared: "+sym.Name)
+				continue
+			}
+			pkg[sym.Name] = sym
+		}
+	}
+
+	var list ErrorList
+	for i, entry := range entries {
+		for _, sym := range entry.Imports {
+			if _, exist := pkg[sym.Name]; !exist {
+				perFile[i].Add(entry.Name, "undefined: "+sym.Name)
+			}
+		}
+		list = append(list, perFile[i]...)
+	}
+
+	return pkg, list
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+func driverSources() []Source {
+	return []Source{
+		{"a.txt", []byte(`func a() {
+			b()
+		}
+		`)},
+		{"b.txt", []byte(`func b() {
+			c(1)
+		}
+		`)},
+		{"c.txt", []byte(`func c(int x) int {
+			return x
+		}
+		func d() {
+			a()
+		}
+		`)},
+	}
+}
+
+func declNames(decls []ast.Decl) []string {
+	var names []string
+	for _, decl := range decls {
+		names = append(names, decl.(*ast.FuncDecl).Name.Name)
+	}
+	return names
+}
+
+func TestParseFiles(t *testing.T) {
+	for _, workers := range []int{1, 2, 8} {
+		for i := 0; i < 10; i++ {
+			decls, errs := parseFiles(driverSources(), workers, false)
+			assert.Nil(t, errs.Err())
+			assert.Equal(t, []string{"a", "b", "c", "d"}, declNames(decls))
+		}
+	}
+}
+
+func TestParseFilesErrors(t *testing.T) {
+	srcs := append(driverSources(),
+		Source{"e.txt", []byte(`func e() {
+			f()
+			g()
+		}
+		`)},
+		Source{"f.txt", []byte(`func a() {}
+		`)},
+		Source{"g.txt", []byte(`func g( {}
+		`)},
+	)
+
+	expect := []string{
+		"e.txt: undefined: f",
+		"e.txt: undefined: g", // g.txt failed to parse
+		"f.txt: redeclared: a",
+		"g.txt: Expected: ) Found: {, {",
+	}
+	for _, workers := range []int{1, 3, 8} {
+		_, errs := parseFiles(srcs, workers, false)
+		var msgs []string
+		for _, e := range errs {
+			msgs = append(msgs, e.Error())
+		}
+		assert.Equal(t, expect, msgs)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/rabierre/compiler/format"
+)
+
+// fmtCommand formats files and prints them, or with -w writes them back.
+// With -d it prints what formatting changes instead. Standard input is
+// formatted if there are no files. It returns the exit status.
+func fmtCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	write := flags.Bool("w", false, "write result to the source file instead of standard output")
+	diff := flags.Bool("d", false, "print diffs instead of formatted sources")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(stderr, "cannot use -w with standard input")
+			return 2
+		}
+		src, err := ioutil.ReadAll(stdin)
+		if err == nil {
+			err = formatFile("<standard input>", src, false, *diff, stdout)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	status := 0
+	for _, name := range flags.Args() {
+		src, err := ioutil.ReadFile(name)
+		if err == nil {
+			err = formatFile(name, src, *write, *diff, stdout)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func formatFile(name string, src []byte, write, diff bool, out io.Writer) error {
+	res, err := formatSource(src)
+	if err != nil {
+		return &Error{File: name, Msg: err.Error()}
+	}
+
+	if diff {
+		out.Write(format.Diff(name, src, res))
+	}
+	if write {
+		if bytes.Equal(src, res) {
+			return nil
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(name, res, info.Mode().Perm())
+	}
+	if !diff {
+		out.Write(res)
+	}
+	return nil
+}
+
+// formatSource returns src formatted
+func formatSource(src []byte) ([]byte, error) {
+	file, err := parseTree("", src)
+	if err != nil {
+		return nil, err
+	}
+	return format.File(src, file)
+}
+
+// parseTree returns the syntax tree of src as parsed, constants are not
+// folded. Names need not be declared, the file is not compiled.
+func parseTree(name string, src []byte) (file *ast.File, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	p := Parser{}
+	p.Init(src)
+	return p.ParseFile(name), nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const unformatted = `// Shapes
+
+
+enum Shape {
+  SQUARE, // four
+  // round
+  CIRCLE
+}
+const int N = 10 // ten
+func sum(int n) int { int s = 0 for (int i = 0; i < n ; i++) { s = s+i*2 } return s } // trailing
+func pick(Shape a) int {   // opens
+	switch (a) {
+	// first
+	case SQUARE:   a = f( -1)
+	  fallthrough
+
+
+	default: // other
+		a = - -a
+		// end of default
+	}
+	if (a > 1) {} else { return a }
+	return a
+}
+`
+
+const formatted = `// Shapes
+
+enum Shape {
+    SQUARE, // four
+    // round
+    CIRCLE,
+}
+const int N = 10 // ten
+
+func sum(int n) int {
+    int s = 0
+    for (int i = 0; i < n; i++) {
+        s = s + i * 2
+    }
+    return s
+} // trailing
+
+func pick(Shape a) int { // opens
+    switch (a) {
+    // first
+    case SQUARE:
+        a = f(-1)
+        fallthrough
+
+    default: // other
+        a = - -a
+        // end of default
+    }
+    if (a > 1) {} else {
+        return a
+    }
+    return a
+}
+`
+
+func TestFormatSource(t *testing.T) {
+	out, err := formatSource([]byte(unformatted))
+	assert.Nil(t, err)
+	assert.Equal(t, formatted, string(out))
+
+	out, err = formatSource([]byte(formatted))
+	assert.Nil(t, err)
+	assert.Equal(t, formatted, string(out))
+
+	src, err := ioutil.ReadFile("testdata/input.txt")
+	assert.Nil(t, err)
+	out, err = formatSource(src)
+	assert.Nil(t, err)
+	again, err := formatSource(out)
+	assert.Nil(t, err)
+	assert.Equal(t, string(out), string(again))
+
+	out, err = formatSource([]byte("/* a\n  /* b */\n*/\nfunc f() { int x = 1 /* one */ x = f( /* no */ ) }\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "/* a\n  /* b */\n*/\nfunc f() {\n    int x = 1 /* one */\n    x = f() /* no */\n}\n", string(out))
+
+	_, err = formatSource([]byte("func f( {"))
+	assert.NotNil(t, err)
+	_, err = formatSource([]byte("func f() {}\n/* open"))
+	assert.EqualError(t, err, "Unterminated comment from line 2")
+}
+
+func TestFmtCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fmt")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "a.txt")
+	assert.Nil(t, ioutil.WriteFile(name, []byte(unformatted), 0644))
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, fmtCommand([]string{"-d", name}, nil, &stdout, &stderr))
+	assert.True(t, strings.HasPrefix(stdout.String(), "--- "+name+".orig\n+++ "+name+"\n@@ -1,24 +1,33 @@\n // Shapes\n \n-\n"))
+
+	stdout.Reset()
+	assert.Equal(t, 0, fmtCommand([]string{"-w", name}, nil, &stdout, &stderr))
+	assert.Equal(t, "", stdout.String())
+	src, err := ioutil.ReadFile(name)
+	assert.Nil(t, err)
+	assert.Equal(t, formatted, string(src))
+
+	assert.Equal(t, 0, fmtCommand([]string{"-d", name}, nil, &stdout, &stderr))
+	assert.Equal(t, "", stdout.String())
+
+	assert.Equal(t, 0, fmtCommand(nil, strings.NewReader("const  int N =   1"), &stdout, &stderr))
+	assert.Equal(t, "const int N = 1\n", stdout.String())
+
+	assert.Equal(t, 1, fmtCommand([]string{filepath.Join(dir, "none.txt")}, nil, &stdout, &stderr))
+	stderr.Reset()
+	assert.Equal(t, 2, fmtCommand([]string{"-w"}, nil, &stdout, &stderr))
+	assert.Equal(t, "cannot use -w with standard input\n", stderr.String())
+}
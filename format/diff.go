@@ -0,0 +1,130 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Lines of context around changes in a hunk
+const context = 3
+
+// Diff returns the changes from old to new in unified format, nothing if
+// they are equal. name is of the file in the headers.
+func Diff(name string, old, new []byte) []byte {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+	edits := diffLines(lines(old), lines(new))
+
+	// Lines of old and new before each edit
+	a, b := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	for i, e := range edits {
+		a[i+1], b[i+1] = a[i], b[i]
+		if e.op != '+' {
+			a[i+1]++
+		}
+		if e.op != '-' {
+			b[i+1]++
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s.orig\n+++ %s\n", name, name)
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+
+		// Changes less than two contexts apart share a hunk
+		start, end := i-context, i
+		if start < 0 {
+			start = 0
+		}
+		for j := i; j < len(edits) && j <= end+2*context+1; j++ {
+			if edits[j].op != ' ' {
+				end = j
+			}
+		}
+		if end += context + 1; end > len(edits) {
+			end = len(edits)
+		}
+
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(a[start], a[end]), hunkRange(b[start], b[end]))
+		for _, e := range edits[start:end] {
+			buf.WriteByte(e.op)
+			buf.WriteString(e.text)
+			if !strings.HasSuffix(e.text, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return buf.Bytes()
+}
+
+// hunkRange returns lines from up to to as a hunk header has them, from 1
+func hunkRange(from, to int) string {
+	switch to - from {
+	case 0:
+		return fmt.Sprintf("%d,0", from)
+	case 1:
+		return fmt.Sprint(from + 1)
+	}
+	return fmt.Sprintf("%d,%d", from+1, to-from)
+}
+
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	text string
+}
+
+// diffLines returns edits making b of a through their longest common
+// subsequence, removals first
+func diffLines(a, b []string) []edit {
+	// lcs[i][j] is the length of the one of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	return edits
+}
+
+// lines splits text after newlines
+func lines(text []byte) []string {
+	list := strings.SplitAfter(string(text), "\n")
+	if list[len(list)-1] == "" {
+		list = list[:len(list)-1]
+	}
+	return list
+}
@@ -0,0 +1,415 @@
+// Package format prints parsed source files in one layout: a statement
+// per line, blocks indented by four spaces, single spaces around binary
+// operators and after commas.
+//
+// Comments are printed where they were, on a line of their own or after
+// the code of a line. A blank line is kept where the source has one, more
+// are joined. Functions are separated from other declarations by a blank
+// line. Formatting a formatted file changes nothing.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/rabierre/compiler/token"
+)
+
+const indent = "    "
+
+// File returns file formatted. src is the source file was parsed from,
+// positions of its nodes and comments are offsets in it.
+func File(src []byte, file *ast.File) ([]byte, error) {
+	p := &printer{src: src, lines: []int{0}, first: true}
+	for i, ch := range src {
+		if ch == '\n' {
+			p.lines = append(p.lines, i+1)
+		}
+	}
+	if file.Comments != nil {
+		p.comments = file.Comments.List
+	}
+
+	for i, decl := range file.Decls {
+		if i > 0 {
+			_, prev := file.Decls[i-1].(*ast.FuncDecl)
+			_, this := decl.(*ast.FuncDecl)
+			p.force = prev || this
+		}
+		p.decl(decl)
+	}
+	p.flush(len(src) + 1)
+
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.buf.Bytes(), nil
+}
+
+type printer struct {
+	src      []byte
+	lines    []int          // offsets lines of src start at
+	comments []*ast.Comment // not printed yet
+
+	buf    bytes.Buffer
+	indent int
+	first  bool // nothing printed in the block yet
+	force  bool // blank line before what is printed next
+	err    error
+}
+
+// text writes s, indented if it starts a line
+func (p *printer) text(s string) {
+	if p.buf.Len() == 0 || p.buf.Bytes()[p.buf.Len()-1] == '\n' {
+		p.buf.WriteString(strings.Repeat(indent, p.indent))
+	}
+	p.buf.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+}
+
+func (p *printer) bad(pos int) {
+	if p.err == nil {
+		p.err = fmt.Errorf("line %d: cannot format invalid syntax", p.line(pos)+1)
+	}
+}
+
+//--------------------------------------------------------------------------------------
+// Layout
+//
+
+// line returns the line of pos, counted from 0
+func (p *printer) line(pos int) int {
+	return sort.SearchInts(p.lines, pos+1) - 1
+}
+
+func (p *printer) column(pos int) int {
+	return pos - p.lines[p.line(pos)]
+}
+
+// trailing tells if code precedes pos on its line
+func (p *printer) trailing(pos int) bool {
+	start := p.lines[p.line(pos)]
+	return len(bytes.TrimSpace(p.src[start:pos])) > 0
+}
+
+// blankBefore tells if pos starts its line and the line above is blank
+func (p *printer) blankBefore(pos int) bool {
+	n := p.line(pos)
+	if n == 0 || p.trailing(pos) {
+		return false
+	}
+	return len(bytes.TrimSpace(p.src[p.lines[n-1]:p.lines[n]])) == 0
+}
+
+// separate starts a line at pos, after a blank one if the source has one
+func (p *printer) separate(pos int) {
+	if !p.first && (p.force || p.blankBefore(pos)) {
+		p.newline()
+	}
+	p.first, p.force = false, false
+}
+
+// flush prints comments before pos. One after code goes at the end of the
+// line printed last.
+func (p *printer) flush(pos int) {
+	for p.commentBefore(pos) {
+		p.comment()
+	}
+}
+
+// flushDeeper prints comments before pos indented more than it, those
+// of a block closed at pos
+func (p *printer) flushDeeper(pos int) {
+	for p.commentBefore(pos) && p.column(p.comments[0].Pos) > p.column(pos) {
+		p.comment()
+	}
+}
+
+// comment prints the first comment not printed yet
+func (p *printer) comment() {
+	c := p.comments[0]
+	p.comments = p.comments[1:]
+	if p.trailing(c.Pos) && p.buf.Len() > 0 {
+		p.buf.Truncate(p.buf.Len() - 1)
+		p.buf.WriteString(" " + c.Text)
+	} else {
+		p.separate(c.Pos)
+		p.text(c.Text)
+	}
+	p.newline()
+}
+
+func (p *printer) commentBefore(pos int) bool {
+	return len(p.comments) > 0 && p.comments[0].Pos < pos
+}
+
+// start begins a line of the node at pos, comments before it go first
+func (p *printer) start(pos int) {
+	p.flush(pos)
+	p.separate(pos)
+}
+
+// braces writes header and list in braces, {} if there is nothing in them
+func (p *printer) braces(header string, list []ast.Stmt, rbrace int) {
+	if header != "" {
+		header += " "
+	}
+	if len(list) == 0 && !p.commentBefore(rbrace) {
+		p.text(header + "{}")
+		return
+	}
+
+	p.text(header + "{")
+	p.newline()
+	p.indent++
+	p.first = true
+	for _, s := range list {
+		p.stmt(s)
+	}
+	p.flush(rbrace)
+	p.indent--
+	p.first = false
+	p.text("}")
+}
+
+//--------------------------------------------------------------------------------------
+// Declaration
+//
+
+func (p *printer) decl(decl ast.Decl) {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		p.start(d.Pos)
+		params := make([]string, len(d.Params.List))
+		for i, param := range d.Params.List {
+			params[i] = p.simple(param)
+		}
+		header := fmt.Sprintf("func %s(%s)", d.Name.Name, strings.Join(params, ", "))
+		if d.Type != token.VOID {
+			header += " " + ast.TypeName(d.Type, d.TypeName)
+		}
+		p.braces(header, d.Body.List, d.Body.RBracePos)
+		p.newline()
+	case *ast.ConstDecl:
+		p.start(d.Pos)
+		p.text(p.simple(d))
+		p.newline()
+	case *ast.EnumDecl:
+		p.start(d.Pos)
+		p.enum(d)
+		p.newline()
+	}
+}
+
+// An enum written on lines is printed a member per line
+func (p *printer) enum(d *ast.EnumDecl) {
+	members := make([]string, len(d.Members))
+	for i, m := range d.Members {
+		members[i] = m.Name.Name
+		if m.Value != nil {
+			members[i] += " = " + p.expr(m.Value)
+		}
+	}
+
+	switch {
+	case len(members) == 0 && !p.commentBefore(d.RBracePos):
+		p.text("enum " + d.Name.Name + " {}")
+	case p.line(d.Pos) == p.line(d.RBracePos) && !p.commentBefore(d.RBracePos):
+		p.text("enum " + d.Name.Name + " { " + strings.Join(members, ", ") + " }")
+	default:
+		p.text("enum " + d.Name.Name + " {")
+		p.newline()
+		p.indent++
+		p.first = true
+		for i, m := range d.Members {
+			p.start(m.Name.Pos)
+			p.text(members[i] + ",")
+			p.newline()
+		}
+		p.flush(d.RBracePos)
+		p.indent--
+		p.first = false
+		p.text("}")
+	}
+}
+
+//--------------------------------------------------------------------------------------
+// Statement
+//
+
+func (p *printer) stmt(stmt ast.Stmt) {
+	if _, ok := stmt.(*ast.EmptyStmt); ok {
+		return
+	}
+	p.start(pos(stmt))
+
+	switch s := stmt.(type) {
+	case *ast.CompoundStmt:
+		p.braces("", s.List, s.RBracePos)
+	case *ast.IfStmt:
+		p.braces("if ("+p.expr(s.Cond)+")", s.Body.List, s.Body.RBracePos)
+		if s.ElseBody != nil {
+			body := s.ElseBody.(*ast.CompoundStmt)
+			p.braces(" else", body.List, body.RBracePos)
+		}
+	case *ast.ForStmt:
+		header := fmt.Sprintf("for (%s; %s; %s)", p.simple(s.Init), p.expr(s.Cond), p.expr(s.Post))
+		p.braces(header, s.Body.List, s.Body.RBracePos)
+	case *ast.SwitchStmt:
+		p.switchStmt(s)
+	default:
+		p.text(p.simple(stmt))
+	}
+	p.newline()
+}
+
+// Cases are indented as the switch, their statements one more
+func (p *printer) switchStmt(s *ast.SwitchStmt) {
+	header := "switch (" + p.expr(s.Tag) + ")"
+	if len(s.Body) == 0 && !p.commentBefore(s.RBracePos) {
+		p.text(header + " {}")
+		return
+	}
+
+	p.text(header + " {")
+	p.newline()
+	p.first = true
+	for i, clause := range s.Body {
+		p.start(clause.Pos)
+		if clause.List == nil {
+			p.text("default:")
+		} else {
+			p.text("case " + p.exprList(clause.List) + ":")
+		}
+		p.newline()
+
+		p.indent++
+		p.first = true
+		for _, stmt := range clause.Body {
+			p.stmt(stmt)
+		}
+		if i+1 < len(s.Body) {
+			p.flushDeeper(s.Body[i+1].Pos)
+		} else {
+			p.flushDeeper(s.RBracePos)
+		}
+		p.indent--
+		p.first = false
+	}
+	p.flush(s.RBracePos)
+	p.first = false
+	p.text("}")
+}
+
+// simple returns a statement written on one line
+func (p *printer) simple(stmt ast.Stmt) string {
+	switch s := stmt.(type) {
+	case *ast.VarDeclStmt:
+		text := ast.TypeName(s.Type, s.TypeName) + " " + s.Name.Name
+		if s.RValue != nil {
+			text += " = " + p.expr(s.RValue)
+		}
+		return text
+	case *ast.ConstDecl:
+		return fmt.Sprintf("const %s %s = %s", s.Type, s.Name.Name, p.expr(s.Value))
+	case *ast.ExprStmt:
+		return p.expr(s.Val)
+	case *ast.ReturnStmt:
+		if s.Value == nil {
+			return "return"
+		}
+		return "return " + p.expr(s.Value)
+	case *ast.FallthroughStmt:
+		return "fallthrough"
+	}
+	p.bad(pos(stmt))
+	return ""
+}
+
+//--------------------------------------------------------------------------------------
+// Expression
+//
+
+// Expressions have no parentheses, the parser takes none
+func (p *printer) expr(x ast.Expr) string {
+	switch e := x.(type) {
+	case *ast.BasicLit:
+		return e.Value
+	case *ast.Ident:
+		return e.Name
+	case *ast.BinaryExpr:
+		return p.expr(e.LValue) + " " + e.Op.Type.String() + " " + p.expr(e.RValue)
+	case *ast.UnaryExpr:
+		operand := p.expr(e.RValue)
+		if strings.HasPrefix(operand, "-") || strings.HasPrefix(operand, "+") {
+			// - -x, not --x
+			operand = " " + operand
+		}
+		return e.Op.Type.String() + operand
+	case *ast.ShortExpr:
+		return p.expr(e.RValue) + e.Op.Type.String()
+	case *ast.CallExpr:
+		return p.expr(e.Name) + "(" + p.exprList(e.Params.List) + ")"
+	case *ast.AssignExpr:
+		return p.expr(e.LValue) + " = " + p.expr(e.RValue)
+	}
+	p.bad(pos(x))
+	return ""
+}
+
+func (p *printer) exprList(list []ast.Expr) string {
+	texts := make([]string, len(list))
+	for i, x := range list {
+		texts[i] = p.expr(x)
+	}
+	return strings.Join(texts, ", ")
+}
+
+// pos returns where n starts in the source
+func pos(n ast.Node) int {
+	switch x := n.(type) {
+	case *ast.VarDeclStmt:
+		return x.Pos
+	case *ast.ConstDecl:
+		return x.Pos
+	case *ast.IfStmt:
+		return x.Pos
+	case *ast.ForStmt:
+		return x.Pos
+	case *ast.SwitchStmt:
+		return x.Pos
+	case *ast.ReturnStmt:
+		return x.Pos
+	case *ast.FallthroughStmt:
+		return x.Pos
+	case *ast.CompoundStmt:
+		return x.LBracePos
+	case *ast.ExprStmt:
+		return pos(x.Val)
+	case *ast.BadStmt:
+		return x.From
+	case *ast.BasicLit:
+		return x.Pos
+	case *ast.Ident:
+		return x.Pos
+	case *ast.UnaryExpr:
+		return x.Pos
+	case *ast.AssignExpr:
+		return x.Pos
+	case *ast.BinaryExpr:
+		return pos(x.LValue)
+	case *ast.ShortExpr:
+		return pos(x.RValue)
+	case *ast.CallExpr:
+		return pos(x.Name)
+	case *ast.BadExpr:
+		return x.From
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/rabierre/compiler/token"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFile(t *testing.T) {
+	src := "const int N=1 // one\n\n\n// two\nfunc f() { x }"
+	file := &ast.File{
+		Decls: []ast.Decl{
+			&ast.ConstDecl{Pos: 0, Type: token.INT, Name: &ast.Ident{Pos: 10, Name: "N"}, Value: &ast.BasicLit{Pos: 12, Value: "1", Type: token.INT_LIT}},
+			&ast.FuncDecl{Pos: 30, Name: &ast.Ident{Pos: 35, Name: "f"}, Type: token.VOID, Params: &ast.StmtList{}, Body: &ast.CompoundStmt{
+				LBracePos: 39, RBracePos: 43, List: []ast.Stmt{&ast.ExprStmt{Val: &ast.Ident{Pos: 41, Name: "x"}}},
+			}},
+		},
+		Comments: &ast.CommentList{List: []*ast.Comment{{Pos: 14, Text: "// one"}, {Pos: 23, Text: "// two"}}},
+	}
+	out, err := File([]byte(src), file)
+	assert.Nil(t, err)
+	assert.Equal(t, "const int N = 1 // one\n\n// two\nfunc f() {\n    x\n}\n", string(out))
+
+	file.Decls[1].(*ast.FuncDecl).Body.List[0] = &ast.BadStmt{From: 41}
+	_, err = File([]byte(src), file)
+	assert.EqualError(t, err, "line 5: cannot format invalid syntax")
+}
+
+func TestDiff(t *testing.T) {
+	assert.Nil(t, Diff("a", []byte("x\n"), []byte("x\n")))
+
+	var old, new []string
+	for i := 0; i < 20; i++ {
+		old = append(old, string(rune('a'+i)))
+	}
+	new = append(new, old...)
+	new[1] = "B"
+	new = append(new[:15], new[16:]...)
+	want := strings.Join([]string{
+		"--- f.orig",
+		"+++ f",
+		"@@ -1,5 +1,5 @@",
+		" a",
+		"-b",
+		"+B",
+		" c",
+		" d",
+		" e",
+		"@@ -13,7 +13,6 @@",
+		" m",
+		" n",
+		" o",
+		"-p",
+		" q",
+		" r",
+		" s",
+		"",
+	}, "\n")
+	assert.Equal(t, want, string(Diff("f", []byte(strings.Join(old, "\n")+"\n"), []byte(strings.Join(new, "\n")+"\n"))))
+
+	// Changes five lines apart share a hunk
+	new = append(append([]string{}, old...), "u")
+	new[14] = "O"
+	assert.Equal(t, "@@ -12,9 +12,10 @@\n", strings.SplitAfter(string(Diff("f", []byte(strings.Join(old, "\n")+"\n"), []byte(strings.Join(new, "\n")+"\n"))), "\n")[2])
+
+	assert.Equal(t, "--- f.orig\n+++ f\n@@ -1 +1 @@\n-x\n\\ No newline at end of file\n+x\n", string(Diff("f", []byte("x"), []byte("x\n"))))
+}
@@ -0,0 +1,321 @@
+// Package golang emits a Go package from the IR, so programs can be
+// called from Go code. Ints are int64 and doubles float64, conversions
+// C implies are written out.
+//
+// Functions keep their names and get an exported wrapper, so sum is
+// called from other packages as Sum. Blocks become labels and gotos as
+// in C output, variables are declared first so no goto jumps over a
+// declaration.
+package golang
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rabierre/compiler/ir"
+)
+
+// Emit returns declarations of constants and functions of prog. The
+// caller puts declarations of every file in one package.
+func Emit(prog *ir.Program) string {
+	var buf bytes.Buffer
+	for _, c := range prog.Consts {
+		if isFinite(c.Value) {
+			fmt.Fprintf(&buf, "const %s %s = %s\n", name(c.Name), goType(c.Value.Typ), constant(c.Value))
+		} else {
+			// math.Inf and math.NaN are no constants
+			fmt.Fprintf(&buf, "var %s = %s\n", name(c.Name), constant(c.Value))
+		}
+	}
+	for _, f := range prog.Funcs {
+		buf.WriteByte('\n')
+		g := &gen{buf: &buf, prog: prog, f: f}
+		g.function()
+		g.wrapper()
+	}
+	return buf.String()
+}
+
+// File makes a gofmt-ed source file of package pkg from declarations
+// returned by Emit
+func File(pkg, decls string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by compiler. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	if strings.Contains(decls, "math.") {
+		buf.WriteString("import \"math\"\n\n")
+	}
+	buf.WriteString(decls)
+	buf.WriteString(runtime)
+	return format.Source(buf.Bytes())
+}
+
+// Comparisons have int results
+const runtime = `
+func _b2i(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+`
+
+type gen struct {
+	buf  *bytes.Buffer
+	prog *ir.Program
+	f    *ir.Func
+}
+
+func (g *gen) line(format string, args ...interface{}) {
+	fmt.Fprintf(g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+// func sum(n int64) int64
+func (g *gen) signature(fn string) string {
+	params := make([]string, len(g.f.Params))
+	for i, p := range g.f.Params {
+		params[i] = name(p.Name) + " " + goType(p.Typ)
+	}
+	return fmt.Sprintf("func %s(%s) %s", fn, strings.Join(params, ", "), goType(g.f.Result))
+}
+
+// Variables are declared first. Go rejects variables never read, those
+// are assigned to the blank identifier.
+func (g *gen) function() {
+	g.line("%s {", g.signature(name(g.f.Name)))
+
+	var vars []ir.Value
+	for _, v := range g.f.Locals {
+		vars = append(vars, v)
+	}
+	for _, b := range g.f.Blocks {
+		for _, instr := range b.Instrs {
+			if t, ok := ir.Defs(instr).(*ir.Temp); ok {
+				vars = append(vars, t)
+			}
+		}
+	}
+	read := map[ir.Value]bool{}
+	for _, b := range g.f.Blocks {
+		for _, instr := range b.Instrs {
+			for _, use := range ir.Uses(instr) {
+				read[*use] = true
+			}
+		}
+	}
+	for _, v := range vars {
+		g.line("var %s %s", value(v), goType(v.Type()))
+		if !read[v] {
+			g.line("_ = %s", value(v))
+		}
+	}
+
+	labels := jumpTargets(g.f)
+	for i, b := range g.f.Blocks {
+		var next *ir.Block
+		if i+1 < len(g.f.Blocks) {
+			next = g.f.Blocks[i+1]
+		}
+		if labels[b] {
+			g.line("%s:", b.Name())
+		}
+		for _, instr := range b.Instrs {
+			g.instr(instr, next)
+		}
+	}
+	g.line("}")
+}
+
+// wrapper exports the function under its name with a capital letter
+func (g *gen) wrapper() {
+	exported := export(g.f.Name)
+	if exported == g.f.Name {
+		return
+	}
+	if g.prog.Func(exported) != nil {
+		panic(fmt.Sprintf("Exported name of %s is declared: %s", g.f.Name, exported))
+	}
+
+	args := make([]string, len(g.f.Params))
+	for i, p := range g.f.Params {
+		args[i] = name(p.Name)
+	}
+	call := fmt.Sprintf("%s(%s)", name(g.f.Name), strings.Join(args, ", "))
+	g.line("\n// %s calls %s", exported, g.f.Name)
+	g.line("%s {", g.signature(exported))
+	if g.f.Result == ir.Void {
+		g.line("%s", call)
+	} else {
+		g.line("return %s", call)
+	}
+	g.line("}")
+}
+
+// jumpTargets returns blocks instr writes a goto to. Go rejects labels
+// never jumped to.
+func jumpTargets(f *ir.Func) map[*ir.Block]bool {
+	labels := map[*ir.Block]bool{}
+	for i, b := range f.Blocks {
+		var next *ir.Block
+		if i+1 < len(f.Blocks) {
+			next = f.Blocks[i+1]
+		}
+
+		switch t := b.Terminator().(type) {
+		case *ir.Jump:
+			if t.Target != next {
+				labels[t.Target] = true
+			}
+		case *ir.Branch:
+			switch {
+			case t.Else == next:
+				labels[t.Then] = true
+			case t.Then == next:
+				labels[t.Else] = true
+			default:
+				labels[t.Then] = true
+				labels[t.Else] = true
+			}
+		}
+	}
+	return labels
+}
+
+func (g *gen) instr(instr ir.Instr, next *ir.Block) {
+	switch i := instr.(type) {
+	case *ir.Copy:
+		g.line("%s = %s", value(i.Dst), value(i.Src))
+	case *ir.BinOp:
+		expr := fmt.Sprintf("%s %s %s", value(i.X), i.Op, value(i.Y))
+		if i.Op.IsCompare() {
+			expr = "_b2i(" + expr + ")"
+		}
+		g.line("%s = %s", value(i.Dst), expr)
+	case *ir.UnOp:
+		g.line("%s = %s%s", value(i.Dst), i.Op, value(i.X))
+	case *ir.Convert:
+		g.line("%s = %s", value(i.Dst), convert(i.X, i.Dst.Type()))
+	case *ir.Call:
+		args := make([]string, len(i.Args))
+		for j, arg := range i.Args {
+			args[j] = value(arg)
+		}
+		call := fmt.Sprintf("%s(%s)", name(i.Func), strings.Join(args, ", "))
+		if i.Dst != nil {
+			call = value(i.Dst) + " = " + call
+		}
+		g.line("%s", call)
+	case *ir.Jump:
+		if i.Target != next {
+			g.line("goto %s", i.Target.Name())
+		}
+	case *ir.Branch:
+		cond := value(i.Cond)
+		switch {
+		case i.Else == next:
+			g.line("if %s != 0 {\ngoto %s\n}", cond, i.Then.Name())
+		case i.Then == next:
+			g.line("if %s == 0 {\ngoto %s\n}", cond, i.Else.Name())
+		default:
+			g.line("if %s != 0 {\ngoto %s\n}\ngoto %s", cond, i.Then.Name(), i.Else.Name())
+		}
+	case *ir.Return:
+		if i.Value == nil {
+			g.line("return")
+		} else {
+			g.line("return %s", value(i.Value))
+		}
+	default:
+		panic(fmt.Sprintf("Unexpected instruction: %s", instr))
+	}
+}
+
+// convert returns x converted to typ. Go rejects converting a constant
+// double with a fraction to int64, so constants are converted here.
+func convert(x ir.Value, typ ir.Type) string {
+	if x.Type() == typ {
+		return value(x)
+	}
+	if c, ok := x.(*ir.Const); ok {
+		if typ == ir.Double {
+			return value(ir.DoubleConst(float64(c.I)))
+		}
+		return value(ir.IntConst(int64(c.F)))
+	}
+	return fmt.Sprintf("%s(%s)", goType(typ), value(x))
+}
+
+// Temporaries are named with a prefix idents can't have
+func value(v ir.Value) string {
+	switch x := v.(type) {
+	case *ir.Temp:
+		return fmt.Sprintf("_t%d", x.ID)
+	case *ir.Var:
+		return name(x.Name)
+	case *ir.Global:
+		return name(x.Name)
+	case *ir.Const:
+		if s := constant(x); strings.HasPrefix(s, "-") {
+			return "(" + s + ")"
+		}
+		return constant(x)
+	}
+	return v.String()
+}
+
+func constant(c *ir.Const) string {
+	switch {
+	case c.Typ != ir.Double:
+		return c.String()
+	case math.IsNaN(c.F):
+		return "math.NaN()"
+	case math.IsInf(c.F, 1):
+		return "math.Inf(1)"
+	case math.IsInf(c.F, -1):
+		return "math.Inf(-1)"
+	}
+	return c.String()
+}
+
+func isFinite(c *ir.Const) bool {
+	return c.Typ != ir.Double || !math.IsNaN(c.F) && !math.IsInf(c.F, 0)
+}
+
+// Names Go reserves, or the output needs, get a trailing underscore.
+// init can't be called in Go.
+var reserved = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true,
+	"default": true, "defer": true, "else": true, "fallthrough": true, "for": true,
+	"func": true, "go": true, "goto": true, "if": true, "import": true,
+	"interface": true, "map": true, "package": true, "range": true, "return": true,
+	"select": true, "struct": true, "switch": true, "type": true, "var": true,
+	"int64": true, "float64": true, "math": true, "init": true,
+}
+
+func name(s string) string {
+	if reserved[s] {
+		return s + "_"
+	}
+	return s
+}
+
+func export(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[n:]
+}
+
+func goType(typ ir.Type) string {
+	switch typ {
+	case ir.Int:
+		return "int64"
+	case ir.Double:
+		return "float64"
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package golang
+
+import (
+	"math"
+	"testing"
+
+	"github.com/rabierre/compiler/ir"
+	"github.com/stretchr/testify/assert"
+)
+
+// half builds func half(int a) double { return a / 2.0 }
+func half() *ir.Func {
+	a := &ir.Var{Name: "a", Typ: ir.Int}
+	f := &ir.Func{Name: "half", Result: ir.Double, Params: []*ir.Var{a}}
+	x, y := f.NewTemp(ir.Double), f.NewTemp(ir.Double)
+	f.NewBlock().Instrs = []ir.Instr{
+		&ir.Convert{Dst: x, X: a},
+		&ir.BinOp{Dst: y, Op: ir.Div, X: x, Y: ir.DoubleConst(2)},
+		&ir.Return{Value: y},
+	}
+	f.ComputeCFG()
+	return f
+}
+
+func TestFile(t *testing.T) {
+	prog := &ir.Program{
+		Funcs:  []*ir.Func{half()},
+		Consts: []*ir.Constant{{Name: "N", Value: ir.IntConst(-1)}, {Name: "type", Value: ir.DoubleConst(math.Inf(1))}},
+	}
+	src, err := File("p", Emit(prog))
+	assert.Nil(t, err)
+	assert.Equal(t, "// Code generated by compiler. DO NOT EDIT.\n\n"+
+		"package p\n\n"+
+		"import \"math\"\n\n"+
+		"const N int64 = -1\n\n"+
+		"var type_ = math.Inf(1)\n\n"+
+		"func half(a int64) float64 {\n"+
+		"\tvar _t1 float64\n"+
+		"\tvar _t2 float64\n"+
+		"\t_t1 = float64(a)\n"+
+		"\t_t2 = _t1 / 2.0\n"+
+		"\treturn _t2\n"+
+		"}\n\n"+
+		"// Half calls half\n"+
+		"func Half(a int64) float64 {\n"+
+		"\treturn half(a)\n"+
+		"}\n\n"+
+		"func _b2i(b bool) int64 {\n"+
+		"\tif b {\n"+
+		"\t\treturn 1\n"+
+		"\t}\n"+
+		"\treturn 0\n"+
+		"}\n", string(src))
+}
+
+func TestConvertConst(t *testing.T) {
+	assert.Equal(t, "2", convert(ir.DoubleConst(2.5), ir.Int))
+	assert.Equal(t, "(-3.0)", convert(ir.IntConst(-3), ir.Double))
+}
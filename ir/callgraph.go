@@ -0,0 +1,89 @@
+package ir
+
+import "github.com/rabierre/compiler/ast"
+
+// CallGraph records which functions each function of a file calls.
+// Callees declared in other files are listed but have no calls.
+type CallGraph struct {
+	Funcs []string            // declared in the file, in source order
+	Calls map[string][]string // callees by caller, in order of first call
+}
+
+func NewCallGraph(decls []ast.Decl) *CallGraph {
+	g := &CallGraph{Calls: map[string][]string{}}
+	for _, decl := range decls {
+		if d, ok := decl.(*ast.FuncDecl); ok {
+			caller := d.Name.Name
+			g.Funcs = append(g.Funcs, caller)
+			ast.Inspect(d.Body, func(n ast.Node) bool {
+				if call, ok := n.(*ast.CallExpr); ok {
+					callee := call.Name.(*ast.Ident).Name
+					if !contains(g.Calls[caller], callee) {
+						g.Calls[caller] = append(g.Calls[caller], callee)
+					}
+				}
+				return true
+			})
+		}
+	}
+	return g
+}
+
+// Recursive reports whether name can call itself, directly or through
+// other functions of the file
+func (g *CallGraph) Recursive(name string) bool {
+	seen := map[string]bool{}
+	var reaches func(from string) bool
+	reaches = func(from string) bool {
+		for _, callee := range g.Calls[from] {
+			if callee == name {
+				return true
+			}
+			if !seen[callee] {
+				seen[callee] = true
+				if reaches(callee) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return reaches(name)
+}
+
+// Postorder lists functions of the file, callees before their callers
+// except around cycles
+func (g *CallGraph) Postorder() []string {
+	declared := map[string]bool{}
+	for _, name := range g.Funcs {
+		declared[name] = true
+	}
+
+	var order []string
+	seen := map[string]bool{}
+	var visit func(name string)
+	visit = func(name string) {
+		seen[name] = true
+		for _, callee := range g.Calls[name] {
+			if declared[callee] && !seen[callee] {
+				visit(callee)
+			}
+		}
+		order = append(order, name)
+	}
+	for _, name := range g.Funcs {
+		if !seen[name] {
+			visit(name)
+		}
+	}
+	return order
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
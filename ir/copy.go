@@ -0,0 +1,58 @@
+package ir
+
+// copyProp replaces temps defined by copies with their source and
+// removes the copies. A phi whose arguments are all one value, or the
+// phi itself around a loop, is a copy too.
+func copyProp(f *Func) int {
+	subst := map[Value]Value{}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			switch i := instr.(type) {
+			case *Copy:
+				if _, ok := i.Dst.(*Temp); ok {
+					subst[i.Dst] = i.Src
+				}
+			case *Phi:
+				if v := sameArg(i); v != nil {
+					subst[i.Dst] = v
+				}
+			}
+		}
+	}
+
+	replaceUses(f, subst)
+	return removeInstrs(f, func(instr Instr) bool {
+		switch instr.(type) {
+		case *Copy, *Phi:
+			_, replaced := subst[Defs(instr)]
+			return replaced
+		}
+		return false
+	})
+}
+
+// sameArg returns the only value phi merges besides itself, or nil
+func sameArg(phi *Phi) Value {
+	var v Value
+	for _, arg := range phi.Args {
+		if arg == phi.Dst || sameValue(arg, v) {
+			continue
+		}
+		if v != nil {
+			return nil
+		}
+		v = arg
+	}
+	return v
+}
+
+// sameValue reports whether a and b are the same operand. Constants are
+// compared by value, other values by identity.
+func sameValue(a, b Value) bool {
+	if a == b {
+		return true
+	}
+	x, ok := a.(*Const)
+	y, ok2 := b.(*Const)
+	return ok && ok2 && sameConst(x, y)
+}
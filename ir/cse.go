@@ -0,0 +1,84 @@
+package ir
+
+import "fmt"
+
+// cse removes an instruction computing what one dominating it already
+// has, using the earlier result instead. Calls are never merged.
+func cse(f *Func) int {
+	dom := Dominators(f)
+	available := map[string]Value{}
+	subst := map[Value]Value{}
+	removed := map[Instr]bool{}
+
+	var walk func(b *Block)
+	walk = func(b *Block) {
+		var added []string
+		for _, instr := range b.Instrs {
+			// Operands may have been replaced in a dominating block
+			for _, use := range Uses(instr) {
+				if v, ok := subst[*use]; ok {
+					*use = v
+				}
+			}
+
+			key := exprKey(instr)
+			if key == "" {
+				continue
+			}
+			if v, exist := available[key]; exist {
+				subst[Defs(instr)] = v
+				removed[instr] = true
+				continue
+			}
+			available[key] = Defs(instr)
+			added = append(added, key)
+		}
+
+		for _, child := range dom.Children(b) {
+			walk(child)
+		}
+		for _, key := range added {
+			delete(available, key)
+		}
+	}
+	walk(f.Blocks[0])
+
+	// Phis in blocks visited before their arguments' replacement
+	replaceUses(f, subst)
+	return removeInstrs(f, func(instr Instr) bool { return removed[instr] })
+}
+
+// exprKey identifies the value an instruction computes, or is empty if
+// the instruction is not a pure computation. Operands of commutative
+// operators are sorted so a+b and b+a match.
+func exprKey(instr Instr) string {
+	switch i := instr.(type) {
+	case *BinOp:
+		x, y := operandKey(i.X), operandKey(i.Y)
+		if commutative(i.Op) && x > y {
+			x, y = y, x
+		}
+		return fmt.Sprintf("%s %s %s", x, i.Op, y)
+	case *UnOp:
+		return fmt.Sprintf("%s%s", i.Op, operandKey(i.X))
+	case *Convert:
+		return fmt.Sprintf("%s(%s)", i.Dst.Type(), operandKey(i.X))
+	}
+	return ""
+}
+
+// operandKey tells apart constants of different types printed the same
+func operandKey(v Value) string {
+	if c, ok := v.(*Const); ok {
+		return c.Typ.String() + ":" + c.String()
+	}
+	return v.String()
+}
+
+func commutative(op Op) bool {
+	switch op {
+	case Add, Mul, Eq, Ne:
+		return true
+	}
+	return false
+}
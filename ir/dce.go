@@ -0,0 +1,112 @@
+package ir
+
+// dce removes instructions whose results are never used. Calls and
+// terminators are kept for their effects, and so is everything they
+// use, but calls drop unused results. Branches with both targets the
+// same become jumps, unreachable blocks are removed and a block only
+// entered from its predecessor's jump is merged into it.
+func dce(f *Func) int {
+	live := map[Instr]bool{}
+	defs := map[Value]Instr{}
+	var work []Instr
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if t, ok := Defs(instr).(*Temp); ok {
+				defs[t] = instr
+			}
+			switch instr.(type) {
+			case *Call, *Jump, *Branch, *Return:
+				live[instr] = true
+				work = append(work, instr)
+			}
+		}
+	}
+
+	for len(work) > 0 {
+		instr := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, use := range Uses(instr) {
+			if def, ok := defs[*use]; ok && !live[def] {
+				live[def] = true
+				work = append(work, def)
+			}
+		}
+	}
+
+	used := map[Value]bool{}
+	for instr := range live {
+		for _, use := range Uses(instr) {
+			used[*use] = true
+		}
+	}
+
+	changes := removeInstrs(f, func(instr Instr) bool { return !live[instr] })
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if call, ok := instr.(*Call); ok && call.Dst != nil && !used[call.Dst] {
+				call.Dst = nil
+				changes++
+			}
+		}
+	}
+	for _, b := range f.Blocks {
+		if br, ok := b.Terminator().(*Branch); ok && br.Then == br.Else {
+			b.Instrs[len(b.Instrs)-1] = &Jump{Target: br.Then}
+			changes++
+		}
+	}
+	changes += RemoveUnreachable(f)
+	return changes + mergeBlocks(f)
+}
+
+func mergeBlocks(f *Func) int {
+	merged := map[*Block]bool{}
+	subst := map[Value]Value{}
+	for _, b := range f.Blocks {
+		if merged[b] {
+			continue
+		}
+		for {
+			jump, ok := b.Terminator().(*Jump)
+			if !ok {
+				break
+			}
+			next := jump.Target
+			if next == b || next == f.Blocks[0] || len(next.Preds) != 1 {
+				break
+			}
+
+			// Phis of a single predecessor block just copy
+			instrs := next.Instrs
+			for len(instrs) > 0 {
+				phi, ok := instrs[0].(*Phi)
+				if !ok {
+					break
+				}
+				subst[phi.Dst] = phi.Args[0]
+				instrs = instrs[1:]
+			}
+
+			b.Instrs = append(b.Instrs[:len(b.Instrs)-1], instrs...)
+			b.Succs = next.Succs
+			for _, s := range next.Succs {
+				s.Preds[predIndex(s, next)] = b
+			}
+			merged[next] = true
+		}
+	}
+	if len(merged) == 0 {
+		return 0
+	}
+
+	blocks := f.Blocks[:0]
+	for _, b := range f.Blocks {
+		if !merged[b] {
+			blocks = append(blocks, b)
+		}
+	}
+	f.Blocks = blocks
+	f.Renumber()
+	replaceUses(f, subst)
+	return len(merged)
+}
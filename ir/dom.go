@@ -0,0 +1,164 @@
+package ir
+
+// DomTree is the dominator tree of a function, computed with the
+// iterative algorithm of Cooper, Harvey and Kennedy. Blocks not
+// reachable from the entry are not in the tree.
+type DomTree struct {
+	idom     map[*Block]*Block
+	children map[*Block][]*Block
+	order    []*Block // reverse postorder
+	frontier map[*Block][]*Block
+}
+
+func Dominators(f *Func) *DomTree {
+	d := &DomTree{
+		idom:     map[*Block]*Block{},
+		children: map[*Block][]*Block{},
+	}
+	d.order = reversePostorder(f.Blocks[0])
+	rpo := map[*Block]int{}
+	for i, b := range d.order {
+		rpo[b] = i
+	}
+
+	entry := f.Blocks[0]
+	d.idom[entry] = entry
+	for changed := true; changed; {
+		changed = false
+		for _, b := range d.order[1:] {
+			var idom *Block
+			for _, p := range b.Preds {
+				if d.idom[p] == nil {
+					continue
+				}
+				if idom == nil {
+					idom = p
+				} else {
+					idom = d.intersect(p, idom, rpo)
+				}
+			}
+			if d.idom[b] != idom {
+				d.idom[b] = idom
+				changed = true
+			}
+		}
+	}
+
+	for _, b := range f.Blocks {
+		if idom := d.IDom(b); idom != nil {
+			d.children[idom] = append(d.children[idom], b)
+		}
+	}
+	return d
+}
+
+func (d *DomTree) intersect(a, b *Block, rpo map[*Block]int) *Block {
+	for a != b {
+		for rpo[a] > rpo[b] {
+			a = d.idom[a]
+		}
+		for rpo[b] > rpo[a] {
+			b = d.idom[b]
+		}
+	}
+	return a
+}
+
+// reversePostorder lists blocks reachable from entry, each before its
+// successors except along back edges
+func reversePostorder(entry *Block) []*Block {
+	var post []*Block
+	seen := map[*Block]bool{}
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		seen[b] = true
+		for _, s := range b.Succs {
+			if !seen[s] {
+				visit(s)
+			}
+		}
+		post = append(post, b)
+	}
+	visit(entry)
+
+	for i, j := 0, len(post)-1; i < j; i, j = i+1, j-1 {
+		post[i], post[j] = post[j], post[i]
+	}
+	return post
+}
+
+// IDom returns immediate dominator of b, nil for the entry and
+// unreachable blocks
+func (d *DomTree) IDom(b *Block) *Block {
+	if idom := d.idom[b]; idom != b {
+		return idom
+	}
+	return nil
+}
+
+func (d *DomTree) Children(b *Block) []*Block {
+	return d.children[b]
+}
+
+func (d *DomTree) Reachable(b *Block) bool {
+	return d.idom[b] != nil
+}
+
+// Order returns reachable blocks in reverse postorder
+func (d *DomTree) Order() []*Block {
+	return d.order
+}
+
+// Dominates reports whether every path from the entry to b goes through a
+func (d *DomTree) Dominates(a, b *Block) bool {
+	if !d.Reachable(a) || !d.Reachable(b) {
+		return false
+	}
+	for b != a {
+		idom := d.idom[b]
+		if idom == b {
+			return false
+		}
+		b = idom
+	}
+	return true
+}
+
+// Frontier returns blocks where dominance of b ends: b dominates one of
+// their predecessors but not strictly themselves.
+func (d *DomTree) Frontier(b *Block) []*Block {
+	if d.frontier == nil {
+		d.computeFrontiers()
+	}
+	return d.frontier[b]
+}
+
+func (d *DomTree) computeFrontiers() {
+	d.frontier = map[*Block][]*Block{}
+	for _, b := range d.order {
+		// Entry has an implicit predecessor
+		stop := d.IDom(b)
+		if len(b.Preds) < 2 && stop != nil {
+			continue
+		}
+		for _, p := range b.Preds {
+			if !d.Reachable(p) {
+				continue
+			}
+			for runner := p; runner != nil && runner != stop; runner = d.IDom(runner) {
+				if !containsBlock(d.frontier[runner], b) {
+					d.frontier[runner] = append(d.frontier[runner], b)
+				}
+			}
+		}
+	}
+}
+
+func containsBlock(list []*Block, b *Block) bool {
+	for _, x := range list {
+		if x == b {
+			return true
+		}
+	}
+	return false
+}
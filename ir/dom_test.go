@@ -0,0 +1,72 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// cfg builds a function of n blocks with edges from succs
+func cfg(n int, succs map[int][]int) *Func {
+	f := &Func{Name: "f"}
+	for i := 0; i < n; i++ {
+		f.NewBlock()
+	}
+	for i, b := range f.Blocks {
+		switch s := succs[i]; len(s) {
+		case 0:
+			b.add(&Return{})
+		case 1:
+			b.add(&Jump{Target: f.Blocks[s[0]]})
+		default:
+			b.add(&Branch{Cond: IntConst(1), Then: f.Blocks[s[0]], Else: f.Blocks[s[1]]})
+		}
+	}
+	f.ComputeCFG()
+	return f
+}
+
+func names(blocks []*Block) []string {
+	list := []string{}
+	for _, b := range blocks {
+		list = append(list, b.Name())
+	}
+	return list
+}
+
+func TestDominators(t *testing.T) {
+	// b0 -> b1 -> (b2 | b3) -> b4 -> b1 loop, b4 -> b5, b6 unreachable
+	f := cfg(7, map[int][]int{0: {1}, 1: {2, 3}, 2: {4}, 3: {4}, 4: {1, 5}, 6: {5}})
+	d := Dominators(f)
+	b := f.Blocks
+
+	assert.Nil(t, d.IDom(b[0]))
+	assert.Equal(t, b[0], d.IDom(b[1]))
+	assert.Equal(t, b[1], d.IDom(b[2]))
+	assert.Equal(t, b[1], d.IDom(b[3]))
+	assert.Equal(t, b[1], d.IDom(b[4]))
+	assert.Equal(t, b[4], d.IDom(b[5]))
+	assert.Nil(t, d.IDom(b[6]))
+	assert.False(t, d.Reachable(b[6]))
+
+	assert.True(t, d.Dominates(b[1], b[5]))
+	assert.True(t, d.Dominates(b[4], b[4]))
+	assert.False(t, d.Dominates(b[2], b[4]))
+	assert.False(t, d.Dominates(b[6], b[5]))
+
+	assert.Equal(t, []string{"b2", "b3", "b4"}, names(d.Children(b[1])))
+	assert.Equal(t, []string{"b4"}, names(d.Frontier(b[2])))
+	assert.Equal(t, []string{"b4"}, names(d.Frontier(b[3])))
+	assert.Equal(t, []string{"b1"}, names(d.Frontier(b[4])))
+	assert.Equal(t, []string{"b1"}, names(d.Frontier(b[1])))
+	assert.Empty(t, d.Frontier(b[0]))
+	assert.Equal(t, "b0", d.Order()[0].Name())
+	assert.Len(t, d.Order(), 6)
+}
+
+func TestDominatorsEntryLoop(t *testing.T) {
+	f := cfg(2, map[int][]int{0: {1}, 1: {0}})
+	d := Dominators(f)
+	assert.Equal(t, f.Blocks[0], d.IDom(f.Blocks[1]))
+	assert.Equal(t, []string{"b0"}, names(d.Frontier(f.Blocks[1])))
+}
@@ -0,0 +1,215 @@
+package ir
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Inliner replaces calls to small functions of the same file by their
+// body. It runs on the whole program before functions go to SSA form.
+type Inliner struct {
+	Budget    int      // callees costing more are not inlined
+	Decisions []string // why each call was or wasn't inlined, in order
+
+	nvars int
+}
+
+// InlineBudget returns the budget of an optimization level, 0 disables
+// inlining
+func InlineBudget(level int) int {
+	switch {
+	case level <= 0:
+		return 0
+	case level == 1:
+		return 8
+	}
+	return 24
+}
+
+// Cost estimates size of f: every instruction but jumps counts one,
+// calls count more for their argument copies and the call itself.
+func Cost(f *Func) int {
+	cost := 0
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			switch i := instr.(type) {
+			case *Jump:
+			case *Call:
+				cost += 2 + len(i.Args)
+			default:
+				cost++
+			}
+		}
+	}
+	return cost
+}
+
+// Run inlines calls in prog and returns how many. Callers are visited
+// after their callees, so a callee is measured with its own calls
+// inlined already. Recursive functions are never inlined.
+func (in *Inliner) Run(prog *Program, graph *CallGraph) int {
+	if in.Budget <= 0 {
+		return 0
+	}
+
+	inlined := 0
+	for _, name := range graph.Postorder() {
+		f := prog.Func(name)
+		// Copied blocks had their calls decided in the callee already
+		copied := map[*Block]bool{}
+		for bi := 0; bi < len(f.Blocks); bi++ {
+			b := f.Blocks[bi]
+			if copied[b] {
+				continue
+			}
+			for i, instr := range b.Instrs {
+				call, ok := instr.(*Call)
+				if !ok {
+					continue
+				}
+				callee := prog.Func(call.Func)
+				if callee == nil || !in.decide(f, callee, graph) {
+					continue
+				}
+				// Rest of b moves to a new block visited later
+				for _, nb := range in.inline(f, b, i, callee) {
+					copied[nb] = true
+				}
+				inlined++
+				break
+			}
+		}
+		f.ComputeCFG()
+	}
+	return inlined
+}
+
+func (in *Inliner) decide(caller, callee *Func, graph *CallGraph) bool {
+	if graph.Recursive(callee.Name) {
+		in.log("not inlining %s into %s: recursive", callee.Name, caller.Name)
+		return false
+	}
+	cost := Cost(callee)
+	if cost > in.Budget {
+		in.log("not inlining %s into %s: cost %d over budget %d", callee.Name, caller.Name, cost, in.Budget)
+		return false
+	}
+	in.log("inlining %s into %s: cost %d", callee.Name, caller.Name, cost)
+	return true
+}
+
+func (in *Inliner) log(format string, args ...interface{}) {
+	in.Decisions = append(in.Decisions, fmt.Sprintf(format, args...))
+}
+
+// inline replaces the i-th instruction of b, a call to callee, by a
+// copy of callee's blocks. Parameters and locals of the copy are new
+// variables of f, named after the callee so they can't clash with
+// names of f or of other copies. Returns the copied blocks.
+func (in *Inliner) inline(f *Func, b *Block, i int, callee *Func) []*Block {
+	call := b.Instrs[i].(*Call)
+
+	// Instructions after the call continue in a new block
+	rest := &Block{Instrs: append([]Instr{}, b.Instrs[i+1:]...)}
+	b.Instrs = b.Instrs[:i]
+
+	vars := map[*Var]*Var{}
+	for _, v := range append(append([]*Var{}, callee.Params...), callee.Locals...) {
+		vars[v] = in.newVar(f, callee.Name, v.Name, v.Typ)
+		vars[v].TypeName = v.TypeName
+	}
+	var result *Var
+	if call.Dst != nil {
+		result = in.newVar(f, callee.Name, "result", call.Dst.Type())
+		rest.Instrs = append([]Instr{&Copy{Dst: call.Dst, Src: result}}, rest.Instrs...)
+	}
+
+	blocks := map[*Block]*Block{}
+	for _, cb := range callee.Blocks {
+		blocks[cb] = &Block{}
+	}
+	temps := map[*Temp]*Temp{}
+	value := func(v Value) Value {
+		switch x := v.(type) {
+		case *Var:
+			return vars[x]
+		case *Temp:
+			if temps[x] == nil {
+				temps[x] = f.NewTemp(x.Typ)
+			}
+			return temps[x]
+		}
+		return v
+	}
+
+	for j, param := range callee.Params {
+		b.add(&Copy{Dst: vars[param], Src: call.Args[j]})
+	}
+	b.add(&Jump{Target: blocks[callee.Blocks[0]]})
+
+	for _, cb := range callee.Blocks {
+		nb := blocks[cb]
+		for _, instr := range cb.Instrs {
+			nb.Instrs = append(nb.Instrs, cloneInstr(instr, value, blocks, result, rest)...)
+		}
+	}
+
+	// Copies go right after b, then the rest, so jumps between them
+	// mostly fall through
+	index := 0
+	for j, x := range f.Blocks {
+		if x == b {
+			index = j + 1
+		}
+	}
+	var copies []*Block
+	for _, cb := range callee.Blocks {
+		copies = append(copies, blocks[cb])
+	}
+	added := append(append([]*Block{}, copies...), rest)
+	f.Blocks = append(f.Blocks[:index], append(added, f.Blocks[index:]...)...)
+	f.Renumber()
+	return copies
+}
+
+func (in *Inliner) newVar(f *Func, callee, name string, typ Type) *Var {
+	in.nvars++
+	v := &Var{Name: "_" + callee + "_" + name + strconv.Itoa(in.nvars), Typ: typ}
+	f.Locals = append(f.Locals, v)
+	return v
+}
+
+// cloneInstr copies instr with operands mapped by value and targets by
+// blocks. Returns store the value in result and jump to rest.
+func cloneInstr(instr Instr, value func(Value) Value, blocks map[*Block]*Block, result *Var, rest *Block) []Instr {
+	switch i := instr.(type) {
+	case *Copy:
+		return []Instr{&Copy{Dst: value(i.Dst), Src: value(i.Src)}}
+	case *BinOp:
+		return []Instr{&BinOp{Dst: value(i.Dst), Op: i.Op, X: value(i.X), Y: value(i.Y)}}
+	case *UnOp:
+		return []Instr{&UnOp{Dst: value(i.Dst), Op: i.Op, X: value(i.X)}}
+	case *Convert:
+		return []Instr{&Convert{Dst: value(i.Dst), X: value(i.X)}}
+	case *Call:
+		c := &Call{Func: i.Func}
+		if i.Dst != nil {
+			c.Dst = value(i.Dst)
+		}
+		for _, arg := range i.Args {
+			c.Args = append(c.Args, value(arg))
+		}
+		return []Instr{c}
+	case *Jump:
+		return []Instr{&Jump{Target: blocks[i.Target]}}
+	case *Branch:
+		return []Instr{&Branch{Cond: value(i.Cond), Then: blocks[i.Then], Else: blocks[i.Else]}}
+	case *Return:
+		jump := &Jump{Target: rest}
+		if result == nil {
+			return []Instr{jump}
+		}
+		return []Instr{&Copy{Dst: result, Src: value(i.Value)}, jump}
+	}
+	panic(fmt.Sprintf("Unexpected instruction: %s", instr))
+}
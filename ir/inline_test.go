@@ -0,0 +1,93 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// inc builds func inc(int a) int { a = a + 1 return a }
+func inc() *Func {
+	a := &Var{Name: "a", Typ: Int}
+	f := &Func{Name: "inc", Result: Int, Params: []*Var{a}}
+	b := f.NewBlock()
+	b.add(&BinOp{Dst: a, Op: Add, X: a, Y: IntConst(1)})
+	b.add(&Return{Value: a})
+	f.ComputeCFG()
+	return f
+}
+
+// twice builds func twice(int a) int { return inc(inc(a)) }
+func twice() *Func {
+	a := &Var{Name: "a", Typ: Int}
+	f := &Func{Name: "twice", Result: Int, Params: []*Var{a}}
+	b := f.NewBlock()
+	x, y := f.NewTemp(Int), f.NewTemp(Int)
+	b.add(&Call{Dst: x, Func: "inc", Args: []Value{a}})
+	b.add(&Call{Dst: y, Func: "inc", Args: []Value{x}})
+	b.add(&Return{Value: y})
+	f.ComputeCFG()
+	return f
+}
+
+func TestInline(t *testing.T) {
+	prog := &Program{Funcs: []*Func{inc(), twice()}}
+	graph := &CallGraph{Funcs: []string{"inc", "twice"}, Calls: map[string][]string{"twice": {"inc"}}}
+
+	in := &Inliner{Budget: InlineBudget(1)}
+	assert.Equal(t, 2, in.Run(prog, graph))
+	assert.Equal(t, []string{
+		"inlining inc into twice: cost 2",
+		"inlining inc into twice: cost 2",
+	}, in.Decisions)
+
+	f := prog.Func("twice")
+	assert.Nil(t, Verify(f))
+	expect := "func twice(int a) int\n" +
+		"\tvar int _inc_a1\n" +
+		"\tvar int _inc_result2\n" +
+		"\tvar int _inc_a3\n" +
+		"\tvar int _inc_result4\n" +
+		"b0:\n" +
+		"\t_inc_a1 = a\n" +
+		"\tjump b1\n" +
+		"b1: ; preds b0\n" +
+		"\t_inc_a1 = _inc_a1 + 1\n" +
+		"\t_inc_result2 = _inc_a1\n" +
+		"\tjump b2\n" +
+		"b2: ; preds b1\n" +
+		"\t%1:int = _inc_result2\n" +
+		"\t_inc_a3 = %1\n" +
+		"\tjump b3\n" +
+		"b3: ; preds b2\n" +
+		"\t_inc_a3 = _inc_a3 + 1\n" +
+		"\t_inc_result4 = _inc_a3\n" +
+		"\tjump b4\n" +
+		"b4: ; preds b3\n" +
+		"\t%2:int = _inc_result4\n" +
+		"\tret %2\n"
+	assert.Equal(t, expect, f.String())
+
+	// Both copies fold away
+	assert.Nil(t, NewPipeline(2).Run(f))
+	assert.Equal(t, "func twice(int a) int\nb0:\n\t%4:int = a + 1\n\t%7:int = %4 + 1\n\tret %7\n", f.String())
+}
+
+func TestInlineRefused(t *testing.T) {
+	prog := &Program{Funcs: []*Func{inc(), twice()}}
+	graph := &CallGraph{Funcs: []string{"inc", "twice"}, Calls: map[string][]string{"twice": {"inc"}, "inc": {"twice"}}}
+
+	in := &Inliner{Budget: 1}
+	assert.Equal(t, 0, in.Run(prog, graph))
+	assert.Equal(t, []string{
+		"not inlining inc into twice: recursive",
+		"not inlining inc into twice: recursive",
+	}, in.Decisions)
+
+	graph.Calls = map[string][]string{"twice": {"inc"}}
+	in = &Inliner{Budget: 1}
+	assert.Equal(t, 0, in.Run(prog, graph))
+	assert.Equal(t, "not inlining inc into twice: cost 2 over budget 1", in.Decisions[0])
+
+	assert.Equal(t, 0, (&Inliner{}).Run(prog, graph))
+}
@@ -0,0 +1,465 @@
+package ir
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Type int
+
+const (
+	Void Type = iota
+	Int
+	Double
+)
+
+func (t Type) String() string {
+	switch t {
+	case Int:
+		return "int"
+	case Double:
+		return "double"
+	}
+	return "void"
+}
+
+// ParseType returns type of a declared type name.
+// Named types are enums which are int.
+func ParseType(name string) Type {
+	switch name {
+	case "void":
+		return Void
+	case "double":
+		return Double
+	}
+	return Int
+}
+
+// Signature of a top level name declared in another file: result and
+// parameter types of a function, or type of a constant.
+type Signature struct {
+	Func   bool
+	Result Type
+	Params []Type
+}
+
+//--------------------------------------------------------------------------------------
+// Value
+//
+type Value interface {
+	Type() Type
+	String() string
+}
+
+type Const struct {
+	Typ Type
+	I   int64 // Int
+	F   float64
+}
+
+// Local variable or parameter, assigned any number of times
+type Var struct {
+	Name     string
+	Typ      Type
+	TypeName string // enum it is declared with, empty for int and double
+}
+
+// Temporary, assigned exactly once
+type Temp struct {
+	ID  int
+	Typ Type
+}
+
+// Constant declared in another file
+type Global struct {
+	Name string
+	Typ  Type
+}
+
+func IntConst(i int64) *Const      { return &Const{Typ: Int, I: i} }
+func DoubleConst(f float64) *Const { return &Const{Typ: Double, F: f} }
+
+// zero returns the value uninitialized variables of typ have, nil for Void
+func zero(typ Type) Value {
+	switch typ {
+	case Int:
+		return IntConst(0)
+	case Double:
+		return DoubleConst(0)
+	}
+	return nil
+}
+
+func (c *Const) Type() Type  { return c.Typ }
+func (v *Var) Type() Type    { return v.Typ }
+func (t *Temp) Type() Type   { return t.Typ }
+func (g *Global) Type() Type { return g.Typ }
+
+func (c *Const) String() string {
+	if c.Typ == Double {
+		s := strconv.FormatFloat(c.F, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEn") {
+			s += ".0"
+		}
+		return s
+	}
+	return strconv.FormatInt(c.I, 10)
+}
+
+func (v *Var) String() string    { return v.Name }
+func (t *Temp) String() string   { return "%" + strconv.Itoa(t.ID) }
+func (g *Global) String() string { return "@" + g.Name }
+
+//--------------------------------------------------------------------------------------
+// Instruction
+//
+type Instr interface {
+	String() string
+}
+
+type Op int
+
+const (
+	Add Op = iota
+	Sub
+	Mul
+	Div
+	Eq
+	Ne
+	Lt
+	Le
+	Gt
+	Ge
+	Neg
+)
+
+var ops = [...]string{
+	Add: "+",
+	Sub: "-",
+	Mul: "*",
+	Div: "/",
+	Eq:  "==",
+	Ne:  "!=",
+	Lt:  "<",
+	Le:  "<=",
+	Gt:  ">",
+	Ge:  ">=",
+	Neg: "-",
+}
+
+func (op Op) String() string { return ops[op] }
+
+// IsCompare reports whether op results in int 1 or 0
+func (op Op) IsCompare() bool { return Eq <= op && op <= Ge }
+
+// Dst = Src
+type Copy struct {
+	Dst Value
+	Src Value
+}
+
+// Dst = X Op Y, operands have the same type
+type BinOp struct {
+	Dst  Value
+	Op   Op
+	X, Y Value
+}
+
+// Dst = Op X
+type UnOp struct {
+	Dst Value
+	Op  Op
+	X   Value
+}
+
+// Dst = X converted to type of Dst
+type Convert struct {
+	Dst Value
+	X   Value
+}
+
+// Dst = Func(Args), Dst is nil for void functions
+type Call struct {
+	Dst  Value
+	Func string
+	Args []Value
+}
+
+type Jump struct {
+	Target *Block
+}
+
+// Jumps to Then if Cond is not zero
+type Branch struct {
+	Cond       Value
+	Then, Else *Block
+}
+
+// Value is nil for void functions
+type Return struct {
+	Value Value
+}
+
+// Dst = Args[i] when control comes from the i-th predecessor of the
+// block. Phis are at the start of blocks, only in SSA form.
+type Phi struct {
+	Dst  Value
+	Args []Value
+}
+
+func (i *Copy) String() string {
+	return fmt.Sprintf("%s = %s", def(i.Dst), i.Src)
+}
+
+func (i *BinOp) String() string {
+	return fmt.Sprintf("%s = %s %s %s", def(i.Dst), i.X, i.Op, i.Y)
+}
+
+func (i *UnOp) String() string {
+	return fmt.Sprintf("%s = %s%s", def(i.Dst), i.Op, i.X)
+}
+
+func (i *Convert) String() string {
+	return fmt.Sprintf("%s = %s(%s)", def(i.Dst), i.Dst.Type(), i.X)
+}
+
+func (i *Call) String() string {
+	args := make([]string, len(i.Args))
+	for j, arg := range i.Args {
+		args[j] = arg.String()
+	}
+	call := fmt.Sprintf("call %s(%s)", i.Func, strings.Join(args, ", "))
+	if i.Dst == nil {
+		return call
+	}
+	return def(i.Dst) + " = " + call
+}
+
+func (i *Jump) String() string {
+	return "jump " + i.Target.Name()
+}
+
+func (i *Branch) String() string {
+	return fmt.Sprintf("branch %s, %s, %s", i.Cond, i.Then.Name(), i.Else.Name())
+}
+
+func (i *Return) String() string {
+	if i.Value == nil {
+		return "ret"
+	}
+	return "ret " + i.Value.String()
+}
+
+func (i *Phi) String() string {
+	args := make([]string, len(i.Args))
+	for j, arg := range i.Args {
+		args[j] = arg.String()
+	}
+	return fmt.Sprintf("%s = phi %s", def(i.Dst), strings.Join(args, ", "))
+}
+
+// Temps are typed where they are defined
+func def(v Value) string {
+	if t, ok := v.(*Temp); ok {
+		return t.String() + ":" + t.Typ.String()
+	}
+	return v.String()
+}
+
+// Defs returns value defined by instr or nil
+func Defs(instr Instr) Value {
+	switch i := instr.(type) {
+	case *Copy:
+		return i.Dst
+	case *BinOp:
+		return i.Dst
+	case *UnOp:
+		return i.Dst
+	case *Convert:
+		return i.Dst
+	case *Call:
+		return i.Dst
+	case *Phi:
+		return i.Dst
+	}
+	return nil
+}
+
+// Uses returns pointers to operands of instr, so passes can replace them
+func Uses(instr Instr) []*Value {
+	switch i := instr.(type) {
+	case *Copy:
+		return []*Value{&i.Src}
+	case *BinOp:
+		return []*Value{&i.X, &i.Y}
+	case *UnOp:
+		return []*Value{&i.X}
+	case *Convert:
+		return []*Value{&i.X}
+	case *Call:
+		return argUses(i.Args)
+	case *Phi:
+		return argUses(i.Args)
+	case *Branch:
+		return []*Value{&i.Cond}
+	case *Return:
+		if i.Value != nil {
+			return []*Value{&i.Value}
+		}
+	}
+	return nil
+}
+
+func argUses(args []Value) []*Value {
+	uses := make([]*Value, len(args))
+	for j := range args {
+		uses[j] = &args[j]
+	}
+	return uses
+}
+
+//--------------------------------------------------------------------------------------
+// Block and Function
+//
+type Block struct {
+	Index  int
+	Instrs []Instr
+	Preds  []*Block
+	Succs  []*Block
+}
+
+func (b *Block) Name() string {
+	return "b" + strconv.Itoa(b.Index)
+}
+
+// Terminator returns the last instruction if it ends the block
+func (b *Block) Terminator() Instr {
+	if len(b.Instrs) == 0 {
+		return nil
+	}
+	switch last := b.Instrs[len(b.Instrs)-1].(type) {
+	case *Jump, *Branch, *Return:
+		return last
+	}
+	return nil
+}
+
+func (b *Block) add(instr Instr) {
+	b.Instrs = append(b.Instrs, instr)
+}
+
+type Func struct {
+	Name       string
+	Result     Type
+	ResultName string // enum of the result, empty for other types
+	Params     []*Var
+	Locals     []*Var
+	Blocks     []*Block // Blocks[0] is the entry
+	SSA        bool     // Locals are replaced by temps and phis, see ToSSA
+
+	ntemp int
+}
+
+func (f *Func) NewTemp(typ Type) *Temp {
+	f.ntemp++
+	return &Temp{ID: f.ntemp, Typ: typ}
+}
+
+func (f *Func) NewBlock() *Block {
+	b := &Block{Index: len(f.Blocks)}
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+// ComputeCFG sets Preds and Succs of blocks from their terminators.
+// Order of Preds changes, so it is not for functions with phis.
+func (f *Func) ComputeCFG() {
+	for _, b := range f.Blocks {
+		b.Preds = nil
+		b.Succs = nil
+	}
+	for _, b := range f.Blocks {
+		switch t := b.Terminator().(type) {
+		case *Jump:
+			link(b, t.Target)
+		case *Branch:
+			link(b, t.Then)
+			if t.Else != t.Then {
+				link(b, t.Else)
+			}
+		}
+	}
+}
+
+func link(from, to *Block) {
+	from.Succs = append(from.Succs, to)
+	to.Preds = append(to.Preds, from)
+}
+
+// Renumber gives blocks indexes by their order in f.Blocks
+func (f *Func) Renumber() {
+	for i, b := range f.Blocks {
+		b.Index = i
+	}
+}
+
+func (f *Func) String() string {
+	var buf bytes.Buffer
+
+	params := make([]string, len(f.Params))
+	for i, p := range f.Params {
+		params[i] = p.Typ.String() + " " + p.Name
+	}
+	fmt.Fprintf(&buf, "func %s(%s) %s\n", f.Name, strings.Join(params, ", "), f.Result)
+	for _, v := range f.Locals {
+		fmt.Fprintf(&buf, "\tvar %s %s\n", v.Typ, v.Name)
+	}
+
+	for _, b := range f.Blocks {
+		buf.WriteString(b.Name() + ":")
+		if len(b.Preds) > 0 {
+			preds := make([]string, len(b.Preds))
+			for i, p := range b.Preds {
+				preds[i] = p.Name()
+			}
+			buf.WriteString(" ; preds " + strings.Join(preds, ", "))
+		}
+		buf.WriteByte('\n')
+		for _, instr := range b.Instrs {
+			buf.WriteString("\t" + instr.String() + "\n")
+		}
+	}
+	return buf.String()
+}
+
+type Program struct {
+	Funcs   []*Func
+	Consts  []*Constant          // declared by the file, enum members included
+	Globals map[string]Signature // names of other files the file may use
+}
+
+// Constant is a named constant other files see as a Global
+type Constant struct {
+	Name  string
+	Value *Const
+}
+
+// String returns textual form of every function
+func (p *Program) String() string {
+	list := make([]string, len(p.Funcs))
+	for i, f := range p.Funcs {
+		list[i] = f.String()
+	}
+	return strings.Join(list, "\n")
+}
+
+func (p *Program) Func(name string) *Func {
+	for _, f := range p.Funcs {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuncString(t *testing.T) {
+	a := &Var{Name: "a", Typ: Int}
+	f := &Func{Name: "abs", Result: Int, Params: []*Var{a}}
+	entry, neg, done := f.NewBlock(), f.NewBlock(), f.NewBlock()
+
+	cond := f.NewTemp(Int)
+	entry.add(&BinOp{Dst: cond, Op: Lt, X: a, Y: IntConst(0)})
+	entry.add(&Branch{Cond: cond, Then: neg, Else: done})
+	neg.add(&UnOp{Dst: a, Op: Neg, X: a})
+	neg.add(&Jump{Target: done})
+	done.add(&Return{Value: a})
+	f.ComputeCFG()
+
+	expect := "func abs(int a) int\n" +
+		"b0:\n" +
+		"\t%1:int = a < 0\n" +
+		"\tbranch %1, b1, b2\n" +
+		"b1: ; preds b0\n" +
+		"\ta = -a\n" +
+		"\tjump b2\n" +
+		"b2: ; preds b0, b1\n" +
+		"\tret a\n"
+	assert.Equal(t, expect, f.String())
+	assert.Equal(t, []*Block{neg, done}, entry.Succs)
+	assert.Nil(t, neg.Terminator().(*Jump).Target.Succs)
+}
+
+func TestDefsUses(t *testing.T) {
+	x := &Var{Name: "x", Typ: Double}
+	d := &Temp{ID: 1, Typ: Double}
+	instr := &BinOp{Dst: d, Op: Mul, X: x, Y: DoubleConst(2)}
+	assert.Equal(t, "%1:double = x * 2.0", instr.String())
+	assert.Equal(t, d, Defs(instr))
+
+	// Uses point into the instruction so operands can be replaced
+	for _, use := range Uses(instr) {
+		if *use == Value(x) {
+			*use = DoubleConst(1.5)
+		}
+	}
+	assert.Equal(t, "%1:double = 1.5 * 2.0", instr.String())
+
+	assert.Nil(t, Defs(&Call{Func: "f"}))
+	assert.Empty(t, Uses(&Return{}))
+}
@@ -0,0 +1,88 @@
+package ir
+
+// Liveness holds variables and temps live on entry to and exit from each
+// block. Phi arguments are live out of their predecessor only and phi
+// results are not live in.
+type Liveness struct {
+	In, Out map[*Block]map[Value]bool
+}
+
+// ComputeLiveness solves the backward dataflow equations
+//
+//	out(b) = union of in(s) for successors s, plus phi arguments from b
+//	in(b)  = uses(b) + (out(b) - defs(b))
+//
+// by iterating over blocks in reverse until nothing changes.
+func ComputeLiveness(f *Func) *Liveness {
+	live := &Liveness{In: map[*Block]map[Value]bool{}, Out: map[*Block]map[Value]bool{}}
+	for _, b := range f.Blocks {
+		live.In[b] = map[Value]bool{}
+		live.Out[b] = map[Value]bool{}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := len(f.Blocks) - 1; i >= 0; i-- {
+			b := f.Blocks[i]
+			out := live.Out[b]
+			for _, s := range b.Succs {
+				for v := range live.In[s] {
+					if !out[v] {
+						out[v] = true
+						changed = true
+					}
+				}
+				for _, instr := range s.Instrs {
+					phi, ok := instr.(*Phi)
+					if !ok {
+						break
+					}
+					if arg := phi.Args[predIndex(s, b)]; isVariable(arg) && !out[arg] {
+						out[arg] = true
+						changed = true
+					}
+				}
+			}
+
+			in := blockLiveIn(b, out)
+			if len(in) != len(live.In[b]) {
+				live.In[b] = in
+				changed = true
+			}
+		}
+	}
+	return live
+}
+
+// blockLiveIn walks b backwards from the values live at its end
+func blockLiveIn(b *Block, out map[Value]bool) map[Value]bool {
+	in := map[Value]bool{}
+	for v := range out {
+		in[v] = true
+	}
+	for i := len(b.Instrs) - 1; i >= 0; i-- {
+		instr := b.Instrs[i]
+		if d := Defs(instr); d != nil {
+			delete(in, d)
+		}
+		if _, ok := instr.(*Phi); ok {
+			continue
+		}
+		for _, use := range Uses(instr) {
+			if isVariable(*use) {
+				in[*use] = true
+			}
+		}
+	}
+	return in
+}
+
+// isVariable reports whether v lives in a register or slot of the
+// function, rather than being a constant or a global
+func isVariable(v Value) bool {
+	switch v.(type) {
+	case *Var, *Temp:
+		return true
+	}
+	return false
+}
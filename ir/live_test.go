@@ -0,0 +1,44 @@
+package ir
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// liveNames lists a liveness set sorted
+func liveNames(set map[Value]bool) []string {
+	list := []string{}
+	for v := range set {
+		list = append(list, v.String())
+	}
+	sort.Strings(list)
+	return list
+}
+
+func TestLiveness(t *testing.T) {
+	f := sum()
+	live := ComputeLiveness(f)
+	entry, head, body, done := f.Blocks[0], f.Blocks[1], f.Blocks[2], f.Blocks[3]
+
+	assert.Equal(t, []string{"n"}, liveNames(live.In[entry]))
+	assert.Equal(t, []string{"i", "n", "s"}, liveNames(live.Out[entry]))
+	assert.Equal(t, []string{"i", "n", "s"}, liveNames(live.In[head]))
+	assert.Equal(t, []string{"i", "n", "s"}, liveNames(live.Out[body]))
+	assert.Equal(t, []string{"s"}, liveNames(live.In[done]))
+	assert.Equal(t, []string{}, liveNames(live.Out[done]))
+}
+
+func TestLivenessSSA(t *testing.T) {
+	f := sum()
+	ToSSA(f)
+	live := ComputeLiveness(f)
+	entry, head, body := f.Blocks[0], f.Blocks[1], f.Blocks[2]
+
+	// Phi arguments are live out of their own predecessor only
+	assert.Equal(t, []string{"%4", "%5", "n"}, liveNames(live.Out[entry]))
+	assert.Equal(t, []string{"n"}, liveNames(live.In[head]))
+	assert.Equal(t, []string{"%6", "%7", "n"}, liveNames(live.Out[body]))
+	assert.Equal(t, []string{"%2", "%3", "n"}, liveNames(live.In[body]))
+}
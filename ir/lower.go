@@ -0,0 +1,500 @@
+package ir
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/rabierre/compiler/token"
+)
+
+// Lower lowers functions declared in decls, which have to be constant
+// folded already. Names used by the file but declared in other files
+// are looked up in globals. Errors are reported by panic as Parser does.
+func Lower(decls []ast.Decl, globals map[string]Signature) *Program {
+	l := &lowerer{
+		globals: globals,
+		funcs:   map[string]*ast.FuncDecl{},
+		consts:  map[string]*Const{},
+	}
+
+	prog := &Program{Globals: globals}
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			l.funcs[d.Name.Name] = d
+		case *ast.ConstDecl:
+			l.consts[d.Name.Name] = l.literal(d.Value.(*ast.BasicLit))
+			prog.Consts = append(prog.Consts, &Constant{Name: d.Name.Name, Value: l.consts[d.Name.Name]})
+		case *ast.EnumDecl:
+			for _, member := range d.Members {
+				l.consts[member.Name.Name] = l.literal(member.Value.(*ast.BasicLit))
+				prog.Consts = append(prog.Consts, &Constant{Name: member.Name.Name, Value: l.consts[member.Name.Name]})
+			}
+		}
+	}
+
+	for _, decl := range decls {
+		if d, ok := decl.(*ast.FuncDecl); ok {
+			prog.Funcs = append(prog.Funcs, l.lowerFunc(d))
+		}
+	}
+	return prog
+}
+
+// TypeOf returns type of a declaration, name is set for named types
+func TypeOf(typ token.Type, name *ast.Ident) Type {
+	switch typ {
+	case token.INT:
+		return Int
+	case token.DOUBLE:
+		return Double
+	case token.IDENT:
+		return ParseType(name.Name)
+	}
+	return Void
+}
+
+// enumName returns the name of a named type, empty for none
+func enumName(name *ast.Ident) string {
+	if name == nil {
+		return ""
+	}
+	return name.Name
+}
+
+type lowerer struct {
+	globals map[string]Signature
+	funcs   map[string]*ast.FuncDecl
+	consts  map[string]*Const // top level constants and enum members
+
+	fn     *Func
+	block  *Block // where instructions are added, nil after return
+	scopes []map[string]*Var
+	names  map[string]int // declarations by name, to rename shadowing locals
+}
+
+func (l *lowerer) lowerFunc(d *ast.FuncDecl) *Func {
+	l.fn = &Func{Name: d.Name.Name, Result: TypeOf(d.Type, d.TypeName), ResultName: enumName(d.TypeName)}
+	l.names = map[string]int{}
+	l.openScope()
+
+	for _, param := range d.Params.List {
+		p := param.(*ast.VarDeclStmt)
+		v := l.declare(p.Name.Name, TypeOf(p.Type, p.TypeName))
+		v.TypeName = enumName(p.TypeName)
+		l.fn.Params = append(l.fn.Params, v)
+	}
+
+	l.block = l.fn.NewBlock()
+	l.stmtList(d.Body.List)
+	// Falling off the end returns zero value
+	if l.block != nil {
+		l.add(&Return{Value: zero(l.fn.Result)})
+	}
+
+	l.closeScope()
+	l.fn.ComputeCFG()
+	return l.fn
+}
+
+func (l *lowerer) openScope() {
+	l.scopes = append(l.scopes, map[string]*Var{})
+}
+
+func (l *lowerer) closeScope() {
+	l.scopes = l.scopes[:len(l.scopes)-1]
+}
+
+// declare adds a variable to the current scope. Names are unique in a
+// function, shadowing ones are renamed with a prefix idents can't have.
+func (l *lowerer) declare(name string, typ Type) *Var {
+	v := &Var{Name: name, Typ: typ}
+	if n := l.names[name]; n > 0 {
+		v.Name = "_" + name + strconv.Itoa(n)
+	}
+	l.names[name]++
+
+	l.scopes[len(l.scopes)-1][name] = v
+	return v
+}
+
+func (l *lowerer) lookup(name string) *Var {
+	for i := len(l.scopes) - 1; i >= 0; i-- {
+		if v, exist := l.scopes[i][name]; exist {
+			return v
+		}
+	}
+	return nil
+}
+
+// add appends instr to the current block. There is none after a return:
+// jumps out of it are dropped and other code, unreachable but still
+// lowered, starts a new block.
+func (l *lowerer) add(instr Instr) {
+	if l.block == nil {
+		if _, ok := instr.(*Jump); ok {
+			return
+		}
+		l.block = l.fn.NewBlock()
+	}
+	l.block.add(instr)
+}
+
+// startBlock makes b the current block
+func (l *lowerer) startBlock(b *Block) {
+	l.block = b
+}
+
+//--------------------------------------------------------------------------------------
+// Statement
+//
+func (l *lowerer) stmtList(list []ast.Stmt) {
+	for _, s := range list {
+		l.stmt(s)
+	}
+}
+
+func (l *lowerer) stmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.VarDeclStmt:
+		var value Value
+		if s.RValue != nil {
+			value = l.expr(s.RValue)
+		}
+		v := l.declare(s.Name.Name, TypeOf(s.Type, s.TypeName))
+		v.TypeName = enumName(s.TypeName)
+		l.fn.Locals = append(l.fn.Locals, v)
+		if value != nil {
+			l.add(&Copy{Dst: v, Src: l.convert(value, v.Typ)})
+		}
+	case *ast.ExprStmt:
+		if call, ok := s.Val.(*ast.CallExpr); ok {
+			l.call(call, false)
+		} else {
+			l.expr(s.Val)
+		}
+	case *ast.ReturnStmt:
+		l.returnStmt(s)
+	case *ast.CompoundStmt:
+		l.openScope()
+		l.stmtList(s.List)
+		l.closeScope()
+	case *ast.IfStmt:
+		l.ifStmt(s)
+	case *ast.ForStmt:
+		l.forStmt(s)
+	case *ast.SwitchStmt:
+		l.switchStmt(s)
+	case *ast.ConstDecl:
+		// uses are folded already
+	}
+}
+
+func (l *lowerer) returnStmt(s *ast.ReturnStmt) {
+	var value Value
+	switch {
+	case l.fn.Result == Void && s.Value != nil:
+		panic("Too many return values in " + l.fn.Name)
+	case l.fn.Result != Void && s.Value == nil:
+		panic("Not enough return values in " + l.fn.Name)
+	case s.Value != nil:
+		value = l.convert(l.expr(s.Value), l.fn.Result)
+	}
+	l.add(&Return{Value: value})
+	l.block = nil
+}
+
+func (l *lowerer) ifStmt(s *ast.IfStmt) {
+	cond := l.cond(s.Cond)
+	then, done := l.fn.NewBlock(), l.fn.NewBlock()
+	els := done
+	if s.ElseBody != nil {
+		els = l.fn.NewBlock()
+	}
+	l.add(&Branch{Cond: cond, Then: then, Else: els})
+
+	l.startBlock(then)
+	l.stmt(s.Body)
+	l.add(&Jump{Target: done})
+
+	if s.ElseBody != nil {
+		l.startBlock(els)
+		l.stmt(s.ElseBody)
+		l.add(&Jump{Target: done})
+	}
+
+	l.startBlock(done)
+}
+
+func (l *lowerer) forStmt(s *ast.ForStmt) {
+	l.openScope()
+	if s.Init != nil {
+		l.stmt(s.Init)
+	}
+
+	head, body, post, done := l.fn.NewBlock(), l.fn.NewBlock(), l.fn.NewBlock(), l.fn.NewBlock()
+	l.add(&Jump{Target: head})
+
+	l.startBlock(head)
+	if s.Cond != nil {
+		l.add(&Branch{Cond: l.cond(s.Cond), Then: body, Else: done})
+	} else {
+		l.add(&Jump{Target: body})
+	}
+
+	l.startBlock(body)
+	l.stmt(s.Body)
+	l.add(&Jump{Target: post})
+
+	l.startBlock(post)
+	if s.Post != nil {
+		l.expr(s.Post)
+	}
+	l.add(&Jump{Target: head})
+
+	l.closeScope()
+	l.startBlock(done)
+}
+
+// switchStmt tests cases in order, then jumps to default if any.
+// Bodies jump to the end unless they fall through.
+func (l *lowerer) switchStmt(s *ast.SwitchStmt) {
+	tag := l.expr(s.Tag)
+	done := l.fn.NewBlock()
+
+	bodies := make([]*Block, len(s.Body))
+	for i := range s.Body {
+		bodies[i] = l.fn.NewBlock()
+	}
+
+	dflt := done
+	for i, clause := range s.Body {
+		if clause.List == nil {
+			dflt = bodies[i]
+		}
+		for _, x := range clause.List {
+			value := l.convert(l.expr(x), tag.Type())
+			eq := l.fn.NewTemp(Int)
+			l.add(&BinOp{Dst: eq, Op: Eq, X: tag, Y: value})
+
+			next := l.fn.NewBlock()
+			l.add(&Branch{Cond: eq, Then: bodies[i], Else: next})
+			l.startBlock(next)
+		}
+	}
+	l.add(&Jump{Target: dflt})
+
+	for i, clause := range s.Body {
+		l.startBlock(bodies[i])
+		l.openScope()
+		l.stmtList(clause.Body)
+		l.closeScope()
+
+		if _, ok := last(clause.Body).(*ast.FallthroughStmt); ok {
+			l.add(&Jump{Target: bodies[i+1]})
+		} else {
+			l.add(&Jump{Target: done})
+		}
+	}
+
+	l.startBlock(done)
+}
+
+func last(list []ast.Stmt) ast.Stmt {
+	if len(list) == 0 {
+		return nil
+	}
+	return list[len(list)-1]
+}
+
+//--------------------------------------------------------------------------------------
+// Expression
+//
+
+// cond returns int value of x to branch on
+func (l *lowerer) cond(x ast.Expr) Value {
+	v := l.expr(x)
+	if v.Type() == Int {
+		return v
+	}
+
+	t := l.fn.NewTemp(Int)
+	l.add(&BinOp{Dst: t, Op: Ne, X: v, Y: DoubleConst(0)})
+	return t
+}
+
+func (l *lowerer) expr(x ast.Expr) Value {
+	switch e := x.(type) {
+	case *ast.BasicLit:
+		return l.literal(e)
+	case *ast.Ident:
+		return l.ident(e)
+	case *ast.UnaryExpr:
+		v := l.expr(e.RValue)
+		if e.Op.Type == token.PLUS {
+			return v
+		}
+		t := l.fn.NewTemp(v.Type())
+		l.add(&UnOp{Dst: t, Op: Neg, X: v})
+		return t
+	case *ast.BinaryExpr:
+		return l.binaryExpr(e)
+	case *ast.CallExpr:
+		return l.call(e, true)
+	case *ast.AssignExpr:
+		v := l.variable(e.LValue)
+		l.add(&Copy{Dst: v, Src: l.convert(l.expr(e.RValue), v.Typ)})
+		return v
+	case *ast.ShortExpr:
+		// Value is the one before increment
+		v := l.variable(e.RValue)
+		old := l.fn.NewTemp(v.Typ)
+		l.add(&Copy{Dst: old, Src: v})
+		op := Add
+		if e.Op.Type == token.DEC {
+			op = Sub
+		}
+		l.add(&BinOp{Dst: v, Op: op, X: v, Y: l.convert(IntConst(1), v.Typ)})
+		return old
+	}
+	panic(fmt.Sprintf("Invalid expression: %#v", x))
+}
+
+func (l *lowerer) literal(e *ast.BasicLit) *Const {
+	switch e.Type {
+	case token.INT_LIT:
+		i, err := strconv.ParseInt(e.Value, 10, 64)
+		if err != nil {
+			panic("Invalid int literal: " + e.Value)
+		}
+		return IntConst(i)
+	case token.DOUBLE_LIT:
+		f, err := strconv.ParseFloat(e.Value, 64)
+		if err != nil {
+			panic("Invalid double literal: " + e.Value)
+		}
+		return DoubleConst(f)
+	case token.TRUE:
+		return IntConst(1)
+	}
+	return IntConst(0) // token.FALSE
+}
+
+func (l *lowerer) ident(e *ast.Ident) Value {
+	if v := l.lookup(e.Name); v != nil {
+		return v
+	}
+	if c, exist := l.consts[e.Name]; exist {
+		return c
+	}
+	if sig, exist := l.globals[e.Name]; exist && !sig.Func {
+		return &Global{Name: e.Name, Typ: sig.Result}
+	}
+	panic("Undefined: " + e.Name)
+}
+
+// variable returns the variable x assigns to
+func (l *lowerer) variable(x ast.Expr) *Var {
+	if id, ok := x.(*ast.Ident); ok {
+		if v := l.lookup(id.Name); v != nil {
+			return v
+		}
+	}
+	panic(fmt.Sprintf("Cannot assign to %#v", x))
+}
+
+var binOps = map[token.Type]Op{
+	token.PLUS:   Add,
+	token.MINUS:  Sub,
+	token.MULTI:  Mul,
+	token.DIVIDE: Div,
+	token.EQ:     Eq,
+	token.NEQ:    Ne,
+	token.LESS:   Lt,
+	token.LEQ:    Le,
+	token.GRT:    Gt,
+	token.GEQ:    Ge,
+}
+
+// Operands are converted to double if either is
+func (l *lowerer) binaryExpr(e *ast.BinaryExpr) Value {
+	op, ok := binOps[e.Op.Type]
+	if !ok {
+		panic("Invalid operator: " + e.Op.Type.String())
+	}
+
+	x, y := l.expr(e.LValue), l.expr(e.RValue)
+	typ := Int
+	if x.Type() == Double || y.Type() == Double {
+		typ = Double
+	}
+	x, y = l.convert(x, typ), l.convert(y, typ)
+
+	if op.IsCompare() {
+		typ = Int
+	}
+	t := l.fn.NewTemp(typ)
+	l.add(&BinOp{Dst: t, Op: op, X: x, Y: y})
+	return t
+}
+
+func (l *lowerer) convert(v Value, typ Type) Value {
+	if v.Type() == typ {
+		return v
+	}
+	if v.Type() == Void {
+		panic("Void value used as " + typ.String())
+	}
+
+	if c, ok := v.(*Const); ok {
+		if typ == Double {
+			return DoubleConst(float64(c.I))
+		}
+		return IntConst(int64(c.F))
+	}
+
+	t := l.fn.NewTemp(typ)
+	l.add(&Convert{Dst: t, X: v})
+	return t
+}
+
+// call lowers a function call, value is false if the result is unused
+func (l *lowerer) call(e *ast.CallExpr, value bool) Value {
+	name := e.Name.(*ast.Ident).Name
+	sig := l.signature(name)
+
+	if len(e.Params.List) != len(sig.Params) {
+		panic(fmt.Sprintf("Wrong number of arguments to %s: %d, want %d", name, len(e.Params.List), len(sig.Params)))
+	}
+	args := make([]Value, len(sig.Params))
+	for i, param := range e.Params.List {
+		args[i] = l.convert(l.expr(param), sig.Params[i])
+	}
+
+	call := &Call{Func: name, Args: args}
+	if sig.Result == Void {
+		if value {
+			panic(name + "() used as value")
+		}
+	} else {
+		call.Dst = l.fn.NewTemp(sig.Result)
+	}
+	l.add(call)
+	return call.Dst
+}
+
+func (l *lowerer) signature(name string) Signature {
+	if d, exist := l.funcs[name]; exist {
+		sig := Signature{Func: true, Result: TypeOf(d.Type, d.TypeName)}
+		for _, param := range d.Params.List {
+			p := param.(*ast.VarDeclStmt)
+			sig.Params = append(sig.Params, TypeOf(p.Type, p.TypeName))
+		}
+		return sig
+	}
+	if sig, exist := l.globals[name]; exist && sig.Func {
+		return sig
+	}
+	panic("Undefined function: " + name)
+}
@@ -0,0 +1,144 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pass transforms a function in SSA form and returns how many changes
+// it made, zero when there was nothing to do.
+type Pass struct {
+	Name string
+	Run  func(f *Func) int
+}
+
+var (
+	SCCP     = Pass{"sccp", sccp}
+	CopyProp = Pass{"copyprop", copyProp}
+	CSE      = Pass{"cse", cse}
+	DCE      = Pass{"dce", dce}
+)
+
+// Stats counts changes of every pass over all functions
+type Stats struct {
+	Names   []string // in order passes first ran
+	Changes map[string]int
+}
+
+func (s *Stats) Add(name string, changes int) {
+	if s.Changes == nil {
+		s.Changes = map[string]int{}
+	}
+	if _, exist := s.Changes[name]; !exist {
+		s.Names = append(s.Names, name)
+	}
+	s.Changes[name] += changes
+}
+
+func (s Stats) String() string {
+	if len(s.Names) == 0 {
+		return "opt: no passes run"
+	}
+	list := make([]string, len(s.Names))
+	for i, name := range s.Names {
+		list[i] = fmt.Sprintf("%s %d", name, s.Changes[name])
+	}
+	return "opt: " + strings.Join(list, ", ")
+}
+
+// Pipeline runs passes in order over functions in SSA form. With
+// Repeat, the passes run again until none of them changes anything.
+type Pipeline struct {
+	Passes []Pass
+	Repeat bool
+	Stats  Stats
+}
+
+// maxRounds bounds repeated runs, every round should shrink the
+// function but a pass pair undoing each other must not hang the build
+const maxRounds = 10
+
+// NewPipeline returns passes of an optimization level:
+// 0 runs nothing, 1 propagates constants and copies then removes dead
+// code, 2 also eliminates common subexpressions and repeats.
+func NewPipeline(level int) *Pipeline {
+	switch {
+	case level <= 0:
+		return &Pipeline{}
+	case level == 1:
+		return &Pipeline{Passes: []Pass{SCCP, CopyProp, DCE}}
+	}
+	return &Pipeline{Passes: []Pass{SCCP, CopyProp, CSE, DCE}, Repeat: true}
+}
+
+// Run optimizes f, leaving it in SSA form. Functions are verified after
+// every pass, an error names the pass which broke it.
+func (p *Pipeline) Run(f *Func) error {
+	ToSSA(f)
+	if err := Verify(f); err != nil {
+		return fmt.Errorf("ssa: %s", err)
+	}
+
+	for round := 0; round < maxRounds; round++ {
+		changed := false
+		for _, pass := range p.Passes {
+			n := pass.Run(f)
+			p.Stats.Add(pass.Name, n)
+			if n == 0 {
+				continue
+			}
+			changed = true
+			if err := Verify(f); err != nil {
+				return fmt.Errorf("%s: %s", pass.Name, err)
+			}
+		}
+		if !changed || !p.Repeat {
+			break
+		}
+	}
+	return nil
+}
+
+// replaceUses substitutes values of subst in every operand of f.
+// Chains are followed, so a value replaced by a replaced value ends up
+// as the last one.
+func replaceUses(f *Func, subst map[Value]Value) {
+	if len(subst) == 0 {
+		return
+	}
+	resolve := func(v Value) Value {
+		// Bounded in case unreachable code made a cycle
+		for n := 0; n <= len(subst); n++ {
+			to, ok := subst[v]
+			if !ok {
+				break
+			}
+			v = to
+		}
+		return v
+	}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			for _, use := range Uses(instr) {
+				*use = resolve(*use)
+			}
+		}
+	}
+}
+
+// removeInstrs deletes instructions for which dead returns true
+func removeInstrs(f *Func, dead func(Instr) bool) int {
+	removed := 0
+	for _, b := range f.Blocks {
+		instrs := b.Instrs[:0]
+		for _, instr := range b.Instrs {
+			if dead(instr) {
+				removed++
+				continue
+			}
+			instrs = append(instrs, instr)
+		}
+		b.Instrs = instrs
+	}
+	return removed
+}
@@ -0,0 +1,102 @@
+package ir
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFoldBinary(t *testing.T) {
+	suite := []struct {
+		op     Op
+		x, y   *Const
+		expect string
+	}{
+		{Add, IntConst(2), IntConst(3), "5"},
+		{Div, IntConst(-7), IntConst(2), "-3"},
+		{Div, IntConst(1), IntConst(0), ""},
+		{Mul, IntConst(math.MaxInt32), IntConst(2), ""},
+		{Lt, IntConst(1), IntConst(2), "1"},
+		{Div, DoubleConst(1), DoubleConst(4), "0.25"},
+		{Ge, DoubleConst(1), DoubleConst(4), "0"},
+		{Div, DoubleConst(1), DoubleConst(0), ""},
+		{Div, DoubleConst(0), DoubleConst(0), ""},
+		{Mul, DoubleConst(1e308), DoubleConst(10), ""},
+	}
+
+	for _, s := range suite {
+		c, ok := foldBinary(s.op, s.x, s.y)
+		if s.expect == "" {
+			assert.False(t, ok, "%s %s %s", s.x, s.op, s.y)
+			continue
+		}
+		assert.True(t, ok)
+		assert.Equal(t, s.expect, c.String())
+	}
+
+	c, ok := foldConvert(DoubleConst(-2.7), Int)
+	assert.True(t, ok)
+	assert.Equal(t, "-2", c.String())
+	_, ok = foldConvert(DoubleConst(1e10), Int)
+	assert.False(t, ok)
+}
+
+func TestPipeline(t *testing.T) {
+	f := sum()
+	p := NewPipeline(2)
+	assert.Nil(t, p.Run(f))
+	assert.True(t, f.SSA)
+	assert.Equal(t, []string{"sccp", "copyprop", "cse", "dce"}, p.Stats.Names)
+	assert.Equal(t, "opt: sccp 2, copyprop 0, cse 0, dce 0", p.Stats.String())
+
+	broken := Pass{"broken", func(f *Func) int {
+		f.Blocks[0].Instrs = nil
+		return 1
+	}}
+	p = &Pipeline{Passes: []Pass{SCCP, broken}}
+	assert.EqualError(t, p.Run(sum()), "broken: sum: b0: missing terminator")
+
+	assert.Equal(t, "opt: no passes run", NewPipeline(0).Stats.String())
+}
+
+func TestSCCPLoop(t *testing.T) {
+	// Branch on a phi of equal constants is folded, so the loop goes away
+	x := &Var{Name: "x", Typ: Int}
+	f := &Func{Name: "f", Result: Int, Locals: []*Var{x}}
+	entry, head, body, done := f.NewBlock(), f.NewBlock(), f.NewBlock(), f.NewBlock()
+	entry.add(&Copy{Dst: x, Src: IntConst(1)})
+	entry.add(&Jump{Target: head})
+	cond := f.NewTemp(Int)
+	head.add(&BinOp{Dst: cond, Op: Eq, X: x, Y: IntConst(2)})
+	head.add(&Branch{Cond: cond, Then: body, Else: done})
+	body.add(&Copy{Dst: x, Src: IntConst(2)})
+	body.add(&Jump{Target: head})
+	done.add(&Return{Value: x})
+	f.ComputeCFG()
+
+	ToSSA(f)
+	assert.Equal(t, 5, sccp(f))
+	assert.Nil(t, Verify(f))
+	assert.Equal(t, 2, dce(f))
+	assert.Equal(t, "func f() int\nb0:\n\tret 1\n", f.String())
+}
+
+func TestSCCPDoubleDivZero(t *testing.T) {
+	// Division by zero is left to run, C has no literal for its result
+	x, y := &Var{Name: "x", Typ: Double}, &Var{Name: "y", Typ: Double}
+	f := &Func{Name: "f", Result: Double, Locals: []*Var{x, y}}
+	b := f.NewBlock()
+	b.add(&Copy{Dst: x, Src: DoubleConst(1)})
+	b.add(&Copy{Dst: y, Src: DoubleConst(0)})
+	q := f.NewTemp(Double)
+	b.add(&BinOp{Dst: q, Op: Div, X: x, Y: y})
+	b.add(&Return{Value: q})
+	f.ComputeCFG()
+
+	ToSSA(f)
+	sccp(f)
+	assert.Nil(t, Verify(f))
+	dce(f)
+	assert.Equal(t, "func f() double\nb0:\n\t%1:double = 1.0 / 0.0\n\tret %1\n", f.String())
+}
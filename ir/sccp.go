@@ -0,0 +1,339 @@
+package ir
+
+import "math"
+
+// Lattice of a temp in SCCP: unknown yet, one constant or overdefined
+type lattice struct {
+	state int
+	c     *Const
+}
+
+const (
+	unknown = iota
+	constant
+	overdefined
+)
+
+type edge struct {
+	from, to *Block
+}
+
+// sccp is sparse conditional constant propagation of Wegman and Zadeck.
+// It finds temps having one constant value on every executable path,
+// replaces their uses, folds branches on constants and removes blocks
+// which can't execute.
+func sccp(f *Func) int {
+	s := &propagator{
+		values:     map[*Temp]lattice{},
+		executable: map[*Block]bool{},
+		edges:      map[edge]bool{},
+		uses:       map[*Temp][]Instr{},
+		blockOf:    map[Instr]*Block{},
+	}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			s.blockOf[instr] = b
+			for _, use := range Uses(instr) {
+				if t, ok := (*use).(*Temp); ok {
+					s.uses[t] = append(s.uses[t], instr)
+				}
+			}
+		}
+	}
+	s.run(f.Blocks[0])
+
+	changes := 0
+	subst := map[Value]Value{}
+	for t, v := range s.values {
+		if v.state == constant {
+			subst[t] = v.c
+		}
+	}
+	replaceUses(f, subst)
+	changes += removeInstrs(f, func(instr Instr) bool {
+		_, replaced := subst[Defs(instr)]
+		_, isCall := instr.(*Call)
+		return replaced && !isCall
+	})
+
+	for _, b := range f.Blocks {
+		if br, ok := b.Terminator().(*Branch); ok && s.executable[b] {
+			if c, ok := br.Cond.(*Const); ok {
+				foldBranch(b, br, c.I != 0)
+				changes++
+			}
+		}
+	}
+	return changes + RemoveUnreachable(f)
+}
+
+// foldBranch replaces br, the terminator of b, by a jump to the taken
+// target and unlinks the other one
+func foldBranch(b *Block, br *Branch, taken bool) {
+	target, other := br.Then, br.Else
+	if !taken {
+		target, other = other, target
+	}
+	b.Instrs[len(b.Instrs)-1] = &Jump{Target: target}
+	if other == target {
+		return
+	}
+	other.removePred(predIndex(other, b))
+	for i, s := range b.Succs {
+		if s == other {
+			b.Succs = append(b.Succs[:i], b.Succs[i+1:]...)
+			break
+		}
+	}
+}
+
+type propagator struct {
+	values     map[*Temp]lattice
+	executable map[*Block]bool
+	edges      map[edge]bool
+	uses       map[*Temp][]Instr
+	blockOf    map[Instr]*Block
+
+	flowWork []edge
+	ssaWork  []Instr
+}
+
+func (s *propagator) run(entry *Block) {
+	s.executable[entry] = true
+	for _, instr := range entry.Instrs {
+		s.visit(instr, entry)
+	}
+
+	for len(s.flowWork) > 0 || len(s.ssaWork) > 0 {
+		if n := len(s.flowWork); n > 0 {
+			e := s.flowWork[n-1]
+			s.flowWork = s.flowWork[:n-1]
+			s.visitEdge(e)
+			continue
+		}
+		n := len(s.ssaWork)
+		instr := s.ssaWork[n-1]
+		s.ssaWork = s.ssaWork[:n-1]
+		if b := s.blockOf[instr]; s.executable[b] {
+			s.visit(instr, b)
+		}
+	}
+}
+
+func (s *propagator) visitEdge(e edge) {
+	if s.edges[e] {
+		return
+	}
+	s.edges[e] = true
+
+	// Phis see a new argument, other instructions only run once
+	if s.executable[e.to] {
+		for _, instr := range e.to.Instrs {
+			if _, ok := instr.(*Phi); !ok {
+				break
+			}
+			s.visit(instr, e.to)
+		}
+		return
+	}
+	s.executable[e.to] = true
+	for _, instr := range e.to.Instrs {
+		s.visit(instr, e.to)
+	}
+}
+
+func (s *propagator) visit(instr Instr, b *Block) {
+	switch i := instr.(type) {
+	case *Phi:
+		v := lattice{state: unknown}
+		for j, arg := range i.Args {
+			if s.edges[edge{b.Preds[j], b}] {
+				v = meet(v, s.value(arg))
+			}
+		}
+		s.set(i.Dst, v)
+	case *Copy:
+		s.set(i.Dst, s.value(i.Src))
+	case *BinOp:
+		s.set(i.Dst, s.eval(func(c []*Const) (*Const, bool) { return foldBinary(i.Op, c[0], c[1]) }, i.X, i.Y))
+	case *UnOp:
+		s.set(i.Dst, s.eval(func(c []*Const) (*Const, bool) { return foldUnary(i.Op, c[0]) }, i.X))
+	case *Convert:
+		s.set(i.Dst, s.eval(func(c []*Const) (*Const, bool) { return foldConvert(c[0], i.Dst.Type()) }, i.X))
+	case *Call:
+		if i.Dst != nil {
+			s.set(i.Dst, lattice{state: overdefined})
+		}
+	case *Jump:
+		s.flowWork = append(s.flowWork, edge{b, i.Target})
+	case *Branch:
+		switch v := s.value(i.Cond); v.state {
+		case constant:
+			target := i.Then
+			if v.c.I == 0 {
+				target = i.Else
+			}
+			s.flowWork = append(s.flowWork, edge{b, target})
+		case overdefined:
+			s.flowWork = append(s.flowWork, edge{b, i.Then}, edge{b, i.Else})
+		}
+	}
+}
+
+// eval folds operands if all of them are constants
+func (s *propagator) eval(fold func([]*Const) (*Const, bool), operands ...Value) lattice {
+	consts := make([]*Const, len(operands))
+	for i, x := range operands {
+		v := s.value(x)
+		if v.state != constant {
+			return v
+		}
+		consts[i] = v.c
+	}
+	if c, ok := fold(consts); ok {
+		return lattice{state: constant, c: c}
+	}
+	return lattice{state: overdefined}
+}
+
+func (s *propagator) value(x Value) lattice {
+	switch v := x.(type) {
+	case *Const:
+		return lattice{state: constant, c: v}
+	case *Temp:
+		return s.values[v]
+	}
+	// Parameters and globals may be anything
+	return lattice{state: overdefined}
+}
+
+func (s *propagator) set(dst Value, v lattice) {
+	t, ok := dst.(*Temp)
+	if !ok {
+		return
+	}
+	old := s.values[t]
+	if old.state == v.state && (v.state != constant || sameConst(old.c, v.c)) {
+		return
+	}
+	s.values[t] = v
+	s.ssaWork = append(s.ssaWork, s.uses[t]...)
+}
+
+func meet(a, b lattice) lattice {
+	switch {
+	case a.state == unknown:
+		return b
+	case b.state == unknown:
+		return a
+	case a.state == constant && b.state == constant && sameConst(a.c, b.c):
+		return a
+	}
+	return lattice{state: overdefined}
+}
+
+func sameConst(a, b *Const) bool {
+	return a.Typ == b.Typ && a.I == b.I && math.Float64bits(a.F) == math.Float64bits(b.F)
+}
+
+//--------------------------------------------------------------------------------------
+// Constant folding, with C semantics of 32 bit int and double
+//
+
+// Folding is refused where C is undefined: division by zero and
+// results not fitting in int. Doubles which are not finite are refused
+// too, C has no literal for them.
+func foldBinary(op Op, x, y *Const) (*Const, bool) {
+	if x.Typ == Double {
+		a, b := x.F, y.F
+		var r float64
+		switch op {
+		case Add:
+			r = a + b
+		case Sub:
+			r = a - b
+		case Mul:
+			r = a * b
+		case Div:
+			r = a / b
+		default:
+			return boolConst(compare(op, a, b)), true
+		}
+		if math.IsInf(r, 0) || math.IsNaN(r) {
+			return nil, false
+		}
+		return DoubleConst(r), true
+	}
+
+	a, b := x.I, y.I
+	var r int64
+	switch op {
+	case Add:
+		r = a + b
+	case Sub:
+		r = a - b
+	case Mul:
+		r = a * b
+	case Div:
+		if b == 0 {
+			return nil, false
+		}
+		r = a / b
+	default:
+		return boolConst(compare(op, float64(a), float64(b))), true
+	}
+	return intConst(r)
+}
+
+func compare(op Op, a, b float64) bool {
+	switch op {
+	case Eq:
+		return a == b
+	case Ne:
+		return a != b
+	case Lt:
+		return a < b
+	case Le:
+		return a <= b
+	case Gt:
+		return a > b
+	}
+	return a >= b
+}
+
+func foldUnary(op Op, x *Const) (*Const, bool) {
+	if x.Typ == Double {
+		return DoubleConst(-x.F), true
+	}
+	return intConst(-x.I)
+}
+
+func foldConvert(x *Const, typ Type) (*Const, bool) {
+	if typ == Double {
+		if x.Typ == Double {
+			return x, true
+		}
+		return DoubleConst(float64(x.I)), true
+	}
+	if x.Typ == Int {
+		return x, true
+	}
+	if math.IsNaN(x.F) || x.F >= math.MaxInt32+1 || x.F <= math.MinInt32-1 {
+		return nil, false
+	}
+	return IntConst(int64(x.F)), true
+}
+
+func intConst(i int64) (*Const, bool) {
+	if i < math.MinInt32 || i > math.MaxInt32 {
+		return nil, false
+	}
+	return IntConst(i), true
+}
+
+func boolConst(b bool) *Const {
+	if b {
+		return IntConst(1)
+	}
+	return IntConst(0)
+}
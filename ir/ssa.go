@@ -0,0 +1,268 @@
+package ir
+
+import "fmt"
+
+// ToSSA puts f in SSA form. Every assignment to a local or parameter
+// defines a new temp instead, and phis merge them where control flow
+// joins. Locals are gone afterwards, parameters are only read.
+// Unreachable blocks are removed first.
+func ToSSA(f *Func) {
+	if f.SSA {
+		return
+	}
+	RemoveUnreachable(f)
+	dom := Dominators(f)
+
+	vars := append(append([]*Var{}, f.Params...), f.Locals...)
+	phis := map[*Phi]*Var{}
+	for _, v := range vars {
+		insertPhis(f, dom, v, phis)
+	}
+
+	// Parameters start with their incoming value, locals with zero
+	stacks := map[*Var][]Value{}
+	for _, v := range f.Params {
+		stacks[v] = []Value{v}
+	}
+	for _, v := range f.Locals {
+		stacks[v] = []Value{zero(v.Typ)}
+	}
+
+	var rename func(b *Block)
+	rename = func(b *Block) {
+		var defined []*Var
+		for _, instr := range b.Instrs {
+			if phi, ok := instr.(*Phi); ok {
+				v := phis[phi]
+				stacks[v] = append(stacks[v], phi.Dst)
+				defined = append(defined, v)
+				continue
+			}
+
+			for _, use := range Uses(instr) {
+				if v, ok := (*use).(*Var); ok {
+					*use = top(stacks[v])
+				}
+			}
+			if v, ok := Defs(instr).(*Var); ok {
+				t := f.NewTemp(v.Typ)
+				setDef(instr, t)
+				stacks[v] = append(stacks[v], t)
+				defined = append(defined, v)
+			}
+		}
+
+		for _, s := range b.Succs {
+			j := predIndex(s, b)
+			for _, instr := range s.Instrs {
+				phi, ok := instr.(*Phi)
+				if !ok {
+					break
+				}
+				phi.Args[j] = top(stacks[phis[phi]])
+			}
+		}
+
+		for _, child := range dom.Children(b) {
+			rename(child)
+		}
+		for _, v := range defined {
+			stacks[v] = stacks[v][:len(stacks[v])-1]
+		}
+	}
+	rename(f.Blocks[0])
+
+	f.Locals = nil
+	f.SSA = true
+	prunePhis(f)
+}
+
+// insertPhis places a phi for v at the iterated dominance frontier of
+// blocks assigning v. Entry assigns every variable its initial value.
+func insertPhis(f *Func, dom *DomTree, v *Var, phis map[*Phi]*Var) {
+	work := []*Block{f.Blocks[0]}
+	defined := map[*Block]bool{f.Blocks[0]: true}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if Defs(instr) == Value(v) && !defined[b] {
+				defined[b] = true
+				work = append(work, b)
+			}
+		}
+	}
+
+	placed := map[*Block]bool{}
+	for len(work) > 0 {
+		b := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, y := range dom.Frontier(b) {
+			if placed[y] {
+				continue
+			}
+			placed[y] = true
+
+			phi := &Phi{Dst: f.NewTemp(v.Typ), Args: make([]Value, len(y.Preds))}
+			y.Instrs = append([]Instr{phi}, y.Instrs...)
+			phis[phi] = v
+			if !defined[y] {
+				defined[y] = true
+				work = append(work, y)
+			}
+		}
+	}
+}
+
+// prunePhis removes phis whose value is never used except by dead phis
+func prunePhis(f *Func) {
+	live := map[Value]bool{}
+	var work []*Phi
+	defs := map[Value]*Phi{}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if phi, ok := instr.(*Phi); ok {
+				defs[phi.Dst] = phi
+			}
+		}
+	}
+	mark := func(v Value) {
+		if phi, ok := defs[v]; ok && !live[v] {
+			live[v] = true
+			work = append(work, phi)
+		}
+	}
+
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if _, ok := instr.(*Phi); ok {
+				continue
+			}
+			for _, use := range Uses(instr) {
+				mark(*use)
+			}
+		}
+	}
+	for len(work) > 0 {
+		phi := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, arg := range phi.Args {
+			mark(arg)
+		}
+	}
+
+	for _, b := range f.Blocks {
+		instrs := b.Instrs[:0]
+		for _, instr := range b.Instrs {
+			if phi, ok := instr.(*Phi); ok && !live[phi.Dst] {
+				continue
+			}
+			instrs = append(instrs, instr)
+		}
+		b.Instrs = instrs
+	}
+}
+
+// FromSSA replaces phis by copies. Every phi gets a new local which
+// predecessors assign before they jump and the phi's temp reads, so
+// phis of one block never see copies made for each other.
+func FromSSA(f *Func) {
+	if !f.SSA {
+		return
+	}
+	for _, b := range f.Blocks {
+		for i, instr := range b.Instrs {
+			phi, ok := instr.(*Phi)
+			if !ok {
+				break
+			}
+
+			v := &Var{Name: fmt.Sprintf("_phi%d", phi.Dst.(*Temp).ID), Typ: phi.Dst.Type()}
+			f.Locals = append(f.Locals, v)
+			for j, p := range b.Preds {
+				p.insertBeforeTerminator(&Copy{Dst: v, Src: phi.Args[j]})
+			}
+			b.Instrs[i] = &Copy{Dst: phi.Dst, Src: v}
+		}
+	}
+	f.SSA = false
+}
+
+// RemoveUnreachable deletes blocks not reachable from the entry and
+// returns how many. Phis lose arguments of deleted predecessors.
+func RemoveUnreachable(f *Func) int {
+	reachable := map[*Block]bool{}
+	for _, b := range reversePostorder(f.Blocks[0]) {
+		reachable[b] = true
+	}
+	if len(reachable) == len(f.Blocks) {
+		return 0
+	}
+
+	blocks := f.Blocks[:0]
+	removed := 0
+	for _, b := range f.Blocks {
+		if !reachable[b] {
+			removed++
+			continue
+		}
+		for j := len(b.Preds) - 1; j >= 0; j-- {
+			if !reachable[b.Preds[j]] {
+				b.removePred(j)
+			}
+		}
+		blocks = append(blocks, b)
+	}
+	f.Blocks = blocks
+	f.Renumber()
+	return removed
+}
+
+// removePred deletes the j-th predecessor and its phi arguments
+func (b *Block) removePred(j int) {
+	b.Preds = append(b.Preds[:j], b.Preds[j+1:]...)
+	for _, instr := range b.Instrs {
+		phi, ok := instr.(*Phi)
+		if !ok {
+			break
+		}
+		phi.Args = append(phi.Args[:j], phi.Args[j+1:]...)
+	}
+}
+
+func (b *Block) insertBeforeTerminator(instr Instr) {
+	n := len(b.Instrs)
+	if b.Terminator() == nil {
+		b.add(instr)
+		return
+	}
+	b.Instrs = append(b.Instrs[:n-1], instr, b.Instrs[n-1])
+}
+
+func predIndex(b, pred *Block) int {
+	for j, p := range b.Preds {
+		if p == pred {
+			return j
+		}
+	}
+	return -1
+}
+
+func setDef(instr Instr, v Value) {
+	switch i := instr.(type) {
+	case *Copy:
+		i.Dst = v
+	case *BinOp:
+		i.Dst = v
+	case *UnOp:
+		i.Dst = v
+	case *Convert:
+		i.Dst = v
+	case *Call:
+		i.Dst = v
+	case *Phi:
+		i.Dst = v
+	}
+}
+
+func top(stack []Value) Value {
+	return stack[len(stack)-1]
+}
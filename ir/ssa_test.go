@@ -0,0 +1,133 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sum builds
+//
+//	func sum(int n) int { int s = 0 for (int i = 0; i < n; i++) { s = s + i } return s }
+func sum() *Func {
+	n := &Var{Name: "n", Typ: Int}
+	s := &Var{Name: "s", Typ: Int}
+	i := &Var{Name: "i", Typ: Int}
+	f := &Func{Name: "sum", Result: Int, Params: []*Var{n}, Locals: []*Var{s, i}}
+	entry, head, body, done := f.NewBlock(), f.NewBlock(), f.NewBlock(), f.NewBlock()
+
+	entry.add(&Copy{Dst: s, Src: IntConst(0)})
+	entry.add(&Copy{Dst: i, Src: IntConst(0)})
+	entry.add(&Jump{Target: head})
+
+	cond := f.NewTemp(Int)
+	head.add(&BinOp{Dst: cond, Op: Lt, X: i, Y: n})
+	head.add(&Branch{Cond: cond, Then: body, Else: done})
+
+	body.add(&BinOp{Dst: s, Op: Add, X: s, Y: i})
+	body.add(&BinOp{Dst: i, Op: Add, X: i, Y: IntConst(1)})
+	body.add(&Jump{Target: head})
+
+	done.add(&Return{Value: s})
+	f.ComputeCFG()
+	return f
+}
+
+func TestToSSA(t *testing.T) {
+	f := sum()
+	assert.Nil(t, Verify(f))
+
+	ToSSA(f)
+	expect := "func sum(int n) int\n" +
+		"b0:\n" +
+		"\t%4:int = 0\n" +
+		"\t%5:int = 0\n" +
+		"\tjump b1\n" +
+		"b1: ; preds b0, b2\n" +
+		"\t%3:int = phi %5, %7\n" +
+		"\t%2:int = phi %4, %6\n" +
+		"\t%1:int = %3 < n\n" +
+		"\tbranch %1, b2, b3\n" +
+		"b2: ; preds b1\n" +
+		"\t%6:int = %2 + %3\n" +
+		"\t%7:int = %3 + 1\n" +
+		"\tjump b1\n" +
+		"b3: ; preds b1\n" +
+		"\tret %2\n"
+	assert.Equal(t, expect, f.String())
+	assert.True(t, f.SSA)
+	assert.Nil(t, Verify(f))
+
+	FromSSA(f)
+	assert.False(t, f.SSA)
+	assert.Nil(t, Verify(f))
+	expect = "func sum(int n) int\n" +
+		"\tvar int _phi3\n" +
+		"\tvar int _phi2\n" +
+		"b0:\n" +
+		"\t%4:int = 0\n" +
+		"\t%5:int = 0\n" +
+		"\t_phi3 = %5\n" +
+		"\t_phi2 = %4\n" +
+		"\tjump b1\n" +
+		"b1: ; preds b0, b2\n" +
+		"\t%3:int = _phi3\n" +
+		"\t%2:int = _phi2\n" +
+		"\t%1:int = %3 < n\n" +
+		"\tbranch %1, b2, b3\n" +
+		"b2: ; preds b1\n" +
+		"\t%6:int = %2 + %3\n" +
+		"\t%7:int = %3 + 1\n" +
+		"\t_phi3 = %7\n" +
+		"\t_phi2 = %6\n" +
+		"\tjump b1\n" +
+		"b3: ; preds b1\n" +
+		"\tret %2\n"
+	assert.Equal(t, expect, f.String())
+}
+
+func TestToSSAParamAndUnreachable(t *testing.T) {
+	// func abs(int a) int { if (a < 0) { a = -a } return a } with a dead block
+	a := &Var{Name: "a", Typ: Int}
+	f := &Func{Name: "abs", Result: Int, Params: []*Var{a}}
+	entry, neg, done, dead := f.NewBlock(), f.NewBlock(), f.NewBlock(), f.NewBlock()
+	cond := f.NewTemp(Int)
+	entry.add(&BinOp{Dst: cond, Op: Lt, X: a, Y: IntConst(0)})
+	entry.add(&Branch{Cond: cond, Then: neg, Else: done})
+	neg.add(&UnOp{Dst: a, Op: Neg, X: a})
+	neg.add(&Jump{Target: done})
+	done.add(&Return{Value: a})
+	dead.add(&Jump{Target: done})
+	f.ComputeCFG()
+
+	ToSSA(f)
+	assert.Nil(t, Verify(f))
+	assert.Len(t, f.Blocks, 3)
+	assert.Equal(t, "%2:int = phi a, %3", f.Blocks[2].Instrs[0].String())
+}
+
+func TestVerify(t *testing.T) {
+	f := sum()
+	ToSSA(f)
+	body := f.Blocks[2]
+
+	// Swap uses of the loop: %6 is used before it is defined
+	add := body.Instrs[0].(*BinOp)
+	add.X = body.Instrs[1].(*BinOp).Dst
+	assert.EqualError(t, Verify(f), "sum: b2: %7 used before definition in %6:int = %7 + %3")
+
+	f = sum()
+	ToSSA(f)
+	head := f.Blocks[1]
+	head.Instrs[0].(*Phi).Args = head.Instrs[0].(*Phi).Args[:1]
+	assert.EqualError(t, Verify(f), "sum: b1: %3:int = phi %5 has 1 arguments for 2 predecessors")
+
+	f = sum()
+	ToSSA(f)
+	f.Blocks[3].Instrs = append([]Instr{&Copy{Dst: f.Params[0], Src: IntConst(1)}}, f.Blocks[3].Instrs...)
+	assert.EqualError(t, Verify(f), "sum: b3: n assigned in SSA form")
+
+	f = sum()
+	f.Blocks[0].Instrs = f.Blocks[0].Instrs[:2]
+	assert.EqualError(t, Verify(f), "sum: b0: missing terminator")
+}
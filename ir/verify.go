@@ -0,0 +1,198 @@
+package ir
+
+import "fmt"
+
+// Verify checks that f is well formed: blocks end with their only
+// terminator, Preds and Succs match the terminators, temps are defined
+// once and operand types agree. In SSA form it also checks that locals
+// are never assigned, phis are at block starts with an argument per
+// predecessor and every use is dominated by its definition.
+func Verify(f *Func) error {
+	v := &verifier{f: f, defs: map[*Temp]*Block{}, pos: map[*Temp]int{}}
+	if err := v.verify(); err != nil {
+		return fmt.Errorf("%s: %s", f.Name, err)
+	}
+	return nil
+}
+
+type verifier struct {
+	f    *Func
+	defs map[*Temp]*Block
+	pos  map[*Temp]int // index of the definition in its block
+}
+
+func (v *verifier) verify() error {
+	f := v.f
+	if len(f.Blocks) == 0 {
+		return fmt.Errorf("no blocks")
+	}
+
+	blocks := map[*Block]bool{}
+	for i, b := range f.Blocks {
+		if b.Index != i {
+			return fmt.Errorf("%s: index is %d", b.Name(), i)
+		}
+		blocks[b] = true
+	}
+
+	for _, b := range f.Blocks {
+		if err := v.block(b, blocks); err != nil {
+			return fmt.Errorf("%s: %s", b.Name(), err)
+		}
+	}
+
+	if !f.SSA {
+		return nil
+	}
+
+	dom := Dominators(f)
+	for _, b := range f.Blocks {
+		if !dom.Reachable(b) {
+			return fmt.Errorf("%s: unreachable", b.Name())
+		}
+	}
+	for _, b := range f.Blocks {
+		for i, instr := range b.Instrs {
+			phi, isPhi := instr.(*Phi)
+			for j, use := range Uses(instr) {
+				t, ok := (*use).(*Temp)
+				if !ok {
+					continue
+				}
+				// Phi arguments are used at the end of predecessors
+				at, pos := b, i
+				if isPhi {
+					at, pos = b.Preds[j], len(b.Preds[j].Instrs)
+				}
+				if !v.dominates(dom, t, at, pos) {
+					if isPhi {
+						return fmt.Errorf("%s: %s not defined on edge from %s", b.Name(), phi, at.Name())
+					}
+					return fmt.Errorf("%s: %s used before definition in %s", b.Name(), t, instr)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (v *verifier) dominates(dom *DomTree, t *Temp, b *Block, pos int) bool {
+	if v.defs[t] == b {
+		return v.pos[t] < pos
+	}
+	return dom.Dominates(v.defs[t], b)
+}
+
+func (v *verifier) block(b *Block, blocks map[*Block]bool) error {
+	f := v.f
+	if b.Terminator() == nil {
+		return fmt.Errorf("missing terminator")
+	}
+
+	phis := true
+	for i, instr := range b.Instrs {
+		switch instr := instr.(type) {
+		case *Jump, *Branch, *Return:
+			if i != len(b.Instrs)-1 {
+				return fmt.Errorf("terminator %s in the middle", instr)
+			}
+		case *Phi:
+			if !f.SSA {
+				return fmt.Errorf("phi outside of SSA form: %s", instr)
+			}
+			if !phis {
+				return fmt.Errorf("phi after other instructions: %s", instr)
+			}
+			if len(instr.Args) != len(b.Preds) {
+				return fmt.Errorf("%s has %d arguments for %d predecessors", instr, len(instr.Args), len(b.Preds))
+			}
+		}
+		if _, ok := instr.(*Phi); !ok {
+			phis = false
+		}
+
+		if err := v.types(instr); err != nil {
+			return err
+		}
+
+		switch dst := Defs(instr).(type) {
+		case *Temp:
+			if v.defs[dst] != nil {
+				return fmt.Errorf("%s defined twice", dst)
+			}
+			v.defs[dst] = b
+			v.pos[dst] = i
+		case *Var:
+			if f.SSA {
+				return fmt.Errorf("%s assigned in SSA form", dst)
+			}
+		}
+	}
+
+	var succs []*Block
+	switch t := b.Terminator().(type) {
+	case *Jump:
+		succs = []*Block{t.Target}
+	case *Branch:
+		succs = []*Block{t.Then}
+		if t.Else != t.Then {
+			succs = append(succs, t.Else)
+		}
+	}
+	if len(succs) != len(b.Succs) {
+		return fmt.Errorf("successors don't match terminator")
+	}
+	for i, s := range succs {
+		if !blocks[s] {
+			return fmt.Errorf("jump to block of another function")
+		}
+		if b.Succs[i] != s {
+			return fmt.Errorf("successors don't match terminator")
+		}
+		if predIndex(s, b) < 0 {
+			return fmt.Errorf("missing from predecessors of %s", s.Name())
+		}
+	}
+	for _, p := range b.Preds {
+		if !containsBlock(p.Succs, b) {
+			return fmt.Errorf("predecessor %s doesn't jump here", p.Name())
+		}
+	}
+	return nil
+}
+
+func (v *verifier) types(instr Instr) error {
+	mismatch := func() error {
+		return fmt.Errorf("type mismatch: %s", instr)
+	}
+
+	switch i := instr.(type) {
+	case *Copy:
+		if i.Dst.Type() != i.Src.Type() {
+			return mismatch()
+		}
+	case *BinOp:
+		result := i.X.Type()
+		if i.Op.IsCompare() {
+			result = Int
+		}
+		if i.X.Type() != i.Y.Type() || i.Dst.Type() != result {
+			return mismatch()
+		}
+	case *UnOp:
+		if i.Dst.Type() != i.X.Type() {
+			return mismatch()
+		}
+	case *Phi:
+		for _, arg := range i.Args {
+			if arg == nil || arg.Type() != i.Dst.Type() {
+				return mismatch()
+			}
+		}
+	case *Return:
+		if i.Value == nil && v.f.Result != Void || i.Value != nil && i.Value.Type() != v.f.Result {
+			return mismatch()
+		}
+	}
+	return nil
+}
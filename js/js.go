@@ -0,0 +1,592 @@
+// Package js emits a JavaScript ES module from the AST, to run programs
+// in Node or a browser. Every top level function and constant is
+// exported.
+//
+// Numbers of JavaScript are doubles. Ints are kept 32 bits by |0 after
+// each operation, Math.imul multiplies them and the runtime divides them
+// truncating as C does.
+package js
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/rabierre/compiler/ir"
+	"github.com/rabierre/compiler/token"
+)
+
+// Runtime is put once before the code of all files. Its names start
+// with $, which idents can't have.
+const Runtime = `// Runtime
+function $idiv(x, y) {
+  if (y === 0) {
+    throw new RangeError("integer division by zero");
+  }
+  return x / y | 0;
+}
+
+function $name(names, v) {
+  return names.has(v) ? names.get(v) : "?";
+}
+`
+
+// Emit returns JavaScript of decls, which have to be constant folded
+// already. Names used by the file but declared in other files are
+// looked up in globals. Errors are reported by panic as ir.Lower does.
+func Emit(decls []ast.Decl, globals map[string]ir.Signature) string {
+	g := &gen{
+		globals: globals,
+		funcs:   map[string]*ast.FuncDecl{},
+		consts:  map[string]ir.Type{},
+	}
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			g.funcs[d.Name.Name] = d
+		case *ast.ConstDecl:
+			g.consts[d.Name.Name] = literalType(d.Value.(*ast.BasicLit))
+		case *ast.EnumDecl:
+			for _, member := range d.Members {
+				g.consts[member.Name.Name] = ir.Int
+			}
+		}
+	}
+
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.ConstDecl:
+			g.line("export const %s = %s;", name(d.Name.Name), literal(d.Value.(*ast.BasicLit)))
+		case *ast.EnumDecl:
+			g.enum(d)
+		case *ast.FuncDecl:
+			g.buf.WriteByte('\n')
+			g.function(d)
+		}
+	}
+	return g.buf.String()
+}
+
+// Module puts code returned by Emit after the runtime
+func Module(code string) string {
+	return Runtime + "\n" + code
+}
+
+type gen struct {
+	buf     bytes.Buffer
+	globals map[string]ir.Signature
+	funcs   map[string]*ast.FuncDecl
+	consts  map[string]ir.Type // top level constants and enum members
+
+	fn     *ast.FuncDecl
+	result ir.Type
+	scopes []map[string]ir.Type
+	depth  int
+}
+
+func (g *gen) line(format string, args ...interface{}) {
+	g.buf.WriteString(strings.Repeat("  ", g.depth))
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+// Members are constants, Color_name returns the name of a value as in
+// C output. Members sharing a value are named by the first.
+func (g *gen) enum(d *ast.EnumDecl) {
+	var entries []string
+	seen := map[string]bool{}
+	for _, member := range d.Members {
+		value := literal(member.Value.(*ast.BasicLit))
+		g.line("export const %s = %s;", name(member.Name.Name), value)
+		if !seen[value] {
+			seen[value] = true
+			entries = append(entries, fmt.Sprintf("[%s, %q]", value, member.Name.Name))
+		}
+	}
+	g.line("const $%s = new Map([%s]);", d.Name.Name, strings.Join(entries, ", "))
+	g.line("export function %s_name(v) {", d.Name.Name)
+	g.line("  return $name($%s, v);", d.Name.Name)
+	g.line("}")
+}
+
+func (g *gen) function(d *ast.FuncDecl) {
+	g.fn = d
+	g.result = ir.TypeOf(d.Type, d.TypeName)
+	g.openScope()
+
+	var params []string
+	for _, param := range d.Params.List {
+		p := param.(*ast.VarDeclStmt)
+		g.declare(p.Name.Name, ir.TypeOf(p.Type, p.TypeName))
+		params = append(params, name(p.Name.Name))
+	}
+	g.line("export function %s(%s) {", name(d.Name.Name), strings.Join(params, ", "))
+	g.depth++
+	g.stmtList(d.Body.List)
+	// Falling off the end returns zero value, not undefined
+	if _, ok := last(d.Body.List).(*ast.ReturnStmt); !ok && g.result != ir.Void {
+		g.line("return 0;")
+	}
+	g.depth--
+	g.line("}")
+	g.closeScope()
+}
+
+func (g *gen) openScope() {
+	g.scopes = append(g.scopes, map[string]ir.Type{})
+}
+
+func (g *gen) closeScope() {
+	g.scopes = g.scopes[:len(g.scopes)-1]
+}
+
+func (g *gen) declare(ident string, typ ir.Type) {
+	g.scopes[len(g.scopes)-1][ident] = typ
+}
+
+func (g *gen) lookup(ident string) (ir.Type, bool) {
+	for i := len(g.scopes) - 1; i >= 0; i-- {
+		if typ, exist := g.scopes[i][ident]; exist {
+			return typ, true
+		}
+	}
+	return ir.Void, false
+}
+
+//--------------------------------------------------------------------------------------
+// Statement
+//
+
+func (g *gen) stmtList(list []ast.Stmt) {
+	for _, s := range list {
+		g.stmt(s)
+	}
+}
+
+func (g *gen) stmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.VarDeclStmt:
+		g.line("%s;", g.varDecl(s))
+	case *ast.ExprStmt:
+		g.line("%s;", g.effect(s.Val))
+	case *ast.ReturnStmt:
+		g.returnStmt(s)
+	case *ast.CompoundStmt:
+		g.line("{")
+		g.block(s.List)
+		g.line("}")
+	case *ast.IfStmt:
+		g.ifStmt(s, "")
+	case *ast.ForStmt:
+		g.forStmt(s)
+	case *ast.SwitchStmt:
+		g.switchStmt(s)
+	case *ast.ConstDecl, *ast.FallthroughStmt:
+		// uses are folded already, switchStmt leaves out the break
+	}
+}
+
+// block writes list in a scope, braces are written by the caller
+func (g *gen) block(list []ast.Stmt) {
+	g.depth++
+	g.openScope()
+	g.stmtList(list)
+	g.closeScope()
+	g.depth--
+}
+
+// Variables without value start zero
+func (g *gen) varDecl(s *ast.VarDeclStmt) string {
+	typ := ir.TypeOf(s.Type, s.TypeName)
+	value := "0"
+	if s.RValue != nil {
+		value = g.exprAs(s.RValue, typ)
+	}
+	g.declare(s.Name.Name, typ)
+	return fmt.Sprintf("let %s = %s", name(s.Name.Name), bare(value))
+}
+
+func (g *gen) returnStmt(s *ast.ReturnStmt) {
+	switch {
+	case g.result == ir.Void && s.Value != nil:
+		panic("Too many return values in " + g.fn.Name.Name)
+	case g.result != ir.Void && s.Value == nil:
+		panic("Not enough return values in " + g.fn.Name.Name)
+	case s.Value != nil:
+		g.line("return %s;", bare(g.exprAs(s.Value, g.result)))
+	default:
+		g.line("return;")
+	}
+}
+
+// ifStmt writes else if chains without nesting, prefix is "} else "
+// for all but the first
+func (g *gen) ifStmt(s *ast.IfStmt, prefix string) {
+	g.line("%sif (%s) {", prefix, g.cond(s.Cond))
+	g.block(s.Body.List)
+	switch els := s.ElseBody.(type) {
+	case nil:
+		g.line("}")
+	case *ast.IfStmt:
+		g.ifStmt(els, "} else ")
+	case *ast.CompoundStmt:
+		g.line("} else {")
+		g.block(els.List)
+		g.line("}")
+	default:
+		g.line("} else {")
+		g.depth++
+		g.stmt(els)
+		g.depth--
+		g.line("}")
+	}
+}
+
+func (g *gen) forStmt(s *ast.ForStmt) {
+	g.openScope()
+	var init, cond, post string
+	switch i := s.Init.(type) {
+	case nil:
+	case *ast.VarDeclStmt:
+		init = g.varDecl(i)
+	case *ast.ExprStmt:
+		init = g.effect(i.Val)
+	default:
+		panic(fmt.Sprintf("Invalid for init: %#v", s.Init))
+	}
+	if s.Cond != nil {
+		cond = " " + g.cond(s.Cond)
+	}
+	if s.Post != nil {
+		post = " " + g.effect(s.Post)
+	}
+	g.line("for (%s;%s;%s) {", init, cond, post)
+	g.block(s.Body.List)
+	g.line("}")
+	g.closeScope()
+}
+
+// Cases of JavaScript fall through unless they break, and compare with
+// ===, which is == of C for numbers of one type
+func (g *gen) switchStmt(s *ast.SwitchStmt) {
+	tag, typ := g.expr(s.Tag)
+	g.line("switch (%s) {", tag)
+	for _, clause := range s.Body {
+		if clause.List == nil {
+			g.line("default: {")
+		}
+		for i, x := range clause.List {
+			open := ""
+			if i == len(clause.List)-1 {
+				open = " {"
+			}
+			g.line("case %s:%s", g.exprAs(x, typ), open)
+		}
+		g.block(clause.Body)
+		if _, ok := last(clause.Body).(*ast.FallthroughStmt); !ok {
+			g.line("  break;")
+		}
+		g.line("}")
+	}
+	g.line("}")
+}
+
+func last(list []ast.Stmt) ast.Stmt {
+	if len(list) == 0 {
+		return nil
+	}
+	return list[len(list)-1]
+}
+
+//--------------------------------------------------------------------------------------
+// Expression
+//
+
+// cond returns x as a JavaScript condition. NaN is false in JavaScript
+// but not zero in C, so doubles are compared.
+func (g *gen) cond(x ast.Expr) string {
+	if e, ok := x.(*ast.BinaryExpr); ok && compares(e) {
+		return bare(g.compare(e))
+	}
+	code, typ := g.expr(x)
+	if typ == ir.Double {
+		return code + " !== 0"
+	}
+	return code
+}
+
+// effect returns x as a statement whose value is unused. Increments
+// and assignments are simpler then.
+func (g *gen) effect(x ast.Expr) string {
+	if e, ok := x.(*ast.ShortExpr); ok {
+		v, typ := g.variable(e.RValue)
+		op := "+"
+		if e.Op.Type == token.DEC {
+			op = "-"
+		}
+		if typ == ir.Double {
+			return fmt.Sprintf("%s %s= 1", v, op)
+		}
+		return fmt.Sprintf("%s = %s %s 1 | 0", v, v, op)
+	}
+	if e, ok := x.(*ast.AssignExpr); ok {
+		v, typ := g.variable(e.LValue)
+		return fmt.Sprintf("%s = %s", v, bare(g.exprAs(e.RValue, typ)))
+	}
+	code, _ := g.expr(x)
+	return bare(code)
+}
+
+// expr returns code of x and its type. Code is an operand or in
+// parentheses, so it can be used in any other expression.
+func (g *gen) expr(x ast.Expr) (string, ir.Type) {
+	switch e := x.(type) {
+	case *ast.BasicLit:
+		return literal(e), literalType(e)
+	case *ast.Ident:
+		return g.ident(e)
+	case *ast.UnaryExpr:
+		code, typ := g.expr(e.RValue)
+		switch {
+		case e.Op.Type == token.PLUS:
+			return code, typ
+		case typ == ir.Double:
+			return "(-" + code + ")", typ
+		}
+		return "(-" + code + " | 0)", typ
+	case *ast.BinaryExpr:
+		return g.binaryExpr(e)
+	case *ast.CallExpr:
+		return g.call(e)
+	case *ast.AssignExpr:
+		v, typ := g.variable(e.LValue)
+		return fmt.Sprintf("(%s = %s)", v, g.exprAs(e.RValue, typ)), typ
+	case *ast.ShortExpr:
+		// Value is the one before increment, which x++ gives but
+		// doesn't wrap
+		v, typ := g.variable(e.RValue)
+		op, undo := "+", "-"
+		if e.Op.Type == token.DEC {
+			op, undo = "-", "+"
+		}
+		if typ == ir.Double {
+			return "(" + v + op + op + ")", typ
+		}
+		return fmt.Sprintf("((%s = %s %s 1 | 0) %s 1 | 0)", v, v, op, undo), typ
+	}
+	panic(fmt.Sprintf("Invalid expression: %#v", x))
+}
+
+func (g *gen) ident(e *ast.Ident) (string, ir.Type) {
+	if typ, exist := g.lookup(e.Name); exist {
+		return name(e.Name), typ
+	}
+	if typ, exist := g.consts[e.Name]; exist {
+		return name(e.Name), typ
+	}
+	if sig, exist := g.globals[e.Name]; exist && !sig.Func {
+		return name(e.Name), sig.Result
+	}
+	panic("Undefined: " + e.Name)
+}
+
+// variable returns the variable x assigns to
+func (g *gen) variable(x ast.Expr) (string, ir.Type) {
+	if id, ok := x.(*ast.Ident); ok {
+		if typ, exist := g.lookup(id.Name); exist {
+			return name(id.Name), typ
+		}
+	}
+	panic(fmt.Sprintf("Cannot assign to %#v", x))
+}
+
+var binOps = map[token.Type]string{
+	token.PLUS:   "+",
+	token.MINUS:  "-",
+	token.MULTI:  "*",
+	token.DIVIDE: "/",
+	token.EQ:     "===",
+	token.NEQ:    "!==",
+	token.LESS:   "<",
+	token.LEQ:    "<=",
+	token.GRT:    ">",
+	token.GEQ:    ">=",
+}
+
+// Operands are converted to double if either is, which ints already are
+func (g *gen) binaryExpr(e *ast.BinaryExpr) (string, ir.Type) {
+	op, x, y, typ := g.operands(e)
+
+	switch {
+	case compares(e):
+		return "(" + g.compare(e) + " ? 1 : 0)", ir.Int
+	case typ == ir.Double:
+		return fmt.Sprintf("(%s %s %s)", x, op, y), typ
+	case op == "*":
+		return fmt.Sprintf("Math.imul(%s, %s)", x, y), typ
+	case op == "/":
+		return fmt.Sprintf("$idiv(%s, %s)", x, y), typ
+	}
+	return fmt.Sprintf("(%s %s %s | 0)", x, op, y), typ
+}
+
+func (g *gen) operands(e *ast.BinaryExpr) (op, x, y string, typ ir.Type) {
+	op, ok := binOps[e.Op.Type]
+	if !ok {
+		panic("Invalid operator: " + e.Op.Type.String())
+	}
+
+	x, xtyp := g.expr(e.LValue)
+	y, ytyp := g.expr(e.RValue)
+	if xtyp == ir.Void || ytyp == ir.Void {
+		panic("Void value used in " + e.Op.Type.String())
+	}
+	typ = ir.Int
+	if xtyp == ir.Double || ytyp == ir.Double {
+		typ = ir.Double
+	}
+	return op, x, y, typ
+}
+
+// compare returns a comparison as a JavaScript boolean
+func (g *gen) compare(e *ast.BinaryExpr) string {
+	op, x, y, _ := g.operands(e)
+	return fmt.Sprintf("(%s %s %s)", x, op, y)
+}
+
+func compares(e *ast.BinaryExpr) bool {
+	switch e.Op.Type {
+	case token.EQ, token.NEQ, token.LESS, token.LEQ, token.GRT, token.GEQ:
+		return true
+	}
+	return false
+}
+
+// bare returns code without parentheses around all of it, for where
+// no operator binds to it
+func bare(code string) string {
+	if !strings.HasPrefix(code, "(") {
+		return code
+	}
+	depth := 0
+	for i, r := range code {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 && i < len(code)-1 {
+				return code
+			}
+		}
+	}
+	return code[1 : len(code)-1]
+}
+
+// exprAs returns code of x converted to typ. Ints are doubles already,
+// doubles are truncated.
+func (g *gen) exprAs(x ast.Expr, typ ir.Type) string {
+	code, from := g.expr(x)
+	switch {
+	case from == typ || typ == ir.Double && from == ir.Int:
+		return code
+	case from == ir.Void:
+		panic("Void value used as " + typ.String())
+	}
+	return "(" + code + " | 0)"
+}
+
+func (g *gen) call(e *ast.CallExpr) (string, ir.Type) {
+	fn := e.Name.(*ast.Ident).Name
+	sig := g.signature(fn)
+
+	if len(e.Params.List) != len(sig.Params) {
+		panic(fmt.Sprintf("Wrong number of arguments to %s: %d, want %d", fn, len(e.Params.List), len(sig.Params)))
+	}
+	args := make([]string, len(sig.Params))
+	for i, param := range e.Params.List {
+		args[i] = g.exprAs(param, sig.Params[i])
+	}
+	return fmt.Sprintf("%s(%s)", name(fn), strings.Join(args, ", ")), sig.Result
+}
+
+func (g *gen) signature(fn string) ir.Signature {
+	if d, exist := g.funcs[fn]; exist {
+		sig := ir.Signature{Func: true, Result: ir.TypeOf(d.Type, d.TypeName)}
+		for _, param := range d.Params.List {
+			p := param.(*ast.VarDeclStmt)
+			sig.Params = append(sig.Params, ir.TypeOf(p.Type, p.TypeName))
+		}
+		return sig
+	}
+	if sig, exist := g.globals[fn]; exist && sig.Func {
+		return sig
+	}
+	panic("Undefined function: " + fn)
+}
+
+// Int literals wrap to 32 bits as int of C, doubles keep a point
+func literal(e *ast.BasicLit) string {
+	switch e.Type {
+	case token.INT_LIT:
+		i, err := strconv.ParseInt(e.Value, 10, 64)
+		if err != nil {
+			panic("Invalid int literal: " + e.Value)
+		}
+		if int32(i) < 0 {
+			return fmt.Sprintf("(%d)", int32(i))
+		}
+		return strconv.Itoa(int(int32(i)))
+	case token.DOUBLE_LIT:
+		f, err := strconv.ParseFloat(e.Value, 64)
+		if err != nil {
+			panic("Invalid double literal: " + e.Value)
+		}
+		switch {
+		case math.IsNaN(f):
+			return "NaN"
+		case math.IsInf(f, 1):
+			return "Infinity"
+		case math.IsInf(f, -1):
+			return "(-Infinity)"
+		case f < 0:
+			return "(" + strconv.FormatFloat(f, 'g', -1, 64) + ")"
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	case token.TRUE:
+		return "1"
+	}
+	return "0" // token.FALSE
+}
+
+func literalType(e *ast.BasicLit) ir.Type {
+	if e.Type == token.DOUBLE_LIT {
+		return ir.Double
+	}
+	return ir.Int
+}
+
+// Words JavaScript reserves get a trailing $
+var reserved = map[string]bool{
+	"await": true, "break": true, "case": true, "catch": true, "class": true,
+	"const": true, "continue": true, "debugger": true, "default": true, "delete": true,
+	"do": true, "else": true, "enum": true, "export": true, "extends": true,
+	"false": true, "finally": true, "for": true, "function": true, "if": true,
+	"import": true, "in": true, "instanceof": true, "new": true, "null": true,
+	"return": true, "super": true, "switch": true, "this": true, "throw": true,
+	"true": true, "try": true, "typeof": true, "var": true, "void": true,
+	"while": true, "with": true, "yield": true, "let": true, "static": true,
+	"implements": true, "interface": true, "package": true, "private": true,
+	"protected": true, "public": true, "arguments": true, "eval": true,
+	"undefined": true, "NaN": true, "Infinity": true, "Math": true,
+}
+
+func name(s string) string {
+	if reserved[s] {
+		return s + "$"
+	}
+	return s
+}
@@ -0,0 +1,48 @@
+package js
+
+import (
+	"testing"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/rabierre/compiler/token"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBare(t *testing.T) {
+	assert.Equal(t, "a + b | 0", bare("(a + b | 0)"))
+	assert.Equal(t, "(a | 0) + (b | 0)", bare("(a | 0) + (b | 0)"))
+	assert.Equal(t, "f(a)", bare("f(a)"))
+}
+
+// func half(int n) double { return n / 2 } and enum Color { RED, GREEN = 5, LIME = 5 }
+func TestEmit(t *testing.T) {
+	n := &ast.Ident{Name: "n"}
+	decls := []ast.Decl{
+		&ast.EnumDecl{Name: &ast.Ident{Name: "Color"}, Members: []*ast.EnumMember{
+			{Name: &ast.Ident{Name: "RED"}, Value: &ast.BasicLit{Value: "0", Type: token.INT_LIT}},
+			{Name: &ast.Ident{Name: "GREEN"}, Value: &ast.BasicLit{Value: "5", Type: token.INT_LIT}},
+			{Name: &ast.Ident{Name: "LIME"}, Value: &ast.BasicLit{Value: "5", Type: token.INT_LIT}},
+		}},
+		&ast.FuncDecl{
+			Name:   &ast.Ident{Name: "half"},
+			Type:   token.DOUBLE,
+			Params: &ast.StmtList{List: []ast.Stmt{&ast.VarDeclStmt{Type: token.INT, Name: n}}},
+			Body: &ast.CompoundStmt{List: []ast.Stmt{&ast.ReturnStmt{Value: &ast.BinaryExpr{
+				LValue: n,
+				RValue: &ast.BasicLit{Value: "2", Type: token.INT_LIT},
+				Op:     ast.Operator{Type: token.DIVIDE},
+			}}}},
+		},
+	}
+	assert.Equal(t, "export const RED = 0;\n"+
+		"export const GREEN = 5;\n"+
+		"export const LIME = 5;\n"+
+		"const $Color = new Map([[0, \"RED\"], [5, \"GREEN\"]]);\n"+
+		"export function Color_name(v) {\n"+
+		"  return $name($Color, v);\n"+
+		"}\n"+
+		"\n"+
+		"export function half(n) {\n"+
+		"  return $idiv(n, 2);\n"+
+		"}\n", Emit(decls, nil))
+}
@@ -0,0 +1,259 @@
+// Package llvm emits LLVM IR in textual form from the IR, for llvm-as,
+// opt, llc or lli. Ints are i32 and doubles double.
+//
+// Variables live in allocas, which mem2reg of opt turns into registers,
+// temporaries are registers. Pointers are typed, as LLVM before 17
+// reads by default.
+package llvm
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/rabierre/compiler/ir"
+)
+
+// Emit returns definitions of constants and functions of prog. The
+// caller puts definitions of every file in one module, so calls between
+// files need no declarations.
+func Emit(prog *ir.Program) string {
+	var buf bytes.Buffer
+	for _, c := range prog.Consts {
+		fmt.Fprintf(&buf, "@%s = constant %s %s\n", c.Name, llvmType(c.Value.Typ), constant(c.Value))
+	}
+	for _, f := range prog.Funcs {
+		buf.WriteByte('\n')
+		g := &gen{buf: &buf, prog: prog, f: f, temps: map[*ir.Temp]string{}}
+		g.function()
+	}
+	return buf.String()
+}
+
+// Module puts definitions returned by Emit in a module for triple,
+// empty leaves the target to LLVM
+func Module(defs, triple string) string {
+	if triple == "" {
+		return defs
+	}
+	return fmt.Sprintf("target triple = %q\n\n", triple) + defs
+}
+
+type gen struct {
+	buf  *bytes.Buffer
+	prog *ir.Program
+	f    *ir.Func
+
+	temps map[*ir.Temp]string // operands of temps, constants are used in place
+	n     int                 // registers of loads and conversions
+}
+
+func (g *gen) emit(format string, args ...interface{}) {
+	g.buf.WriteString("  ")
+	fmt.Fprintf(g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+// reg returns a new register name
+func (g *gen) reg() string {
+	g.n++
+	return fmt.Sprintf("%%.%d", g.n)
+}
+
+// The entry block allocates variables and stores parameters, so blocks
+// of the function can all be jumped to
+func (g *gen) function() {
+	fmt.Fprintf(g.buf, "define %s @%s(", llvmType(g.f.Result), g.f.Name)
+	for i, p := range g.f.Params {
+		if i > 0 {
+			g.buf.WriteString(", ")
+		}
+		fmt.Fprintf(g.buf, "%s %%%s", llvmType(p.Typ), p.Name)
+	}
+	g.buf.WriteString(") {\nentry:\n")
+
+	for _, v := range append(append([]*ir.Var{}, g.f.Params...), g.f.Locals...) {
+		g.emit("%s = alloca %s", addr(v), llvmType(v.Typ))
+	}
+	for _, p := range g.f.Params {
+		g.emit("store %s %%%s, %s* %s", llvmType(p.Typ), p.Name, llvmType(p.Typ), addr(p))
+	}
+	g.emit("br label %%%s", g.f.Blocks[0].Name())
+
+	// Blocks are generated in reverse postorder, so a temp is defined
+	// before blocks using it, but written in their order. Blocks nothing
+	// jumps to are left out.
+	dom := ir.Dominators(g.f)
+	out := g.buf
+	code := map[*ir.Block]*bytes.Buffer{}
+	for _, b := range dom.Order() {
+		g.buf = &bytes.Buffer{}
+		code[b] = g.buf
+		for _, instr := range b.Instrs {
+			g.instr(instr)
+		}
+	}
+	g.buf = out
+	for _, b := range g.f.Blocks {
+		if dom.Reachable(b) {
+			fmt.Fprintf(g.buf, "%s:\n", b.Name())
+			g.buf.Write(code[b].Bytes())
+		}
+	}
+	g.buf.WriteString("}\n")
+}
+
+var intOps = map[ir.Op]string{
+	ir.Add: "add", ir.Sub: "sub", ir.Mul: "mul", ir.Div: "sdiv",
+	ir.Eq: "icmp eq", ir.Ne: "icmp ne", ir.Lt: "icmp slt", ir.Le: "icmp sle", ir.Gt: "icmp sgt", ir.Ge: "icmp sge",
+}
+
+// Comparisons are ordered, false if an operand is NaN, except != as in C
+var doubleOps = map[ir.Op]string{
+	ir.Add: "fadd", ir.Sub: "fsub", ir.Mul: "fmul", ir.Div: "fdiv",
+	ir.Eq: "fcmp oeq", ir.Ne: "fcmp une", ir.Lt: "fcmp olt", ir.Le: "fcmp ole", ir.Gt: "fcmp ogt", ir.Ge: "fcmp oge",
+}
+
+func (g *gen) instr(instr ir.Instr) {
+	switch i := instr.(type) {
+	case *ir.Copy:
+		g.set(i.Dst, g.value(i.Src))
+	case *ir.BinOp:
+		ops := intOps
+		if i.X.Type() == ir.Double {
+			ops = doubleOps
+		}
+		x, y := g.value(i.X), g.value(i.Y)
+		r := g.reg()
+		g.emit("%s = %s %s %s, %s", r, ops[i.Op], llvmType(i.X.Type()), x, y)
+		if i.Op.IsCompare() {
+			wide := g.reg()
+			g.emit("%s = zext i1 %s to i32", wide, r)
+			r = wide
+		}
+		g.set(i.Dst, r)
+	case *ir.UnOp:
+		x := g.value(i.X)
+		r := g.reg()
+		if i.X.Type() == ir.Double {
+			g.emit("%s = fneg double %s", r, x)
+		} else {
+			g.emit("%s = sub i32 0, %s", r, x)
+		}
+		g.set(i.Dst, r)
+	case *ir.Convert:
+		x := g.value(i.X)
+		if i.X.Type() == i.Dst.Type() {
+			g.set(i.Dst, x)
+			break
+		}
+		r := g.reg()
+		if i.Dst.Type() == ir.Double {
+			g.emit("%s = sitofp i32 %s to double", r, x)
+		} else {
+			g.emit("%s = fptosi double %s to i32", r, x)
+		}
+		g.set(i.Dst, r)
+	case *ir.Call:
+		g.call(i)
+	case *ir.Jump:
+		g.emit("br label %%%s", i.Target.Name())
+	case *ir.Branch:
+		cond := g.value(i.Cond)
+		r := g.reg()
+		g.emit("%s = icmp ne i32 %s, 0", r, cond)
+		g.emit("br i1 %s, label %%%s, label %%%s", r, i.Then.Name(), i.Else.Name())
+	case *ir.Return:
+		if i.Value == nil {
+			g.emit("ret void")
+		} else {
+			g.emit("ret %s %s", llvmType(i.Value.Type()), g.value(i.Value))
+		}
+	default:
+		panic(fmt.Sprintf("Unexpected instruction: %s", instr))
+	}
+}
+
+func (g *gen) call(i *ir.Call) {
+	args := ""
+	for j, arg := range i.Args {
+		if j > 0 {
+			args += ", "
+		}
+		args += llvmType(arg.Type()) + " " + g.value(arg)
+	}
+	call := fmt.Sprintf("call %s @%s(%s)", llvmType(g.result(i.Func)), i.Func, args)
+	if i.Dst == nil {
+		g.emit("%s", call)
+		return
+	}
+	r := g.reg()
+	g.emit("%s = %s", r, call)
+	g.set(i.Dst, r)
+}
+
+// result returns result type of a function of the module
+func (g *gen) result(fn string) ir.Type {
+	if f := g.prog.Func(fn); f != nil {
+		return f.Result
+	}
+	if sig, ok := g.prog.Globals[fn]; ok {
+		return sig.Result
+	}
+	panic("Undefined function: " + fn)
+}
+
+// value returns an operand holding v, loading variables and globals
+func (g *gen) value(v ir.Value) string {
+	switch x := v.(type) {
+	case *ir.Const:
+		return constant(x)
+	case *ir.Temp:
+		operand, ok := g.temps[x]
+		if !ok {
+			panic(fmt.Sprintf("Temp %s used before its definition in %s", x, g.f.Name))
+		}
+		return operand
+	case *ir.Global:
+		r := g.reg()
+		g.emit("%s = load %s, %s* @%s", r, llvmType(x.Typ), llvmType(x.Typ), x.Name)
+		return r
+	}
+	r := g.reg()
+	typ := llvmType(v.Type())
+	g.emit("%s = load %s, %s* %s", r, typ, typ, addr(v.(*ir.Var)))
+	return r
+}
+
+// set makes operand the value of dst. Temps are assigned once, so they
+// just name the operand.
+func (g *gen) set(dst ir.Value, operand string) {
+	if t, ok := dst.(*ir.Temp); ok {
+		g.temps[t] = operand
+		return
+	}
+	typ := llvmType(dst.Type())
+	g.emit("store %s %s, %s* %s", typ, operand, typ, addr(dst.(*ir.Var)))
+}
+
+func addr(v *ir.Var) string {
+	return "%" + v.Name + ".addr"
+}
+
+func llvmType(typ ir.Type) string {
+	switch typ {
+	case ir.Int:
+		return "i32"
+	case ir.Double:
+		return "double"
+	}
+	return "void"
+}
+
+// Doubles are written as bits, the only form exact for every value
+func constant(c *ir.Const) string {
+	if c.Typ == ir.Double {
+		return fmt.Sprintf("0x%016X", math.Float64bits(c.F))
+	}
+	return fmt.Sprint(c.I)
+}
@@ -0,0 +1,31 @@
+package llvm
+
+import (
+	"testing"
+
+	"github.com/rabierre/compiler/ir"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvert(t *testing.T) {
+	// func half(int a) double { return a / 2.0 } with a call result dropped
+	a := &ir.Var{Name: "a", Typ: ir.Int}
+	f := &ir.Func{Name: "half", Result: ir.Double, Params: []*ir.Var{a}}
+	x, y := f.NewTemp(ir.Double), f.NewTemp(ir.Double)
+	f.NewBlock().Instrs = []ir.Instr{
+		&ir.Call{Func: "g"},
+		&ir.Convert{Dst: x, X: a},
+		&ir.BinOp{Dst: y, Op: ir.Div, X: x, Y: ir.DoubleConst(2)},
+		&ir.Return{Value: y},
+	}
+	f.ComputeCFG()
+	prog := &ir.Program{Funcs: []*ir.Func{f}, Globals: map[string]ir.Signature{"g": {Func: true, Result: ir.Int}}}
+
+	out := Emit(prog)
+	assert.Contains(t, out, "b0:\n"+
+		"  call i32 @g()\n"+
+		"  %.1 = load i32, i32* %a.addr\n"+
+		"  %.2 = sitofp i32 %.1 to double\n"+
+		"  %.3 = fdiv double %.2, 0x4000000000000000\n"+
+		"  ret double %.3\n")
+}
@@ -0,0 +1,135 @@
+// Package lsp reads and writes Language Server Protocol messages: JSON-RPC
+// 2.0 with a header giving the length of each. It has the types of the
+// requests a server of this language answers, and converts byte offsets
+// of sources to protocol positions.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Message is a request, a notification if ID is nil, or a response
+type Message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error codes of JSON-RPC and the protocol
+const (
+	ParseError           = -32700
+	InvalidRequest       = -32600
+	MethodNotFound       = -32601
+	InvalidParams        = -32602
+	InternalError        = -32603
+	ServerNotInitialized = -32002
+)
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Conn exchanges messages over a stream. Writes may come from several
+// goroutines.
+type Conn struct {
+	r  *bufio.Reader
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func NewConn(r io.Reader, w io.Writer) *Conn {
+	return &Conn{r: bufio.NewReader(r), w: w}
+}
+
+// Read returns the next message, io.EOF when the stream ends between two
+func (c *Conn) Read() (*Message, error) {
+	length := -1
+	for {
+		line, err := c.r.ReadString('\n')
+		if err == io.EOF && line == "" && length < 0 {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid header: %q", line)
+		}
+		if strings.EqualFold(line[:i], "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[i+1:])); err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid header: %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("missing Content-Length")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, err
+	}
+	msg := &Message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, &Error{Code: ParseError, Message: err.Error()}
+	}
+	return msg, nil
+}
+
+// Reply answers the request id with result, or err if it is not nil
+func (c *Conn) Reply(id json.RawMessage, result interface{}, err *Error) error {
+	if err != nil {
+		return c.write(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			ID      json.RawMessage `json:"id"`
+			Error   *Error          `json:"error"`
+		}{"2.0", id, err})
+	}
+	return c.write(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  interface{}     `json:"result"`
+	}{"2.0", id, result})
+}
+
+func (c *Conn) Notify(method string, params interface{}) error {
+	return c.write(struct {
+		JSONRPC string      `json:"jsonrpc"`
+		Method  string      `json:"method"`
+		Params  interface{} `json:"params"`
+	}{"2.0", method, params})
+}
+
+func (c *Conn) write(msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(body)
+	return err
+}
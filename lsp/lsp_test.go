@@ -0,0 +1,70 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConn(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewConn(nil, &buf)
+	assert.Nil(t, c.Reply(json.RawMessage("1"), nil, nil))
+	assert.Nil(t, c.Reply(json.RawMessage(`"a"`), nil, &Error{Code: MethodNotFound, Message: "no"}))
+	assert.Nil(t, c.Notify("n", []int{1}))
+	assert.Equal(t, "Content-Length: 38\r\n\r\n"+`{"jsonrpc":"2.0","id":1,"result":null}`+
+		"Content-Length: 65\r\n\r\n"+`{"jsonrpc":"2.0","id":"a","error":{"code":-32601,"message":"no"}}`+
+		"Content-Length: 43\r\n\r\n"+`{"jsonrpc":"2.0","method":"n","params":[1]}`, buf.String())
+
+	c = NewConn(strings.NewReader(buf.String()+"content-length: 2\r\nContent-Type: x\r\n\r\n{}"), nil)
+	msg, err := c.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, "1", string(msg.ID))
+	assert.Equal(t, "null", string(msg.Result))
+	msg, err = c.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, MethodNotFound, msg.Error.Code)
+	msg, err = c.Read()
+	assert.Nil(t, err)
+	assert.Nil(t, msg.ID)
+	assert.Equal(t, "n", msg.Method)
+	assert.Equal(t, "[1]", string(msg.Params))
+	_, err = c.Read()
+	assert.Nil(t, err)
+	_, err = c.Read()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = NewConn(strings.NewReader("Content-Length: 1\r\n\r\n{"), nil).Read()
+	assert.Equal(t, ParseError, err.(*Error).Code)
+	_, err = NewConn(strings.NewReader("\r\n{}"), nil).Read()
+	assert.EqualError(t, err, "missing Content-Length")
+	_, err = NewConn(strings.NewReader("Content-Length: 5\r\n\r\n{}"), nil).Read()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestMapper(t *testing.T) {
+	m := NewMapper([]byte("ab\nx😀y\n"))
+	assert.Equal(t, Position{0, 0}, m.Position(0))
+	assert.Equal(t, Position{0, 2}, m.Position(2))
+	assert.Equal(t, Position{1, 0}, m.Position(3))
+	assert.Equal(t, Position{1, 3}, m.Position(8))
+	assert.Equal(t, Position{2, 0}, m.Position(10))
+	assert.Equal(t, Position{2, 0}, m.Position(99))
+	assert.Equal(t, Position{0, 0}, m.Position(-1))
+
+	assert.Equal(t, 8, m.Offset(Position{1, 3}))
+	assert.Equal(t, 9, m.Offset(Position{1, 4}))
+	assert.Equal(t, 9, m.Offset(Position{1, 40}))
+	assert.Equal(t, 2, m.Offset(Position{0, 9}))
+	assert.Equal(t, 10, m.Offset(Position{5, 0}))
+	assert.Equal(t, 0, m.Offset(Position{-1, 0}))
+	for offset := 0; offset <= 10; offset++ {
+		if offset < 5 || offset > 7 {
+			assert.Equal(t, offset, m.Offset(m.Position(offset)))
+		}
+	}
+}
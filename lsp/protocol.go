@@ -0,0 +1,240 @@
+package lsp
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+//--------------------------------------------------------------------------------------
+// Positions
+//
+
+// Lines and characters count from 0, characters in UTF-16 code units
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+// Mapper converts byte offsets of a text to positions and back
+type Mapper struct {
+	text  []byte
+	lines []int // offsets lines start at
+}
+
+func NewMapper(text []byte) *Mapper {
+	m := &Mapper{text: text, lines: []int{0}}
+	for i, ch := range text {
+		if ch == '\n' {
+			m.lines = append(m.lines, i+1)
+		}
+	}
+	return m
+}
+
+func (m *Mapper) Position(offset int) Position {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(m.text) {
+		offset = len(m.text)
+	}
+	line := sort.SearchInts(m.lines, offset+1) - 1
+	char := 0
+	for _, r := range string(m.text[m.lines[line]:offset]) {
+		char += utf16Len(r)
+	}
+	return Position{Line: line, Character: char}
+}
+
+func (m *Mapper) Range(from, to int) Range {
+	return Range{Start: m.Position(from), End: m.Position(to)}
+}
+
+// Offset returns the offset of pos, the end of its line if the line is
+// shorter
+func (m *Mapper) Offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(m.lines) {
+		return len(m.text)
+	}
+	offset := m.lines[pos.Line]
+	for char := 0; char < pos.Character && offset < len(m.text) && m.text[offset] != '\n'; {
+		r, n := utf8.DecodeRune(m.text[offset:])
+		char += utf16Len(r)
+		offset += n
+	}
+	return offset
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+//--------------------------------------------------------------------------------------
+// Lifecycle
+//
+
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+	ServerInfo   *ServerInfo        `json:"serverInfo,omitempty"`
+}
+
+type ServerCapabilities struct {
+	TextDocumentSync       int                `json:"textDocumentSync"`
+	HoverProvider          bool               `json:"hoverProvider"`
+	DefinitionProvider     bool               `json:"definitionProvider"`
+	ReferencesProvider     bool               `json:"referencesProvider"`
+	DocumentSymbolProvider bool               `json:"documentSymbolProvider"`
+	CompletionProvider     *CompletionOptions `json:"completionProvider,omitempty"`
+}
+
+// Kinds of TextDocumentSync
+const (
+	SyncNone = iota
+	SyncFull
+	SyncIncremental
+)
+
+type ServerInfo struct {
+	Name string `json:"name"`
+}
+
+type CompletionOptions struct{}
+
+//--------------------------------------------------------------------------------------
+// Documents
+//
+
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type VersionedTextDocumentIdentifier struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+}
+
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+// A change without a range replaces the text
+type TextDocumentContentChangeEvent struct {
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
+}
+
+type DidChangeTextDocumentParams struct {
+	TextDocument   VersionedTextDocumentIdentifier  `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type TextDocumentPositionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+//--------------------------------------------------------------------------------------
+// Language features
+//
+
+// Severities of diagnostics
+const (
+	SeverityError = iota + 1
+	SeverityWarning
+	SeverityInformation
+	SeverityHint
+)
+
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+type PublishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+type ReferenceParams struct {
+	TextDocumentPositionParams
+	Context struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+	Range    *Range        `json:"range,omitempty"`
+}
+
+type MarkupContent struct {
+	Kind  string `json:"kind"` // "plaintext" or "markdown"
+	Value string `json:"value"`
+}
+
+type DocumentSymbolParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type DocumentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           int              `json:"kind"`
+	Range          Range            `json:"range"`
+	SelectionRange Range            `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+// Kinds of symbols
+const (
+	SymbolEnum       = 10
+	SymbolFunction   = 12
+	SymbolVariable   = 13
+	SymbolConstant   = 14
+	SymbolEnumMember = 22
+)
+
+type CompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Kinds of completion items
+const (
+	CompletionFunction   = 3
+	CompletionVariable   = 6
+	CompletionEnum       = 13
+	CompletionKeyword    = 14
+	CompletionEnumMember = 20
+	CompletionConstant   = 21
+)
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/rabierre/compiler/lsp"
+	"github.com/rabierre/compiler/token"
+)
+
+// lspCommand serves the Language Server Protocol on stdin and stdout
+// until the client exits. It returns the exit status.
+func lspCommand(stdin io.Reader, stdout, stderr io.Writer) int {
+	s := &langServer{conn: lsp.NewConn(stdin, stdout), docs: map[string]*document{}, good: map[string]*document{}}
+	if err := s.serve(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if !s.shutdown {
+		return 1
+	}
+	return 0
+}
+
+// langServer answers an editor about the sources it has open. A source is
+// compiled with the others of its directory, names it does not declare
+// are looked up in them.
+type langServer struct {
+	conn        *lsp.Conn
+	docs        map[string]*document // open documents by URI
+	good        map[string]*document // last of them which parsed
+	initialized bool
+	shutdown    bool
+}
+
+func (s *langServer) serve() error {
+	for {
+		msg, err := s.conn.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if e, ok := err.(*lsp.Error); ok {
+			s.conn.Reply(nil, nil, e)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		result, e := s.handle(msg)
+		if msg.ID != nil {
+			if err := s.conn.Reply(msg.ID, result, e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *langServer) handle(msg *lsp.Message) (result interface{}, e *lsp.Error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, e = nil, &lsp.Error{Code: lsp.InternalError, Message: fmt.Sprint(r)}
+		}
+	}()
+
+	switch {
+	case s.shutdown:
+		return nil, &lsp.Error{Code: lsp.InvalidRequest, Message: "server is shut down"}
+	case msg.Method == "initialize":
+		s.initialized = true
+		return lsp.InitializeResult{
+			Capabilities: lsp.ServerCapabilities{
+				TextDocumentSync:       lsp.SyncFull,
+				HoverProvider:          true,
+				DefinitionProvider:     true,
+				ReferencesProvider:     true,
+				DocumentSymbolProvider: true,
+				CompletionProvider:     &lsp.CompletionOptions{},
+			},
+			ServerInfo: &lsp.ServerInfo{Name: "compiler"},
+		}, nil
+	case !s.initialized:
+		return nil, &lsp.Error{Code: lsp.ServerNotInitialized, Message: "server is not initialized"}
+	}
+
+	switch msg.Method {
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params lsp.DidOpenTextDocumentParams
+		if e := decode(msg, &params); e != nil {
+			return nil, e
+		}
+		s.update(params.TextDocument.URI, []byte(params.TextDocument.Text))
+		return nil, nil
+	case "textDocument/didChange":
+		var params lsp.DidChangeTextDocumentParams
+		if e := decode(msg, &params); e != nil {
+			return nil, e
+		}
+		s.change(params)
+		return nil, nil
+	case "textDocument/didClose":
+		var params lsp.DidCloseTextDocumentParams
+		if e := decode(msg, &params); e != nil {
+			return nil, e
+		}
+		delete(s.docs, params.TextDocument.URI)
+		delete(s.good, params.TextDocument.URI)
+		s.conn.Notify("textDocument/publishDiagnostics", lsp.PublishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []lsp.Diagnostic{}})
+		s.publish()
+		return nil, nil
+	case "textDocument/definition":
+		var params lsp.TextDocumentPositionParams
+		if e := decode(msg, &params); e != nil {
+			return nil, e
+		}
+		return s.definition(params), nil
+	case "textDocument/references":
+		var params lsp.ReferenceParams
+		if e := decode(msg, &params); e != nil {
+			return nil, e
+		}
+		return s.references(params), nil
+	case "textDocument/hover":
+		var params lsp.TextDocumentPositionParams
+		if e := decode(msg, &params); e != nil {
+			return nil, e
+		}
+		return s.hover(params), nil
+	case "textDocument/documentSymbol":
+		var params lsp.DocumentSymbolParams
+		if e := decode(msg, &params); e != nil {
+			return nil, e
+		}
+		return s.symbols(params), nil
+	case "textDocument/completion":
+		var params lsp.TextDocumentPositionParams
+		if e := decode(msg, &params); e != nil {
+			return nil, e
+		}
+		return s.complete(params), nil
+	}
+
+	if msg.ID == nil {
+		// Notifications not understood are ignored
+		return nil, nil
+	}
+	return nil, &lsp.Error{Code: lsp.MethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+func decode(msg *lsp.Message, params interface{}) *lsp.Error {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return &lsp.Error{Code: lsp.InvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+//--------------------------------------------------------------------------------------
+// Documents
+//
+
+// document is a source parsed for the requests about it
+type document struct {
+	uri   string
+	path  string // empty if the URI is not of a file
+	text  []byte
+	lines *lsp.Mapper
+
+	file   *ast.File // nil if the source does not parse
+	err    *lsp.Diagnostic
+	idents []*ast.Ident // in source order
+}
+
+func parseDocument(uri string, text []byte) (doc *document) {
+	doc = &document{uri: uri, path: uriPath(uri), text: text, lines: lsp.NewMapper(text)}
+
+	p := &Parser{}
+	defer func() {
+		if r := recover(); r != nil {
+			doc.file = nil
+			doc.err = &lsp.Diagnostic{
+				Range:    doc.lines.Range(p.pos, p.pos+len(p.val)),
+				Severity: lsp.SeverityError,
+				Source:   "compiler",
+				Message:  fmt.Sprint(r),
+			}
+		}
+	}()
+	p.Init(text)
+	doc.file = p.ParseFile(doc.path)
+
+	ast.Inspect(doc.file, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			doc.idents = append(doc.idents, id)
+		}
+		return true
+	})
+	sort.SliceStable(doc.idents, func(i, j int) bool { return doc.idents[i].Pos < doc.idents[j].Pos })
+	return doc
+}
+
+// update parses the open document uri and publishes diagnostics of the
+// open ones, names declared in it may be used by others
+func (s *langServer) update(uri string, text []byte) {
+	doc := parseDocument(uri, text)
+	s.docs[uri] = doc
+	if doc.file != nil {
+		s.good[uri] = doc
+	}
+	s.publish()
+}
+
+func (s *langServer) change(params lsp.DidChangeTextDocumentParams) {
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil {
+		return
+	}
+	text := doc.text
+	for _, change := range params.ContentChanges {
+		if change.Range == nil {
+			text = []byte(change.Text)
+			continue
+		}
+		lines := lsp.NewMapper(text)
+		from, to := lines.Offset(change.Range.Start), lines.Offset(change.Range.End)
+		text = append(append(append([]byte{}, text[:from]...), change.Text...), text[to:]...)
+	}
+	s.update(doc.uri, text)
+}
+
+func (s *langServer) publish() {
+	uris := make([]string, 0, len(s.docs))
+	for uri := range s.docs {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		s.conn.Notify("textDocument/publishDiagnostics", lsp.PublishDiagnosticsParams{URI: uri, Diagnostics: s.diagnose(s.docs[uri])})
+	}
+}
+
+// diagnose reports a syntax error, or names declared nowhere
+func (s *langServer) diagnose(doc *document) []lsp.Diagnostic {
+	list := []lsp.Diagnostic{}
+	if doc.err != nil {
+		return append(list, *doc.err)
+	}
+
+	others := workspace(doc, s.docs)[1:]
+	seen := map[*ast.Ident]bool{}
+	for _, id := range doc.file.UnResolved {
+		if seen[id] || lookupTop(others, id.Name) != nil {
+			continue
+		}
+		seen[id] = true
+		list = append(list, lsp.Diagnostic{
+			Range:    doc.span(id),
+			Severity: lsp.SeverityError,
+			Source:   "compiler",
+			Message:  "undefined: " + id.Name,
+		})
+	}
+	return list
+}
+
+// workspace returns doc then the other parsed sources of its directory
+// with its extension, those in open as they are there
+func workspace(doc *document, open map[string]*document) []*document {
+	list := []*document{doc}
+	for _, other := range siblings(doc, open) {
+		if other.file != nil {
+			list = append(list, other)
+		}
+	}
+	return list
+}
+
+// siblings returns the other sources of the directory of doc with its
+// extension, those which do not parse too
+func siblings(doc *document, open map[string]*document) []*document {
+	var list []*document
+	if doc.path == "" {
+		return list
+	}
+	names, _ := filepath.Glob(filepath.Join(filepath.Dir(doc.path), "*"+filepath.Ext(doc.path)))
+	for _, name := range names {
+		if name == doc.path {
+			continue
+		}
+		uri := fileURI(name)
+		other := open[uri]
+		if other == nil {
+			text, err := ioutil.ReadFile(name)
+			if err != nil {
+				continue
+			}
+			other = parseDocument(uri, text)
+		}
+		list = append(list, other)
+	}
+	return list
+}
+
+// lookupTop returns the top level declaration of name in one of docs
+func lookupTop(docs []*document, name string) *declared {
+	for _, doc := range docs {
+		if obj := doc.file.Scope.Objects[name]; obj != nil {
+			return &declared{doc, obj.Decl}
+		}
+	}
+	return nil
+}
+
+func fileURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.Clean(u.Path)
+}
+
+func (doc *document) span(id *ast.Ident) lsp.Range {
+	return doc.lines.Range(id.Pos, id.Pos+len(id.Name))
+}
+
+// at returns the ident under offset, nil if none
+func (doc *document) at(offset int) *ast.Ident {
+	if doc.file == nil {
+		return nil
+	}
+	for _, id := range doc.idents {
+		if id.Pos <= offset && offset <= id.Pos+len(id.Name) {
+			return id
+		}
+	}
+	return nil
+}
+
+// bind returns what id of doc names, looking for names it does not declare
+// in the other documents
+func bind(doc *document, id *ast.Ident, others []*document) *declared {
+	if id.Obj != nil {
+		return &declared{doc, id.Obj.Decl}
+	}
+	return lookupTop(others, id.Name)
+}
+
+// references calls f for idents naming d in docs, its name first. Idents
+// of the documents d is not in name a top level declaration where they
+// are not declared.
+func (d *declared) references(docs []*document, f func(doc *document, id *ast.Ident)) {
+	name := declName(d.decl)
+	f(d.doc, name)
+
+	obj := d.doc.file.Scope.Objects[name.Name]
+	top := obj != nil && obj.Decl == d.decl
+	for _, doc := range docs {
+		for _, id := range doc.idents {
+			switch {
+			case id == name:
+			case doc == d.doc:
+				if id.Obj != nil && id.Obj.Decl == d.decl {
+					f(doc, id)
+				}
+			case top && id.Name == name.Name && id.Obj == nil:
+				f(doc, id)
+			}
+		}
+	}
+}
+
+//--------------------------------------------------------------------------------------
+// Requests
+//
+
+// declared is a declaration and the document it is in
+type declared struct {
+	doc  *document
+	decl ast.Node
+}
+
+// lookup returns what the ident at pos names, nil if nothing
+func (s *langServer) lookup(params lsp.TextDocumentPositionParams) *declared {
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil {
+		return nil
+	}
+	id := doc.at(doc.lines.Offset(params.Position))
+	if id == nil {
+		return nil
+	}
+	return bind(doc, id, workspace(doc, s.docs)[1:])
+}
+
+func (s *langServer) definition(params lsp.TextDocumentPositionParams) *lsp.Location {
+	t := s.lookup(params)
+	if t == nil {
+		return nil
+	}
+	return &lsp.Location{URI: t.doc.uri, Range: t.doc.span(declName(t.decl))}
+}
+
+func (s *langServer) references(params lsp.ReferenceParams) []lsp.Location {
+	list := []lsp.Location{}
+	t := s.lookup(params.TextDocumentPositionParams)
+	if t == nil {
+		return list
+	}
+	t.references(workspace(t.doc, s.docs), func(doc *document, id *ast.Ident) {
+		if id != declName(t.decl) || params.Context.IncludeDeclaration {
+			list = append(list, lsp.Location{URI: doc.uri, Range: doc.span(id)})
+		}
+	})
+	return list
+}
+
+func (s *langServer) hover(params lsp.TextDocumentPositionParams) *lsp.Hover {
+	t := s.lookup(params)
+	if t == nil {
+		return nil
+	}
+	doc := s.docs[params.TextDocument.URI]
+	span := doc.span(doc.at(doc.lines.Offset(params.Position)))
+
+	text := t.doc.describe(t.decl)
+	if d, ok := t.decl.(*ast.FuncDecl); ok && d.Doc != nil {
+		text += "\n\n" + d.Doc.Text()
+	}
+	return &lsp.Hover{Contents: lsp.MarkupContent{Kind: "plaintext", Value: strings.TrimSpace(text)}, Range: &span}
+}
+
+func (s *langServer) symbols(params lsp.DocumentSymbolParams) []lsp.DocumentSymbol {
+	list := []lsp.DocumentSymbol{}
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil || doc.file == nil {
+		return list
+	}
+
+	for _, decl := range doc.file.Decls {
+		name := declName(decl)
+		sym := lsp.DocumentSymbol{Name: name.Name, Detail: doc.describe(decl), SelectionRange: doc.span(name)}
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			sym.Kind = lsp.SymbolFunction
+			sym.Range = doc.lines.Range(d.Pos, d.Body.RBracePos+1)
+		case *ast.ConstDecl:
+			sym.Kind = lsp.SymbolConstant
+			sym.Range = doc.lines.Range(d.Pos, d.Name.Pos+len(d.Name.Name))
+		case *ast.EnumDecl:
+			sym.Kind = lsp.SymbolEnum
+			sym.Range = doc.lines.Range(d.Pos, d.RBracePos+1)
+			for _, m := range d.Members {
+				sym.Children = append(sym.Children, lsp.DocumentSymbol{
+					Name:           m.Name.Name,
+					Kind:           lsp.SymbolEnumMember,
+					Range:          doc.span(m.Name),
+					SelectionRange: doc.span(m.Name),
+				})
+			}
+		}
+		list = append(list, sym)
+	}
+	return list
+}
+
+// complete offers the names visible at pos, then keywords. The last
+// parse of a document is used while it does not parse.
+func (s *langServer) complete(params lsp.TextDocumentPositionParams) []lsp.CompletionItem {
+	list := []lsp.CompletionItem{}
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil {
+		return list
+	}
+	offset := doc.lines.Offset(params.Position)
+	if doc.file == nil {
+		if doc = s.good[doc.uri]; doc == nil {
+			return list
+		}
+	}
+
+	// Inner declarations hide outer ones, local ones are visible after
+	// their name
+	visible := map[string]*declared{}
+	add := func(doc *document, scope *ast.Scope, local bool) {
+		for name, obj := range scope.Objects {
+			if _, hidden := visible[name]; hidden || local && declName(obj.Decl).Pos+len(name) >= offset {
+				continue
+			}
+			visible[name] = &declared{doc, obj.Decl}
+		}
+	}
+	for scope := doc.file.Scope.Innermost(offset); scope != nil && scope != doc.file.Scope; scope = scope.Outer {
+		add(doc, scope, true)
+	}
+	for _, doc := range workspace(doc, s.docs) {
+		add(doc, doc.file.Scope, false)
+	}
+
+	names := make([]string, 0, len(visible))
+	for name := range visible {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := visible[name]
+		list = append(list, lsp.CompletionItem{Label: name, Kind: completionKind(t.decl), Detail: t.doc.describe(t.decl)})
+	}
+
+	for _, word := range token.Keywords {
+		if word != "" && 'a' <= word[0] && word[0] <= 'z' {
+			list = append(list, lsp.CompletionItem{Label: word, Kind: lsp.CompletionKeyword})
+		}
+	}
+	return list
+}
+
+//--------------------------------------------------------------------------------------
+// Declarations
+//
+
+func declName(decl ast.Node) *ast.Ident {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		return d.Name
+	case *ast.VarDeclStmt:
+		return d.Name
+	case *ast.ConstDecl:
+		return d.Name
+	case *ast.EnumDecl:
+		return d.Name
+	case *ast.EnumMember:
+		return d.Name
+	}
+	return nil
+}
+
+// describe returns decl as it is declared, without values and bodies
+func (doc *document) describe(decl ast.Node) string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		params := make([]string, len(d.Params.List))
+		for i, param := range d.Params.List {
+			params[i] = doc.describe(param)
+		}
+		text := fmt.Sprintf("func %s(%s)", d.Name.Name, strings.Join(params, ", "))
+		if d.Type != token.VOID {
+			text += " " + ast.TypeName(d.Type, d.TypeName)
+		}
+		return text
+	case *ast.VarDeclStmt:
+		return ast.TypeName(d.Type, d.TypeName) + " " + d.Name.Name
+	case *ast.ConstDecl:
+		return fmt.Sprintf("const %s %s", d.Type, d.Name.Name)
+	case *ast.EnumDecl:
+		return "enum " + d.Name.Name
+	case *ast.EnumMember:
+		for _, decl := range doc.file.Decls {
+			if enum, ok := decl.(*ast.EnumDecl); ok {
+				for _, m := range enum.Members {
+					if m == d {
+						return "const " + enum.Name.Name + " " + d.Name.Name
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func completionKind(decl ast.Node) int {
+	switch decl.(type) {
+	case *ast.FuncDecl:
+		return lsp.CompletionFunction
+	case *ast.ConstDecl:
+		return lsp.CompletionConstant
+	case *ast.EnumDecl:
+		return lsp.CompletionEnum
+	case *ast.EnumMember:
+		return lsp.CompletionEnumMember
+	}
+	return lsp.CompletionVariable
+}
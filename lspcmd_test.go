@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rabierre/compiler/lsp"
+	"github.com/stretchr/testify/assert"
+)
+
+const lspSum = `enum Color { RED, GREEN }
+const int N = 10
+
+// sum adds numbers below n
+func sum(int n) int {
+    int s = 0
+    for (int i = 0; i < n; i++) {
+        s = s + i
+    }
+    return s + twice(N)
+}
+`
+
+const lspMain = `func twice(int x) int {
+    return x * 2
+}
+func main() int {
+    Color c = RED
+    return sum(3) + missing
+}
+`
+
+// lspClient queues messages for the server, requests are numbered from 1
+type lspClient struct {
+	in bytes.Buffer
+	id int
+}
+
+func (c *lspClient) request(method string, params interface{}) {
+	c.id++
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "id": c.id, "method": method, "params": params})
+}
+
+func (c *lspClient) notify(method string, params interface{}) {
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (c *lspClient) send(msg interface{}) {
+	body, _ := json.Marshal(msg)
+	fmt.Fprintf(&c.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// run serves the messages, returns the exit status, responses by id and
+// notifications
+func (c *lspClient) run(t *testing.T) (int, map[int]*lsp.Message, []*lsp.Message) {
+	var out, stderr bytes.Buffer
+	status := lspCommand(&c.in, &out, &stderr)
+	assert.Equal(t, "", stderr.String())
+
+	responses := map[int]*lsp.Message{}
+	var notes []*lsp.Message
+	conn := lsp.NewConn(&out, nil)
+	for msg, err := conn.Read(); err == nil; msg, err = conn.Read() {
+		if msg.ID == nil {
+			notes = append(notes, msg)
+			continue
+		}
+		var id int
+		assert.Nil(t, json.Unmarshal(msg.ID, &id))
+		responses[id] = msg
+	}
+	return status, responses, notes
+}
+
+func result(t *testing.T, msg *lsp.Message, v interface{}) {
+	if assert.Nil(t, msg.Error) {
+		assert.Nil(t, json.Unmarshal(msg.Result, v))
+	}
+}
+
+func at(uri string, line, char int) lsp.TextDocumentPositionParams {
+	return lsp.TextDocumentPositionParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}, Position: lsp.Position{Line: line, Character: char}}
+}
+
+func span(line, from, to int) lsp.Range {
+	return lsp.Range{Start: lsp.Position{Line: line, Character: from}, End: lsp.Position{Line: line, Character: to}}
+}
+
+func TestLangServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lsp")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "sum.txt"), []byte(lspSum), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "main.txt"), []byte(lspMain), 0644))
+	sum := (&url.URL{Scheme: "file", Path: filepath.Join(dir, "sum.txt")}).String()
+	main := (&url.URL{Scheme: "file", Path: filepath.Join(dir, "main.txt")}).String()
+
+	c := &lspClient{}
+	c.request("textDocument/hover", at(main, 0, 0))
+	c.request("initialize", map[string]interface{}{})
+	c.notify("initialized", map[string]interface{}{})
+	c.notify("textDocument/didOpen", lsp.DidOpenTextDocumentParams{TextDocument: lsp.TextDocumentItem{URI: main, Text: lspMain}})
+	c.request("textDocument/definition", at(main, 5, 12)) // sum
+	c.request("textDocument/definition", at(main, 1, 12)) // x
+	c.request("textDocument/hover", at(main, 5, 14))
+	c.request("textDocument/hover", at(main, 4, 4))
+	refs := lsp.ReferenceParams{TextDocumentPositionParams: at(main, 0, 5)}
+	refs.Context.IncludeDeclaration = true
+	c.request("textDocument/references", refs)
+	c.request("textDocument/documentSymbol", lsp.DocumentSymbolParams{TextDocument: lsp.TextDocumentIdentifier{URI: main}})
+	c.request("textDocument/completion", at(main, 5, 4))
+	c.notify("textDocument/didChange", lsp.DidChangeTextDocumentParams{
+		TextDocument:   lsp.VersionedTextDocumentIdentifier{URI: main, Version: 2},
+		ContentChanges: []lsp.TextDocumentContentChangeEvent{{Range: &lsp.Range{Start: lsp.Position{Line: 0, Character: 22}, End: lsp.Position{Line: 0, Character: 23}}, Text: ""}},
+	})
+	c.request("textDocument/completion", at(main, 1, 4))
+	c.request("textDocument/rename", at(main, 0, 0))
+	c.request("shutdown", nil)
+	c.request("textDocument/hover", at(main, 0, 0))
+	c.notify("exit", nil)
+
+	status, responses, notes := c.run(t)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, lsp.ServerNotInitialized, responses[1].Error.Code)
+
+	var init lsp.InitializeResult
+	result(t, responses[2], &init)
+	assert.Equal(t, lsp.SyncFull, init.Capabilities.TextDocumentSync)
+	assert.True(t, init.Capabilities.DefinitionProvider)
+
+	var loc lsp.Location
+	result(t, responses[3], &loc)
+	assert.Equal(t, lsp.Location{URI: sum, Range: span(4, 5, 8)}, loc)
+	result(t, responses[4], &loc)
+	assert.Equal(t, lsp.Location{URI: main, Range: span(0, 15, 16)}, loc)
+
+	var hover lsp.Hover
+	result(t, responses[5], &hover)
+	assert.Equal(t, "func sum(int n) int\n\nsum adds numbers below n", hover.Contents.Value)
+	assert.Equal(t, span(5, 11, 14), *hover.Range)
+	result(t, responses[6], &hover)
+	assert.Equal(t, "enum Color", hover.Contents.Value)
+
+	var locs []lsp.Location
+	result(t, responses[7], &locs)
+	assert.Equal(t, []lsp.Location{{URI: main, Range: span(0, 5, 10)}, {URI: sum, Range: span(9, 15, 20)}}, locs)
+
+	var symbols []lsp.DocumentSymbol
+	result(t, responses[8], &symbols)
+	if assert.Len(t, symbols, 2) {
+		assert.Equal(t, lsp.DocumentSymbol{Name: "main", Detail: "func main() int", Kind: lsp.SymbolFunction, Range: lsp.Range{Start: lsp.Position{Line: 3}, End: lsp.Position{Line: 6, Character: 1}}, SelectionRange: span(3, 5, 9)}, symbols[1])
+	}
+
+	labels := func(items []lsp.CompletionItem) map[string]lsp.CompletionItem {
+		m := map[string]lsp.CompletionItem{}
+		for _, item := range items {
+			m[item.Label] = item
+		}
+		return m
+	}
+	var items []lsp.CompletionItem
+	result(t, responses[9], &items)
+	m := labels(items)
+	assert.Equal(t, lsp.CompletionItem{Label: "c", Kind: lsp.CompletionVariable, Detail: "Color c"}, m["c"])
+	assert.Equal(t, lsp.CompletionItem{Label: "RED", Kind: lsp.CompletionEnumMember, Detail: "const Color RED"}, m["RED"])
+	assert.Equal(t, lsp.CompletionItem{Label: "return", Kind: lsp.CompletionKeyword}, m["return"])
+	assert.NotContains(t, m, "x")
+	assert.NotContains(t, m, "s")
+
+	// The last parse is used while the source is broken
+	result(t, responses[10], &items)
+	m = labels(items)
+	assert.Contains(t, m, "x")
+	assert.NotContains(t, m, "c")
+
+	assert.Equal(t, lsp.MethodNotFound, responses[11].Error.Code)
+	assert.Nil(t, responses[12].Error)
+	assert.Equal(t, lsp.InvalidRequest, responses[13].Error.Code)
+
+	var diags []lsp.PublishDiagnosticsParams
+	for _, note := range notes {
+		var d lsp.PublishDiagnosticsParams
+		assert.Nil(t, json.Unmarshal(note.Params, &d))
+		diags = append(diags, d)
+	}
+	if assert.Len(t, diags, 2) {
+		assert.Equal(t, []lsp.Diagnostic{{Range: span(5, 20, 27), Severity: lsp.SeverityError, Source: "compiler", Message: "undefined: missing"}}, diags[0].Diagnostics)
+		assert.Equal(t, []lsp.Diagnostic{{Range: span(1, 4, 10), Severity: lsp.SeverityError, Source: "compiler", Message: "Expected: { Found: return, return"}}, diags[1].Diagnostics)
+	}
+}
+
+func TestLangServerExit(t *testing.T) {
+	c := &lspClient{}
+	c.request("initialize", nil)
+	c.notify("exit", nil)
+	status, _, _ := c.run(t)
+	assert.Equal(t, 1, status)
+
+	c = &lspClient{}
+	c.request("initialize", nil)
+	c.in.WriteString("Content-Length: 1\r\n\r\n{")
+	c.request("shutdown", nil)
+	status, responses, _ := c.run(t)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, lsp.ParseError, responses[0].Error.Code)
+	assert.Nil(t, responses[2].Error)
+}
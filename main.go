@@ -1,5 +1,174 @@
 package main
 
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rabierre/compiler/cache"
+	"github.com/rabierre/compiler/ir"
+	"github.com/rabierre/compiler/vm"
+)
+
+var (
+	output  = flag.String("o", ".", "directory to write mid.h and mid.c, mid.s, mid.wat, mid.ll, mid.go, mid.mjs or mid.vm")
+	workers = flag.Int("j", 0, "number of files parsed in parallel, defaults to the number of CPUs")
+	debug   = flag.Bool("debug", false, "trace parser")
+	ssa     = flag.Bool("ssa", false, "pass functions through SSA form")
+	verbose = flag.Bool("v", false, "print inlining decisions")
+	opt     = flag.Int("O", 0, "optimization level 0, 1 or 2, also given as -O0, -O1 or -O2")
+	target  = flag.String("target", "c", "output language: c, amd64 (GNU assembler, System V ABI), wasm (WebAssembly text), llvm (LLVM IR text), go (Go package), js (JavaScript module) or vm (bytecode)")
+	triple  = flag.String("triple", "", "target triple of LLVM IR, defaults to the one of LLVM tools")
+	pkg     = flag.String("package", "mid", "package name of Go output")
+	regs    = flag.Bool("intervals", false, "print live intervals and registers of amd64 functions")
+	run     = flag.String("run", "", "run main of a bytecode file, its int result is the exit status")
+	disasm  = flag.String("disasm", "", "print the disassembly of a bytecode file")
+	docs    = flag.Bool("comments", false, "carry doc comments of functions into mid.c")
+
+	cacheDir   = flag.String("cache", defaultCacheDir(), "build cache directory, empty disables caching")
+	cacheStats = flag.Bool("cachestats", false, "print build cache statistics")
+	optStats   = flag.Bool("optstats", false, "print changes made by each optimization pass")
+)
+
 func main() {
+	flag.CommandLine.Parse(optFlags(os.Args[1:]))
+	if *run != "" || *disasm != "" {
+		os.Exit(runFile(*run, *disasm))
+	}
+	switch flag.Arg(0) {
+	case "repl":
+		NewREPL(os.Stdout).Run(os.Stdin)
+		return
+	case "fmt":
+		os.Exit(fmtCommand(flag.Args()[1:], os.Stdin, os.Stdout, os.Stderr))
+	case "ast":
+		os.Exit(astCommand(flag.Args()[1:], os.Stdin, os.Stdout, os.Stderr))
+	case "lsp":
+		os.Exit(lspCommand(os.Stdin, os.Stdout, os.Stderr))
+	case "rename":
+		os.Exit(renameCommand(flag.Args()[1:], os.Stdout, os.Stderr))
+	}
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: compiler [flags] file...\n       compiler repl\n       compiler fmt [-w] [-d] [file...]\n       compiler ast [-json] [file...]\n       compiler lsp\n       compiler rename [-w] file:line:column name")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	switch *target {
+	case "c", "amd64", "wasm", "llvm", "go", "js", "vm":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown target %q\n", *target)
+		os.Exit(2)
+	}
+	if *opt < 0 || *opt > 2 {
+		fmt.Fprintf(os.Stderr, "invalid optimization level %d, want 0, 1 or 2\n", *opt)
+		os.Exit(2)
+	}
+
+	c := Compiler{Workers: *workers, Debug: *debug, SSA: *ssa, OptLevel: *opt, Target: *target, Triple: *triple, Package: *pkg, Comments: *docs}
+	c.Init("", *output)
+	if *verbose {
+		c.Verbose = os.Stderr
+	}
+	if *regs {
+		c.Intervals = os.Stderr
+	}
+	if *cacheDir != "" {
+		store, err := cache.Open(*cacheDir)
+		if err != nil {
+			report(err)
+			os.Exit(1)
+		}
+		c.Cache = store
+	}
+
+	err := c.CompileFiles(flag.Args())
+	for _, w := range c.Warnings {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", w.File, w.Msg)
+	}
+	if *cacheStats {
+		fmt.Fprintln(os.Stderr, c.CacheStats)
+	}
+	if *optStats {
+		fmt.Fprintln(os.Stderr, c.OptStats)
+	}
+	if err != nil {
+		report(err)
+		os.Exit(1)
+	}
+}
+
+// runFile disassembles and runs bytecode files, returns the exit status
+func runFile(run, disasm string) int {
+	if disasm != "" {
+		prog, err := loadFile(disasm)
+		if err != nil {
+			report(err)
+			return 1
+		}
+		fmt.Print(vm.Disassemble(prog))
+	}
+	if run == "" {
+		return 0
+	}
+
+	prog, err := loadFile(run)
+	if err != nil {
+		report(err)
+		return 1
+	}
+	result, err := vm.New(prog).Call("main")
+	if err != nil {
+		report(err)
+		return 1
+	}
+	if prog.Func("main").Result == ir.Int {
+		return int(result.Int())
+	}
+	return 0
+}
+
+func loadFile(name string) (*vm.Program, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := vm.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return prog, nil
+}
+
+// optFlags rewrites -O0, -O1 and -O2 as C compilers take them into
+// flags the flag package understands
+func optFlags(args []string) []string {
+	list := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) == 3 && strings.HasPrefix(arg, "-O") && '0' <= arg[2] && arg[2] <= '9' {
+			arg = "-O=" + arg[2:]
+		}
+		list[i] = arg
+	}
+	return list
+}
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "rabierre-compiler")
+}
 
+func report(err error) {
+	if list, ok := err.(ErrorList); ok {
+		for _, e := range list {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
 }
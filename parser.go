@@ -1,14 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
 
 	"github.com/rabierre/compiler/ast"
 	"github.com/rabierre/compiler/token"
 )
 
-var debug bool
-
 type Parser struct {
 	val string
 	tok token.Type
@@ -19,6 +19,9 @@ type Parser struct {
 	scanner  *Scanner
 
 	comments *ast.CommentList
+	groups   []*ast.CommentGroup
+
+	debug bool
 
 	// If we handle source codes in files
 	// This should go in file struct
@@ -34,17 +37,84 @@ func (p *Parser) Init(src []byte) {
 	p.comments = &ast.CommentList{}
 	p.OpenScope() // Top scope
 	p.topScope = p.scope
+	p.topScope.End = len(src)
 
 	p.next()
 }
 
 func (p *Parser) Parse() {
-	p.OpenScope()
+	p.parseFile()
 
-	// TODO parse comment
-	// case token.COMMENT:
-	// 		p.parseComment()
+	if p.debug {
+		for _, un := range p.UnResolved {
+			print(un.Name, " ")
+		}
+		println()
+	}
 
+	if len(p.UnResolved) > 0 {
+		panic("Unresolved ident exist")
+	}
+}
+
+// ParseFile parses a whole source file. Unlike Parse, identifiers which
+// are not declared in the file are left in File.UnResolved so they can be
+// resolved against declarations of other files.
+func (p *Parser) ParseFile(name string) *ast.File {
+	p.parseFile()
+
+	return &ast.File{
+		Name:       name,
+		Decls:      p.decls,
+		Scope:      p.topScope,
+		UnResolved: p.UnResolved,
+		Comments:   p.comments,
+		Groups:     p.groups,
+	}
+}
+
+// ParseInput parses a line of the REPL against scope, which persists
+// between lines. Functions, constants and enums are declared in it as top
+// level declarations, other statements are returned to run. Variables
+// declared by them are declared in scope too.
+func (p *Parser) ParseInput(scope *ast.Scope) ([]ast.Decl, []ast.Stmt) {
+	p.scope, p.topScope = scope, scope
+
+	var stmts []ast.Stmt
+	for p.tok != token.EOF {
+		switch p.tok {
+		case token.FUNC, token.CONST, token.ENUM:
+			p.parseDecl()
+		default:
+			stmts = append(stmts, p.parseStmt())
+		}
+	}
+
+	// Functions may call themselves, they are declared after their body
+	unResolved := p.UnResolved
+	p.UnResolved = []*ast.Ident{}
+	for _, id := range unResolved {
+		p.resolve(id)
+	}
+
+	// Functions are compiled apart from the statements, variables of the
+	// REPL reach only those
+	for _, decl := range p.decls {
+		f, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		ast.Inspect(f.Body, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && id.Obj != nil && id.Obj.Kind == ast.VAR && scope.Objects[id.Name] == id.Obj {
+				panic(fmt.Sprintf("%s uses variable %s of the REPL, functions can't use them", f.Name.Name, id.Name))
+			}
+			return true
+		})
+	}
+	return p.decls, stmts
+}
+
+func (p *Parser) parseFile() {
 	for !p.scanner.fullScaned {
 		// TODO use p.peek() after move parsecomment phase to scanner
 
@@ -54,8 +124,6 @@ func (p *Parser) Parse() {
 		}
 	}
 
-	p.CloseScope()
-
 	unResolved := p.UnResolved
 	p.UnResolved = []*ast.Ident{}
 	old := p.scope
@@ -64,21 +132,10 @@ func (p *Parser) Parse() {
 		p.resolve(id)
 	}
 	p.scope = old
-
-	if debug {
-		for _, un := range p.UnResolved {
-			print(un.Name, " ")
-		}
-		println()
-	}
-
-	if len(p.UnResolved) > 0 {
-		panic("Unresolved ident exist")
-	}
 }
 
 func (p *Parser) parseDecl() {
-	trace("parseDecl")
+	p.trace("parseDecl")
 
 	switch p.tok {
 	// By spec for now, no global variable, no imports are available.
@@ -86,13 +143,23 @@ func (p *Parser) parseDecl() {
 	//
 	case token.FUNC:
 		p.parseFunc()
+	case token.CONST:
+		decl := p.parseConstDecl(p.topScope)
+		p.decls = append(p.decls, decl)
+	case token.ENUM:
+		decl := p.parseEnumDecl()
+		p.decls = append(p.decls, decl)
 	default:
+		panic("Unexpected token: " + p.tok.String())
 	}
 }
 
 func (p *Parser) parseFunc() ast.Decl {
-	trace("parseFunc")
+	p.trace("parseFunc")
 
+	pos, doc := p.pos, p.doc(p.pos)
+	// Parameters are declared in the scope of the body
+	p.OpenScope()
 	p.next() // consune func token
 	ident := p.parseIdent()
 
@@ -102,37 +169,37 @@ func (p *Parser) parseFunc() ast.Decl {
 
 	// TODO parse func type
 	var _typ token.Type
-	if p.tok == token.INT || p.tok == token.DOUBLE {
+	var typeName *ast.Ident
+	switch p.tok {
+	case token.INT, token.DOUBLE:
 		_typ = p.tok
 		p.next() // consume type token
-	} else {
+	case token.IDENT:
+		_typ = p.tok
+		typeName = p.parseTypeName()
+	default:
 		_typ = token.VOID
 	}
 
-	p.OpenScope()
 	for _, param := range params.List {
 		if decl := param.(*ast.VarDeclStmt); decl != nil {
-			obj := ast.NewObject(decl, ast.VAR)
-			p.scope.Insert(obj, decl.Name.Name)
+			p.declare(p.scope, decl, ast.VAR, decl.Name)
 		}
 	}
 
 	body := p.parseBody()
-	decl := &ast.FuncDecl{Name: ident, Body: body, Params: params, Type: _typ}
+	decl := &ast.FuncDecl{Doc: doc, Pos: pos, Name: ident, Body: body, Params: params, Type: _typ, TypeName: typeName}
 
 	// TODO move this to specific function like parse function decl only
 	p.decls = append(p.decls, decl)
 
-	// TODO generalize this
-	// p.declare(decl, nil, p.pkgScope, ast.Fun, ident)
-	obj := ast.NewObject(decl, ast.FUNC)
-	p.topScope.Insert(obj, ident.Name)
+	p.declare(p.topScope, decl, ast.FUNC, ident)
 
 	return decl
 }
 
 func (p *Parser) parseIdent() *ast.Ident {
-	trace("parseIdent")
+	p.trace("parseIdent")
 
 	if p.tok != token.IDENT {
 		panic("Expect IDENT, GOT: " + p.tok.String())
@@ -145,10 +212,10 @@ func (p *Parser) parseIdent() *ast.Ident {
 }
 
 func (p *Parser) parseParamList() *ast.StmtList {
-	trace("parseParamList")
+	p.trace("parseParamList")
 
 	list := []ast.Stmt{}
-	for p.tok == token.INT || p.tok == token.DOUBLE {
+	for p.tok == token.INT || p.tok == token.DOUBLE || p.tok == token.IDENT {
 		list = append(list, p.parseParam())
 
 		if p.tok == token.RPAREN {
@@ -163,17 +230,21 @@ func (p *Parser) parseParamList() *ast.StmtList {
 }
 
 func (p *Parser) parseParam() ast.Stmt {
-	trace("parseParam")
+	p.trace("parseParam")
 
 	param := &ast.VarDeclStmt{Pos: p.pos, Type: p.tok}
-	p.next() // consume type
+	if p.tok == token.IDENT {
+		param.TypeName = p.parseTypeName()
+	} else {
+		p.next() // consume type
+	}
 	param.Name = &ast.Ident{Pos: p.pos, Name: p.val}
 	p.next() // consume variable
 	return param
 }
 
 func (p *Parser) parseBody() *ast.CompoundStmt {
-	trace("parseBody")
+	p.trace("parseBody")
 
 	lbrace := p.expect(token.LBRACE)
 
@@ -190,10 +261,10 @@ func (p *Parser) parseBody() *ast.CompoundStmt {
 }
 
 func (p *Parser) parseCompoundStmt() *ast.CompoundStmt {
-	trace("parseCompoundStmt")
+	p.trace("parseCompoundStmt")
 
-	lbrace := p.expect(token.LBRACE)
 	p.OpenScope()
+	lbrace := p.expect(token.LBRACE)
 
 	list := p.parseStmtList()
 
@@ -208,7 +279,7 @@ func (p *Parser) parseCompoundStmt() *ast.CompoundStmt {
 }
 
 func (p *Parser) parseStmtList() []ast.Stmt {
-	trace("parseStmtList")
+	p.trace("parseStmtList")
 
 	list := []ast.Stmt{}
 	for p.tok != token.RBRACE && p.tok != token.EOF {
@@ -221,17 +292,28 @@ func (p *Parser) parseStmtList() []ast.Stmt {
 }
 
 func (p *Parser) parseStmt() ast.Stmt {
-	trace("parseStmt")
+	p.trace("parseStmt")
 
 	switch p.tok {
 	case token.INT, token.DOUBLE:
 		return p.parseVarDecl()
+	case token.CONST:
+		return p.parseConstDecl(p.scope)
 	case token.IDENT:
+		// Only a declaration has two idents in a row: Color c
+		if next, _ := p.scanner.peek(); next.Kind == token.IDENT {
+			return p.parseVarDecl()
+		}
 		return p.parseExprStmt()
 	case token.FOR:
 		return p.parseForStmt()
 	case token.IF:
 		return p.parseIfStmt()
+	case token.SWITCH:
+		return p.parseSwitchStmt()
+	case token.FALLTHROUGH:
+		// parseCaseClause takes the one in place
+		panic("fallthrough statement out of place")
 	case token.RETURN:
 		return p.parseReturnStmt()
 	case token.LBRACE:
@@ -250,12 +332,17 @@ func (p *Parser) parseStmt() ast.Stmt {
 // int a = 1
 // double b = 1.0
 // int c
+// Color d = RED
 //
 func (p *Parser) parseVarDecl() ast.Stmt {
-	trace("parseVarDecl")
+	p.trace("parseVarDecl")
 
 	decl := &ast.VarDeclStmt{Pos: p.pos, Type: p.tok}
-	p.next() // consume type
+	if p.tok == token.IDENT {
+		decl.TypeName = p.parseTypeName()
+	} else {
+		p.next() // consume type
+	}
 
 	ident := p.parseIdent()
 
@@ -268,19 +355,85 @@ func (p *Parser) parseVarDecl() ast.Stmt {
 	decl.Name = ident
 	decl.RValue = value
 
-	// TODO generalize this
-	obj := ast.NewObject(decl, ast.VAR)
-	p.scope.Insert(obj, ident.Name)
+	p.declare(p.scope, decl, ast.VAR, ident)
+
+	return decl
+}
+
+// parse constant declaration into scope
+// const int a = 1
+// const double b = 2.0 * a
+//
+func (p *Parser) parseConstDecl(scope *ast.Scope) *ast.ConstDecl {
+	p.trace("parseConstDecl")
+
+	decl := &ast.ConstDecl{Pos: p.pos}
+	p.next() // consume const
+
+	if p.tok != token.INT && p.tok != token.DOUBLE {
+		panic("Expect type, GOT: " + p.tok.String())
+	}
+	decl.Type = p.tok
+	p.next() // consume type
+
+	decl.Name = p.parseIdent()
+	p.expect(token.ASSIGN)
+	decl.Value = p.parseExpr(true)
+
+	p.declare(scope, decl, ast.CONST, decl.Name)
 
 	return decl
 }
 
+// parse enum declaration, members are declared in top scope
+// enum Color { RED, GREEN = 5, BLUE }
+//
+func (p *Parser) parseEnumDecl() *ast.EnumDecl {
+	p.trace("parseEnumDecl")
+
+	decl := &ast.EnumDecl{Pos: p.pos}
+	p.next() // consume enum
+
+	decl.Name = p.parseIdent()
+	p.expect(token.LBRACE)
+	for p.tok != token.RBRACE && p.tok != token.EOF {
+		member := &ast.EnumMember{Name: p.parseIdent()}
+		if p.tok == token.ASSIGN {
+			p.next() // consume =
+			member.Value = p.parseExpr(true)
+		}
+		decl.Members = append(decl.Members, member)
+
+		if p.tok != token.COMMA {
+			break
+		}
+		p.next() // consume ,
+	}
+	decl.RBracePos = p.expect(token.RBRACE)
+
+	p.declare(p.topScope, decl, ast.TYPE, decl.Name)
+	for _, member := range decl.Members {
+		p.declare(p.topScope, member, ast.CONST, member.Name)
+	}
+
+	return decl
+}
+
+// Named type in declarations, resolved like other idents
+func (p *Parser) parseTypeName() *ast.Ident {
+	p.trace("parseTypeName")
+
+	id := p.parseIdent()
+	p.resolve(id)
+	return id
+}
+
 // Parse Expr in Statement
 // a = 10
 // funcCall()
 //
 func (p *Parser) parseExprStmt() ast.Stmt {
-	trace("parseExprStmt")
+	p.trace("parseExprStmt")
 
 	x := p.parseExpr(true)
 	if p.tok == token.ASSIGN {
@@ -292,7 +445,11 @@ func (p *Parser) parseExprStmt() ast.Stmt {
 }
 
 func (p *Parser) parseForStmt() ast.Stmt {
-	trace("parseForStmt")
+	p.trace("parseForStmt")
+
+	// Variables declared in init are visible in the loop only,
+	// parseBody closes this scope
+	p.OpenScope()
 
 	pos := p.pos
 	p.next() //consume for
@@ -321,7 +478,7 @@ func (p *Parser) parseForStmt() ast.Stmt {
 }
 
 func (p *Parser) parseIfStmt() ast.Stmt {
-	trace("parseIfStmt")
+	p.trace("parseIfStmt")
 
 	pos := p.pos
 	p.next() // consume if
@@ -340,14 +497,90 @@ func (p *Parser) parseIfStmt() ast.Stmt {
 	return &ast.IfStmt{Pos: pos, Cond: cond, Body: body, ElseBody: elseBody}
 }
 
+// switch (a) {
+// case 1, 2:
+//     fallthrough
+// default:
+// }
+//
+func (p *Parser) parseSwitchStmt() ast.Stmt {
+	p.trace("parseSwitchStmt")
+
+	pos := p.pos
+	p.next() // consume switch
+
+	p.expect(token.LPAREN)
+	tag := p.parseExpr(true)
+	p.expect(token.RPAREN)
+
+	p.expect(token.LBRACE)
+	var body []*ast.CaseClause
+	hasDefault := false
+	for p.tok == token.CASE || p.tok == token.DEFAULT {
+		if p.tok == token.DEFAULT {
+			if hasDefault {
+				panic("multiple defaults in switch")
+			}
+			hasDefault = true
+		}
+		body = append(body, p.parseCaseClause())
+	}
+	rbrace := p.expect(token.RBRACE)
+
+	if n := len(body); n > 0 {
+		if _, ok := lastStmt(body[n-1].Body).(*ast.FallthroughStmt); ok {
+			panic("Cannot fallthrough final case in switch")
+		}
+	}
+
+	return &ast.SwitchStmt{Pos: pos, Tag: tag, Body: body, RBracePos: rbrace}
+}
+
+func (p *Parser) parseCaseClause() *ast.CaseClause {
+	p.trace("parseCaseClause")
+
+	p.OpenScope()
+	clause := &ast.CaseClause{Pos: p.pos}
+	if p.tok == token.CASE {
+		p.next() // consume case
+		clause.List = p.parseExprList().List
+	} else {
+		p.next() // consume default
+	}
+	p.expect(token.COLON)
+
+	for p.tok != token.CASE && p.tok != token.DEFAULT && p.tok != token.RBRACE && p.tok != token.EOF {
+		if _, ok := lastStmt(clause.Body).(*ast.FallthroughStmt); ok {
+			panic("fallthrough must be the last statement of a case")
+		}
+
+		if p.tok == token.FALLTHROUGH {
+			clause.Body = append(clause.Body, &ast.FallthroughStmt{Pos: p.pos})
+			p.next() // consume fallthrough
+			continue
+		}
+		clause.Body = append(clause.Body, p.parseStmt())
+	}
+	p.CloseScope()
+
+	return clause
+}
+
+func lastStmt(list []ast.Stmt) ast.Stmt {
+	if len(list) == 0 {
+		return nil
+	}
+	return list[len(list)-1]
+}
+
 func (p *Parser) parseReturnStmt() ast.Stmt {
-	trace("parseReturnStmt")
+	p.trace("parseReturnStmt")
 
 	pos := p.pos
 	p.next() // consume return
 
 	var expr ast.Expr
-	if p.tok != token.EOF && p.tok != token.RBRACE {
+	if p.tok != token.EOF && p.tok != token.RBRACE && p.tok != token.CASE && p.tok != token.DEFAULT {
 		expr = p.parseExpr(true)
 	}
 
@@ -355,7 +588,7 @@ func (p *Parser) parseReturnStmt() ast.Stmt {
 }
 
 func (p *Parser) parseExprList() *ast.ExprList {
-	trace("parseExprList")
+	p.trace("parseExprList")
 
 	var exprs []ast.Expr
 	for {
@@ -372,14 +605,14 @@ func (p *Parser) parseExprList() *ast.ExprList {
 }
 
 func (p *Parser) parseExpr(lookup bool) ast.Expr {
-	trace("parseExpr")
+	p.trace("parseExpr")
 
 	return p.parseBinaryExpr(token.LowestPriority+1, lookup)
 }
 
 // Term
 func (p *Parser) parseBinaryExpr(prio int, lookup bool) ast.Expr {
-	trace("parseBinaryExpr")
+	p.trace("parseBinaryExpr")
 
 	x := p.parseUnaryExpr(lookup)
 	for {
@@ -391,7 +624,7 @@ func (p *Parser) parseBinaryExpr(prio int, lookup bool) ast.Expr {
 		op := ast.Operator{Type: p.tok}
 		p.next() // consume operator
 
-		y := p.parseBinaryExpr(p.tok.Priority()+1, lookup)
+		y := p.parseBinaryExpr(op.Type.Priority()+1, lookup)
 		x = &ast.BinaryExpr{Pos: p.pos, Op: op, LValue: x, RValue: y}
 	}
 }
@@ -401,17 +634,16 @@ func (p *Parser) parseBinaryExpr(prio int, lookup bool) ast.Expr {
 //         | number
 //         | string
 func (p *Parser) parseUnaryExpr(lookup bool) ast.Expr {
-	trace("parseUnaryExpr")
+	p.trace("parseUnaryExpr")
 
 	switch p.tok {
 	case token.PLUS, token.MINUS:
+		pos := p.pos
 		op := ast.Operator{Type: p.tok}
 		p.next() // consume operator
 
 		x := p.parseUnaryExpr(lookup)
-
-		// TODO fix position: use operator's position
-		return &ast.UnaryExpr{Pos: p.pos, Op: op, RValue: x}
+		return &ast.UnaryExpr{Pos: pos, Op: op, RValue: x}
 	}
 
 	return p.parsePrimaryExpr(lookup)
@@ -422,7 +654,7 @@ func (p *Parser) parseUnaryExpr(lookup bool) ast.Expr {
 // identifier
 //
 func (p *Parser) parsePrimaryExpr(lookup bool) ast.Expr {
-	trace("parsePrimaryExpr")
+	p.trace("parsePrimaryExpr")
 
 	x := p.parseOperand(lookup)
 
@@ -448,7 +680,7 @@ func (p *Parser) parsePrimaryExpr(lookup bool) ast.Expr {
 }
 
 func (p *Parser) parseOperand(lookup bool) ast.Expr {
-	trace("parseOperand")
+	p.trace("parseOperand")
 
 	switch p.tok {
 	case token.IDENT:
@@ -469,7 +701,7 @@ func (p *Parser) parseOperand(lookup bool) ast.Expr {
 }
 
 func (p *Parser) parseCallExpr(x ast.Expr) ast.Expr {
-	trace("parseCallExpr")
+	p.trace("parseCallExpr")
 
 	lparen := p.expect(token.LPAREN)
 	list := []ast.Expr{}
@@ -487,14 +719,16 @@ func (p *Parser) parseCallExpr(x ast.Expr) ast.Expr {
 }
 
 func (p *Parser) parseRHS() ast.Expr {
-	trace("parseRHS")
+	p.trace("parseRHS")
 
 	return p.parseExpr(true)
 }
 
+// next moves to the next token, comments on the way are kept in
+// p.comments
 func (p *Parser) next() {
 	for tok, _ := p.scanner.peek(); tok.Kind == token.COMMENT; tok, _ = p.scanner.peek() {
-		p.scanner.nextLine()
+		p.parseComment()
 	}
 	tok, pos := p.scanner.next()
 	p.tok = tok.Kind
@@ -511,43 +745,83 @@ func (p *Parser) expect(expected token.Type) int {
 	return pos
 }
 
+// // to the end of line or /* */, Pos is of its first /
 func (p *Parser) parseComment() {
-	trace("parseComment")
+	p.trace("parseComment")
 
-	token, pos := p.scanner.nextLine()
+	if tok, _ := p.scanner.peek(); tok.Kind != token.COMMENT {
+		return
+	}
+	tok, pos := p.scanner.next()
 
-	comment := &ast.Comment{pos, token.Val}
+	comment := &ast.Comment{Pos: pos, Text: strings.TrimSpace(tok.Val)}
 	p.comments.Insert(comment)
+
+	if n := len(p.groups); n > 0 && !p.afterCode(comment.Pos) && p.above(p.groups[n-1], comment.Pos) {
+		p.groups[n-1].List = append(p.groups[n-1].List, comment)
+	} else {
+		p.groups = append(p.groups, &ast.CommentGroup{List: []*ast.Comment{comment}})
+	}
+}
+
+// doc returns the comment group right above pos
+func (p *Parser) doc(pos int) *ast.CommentGroup {
+	if n := len(p.groups); n > 0 && p.above(p.groups[n-1], pos) {
+		return p.groups[n-1]
+	}
+	return nil
+}
+
+// above tells if g ends on the line before pos and is on lines of its own
+func (p *Parser) above(g *ast.CommentGroup, pos int) bool {
+	return !p.afterCode(g.List[0].Pos) && p.lineAfter(g.End(), pos)
+}
+
+// afterCode tells if something but spaces precedes pos on its line
+func (p *Parser) afterCode(pos int) bool {
+	start := bytes.LastIndexByte(p.scanner.src[:pos], '\n') + 1
+	return len(bytes.TrimSpace(p.scanner.src[start:pos])) > 0
+}
+
+// lineAfter tells if pos is on the line after end, only spaces between
+func (p *Parser) lineAfter(end, pos int) bool {
+	between := p.scanner.src[end:pos]
+	return bytes.Count(between, []byte("\n")) == 1 && len(bytes.TrimSpace(between)) == 0
 }
 
 func (p *Parser) resolve(expr ast.Expr) {
-	trace("resolve")
+	p.trace("resolve")
 
 	id := expr.(*ast.Ident)
 	if id == nil {
 		return
 	}
 
-	for s := p.scope; s != nil; s = s.Outer {
-		_, exist := s.Objects[id.Name]
-		if exist {
-			return
-		}
+	if id.Obj = p.scope.Lookup(id.Name); id.Obj == nil {
+		p.UnResolved = append(p.UnResolved, id)
 	}
+}
 
-	p.UnResolved = append(p.UnResolved, id)
+// declare inserts decl named name into scope, name denotes it
+func (p *Parser) declare(scope *ast.Scope, decl interface{}, kind ast.ObjectType, name *ast.Ident) {
+	name.Obj = ast.NewObject(decl, kind)
+	scope.Insert(name.Obj, name.Name)
 }
 
+// OpenScope opens a scope nested in the current one from the current token
 func (p *Parser) OpenScope() {
-	p.scope = &ast.Scope{p.scope, map[string]*ast.Object{}}
+	p.scope = ast.NewScope(p.scope)
+	p.scope.Pos = p.pos
 }
 
+// CloseScope ends the current scope at the current token
 func (p *Parser) CloseScope() {
+	p.scope.End = p.pos
 	p.scope = p.scope.Outer
 }
 
-func trace(name string) {
-	if debug {
+func (p *Parser) trace(name string) {
+	if p.debug {
 		println(name)
 	}
 }
@@ -24,6 +24,48 @@ func TestParseComment(t *testing.T) {
 	assert.Equal(t, 1, len(parser.comments.List))
 }
 
+func TestParseComments(t *testing.T) {
+	src := "// head\nfunc f() int { // open\n\t// inside\n\treturn 1 }\n//tail"
+	parser := initParser(src)
+	file := parser.ParseFile("")
+
+	var texts []string
+	for _, c := range file.Comments.List {
+		assert.Equal(t, "/", src[c.Pos:c.Pos+1])
+		texts = append(texts, c.Text)
+	}
+	assert.Equal(t, []string{"// head", "// open", "// inside", "//tail"}, texts)
+	assert.Equal(t, 1, len(file.Decls))
+}
+
+func TestCommentGroups(t *testing.T) {
+	src := "// a\n// b\n\n// c\nfunc f() { // d\n\t// e\n}\n// g\n\nfunc g() {}\n"
+	file := initParser(src).ParseFile("")
+
+	var groups []string
+	for _, g := range file.Groups {
+		groups = append(groups, g.Text())
+	}
+	assert.Equal(t, []string{"a\nb\n", "c\n", "d\n", "e\n", "g\n"}, groups)
+	assert.Equal(t, file.Groups[1], file.Decls[0].(*ast.FuncDecl).Doc)
+	assert.Nil(t, file.Decls[1].(*ast.FuncDecl).Doc)
+}
+
+func TestBlockComments(t *testing.T) {
+	src := "/* a /* b */ */\n/*\n * Sum\n *   adds\n */\nfunc sum(int n) int { /* c */ return n /**/ }\n"
+	file := initParser(src).ParseFile("")
+
+	var texts []string
+	for _, c := range file.Comments.List {
+		texts = append(texts, c.Text)
+	}
+	assert.Equal(t, []string{"/* a /* b */ */", "/*\n * Sum\n *   adds\n */", "/* c */", "/**/"}, texts)
+	doc := file.Decls[0].(*ast.FuncDecl).Doc
+	assert.Equal(t, 2, len(doc.List))
+	assert.Equal(t, "a /* b */\nSum\nadds\n", doc.Text())
+	assert.Equal(t, 3, len(file.Groups))
+}
+
 func TestParseFunction(t *testing.T) {
 	src := `func func1() {}
 		// Comment 1
@@ -195,3 +237,144 @@ func TestResolve(t *testing.T) {
 	})
 	assert.Equal(t, 2, len(parser.UnResolved))
 }
+
+func TestResolveObjects(t *testing.T) {
+	src := `const int N = 1
+func f(int n) int {
+    int x = n
+    for (int i = 0; i < N; i++) {
+        int x = i
+        x = f(x)
+    }
+    switch (x) {
+    case 1:
+        int y = x
+    }
+    return x + g()
+}`
+	file := initParser(src).ParseFile("")
+	n := file.Decls[0].(*ast.ConstDecl)
+	fn := file.Decls[1].(*ast.FuncDecl)
+	param := fn.Params.List[0].(*ast.VarDeclStmt)
+	x := fn.Body.List[0].(*ast.VarDeclStmt)
+	loop := fn.Body.List[1].(*ast.ForStmt)
+	inner := loop.Body.List[0].(*ast.VarDeclStmt)
+	assign := loop.Body.List[1].(*ast.ExprStmt).Val.(*ast.AssignExpr)
+
+	assert.Equal(t, &ast.Object{Kind: ast.FUNC, Decl: fn}, fn.Name.Obj)
+	assert.Equal(t, &ast.Object{Kind: ast.CONST, Decl: n}, loop.Cond.(*ast.BinaryExpr).RValue.(*ast.Ident).Obj)
+	assert.True(t, x.RValue.(*ast.Ident).Obj == param.Name.Obj)
+	assert.Equal(t, ast.VAR, param.Name.Obj.Kind)
+	assert.True(t, assign.LValue.(*ast.Ident).Obj.Decl == inner)
+	// Calls of a function in itself are bound once it is declared
+	assert.True(t, assign.RValue.(*ast.CallExpr).Name.(*ast.Ident).Obj == fn.Name.Obj)
+	assert.True(t, assign.RValue.(*ast.CallExpr).Params.List[0].(*ast.Ident).Obj.Decl == inner)
+	g := fn.Body.List[3].(*ast.ReturnStmt).Value.(*ast.BinaryExpr).RValue.(*ast.CallExpr).Name.(*ast.Ident)
+	assert.Nil(t, g.Obj)
+	assert.Equal(t, []*ast.Ident{g}, file.UnResolved)
+
+	// Scope tree
+	assert.Equal(t, 0, file.Scope.Pos)
+	assert.Equal(t, len(src), file.Scope.End)
+	if assert.Len(t, file.Scope.Children, 1) {
+		body := file.Scope.Children[0]
+		assert.Equal(t, fn.Pos, body.Pos)
+		assert.Equal(t, fn.Body.RBracePos, body.End)
+		assert.True(t, body.Objects["n"] == param.Name.Obj)
+		if assert.Len(t, body.Children, 2) {
+			assert.Equal(t, loop.Pos, body.Children[0].Pos)
+			assert.True(t, file.Scope.Innermost(strings.Index(src, "x = i")) == body.Children[0])
+			assert.True(t, body.Children[0].Lookup("x").Decl == inner)
+			clause := file.Scope.Innermost(strings.Index(src, "int y"))
+			assert.True(t, clause == body.Children[1])
+			assert.True(t, clause.Lookup("x").Decl == x)
+			assert.True(t, clause.Lookup("N").Decl == n)
+			assert.Nil(t, clause.Lookup("g"))
+		}
+	}
+	assert.True(t, file.Scope.Innermost(strings.Index(src, "func")) == file.Scope.Children[0])
+	assert.True(t, file.Scope.Innermost(1) == file.Scope)
+	assert.Nil(t, file.Scope.Innermost(len(src)+1))
+}
+
+func TestParseEnumDecl(t *testing.T) {
+	src := `func show(Color c) Color {
+			Color d = RED
+			return d
+		}
+		enum Color { RED, GREEN = 5, BLUE }
+	`
+	parser := initParser(src)
+	parser.Parse()
+
+	assert.Equal(t, 2, len(parser.decls))
+	fn := parser.decls[0].(*ast.FuncDecl)
+	assert.Equal(t, "Color", fn.TypeName.Name)
+	assert.Equal(t, "Color", fn.Params.List[0].(*ast.VarDeclStmt).TypeName.Name)
+
+	d := fn.Body.List[0].(*ast.VarDeclStmt)
+	assert.Equal(t, token.IDENT, d.Type)
+	assert.Equal(t, "Color", d.TypeName.Name)
+	assert.Equal(t, "d", d.Name.Name)
+
+	enum := parser.decls[1].(*ast.EnumDecl)
+	assert.Equal(t, "Color", enum.Name.Name)
+	assert.Equal(t, 3, len(enum.Members))
+	assert.Nil(t, enum.Members[0].Value)
+	assert.Equal(t, "5", enum.Members[1].Value.(*ast.BasicLit).Value)
+}
+
+func TestParseSwitchStmt(t *testing.T) {
+	src := `switch (1) {
+		case 1, 2:
+			int a = 1
+			fallthrough
+		case 3:
+		default:
+			return
+		}
+	`
+	parser := initParser(src)
+	stmt := parser.parseSwitchStmt().(*ast.SwitchStmt)
+	assert.Equal(t, 3, len(stmt.Body))
+	assert.Equal(t, 2, len(stmt.Body[0].List))
+	assert.Equal(t, 2, len(stmt.Body[0].Body))
+	assert.NotNil(t, stmt.Body[0].Body[1].(*ast.FallthroughStmt))
+	assert.Equal(t, 0, len(stmt.Body[1].Body))
+	assert.Nil(t, stmt.Body[2].List)
+	assert.Nil(t, stmt.Body[2].Body[0].(*ast.ReturnStmt).Value)
+
+	for _, src := range []string{
+		`switch (1) {
+		case 1:
+			fallthrough
+		}`,
+		`switch (1) {
+		case 1:
+			fallthrough
+			return
+		case 2:
+		}`,
+		`switch (1) {
+		case 1:
+			if (1 == 1) {
+				fallthrough
+			}
+		case 2:
+		}`,
+	} {
+		parser = initParser(src)
+		assert.Panics(t, func() {
+			parser.parseSwitchStmt()
+		}, src)
+	}
+
+	parser = initParser(`switch (1) {
+		default:
+		case 1:
+		default:
+		}`)
+	assert.PanicsWithValue(t, "multiple defaults in switch", func() {
+		parser.parseSwitchStmt()
+	})
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/rabierre/compiler/format"
+	"github.com/rabierre/compiler/lsp"
+	"github.com/rabierre/compiler/token"
+)
+
+// renameCommand renames the declaration an ident names and its references
+// in the sources of the directory compiled with it. It prints what
+// changes as diffs, with -w it writes the sources. It returns the exit
+// status.
+func renameCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("rename", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	write := flags.Bool("w", false, "write renamed sources instead of printing diffs")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: compiler rename [-w] file:line:column name")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	name, pos, err := parsePosition(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if name, err = filepath.Abs(name); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	text, err := ioutil.ReadFile(name)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	doc := parseDocument(fileURI(name), text)
+	list, err := rename(doc, siblings(doc, nil), doc.lines.Offset(pos), flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	for _, r := range list {
+		if !*write {
+			stdout.Write(format.Diff(relative(r.doc.path), r.doc.text, r.text))
+			continue
+		}
+		info, err := os.Stat(r.doc.path)
+		if err == nil {
+			err = ioutil.WriteFile(r.doc.path, r.text, info.Mode().Perm())
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// parsePosition splits file:line:column, line and column count from 1
+func parsePosition(arg string) (string, lsp.Position, error) {
+	parts := strings.Split(arg, ":")
+	if n := len(parts); n >= 3 {
+		line, err1 := strconv.Atoi(parts[n-2])
+		column, err2 := strconv.Atoi(parts[n-1])
+		if err1 == nil && err2 == nil && line > 0 && column > 0 {
+			return strings.Join(parts[:n-2], ":"), lsp.Position{Line: line - 1, Character: column - 1}, nil
+		}
+	}
+	return "", lsp.Position{}, fmt.Errorf("invalid position %q, want file:line:column", arg)
+}
+
+// relative returns path relative to the working directory if it is in it
+func relative(path string) string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+//--------------------------------------------------------------------------------------
+// Rename
+//
+
+// renaming is the new text of a document
+type renaming struct {
+	doc  *document
+	text []byte
+}
+
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// rename renames the declaration named by the ident at offset of doc, and
+// idents referring to it there and in others. It is refused if an ident
+// would then name another declaration than it does: a renamed one hidden
+// by a declaration of the new name in a scope between, or one of the new
+// name hidden by the renamed declaration.
+func rename(doc *document, others []*document, offset int, to string) ([]renaming, error) {
+	docs := append([]*document{doc}, others...)
+	for _, doc := range docs {
+		if doc.file == nil {
+			return nil, fmt.Errorf("%s: %s", doc.where(doc.lines.Offset(doc.err.Range.Start)), doc.err.Message)
+		}
+	}
+
+	id := doc.at(offset)
+	if id == nil {
+		return nil, fmt.Errorf("%s: no identifier to rename", doc.where(offset))
+	}
+	d := bind(doc, id, others)
+	if d == nil {
+		return nil, fmt.Errorf("%s: %s is not declared", doc.where(id.Pos), id.Name)
+	}
+	if !identPattern.MatchString(to) || token.KeywordType(to) != token.IDENT {
+		return nil, fmt.Errorf("invalid name %q", to)
+	}
+	from := declName(d.decl).Name
+	if to == from {
+		return nil, nil
+	}
+
+	edits := map[*document][]int{}
+	d.references(docs, func(doc *document, id *ast.Ident) {
+		edits[doc] = append(edits[doc], id.Pos)
+	})
+	// shift moves an offset of a document to where it is once renamed
+	shift := func(doc *document, pos int) int {
+		n := 0
+		for _, p := range edits[doc] {
+			if p < pos {
+				n++
+			}
+		}
+		return pos + n*(len(to)-len(from))
+	}
+
+	var list []renaming
+	renamed := make([]*document, len(docs))
+	for i, doc := range docs {
+		pos := edits[doc]
+		if len(pos) == 0 {
+			renamed[i] = doc
+			continue
+		}
+		sort.Ints(pos)
+		var text []byte
+		last := 0
+		for _, p := range pos {
+			text = append(append(text, doc.text[last:p]...), to...)
+			last = p + len(from)
+		}
+		text = append(text, doc.text[last:]...)
+
+		list = append(list, renaming{doc, text})
+		renamed[i] = parseDocument(doc.uri, text)
+		if renamed[i].err != nil {
+			// Only the renamed declaration is new, it is in a scope
+			// declaring the name
+			return nil, fmt.Errorf("%s: %s is already declared in its scope", renamed[i].where(shift(doc, declName(d.decl).Pos)), to)
+		}
+	}
+
+	// Top level declarations of the sources are linked together
+	if obj := d.doc.file.Scope.Objects[from]; obj != nil && obj.Decl == d.decl {
+		var first *document
+		for _, doc := range renamed {
+			if obj := doc.file.Scope.Objects[to]; obj != nil {
+				if first != nil {
+					return nil, fmt.Errorf("%s: %s is also declared at %s", first.where(declName(first.file.Scope.Objects[to].Decl).Pos), to, doc.where(declName(obj.Decl).Pos))
+				}
+				first = doc
+			}
+		}
+	}
+
+	// Idents name the same declarations, at their new offsets
+	where := func(b *declared) string {
+		if b == nil {
+			return "nothing"
+		}
+		return "the declaration at " + b.doc.where(declName(b.decl).Pos)
+	}
+	idents := make([]map[int]*ast.Ident, len(docs))
+	for i, doc := range renamed {
+		idents[i] = map[int]*ast.Ident{}
+		for _, id := range doc.idents {
+			idents[i][id.Pos] = id
+		}
+	}
+	for i, doc := range docs {
+		for _, id := range doc.idents {
+			var want *declared
+			if b := bind(doc, id, without(docs, i)); b != nil {
+				j := indexOf(docs, b.doc)
+				want = &declared{renamed[j], idents[j][shift(b.doc, declName(b.decl).Pos)].Obj.Decl}
+			}
+			pos := shift(doc, id.Pos)
+			got := bind(renamed[i], idents[i][pos], without(renamed, i))
+			if !(got == nil && want == nil || got != nil && want != nil && *got == *want) {
+				return nil, fmt.Errorf("%s: %s would refer to %s instead of %s", renamed[i].where(pos), idents[i][pos].Name, where(got), where(want))
+			}
+		}
+	}
+
+	return list, nil
+}
+
+func without(docs []*document, i int) []*document {
+	return append(append([]*document{}, docs[:i]...), docs[i+1:]...)
+}
+
+func indexOf(docs []*document, doc *document) int {
+	for i, d := range docs {
+		if d == doc {
+			return i
+		}
+	}
+	return -1
+}
+
+// where returns file:line:column of offset
+func (doc *document) where(offset int) string {
+	pos := doc.lines.Position(offset)
+	return fmt.Sprintf("%s:%d:%d", relative(doc.path), pos.Line+1, pos.Character+1)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rename")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	sum, main := filepath.Join(dir, "sum.txt"), filepath.Join(dir, "main.txt")
+	assert.Nil(t, ioutil.WriteFile(sum, []byte(lspSum), 0644))
+	assert.Nil(t, ioutil.WriteFile(main, []byte(lspMain), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "other.go"), []byte("func"), 0644))
+
+	renamed := func(file string, offset int, to string) (map[string]string, error) {
+		text, err := ioutil.ReadFile(file)
+		assert.Nil(t, err)
+		doc := parseDocument(fileURI(file), text)
+		list, err := rename(doc, siblings(doc, nil), offset, to)
+		texts := map[string]string{}
+		for _, r := range list {
+			texts[filepath.Base(r.doc.path)] = string(r.text)
+		}
+		return texts, err
+	}
+
+	// Parameter n of sum
+	texts, err := renamed(sum, strings.Index(lspSum, "int n")+4, "count")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"sum.txt": strings.Replace(strings.Replace(lspSum, "int n", "int count", 1), "i < n", "i < count", 1)}, texts)
+
+	// twice is called from the other file, Color used there
+	texts, err = renamed(main, strings.Index(lspMain, "twice"), "double2")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"main.txt": strings.Replace(lspMain, "twice", "double2", 1),
+		"sum.txt":  strings.Replace(lspSum, "twice", "double2", 1),
+	}, texts)
+	texts, err = renamed(sum, strings.Index(lspSum, "RED"), "Red")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"main.txt": strings.Replace(lspMain, "RED", "Red", 1),
+		"sum.txt":  strings.Replace(lspSum, "RED", "Red", 1),
+	}, texts)
+	texts, err = renamed(sum, strings.Index(lspSum, "sum("), "sum")
+	assert.Nil(t, err)
+	assert.Empty(t, texts)
+
+	at := func(file, src, text string) string {
+		doc := parseDocument(fileURI(file), []byte(src))
+		return doc.where(strings.Index(src, text))
+	}
+	for _, test := range []struct {
+		file, src string
+		offset    int
+		to, err   string
+	}{
+		{sum, lspSum, strings.Index(lspSum, "n)"), "s", at(sum, lspSum, "n)") + ": s is already declared in its scope"},
+		{sum, lspSum, strings.Index(lspSum, "i = 0"), "s", at(sum, lspSum, "s = s + i") + ": s would refer to the declaration at " + at(sum, lspSum, "i = 0") + " instead of the declaration at " + at(sum, lspSum, "s = 0")},
+		{sum, lspSum, strings.Index(lspSum, "N)"), "twice", at(sum, lspSum, "N = 10") + ": twice is also declared at " + at(main, lspMain, "twice")},
+		{main, lspMain, strings.Index(lspMain, "c ="), "sum", at(main, lspMain, "sum(3)") + ": sum would refer to the declaration at " + at(main, lspMain, "c =") + " instead of the declaration at " + at(sum, lspSum, "sum(")},
+		{main, lspMain, strings.Index(lspMain, "twice"), "sum", at(main, lspMain, "twice") + ": sum is also declared at " + at(sum, lspSum, "sum(")},
+		{main, lspMain, strings.Index(lspMain, "missing"), "m", at(main, lspMain, "missing") + ": missing is not declared"},
+		{main, lspMain, 0, "m", at(main, lspMain, "func") + ": no identifier to rename"},
+		{main, lspMain, strings.Index(lspMain, "x)"), "return", `invalid name "return"`},
+		{main, lspMain, strings.Index(lspMain, "x)"), "a-b", `invalid name "a-b"`},
+	} {
+		_, err := renamed(test.file, test.offset, test.to)
+		assert.EqualError(t, err, test.err)
+	}
+
+	// Sources which do not parse are not renamed
+	broken := filepath.Join(dir, "broken.txt")
+	assert.Nil(t, ioutil.WriteFile(broken, []byte("func f( {"), 0644))
+	_, err = renamed(sum, strings.Index(lspSum, "twice"), "dbl")
+	assert.EqualError(t, err, broken+":1:9: Expected: ) Found: {, {")
+	assert.Nil(t, os.Remove(broken))
+}
+
+func TestRenameCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rename")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	sum, main := filepath.Join(dir, "sum.txt"), filepath.Join(dir, "main.txt")
+	assert.Nil(t, ioutil.WriteFile(sum, []byte(lspSum), 0644))
+	assert.Nil(t, ioutil.WriteFile(main, []byte(lspMain), 0644))
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, renameCommand([]string{main + ":5:5", "Shade"}, &stdout, &stderr))
+	assert.Equal(t, "--- "+main+".orig\n+++ "+main+"\n@@ -2,6 +2,6 @@\n", strings.Join(strings.SplitAfter(stdout.String(), "\n")[:3], ""))
+	assert.Contains(t, stdout.String(), "--- "+sum+".orig\n+++ "+sum+"\n@@ -1,4 +1,4 @@\n-enum Color { RED, GREEN }\n+enum Shade { RED, GREEN }\n")
+
+	stdout.Reset()
+	assert.Equal(t, 0, renameCommand([]string{"-w", main + ":5:5", "Shade"}, &stdout, &stderr))
+	assert.Equal(t, "", stdout.String())
+	text, err := ioutil.ReadFile(sum)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Replace(lspSum, "Color", "Shade", 1), string(text))
+	text, err = ioutil.ReadFile(main)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Replace(lspMain, "Color", "Shade", 1), string(text))
+
+	assert.Equal(t, "", stderr.String())
+	assert.Equal(t, 1, renameCommand([]string{main + ":6:21", "m"}, &stdout, &stderr))
+	assert.Equal(t, main+":6:21: missing is not declared\n", stderr.String())
+	stderr.Reset()
+	assert.Equal(t, 2, renameCommand([]string{main + ":0:1", "m"}, &stdout, &stderr))
+	assert.Equal(t, `invalid position "`+main+`:0:1", want file:line:column`+"\n", stderr.String())
+	assert.Equal(t, 2, renameCommand([]string{main + ":1:1"}, &stdout, &stderr))
+}
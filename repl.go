@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/rabierre/compiler/ir"
+	"github.com/rabierre/compiler/token"
+	"github.com/rabierre/compiler/vm"
+)
+
+// REPL reads declarations and statements and runs them at once.
+// Functions, constants, enums and variables declared at top level persist
+// in one top scope. Statements are compiled to bytecode as the body of a
+// function whose parameters are the variables, values they have at its
+// return are kept for the next input. Functions are compiled apart from
+// them and can't use variables.
+type REPL struct {
+	out   io.Writer
+	scope *ast.Scope
+	decls []ast.Decl  // functions, constants and enums so far
+	vars  []*variable // top level variables in order of declaration
+}
+
+type variable struct {
+	decl  *ast.VarDeclStmt
+	typ   ir.Type
+	value vm.Value
+}
+
+// Name of the function statements run in, idents can't have $
+const evalFunc = "$eval"
+
+func NewREPL(out io.Writer) *REPL {
+	return &REPL{out: out, scope: ast.NewScope(nil)}
+}
+
+// Run evaluates lines of in until it ends. Lines are joined while braces
+// are left open.
+func (r *REPL) Run(in io.Reader) {
+	lines := bufio.NewScanner(in)
+	var src strings.Builder
+	depth := 0
+	for {
+		if depth > 0 {
+			fmt.Fprint(r.out, "... ")
+		} else {
+			fmt.Fprint(r.out, ">>> ")
+		}
+		if !lines.Scan() {
+			fmt.Fprintln(r.out)
+			return
+		}
+		line := lines.Text()
+		src.WriteString(line + "\n")
+		if depth += braces(line); depth > 0 {
+			continue
+		}
+
+		if err := r.Eval(src.String()); err != nil {
+			fmt.Fprintf(r.out, "error: %v\n", err)
+		}
+		src.Reset()
+		depth = 0
+	}
+}
+
+// braces returns how many more braces line opens than it closes, a
+// comment ends the line
+func braces(line string) int {
+	if i := strings.Index(line, "//"); i >= 0 {
+		line = line[:i]
+	}
+	return strings.Count(line, "{") - strings.Count(line, "}")
+}
+
+// Eval runs src and prints variables it declares and the value of a final
+// expression. Nothing is kept if src fails.
+func (r *REPL) Eval(src string) (err error) {
+	saved := make(map[string]*ast.Object, len(r.scope.Objects))
+	for name, obj := range r.scope.Objects {
+		saved[name] = obj
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+		if err != nil {
+			r.scope.Objects = saved
+		}
+	}()
+
+	parser := Parser{}
+	parser.Init([]byte(src))
+	decls, stmts := parser.ParseInput(r.scope)
+	if len(parser.UnResolved) > 0 {
+		return fmt.Errorf("undefined: %s", parser.UnResolved[0].Name)
+	}
+	for _, s := range stmts {
+		if _, ok := s.(*ast.BadStmt); ok {
+			return fmt.Errorf("syntax error")
+		}
+	}
+
+	// A final expression is returned, its value printed
+	var final *ast.ExprStmt
+	if n := len(stmts); n > 0 {
+		final, _ = stmts[n-1].(*ast.ExprStmt)
+	}
+
+	// Variables become parameters, their declarations assignments
+	vars := append([]*variable{}, r.vars...)
+	var declared []*variable
+	for i, s := range stmts {
+		d, ok := s.(*ast.VarDeclStmt)
+		if !ok {
+			continue
+		}
+		v := &variable{decl: d, typ: ir.TypeOf(d.Type, d.TypeName)}
+		vars = append(vars, v)
+		declared = append(declared, v)
+		if d.RValue != nil {
+			stmts[i] = &ast.ExprStmt{Val: &ast.AssignExpr{Pos: d.Pos, LValue: d.Name, RValue: d.RValue}}
+		} else {
+			stmts[i] = &ast.EmptyStmt{}
+		}
+	}
+
+	all := append(append([]ast.Decl{}, r.decls...), decls...)
+	result := ir.Void
+	var enum *ast.Ident
+	if final != nil {
+		if result, enum = typeOf(final.Val, vars, all); result != ir.Void {
+			stmts[len(stmts)-1] = &ast.ReturnStmt{Value: final.Val}
+		}
+	}
+
+	eval := &ast.FuncDecl{
+		Name:   &ast.Ident{Name: evalFunc},
+		Type:   resultToken(result),
+		Params: &ast.StmtList{},
+		Body:   &ast.CompoundStmt{List: stmts},
+	}
+	args := make([]vm.Value, len(vars))
+	for i, v := range vars {
+		eval.Params.List = append(eval.Params.List, &ast.VarDeclStmt{Type: v.decl.Type, TypeName: v.decl.TypeName, Name: v.decl.Name})
+		args[i] = v.value
+	}
+	foldConstants(append(all, eval))
+	prog, err := vm.Link(vm.Compile(append(all, eval), nil))
+	if err != nil {
+		return err
+	}
+
+	machine := vm.New(prog)
+	value, err := machine.Call(evalFunc, args...)
+	if err != nil {
+		return err
+	}
+	for i, v := range vars {
+		v.value = machine.Locals()[i]
+	}
+	r.decls, r.vars = all, vars
+
+	for _, v := range declared {
+		fmt.Fprintf(r.out, "%s = %s (%s)\n", v.decl.Name.Name, formatValue(v.value, v.typ, v.decl.TypeName), ast.TypeName(v.decl.Type, v.decl.TypeName))
+	}
+	if enum != nil {
+		fmt.Fprintf(r.out, "%s (%s)\n", formatValue(value, result, enum), enum.Name)
+	} else if result != ir.Void {
+		fmt.Fprintf(r.out, "%s (%s)\n", formatValue(value, result, nil), result)
+	}
+	return nil
+}
+
+// formatValue returns v as a literal of typ. A value of enum is printed
+// by the first member having it, as Color_name does.
+func formatValue(v vm.Value, typ ir.Type, enum *ast.Ident) string {
+	if typ == ir.Double {
+		return strconv.FormatFloat(v.Double(), 'g', -1, 64)
+	}
+	value := strconv.Itoa(int(v.Int()))
+	if enum != nil {
+		for _, member := range enum.Obj.Decl.(*ast.EnumDecl).Members {
+			if member.Value.(*ast.BasicLit).Value == value {
+				return member.Name.Name
+			}
+		}
+	}
+	return value
+}
+
+func resultToken(typ ir.Type) token.Type {
+	switch typ {
+	case ir.Int:
+		return token.INT
+	case ir.Double:
+		return token.DOUBLE
+	}
+	return token.VOID
+}
+
+// typeOf returns type of x, an expression at top level of an input, and
+// name of the enum it is of if any
+func typeOf(x ast.Expr, vars []*variable, decls []ast.Decl) (ir.Type, *ast.Ident) {
+	switch e := x.(type) {
+	case *ast.BasicLit:
+		if e.Type == token.DOUBLE_LIT {
+			return ir.Double, nil
+		}
+		return ir.Int, nil
+	case *ast.Ident:
+		for _, v := range vars {
+			if v.decl.Name.Name == e.Name {
+				return v.typ, v.decl.TypeName
+			}
+		}
+		for _, decl := range decls {
+			switch d := decl.(type) {
+			case *ast.ConstDecl:
+				if d.Name.Name == e.Name {
+					return ir.TypeOf(d.Type, nil), nil
+				}
+			case *ast.EnumDecl:
+				for _, member := range d.Members {
+					if member.Name.Name == e.Name {
+						return ir.Int, d.Name
+					}
+				}
+			}
+		}
+		return ir.Int, nil
+	case *ast.UnaryExpr:
+		typ, _ := typeOf(e.RValue, vars, decls)
+		return typ, nil
+	case *ast.BinaryExpr:
+		switch e.Op.Type {
+		case token.EQ, token.NEQ, token.LESS, token.LEQ, token.GRT, token.GEQ:
+			return ir.Int, nil
+		}
+		l, _ := typeOf(e.LValue, vars, decls)
+		r, _ := typeOf(e.RValue, vars, decls)
+		if l == ir.Double || r == ir.Double {
+			return ir.Double, nil
+		}
+		return ir.Int, nil
+	case *ast.CallExpr:
+		name := e.Name.(*ast.Ident).Name
+		for _, decl := range decls {
+			if d, ok := decl.(*ast.FuncDecl); ok && d.Name.Name == name {
+				return ir.TypeOf(d.Type, d.TypeName), d.TypeName
+			}
+		}
+	case *ast.AssignExpr:
+		return typeOf(e.LValue, vars, decls)
+	case *ast.ShortExpr:
+		return typeOf(e.RValue, vars, decls)
+	}
+	return ir.Void, nil
+}
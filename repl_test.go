@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestREPLEval(t *testing.T) {
+	var out bytes.Buffer
+	r := NewREPL(&out)
+	eval := func(src, want string) {
+		out.Reset()
+		assert.Nil(t, r.Eval(src), src)
+		assert.Equal(t, want, out.String(), src)
+	}
+
+	eval("int x = 5", "x = 5 (int)\n")
+	eval("x * 2", "10 (int)\n")
+	eval("double d = x / 2.0", "d = 2.5 (double)\n")
+	eval("x = 7", "7 (int)\n")
+	eval("x++", "7 (int)\n")
+	eval("x", "8 (int)\n")
+	eval("func fib(int n) int {\nif (n < 2) { return n }\nreturn fib(n - 1) + fib(n - 2)\n}", "")
+	eval("fib(x)", "21 (int)\n")
+	eval("const double half = 0.5\nhalf * x", "4 (double)\n")
+	eval("for (int i = 0; i < 3 ; i++) { x = x + i }", "")
+	eval("x", "11 (int)\n")
+	eval("enum Color { RED, GREEN, BLUE = 1 }\nColor c = GREEN", "c = GREEN (Color)\n")
+	eval("func next(Color c) Color { return c + 1 }\nnext(c)", "2 (Color)\n")
+	eval("BLUE", "GREEN (Color)\n")
+	eval("c == BLUE", "1 (int)\n")
+
+	// Nothing is kept from failed input
+	for _, src := range []string{"int y = 1\nz", "x = 1 / 0", "int x = 1", "x +"} {
+		out.Reset()
+		assert.NotNil(t, r.Eval(src), src)
+		assert.Equal(t, "", out.String())
+	}
+	eval("x", "11 (int)\n")
+	assert.NotNil(t, r.Eval("y"))
+
+	// Functions can't use variables, their own shadow them
+	assert.EqualError(t, r.Eval("func f() int { return x }"), "f uses variable x of the REPL, functions can't use them")
+	assert.EqualError(t, r.Eval("f()"), "undefined: f")
+	eval("func g(int x) int { return x + 1 }\ng(x)", "12 (int)\n")
+}
+
+func TestREPLRun(t *testing.T) {
+	var out bytes.Buffer
+	in := strings.Join([]string{
+		"func sq(int n) int {",
+		"  return n * n // {",
+		"}",
+		"sq(4)",
+		"undefined",
+	}, "\n")
+	NewREPL(&out).Run(strings.NewReader(in))
+	assert.Equal(t, ">>> ... ... >>> 16 (int)\n>>> error: undefined: undefined\n>>> \n", out.String())
+}
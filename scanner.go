@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"strings"
 
@@ -25,22 +27,6 @@ func (s *Scanner) peek() (token.Token, int) {
 	return tok, pos
 }
 
-// For comment
-func (s *Scanner) nextLine() (token.Token, int) {
-	text := ""
-	pos := s.srcIndex
-	if pos < 0 {
-		pos = 0
-	}
-
-	ch, err := s.nextCh()
-	for ch != "\n" && err != io.EOF {
-		text += ch
-		ch, err = s.nextCh()
-	}
-	return token.Token{text, token.COMMENT}, pos
-}
-
 func (s *Scanner) next() (token.Token, int) {
 	ch, err := s.skipWhiteSpace()
 
@@ -50,6 +36,9 @@ func (s *Scanner) next() (token.Token, int) {
 		s.fullScaned = true
 		return token.Token{"", token.EOF}, pos
 	}
+	if next, _ := s.PeepCh(); ch == "/" && (next == "/" || next == "*") {
+		return s.comment(), pos
+	}
 
 	text := ""
 	isNum := false
@@ -59,6 +48,8 @@ func (s *Scanner) next() (token.Token, int) {
 			if ch == token.Keywords[token.LPAREN] ||
 				ch == token.Keywords[token.RPAREN] ||
 				ch == token.Keywords[token.COMMA] ||
+				ch == token.Keywords[token.COLON] ||
+				ch == token.Keywords[token.SEMI_COLON] ||
 				ch == token.Keywords[token.PLUS] ||
 				ch == token.Keywords[token.MINUS] ||
 				ch == token.Keywords[token.DIVIDE] ||
@@ -85,6 +76,9 @@ func (s *Scanner) next() (token.Token, int) {
 		}
 	case token.COMMA_LIT:
 		text += ch
+	case token.LBRACE_LIT, token.RBRACE_LIT, token.LPAREN_LIT, token.RPAREN_LIT, token.SEMICOLON_LIT:
+		// Never part of an operator, f(-1)
+		text += ch
 	default: // Operator
 		for ch != " " && ch != "\n" && err != io.EOF {
 			text += ch
@@ -105,6 +99,37 @@ func (s *Scanner) next() (token.Token, int) {
 	return ToToken(text, isNum), pos
 }
 
+// comment scans // to the end of line or /* to its */, those nest
+func (s *Scanner) comment() token.Token {
+	start := s.srcIndex
+	if ch, _ := s.nextCh(); ch == "/" {
+		ch, err := s.nextCh()
+		for ch != "\n" && err != io.EOF {
+			ch, err = s.nextCh()
+		}
+		s.undoCh()
+		return token.Token{string(s.src[start : s.srcIndex+1]), token.COMMENT}
+	}
+
+	for depth := 1; depth > 0; {
+		ch, err := s.nextCh()
+		if err == io.EOF {
+			line := bytes.Count(s.src[:start], []byte("\n")) + 1
+			panic(fmt.Sprintf("Unterminated comment from line %d", line))
+		}
+		next, _ := s.PeepCh()
+		switch {
+		case ch == "/" && next == "*":
+			depth++
+			s.nextCh()
+		case ch == "*" && next == "/":
+			depth--
+			s.nextCh()
+		}
+	}
+	return token.Token{string(s.src[start : s.srcIndex+1]), token.COMMENT}
+}
+
 func (s *Scanner) nextCh() (string, error) {
 	s.srcIndex += 1
 	if s.srcIndex >= len(s.src) {
@@ -47,13 +47,20 @@ func SuiteCase() []*Suite {
 			} else {
 				// comment
 			}
-		`, []token.Type{token.IF, token.LPAREN, token.INT_LIT, token.EQ, token.INT_LIT, token.RPAREN, token.LBRACE, token.COMMENT, token.IDENT, token.RBRACE, token.ELSE, token.LBRACE, token.COMMENT, token.IDENT, token.RBRACE, token.EOF}},
+		`, []token.Type{token.IF, token.LPAREN, token.INT_LIT, token.EQ, token.INT_LIT, token.RPAREN, token.LBRACE, token.COMMENT, token.RBRACE, token.ELSE, token.LBRACE, token.COMMENT, token.RBRACE, token.EOF}},
 		&Suite{`func func3() {
 					for(int i = 0; i < 10; i++) {
 					// Comment
 				}
 			}
-		`, []token.Type{token.FUNC, token.IDENT, token.LPAREN, token.RPAREN, token.LBRACE, token.FOR, token.LPAREN, token.INT, token.IDENT, token.ASSIGN, token.INT_LIT, token.SEMI_COLON, token.IDENT, token.LESS, token.INT_LIT, token.SEMI_COLON, token.IDENT, token.INC, token.RPAREN, token.LBRACE, token.COMMENT, token.IDENT, token.RBRACE, token.RBRACE, token.EOF}},
+		`, []token.Type{token.FUNC, token.IDENT, token.LPAREN, token.RPAREN, token.LBRACE, token.FOR, token.LPAREN, token.INT, token.IDENT, token.ASSIGN, token.INT_LIT, token.SEMI_COLON, token.IDENT, token.LESS, token.INT_LIT, token.SEMI_COLON, token.IDENT, token.INC, token.RPAREN, token.LBRACE, token.COMMENT, token.RBRACE, token.RBRACE, token.EOF}},
+		&Suite{`switch (a) {
+				case 1, RED:
+				default:
+			}
+		`, []token.Type{token.SWITCH, token.LPAREN, token.IDENT, token.RPAREN, token.LBRACE, token.CASE, token.INT_LIT, token.COMMA, token.IDENT, token.COLON, token.DEFAULT, token.COLON, token.RBRACE, token.EOF}},
+		&Suite{"a /* b /* c */ d\n */ - /**/ e //- f\n/***/", []token.Type{token.IDENT, token.COMMENT, token.MINUS, token.COMMENT, token.IDENT, token.COMMENT, token.COMMENT, token.EOF}},
+		&Suite{"for (i; i < n; f(-i)) {}", []token.Type{token.FOR, token.LPAREN, token.IDENT, token.SEMI_COLON, token.IDENT, token.LESS, token.IDENT, token.SEMI_COLON, token.IDENT, token.LPAREN, token.MINUS, token.IDENT, token.RPAREN, token.RPAREN, token.LBRACE, token.RBRACE, token.EOF}},
 	}
 }
 
@@ -66,3 +73,22 @@ func TestScan(t *testing.T) {
 		}
 	}
 }
+
+func TestScanComment(t *testing.T) {
+	src := "x /* a\n/* b */\n*/ y // c\n"
+	scanner := initScanner(src)
+	scanner.next()
+	tok, pos := scanner.next()
+	assert.Equal(t, "/* a\n/* b */\n*/", tok.Val)
+	assert.Equal(t, 2, pos)
+	tok, pos = scanner.next()
+	assert.Equal(t, "y", tok.Val)
+	assert.Equal(t, 18, pos)
+	tok, pos = scanner.next()
+	assert.Equal(t, token.Token{"// c", token.COMMENT}, tok)
+	assert.Equal(t, 20, pos)
+
+	scanner = initScanner("x\n/* a /* b */\n")
+	scanner.next()
+	assert.PanicsWithValue(t, "Unterminated comment from line 2", func() { scanner.next() })
+}
@@ -23,6 +23,12 @@ const (
 	RETURN
 	TRUE
 	FALSE
+	CONST
+	ENUM
+	SWITCH
+	CASE
+	DEFAULT
+	FALLTHROUGH
 
 	LPAREN
 	RPAREN
@@ -32,6 +38,7 @@ const (
 	COMMENT
 	SEMI_COLON
 	COMMA
+	COLON
 
 	PLUS
 	MINUS
@@ -154,15 +161,21 @@ func (t Type) Priority() int {
 }
 
 var Keywords = [...]string{
-	IF:     "if",
-	ELSE:   "else",
-	FOR:    "for",
-	FUNC:   "func",
-	INT:    "int",
-	DOUBLE: "double",
-	RETURN: "return",
-	TRUE:   "true",
-	FALSE:  "false",
+	IF:          "if",
+	ELSE:        "else",
+	FOR:         "for",
+	FUNC:        "func",
+	INT:         "int",
+	DOUBLE:      "double",
+	RETURN:      "return",
+	TRUE:        "true",
+	FALSE:       "false",
+	CONST:       "const",
+	ENUM:        "enum",
+	SWITCH:      "switch",
+	CASE:        "case",
+	DEFAULT:     "default",
+	FALLTHROUGH: "fallthrough",
 
 	LPAREN: "(",
 	RPAREN: ")",
@@ -172,6 +185,7 @@ var Keywords = [...]string{
 	COMMENT:    "//",
 	SEMI_COLON: ";",
 	COMMA:      ",",
+	COLON:      ":",
 
 	PLUS:   "+",
 	MINUS:  "-",
@@ -0,0 +1,296 @@
+// Package vm compiles the AST to bytecode for a stack machine and runs it.
+//
+// Instructions are an opcode byte followed by an operand of two bytes,
+// big endian, for ops that take one. Ints are 32 bits and wrap as in C,
+// doubles 64 bits. Operators are typed, so values carry no type at run
+// time.
+//
+// Each function has its own constant pool. Calls and constants of other
+// files go through references in the pool, which Link resolves by name,
+// so files are compiled to units apart and linked when loaded.
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/rabierre/compiler/ir"
+)
+
+type Op byte
+
+const (
+	NOP Op = iota
+
+	CONST // push pool[a]
+	LOAD  // push local a
+	STORE // pop into local a
+	POP
+	DUP
+
+	IADD
+	ISUB
+	IMUL
+	IDIV
+	INEG
+	IEQ
+	INE
+	ILT
+	ILE
+	IGT
+	IGE
+
+	DADD
+	DSUB
+	DMUL
+	DDIV
+	DNEG
+	DEQ
+	DNE
+	DLT
+	DLE
+	DGT
+	DGE
+
+	I2D
+	D2I
+
+	JMP  // jump to a
+	JT   // pop, jump to a if not zero
+	JF   // pop, jump to a if zero
+	CALL // call function of pool[a], arguments are on the stack
+	RET  // return top of stack
+	RETV // return no value
+
+	numOps
+)
+
+var ops = [...]string{
+	NOP:   "NOP",
+	CONST: "CONST",
+	LOAD:  "LOAD",
+	STORE: "STORE",
+	POP:   "POP",
+	DUP:   "DUP",
+	IADD:  "IADD",
+	ISUB:  "ISUB",
+	IMUL:  "IMUL",
+	IDIV:  "IDIV",
+	INEG:  "INEG",
+	IEQ:   "IEQ",
+	INE:   "INE",
+	ILT:   "ILT",
+	ILE:   "ILE",
+	IGT:   "IGT",
+	IGE:   "IGE",
+	DADD:  "DADD",
+	DSUB:  "DSUB",
+	DMUL:  "DMUL",
+	DDIV:  "DDIV",
+	DNEG:  "DNEG",
+	DEQ:   "DEQ",
+	DNE:   "DNE",
+	DLT:   "DLT",
+	DLE:   "DLE",
+	DGT:   "DGT",
+	DGE:   "DGE",
+	I2D:   "I2D",
+	D2I:   "D2I",
+	JMP:   "JMP",
+	JT:    "JT",
+	JF:    "JF",
+	CALL:  "CALL",
+	RET:   "RET",
+	RETV:  "RETV",
+}
+
+func (op Op) String() string {
+	if op < numOps {
+		return ops[op]
+	}
+	return fmt.Sprintf("Op(%d)", op)
+}
+
+// HasOperand reports whether two operand bytes follow op
+func (op Op) HasOperand() bool {
+	switch op {
+	case CONST, LOAD, STORE, JMP, JT, JF, CALL:
+		return true
+	}
+	return false
+}
+
+// Value is a slot of the stack, an int32 or the bits of a float64
+type Value uint64
+
+func IntValue(i int32) Value      { return Value(uint32(i)) }
+func DoubleValue(f float64) Value { return Value(math.Float64bits(f)) }
+func (v Value) Int() int32        { return int32(v) }
+func (v Value) Double() float64   { return math.Float64frombits(uint64(v)) }
+func boolValue(b bool) Value {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type ConstKind byte
+
+const (
+	IntConst    ConstKind = iota + 1
+	DoubleConst           // Value holds the bits
+	FuncRef               // function Name, of any unit
+	GlobalRef             // constant Name of another unit, Link makes it a number
+)
+
+// Const is an entry of a constant pool
+type Const struct {
+	Kind  ConstKind
+	Value Value
+	Name  string
+}
+
+func (c Const) String() string {
+	switch c.Kind {
+	case IntConst:
+		return fmt.Sprint(c.Value.Int())
+	case DoubleConst:
+		return fmt.Sprint(c.Value.Double())
+	}
+	return c.Name
+}
+
+type Function struct {
+	Name     string
+	Result   ir.Type
+	Params   int // first locals
+	Locals   int // slots of parameters and variables
+	MaxStack int // deepest the operand stack gets
+	Consts   []Const
+	Code     []byte
+
+	calls []*Function // callees of FuncRef entries, set by Link
+}
+
+// Global is a top level constant or enum member other units may use
+type Global struct {
+	Name  string
+	Value Const
+}
+
+// Program is a unit of one file, or units linked together
+type Program struct {
+	Globals []*Global
+	Funcs   []*Function
+
+	linked bool
+}
+
+// Func returns the function named name, nil if there is none
+func (p *Program) Func(name string) *Function {
+	for _, f := range p.Funcs {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Link puts units together and resolves references between them
+func Link(units ...*Program) (*Program, error) {
+	prog := &Program{linked: true}
+	funcs := map[string]*Function{}
+	globals := map[string]Const{}
+	for _, u := range units {
+		for _, g := range u.Globals {
+			if _, exist := globals[g.Name]; exist {
+				return nil, fmt.Errorf("%s redeclared", g.Name)
+			}
+			globals[g.Name] = g.Value
+			prog.Globals = append(prog.Globals, g)
+		}
+		for _, f := range u.Funcs {
+			if funcs[f.Name] != nil {
+				return nil, fmt.Errorf("%s redeclared", f.Name)
+			}
+			funcs[f.Name] = f
+			prog.Funcs = append(prog.Funcs, f)
+		}
+	}
+
+	for _, f := range prog.Funcs {
+		f.calls = make([]*Function, len(f.Consts))
+		for i, c := range f.Consts {
+			switch c.Kind {
+			case FuncRef:
+				if f.calls[i] = funcs[c.Name]; f.calls[i] == nil {
+					return nil, fmt.Errorf("undefined function %s in %s", c.Name, f.Name)
+				}
+			case GlobalRef:
+				g, exist := globals[c.Name]
+				if !exist {
+					return nil, fmt.Errorf("undefined %s in %s", c.Name, f.Name)
+				}
+				f.Consts[i] = g
+			}
+		}
+		if err := check(f); err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+	}
+	return prog, nil
+}
+
+// check checks operands of f are in range, so that code read from a file
+// can't make the VM read outside its pool, locals or code. The code has
+// to end with a return or jump.
+func check(f *Function) error {
+	if f.Params > f.Locals {
+		return fmt.Errorf("%d params but %d locals", f.Params, f.Locals)
+	}
+	start := map[int]bool{}
+	var jumps []int
+	var last Op
+	for pc := 0; pc < len(f.Code); pc++ {
+		start[pc] = true
+		last = Op(f.Code[pc])
+		if last >= numOps {
+			return fmt.Errorf("invalid opcode %d at %d", last, pc)
+		}
+		if !last.HasOperand() {
+			continue
+		}
+		if pc+2 >= len(f.Code) {
+			return fmt.Errorf("%s at %d has no operand", last, pc)
+		}
+		a := int(f.Code[pc+1])<<8 | int(f.Code[pc+2])
+		switch last {
+		case CONST:
+			if a >= len(f.Consts) || f.Consts[a].Kind == FuncRef {
+				return fmt.Errorf("CONST at %d is no constant", pc)
+			}
+		case CALL:
+			if a >= len(f.Consts) || f.Consts[a].Kind != FuncRef {
+				return fmt.Errorf("CALL at %d is no function", pc)
+			}
+		case LOAD, STORE:
+			if a >= f.Locals {
+				return fmt.Errorf("%s at %d of local %d", last, pc, a)
+			}
+		default:
+			jumps = append(jumps, a)
+		}
+		pc += 2
+	}
+	for _, a := range jumps {
+		if !start[a] {
+			return fmt.Errorf("jump to %d", a)
+		}
+	}
+	switch last {
+	case RET, RETV, JMP:
+		return nil
+	}
+	return errors.New("code doesn't end with a return")
+}
@@ -0,0 +1,593 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/rabierre/compiler/ast"
+	"github.com/rabierre/compiler/ir"
+	"github.com/rabierre/compiler/token"
+)
+
+// Compile compiles functions declared in decls, which have to be constant
+// folded already, to a unit. Names used by the file but declared in other
+// files are looked up in globals. Errors are reported by panic as
+// ir.Lower does, with the same typing and conversions.
+func Compile(decls []ast.Decl, globals map[string]ir.Signature) *Program {
+	c := &compiler{
+		globals: globals,
+		funcs:   map[string]*ast.FuncDecl{},
+		consts:  map[string]Const{},
+	}
+
+	prog := &Program{}
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			c.funcs[d.Name.Name] = d
+		case *ast.ConstDecl:
+			c.consts[d.Name.Name] = literal(d.Value.(*ast.BasicLit))
+			prog.Globals = append(prog.Globals, &Global{Name: d.Name.Name, Value: c.consts[d.Name.Name]})
+		case *ast.EnumDecl:
+			for _, member := range d.Members {
+				c.consts[member.Name.Name] = literal(member.Value.(*ast.BasicLit))
+				prog.Globals = append(prog.Globals, &Global{Name: member.Name.Name, Value: c.consts[member.Name.Name]})
+			}
+		}
+	}
+
+	for _, decl := range decls {
+		if d, ok := decl.(*ast.FuncDecl); ok {
+			prog.Funcs = append(prog.Funcs, c.function(d))
+		}
+	}
+	return prog
+}
+
+type local struct {
+	slot int
+	typ  ir.Type
+}
+
+type compiler struct {
+	globals map[string]ir.Signature
+	funcs   map[string]*ast.FuncDecl
+	consts  map[string]Const // top level constants and enum members
+
+	fn     *Function
+	pool   map[Const]int
+	scopes []map[string]local
+	depth  int // of the operand stack
+}
+
+func (c *compiler) function(d *ast.FuncDecl) *Function {
+	c.fn = &Function{Name: d.Name.Name, Result: ir.TypeOf(d.Type, d.TypeName)}
+	c.pool = map[Const]int{}
+	c.depth = 0
+	c.openScope()
+
+	for _, param := range d.Params.List {
+		p := param.(*ast.VarDeclStmt)
+		c.declare(p.Name.Name, ir.TypeOf(p.Type, p.TypeName))
+	}
+	c.fn.Params = c.fn.Locals
+
+	c.stmtList(d.Body.List)
+	// Falling off the end returns zero value
+	if _, ok := last(d.Body.List).(*ast.ReturnStmt); !ok {
+		if c.fn.Result == ir.Void {
+			c.emit(RETV)
+		} else {
+			c.constant(zeroConst(c.fn.Result))
+			c.emit(RET)
+		}
+	}
+
+	c.closeScope()
+	return c.fn
+}
+
+func (c *compiler) openScope() {
+	c.scopes = append(c.scopes, map[string]local{})
+}
+
+func (c *compiler) closeScope() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+// declare gives a variable a new slot. Slots are not reused when a scope
+// closes, a function has one for each declaration.
+func (c *compiler) declare(name string, typ ir.Type) local {
+	v := local{slot: c.fn.Locals, typ: typ}
+	if v.slot > 0xffff {
+		panic("Too many locals in " + c.fn.Name)
+	}
+	c.fn.Locals++
+	c.scopes[len(c.scopes)-1][name] = v
+	return v
+}
+
+func (c *compiler) lookup(name string) (local, bool) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if v, exist := c.scopes[i][name]; exist {
+			return v, true
+		}
+	}
+	return local{}, false
+}
+
+//--------------------------------------------------------------------------------------
+// Code
+//
+
+// Stack effect of ops but CALL
+var effect = [numOps]int{
+	CONST: 1, LOAD: 1, STORE: -1, POP: -1, DUP: 1,
+	IADD: -1, ISUB: -1, IMUL: -1, IDIV: -1, IEQ: -1, INE: -1, ILT: -1, ILE: -1, IGT: -1, IGE: -1,
+	DADD: -1, DSUB: -1, DMUL: -1, DDIV: -1, DEQ: -1, DNE: -1, DLT: -1, DLE: -1, DGT: -1, DGE: -1,
+	JT: -1, JF: -1, RET: -1,
+}
+
+func (c *compiler) emit(op Op) {
+	c.fn.Code = append(c.fn.Code, byte(op))
+	c.grow(effect[op])
+}
+
+// emitArg emits op with operand a, returns where the operand is
+func (c *compiler) emitArg(op Op, a int) int {
+	if a > 0xffff {
+		panic(fmt.Sprintf("Operand of %s out of range in %s: %d", op, c.fn.Name, a))
+	}
+	c.fn.Code = append(c.fn.Code, byte(op), byte(a>>8), byte(a))
+	c.grow(effect[op])
+	return len(c.fn.Code) - 2
+}
+
+func (c *compiler) grow(n int) {
+	c.depth += n
+	if c.depth > c.fn.MaxStack {
+		c.fn.MaxStack = c.depth
+	}
+}
+
+// jump emits a jump to be patched, returns where its target goes
+func (c *compiler) jump(op Op) int {
+	return c.emitArg(op, 0)
+}
+
+// patch makes the jump at at go to the current end of code
+func (c *compiler) patch(at int) {
+	c.patchTo(at, len(c.fn.Code))
+}
+
+func (c *compiler) patchTo(at, target int) {
+	if target > 0xffff {
+		panic("Function too long: " + c.fn.Name)
+	}
+	c.fn.Code[at], c.fn.Code[at+1] = byte(target>>8), byte(target)
+}
+
+// constant pushes k, entries of the pool are shared
+func (c *compiler) constant(k Const) {
+	c.emitArg(CONST, c.poolIndex(k))
+}
+
+func (c *compiler) poolIndex(k Const) int {
+	i, exist := c.pool[k]
+	if !exist {
+		i = len(c.fn.Consts)
+		c.pool[k] = i
+		c.fn.Consts = append(c.fn.Consts, k)
+	}
+	return i
+}
+
+//--------------------------------------------------------------------------------------
+// Statement
+//
+
+func (c *compiler) stmtList(list []ast.Stmt) {
+	for _, s := range list {
+		c.stmt(s)
+	}
+}
+
+func (c *compiler) stmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.VarDeclStmt:
+		// Slots start zero, a variable without value is still set
+		// as a loop may run the declaration again
+		typ := ir.TypeOf(s.Type, s.TypeName)
+		if s.RValue != nil {
+			c.convert(c.expr(s.RValue), typ)
+		} else {
+			c.constant(zeroConst(typ))
+		}
+		c.emitArg(STORE, c.declare(s.Name.Name, typ).slot)
+	case *ast.ExprStmt:
+		if typ := c.expr(s.Val); typ != ir.Void {
+			c.emit(POP)
+		}
+	case *ast.ReturnStmt:
+		c.returnStmt(s)
+	case *ast.CompoundStmt:
+		c.openScope()
+		c.stmtList(s.List)
+		c.closeScope()
+	case *ast.IfStmt:
+		c.ifStmt(s)
+	case *ast.ForStmt:
+		c.forStmt(s)
+	case *ast.SwitchStmt:
+		c.switchStmt(s)
+	case *ast.ConstDecl:
+		// uses are folded already
+	}
+}
+
+func (c *compiler) returnStmt(s *ast.ReturnStmt) {
+	switch {
+	case c.fn.Result == ir.Void && s.Value != nil:
+		panic("Too many return values in " + c.fn.Name)
+	case c.fn.Result != ir.Void && s.Value == nil:
+		panic("Not enough return values in " + c.fn.Name)
+	case s.Value != nil:
+		c.convert(c.expr(s.Value), c.fn.Result)
+		c.emit(RET)
+	default:
+		c.emit(RETV)
+	}
+}
+
+func (c *compiler) ifStmt(s *ast.IfStmt) {
+	c.cond(s.Cond)
+	els := c.jump(JF)
+	c.stmt(s.Body)
+	if s.ElseBody == nil {
+		c.patch(els)
+		return
+	}
+	done := c.jump(JMP)
+	c.patch(els)
+	c.stmt(s.ElseBody)
+	c.patch(done)
+}
+
+func (c *compiler) forStmt(s *ast.ForStmt) {
+	c.openScope()
+	if s.Init != nil {
+		c.stmt(s.Init)
+	}
+
+	head := len(c.fn.Code)
+	done := -1
+	if s.Cond != nil {
+		c.cond(s.Cond)
+		done = c.jump(JF)
+	}
+	c.stmt(s.Body)
+	if s.Post != nil {
+		if typ := c.expr(s.Post); typ != ir.Void {
+			c.emit(POP)
+		}
+	}
+	c.patchTo(c.jump(JMP), head)
+	if done >= 0 {
+		c.patch(done)
+	}
+	c.closeScope()
+}
+
+// switchStmt keeps the tag in a slot of its own and tests cases in order,
+// then jumps to default if any. Bodies follow each other, so falling
+// through is not jumping to the end.
+func (c *compiler) switchStmt(s *ast.SwitchStmt) {
+	typ := c.expr(s.Tag)
+	tag := c.declare("", typ) // no ident is empty
+	c.emitArg(STORE, tag.slot)
+
+	tests := make([][]int, len(s.Body))
+	dflt := -1
+	for i, clause := range s.Body {
+		if clause.List == nil {
+			dflt = i
+		}
+		for _, x := range clause.List {
+			c.emitArg(LOAD, tag.slot)
+			c.convert(c.expr(x), typ)
+			if typ == ir.Double {
+				c.emit(DEQ)
+			} else {
+				c.emit(IEQ)
+			}
+			tests[i] = append(tests[i], c.jump(JT))
+		}
+	}
+	toDefault := c.jump(JMP)
+
+	var ends []int
+	for i, clause := range s.Body {
+		for _, at := range tests[i] {
+			c.patch(at)
+		}
+		if i == dflt {
+			c.patch(toDefault)
+		}
+		c.openScope()
+		c.stmtList(clause.Body)
+		c.closeScope()
+
+		if _, ok := last(clause.Body).(*ast.FallthroughStmt); !ok {
+			ends = append(ends, c.jump(JMP))
+		}
+	}
+
+	if dflt < 0 {
+		c.patch(toDefault)
+	}
+	for _, at := range ends {
+		c.patch(at)
+	}
+}
+
+func last(list []ast.Stmt) ast.Stmt {
+	if len(list) == 0 {
+		return nil
+	}
+	return list[len(list)-1]
+}
+
+//--------------------------------------------------------------------------------------
+// Expression
+//
+
+// cond pushes int value of x to jump on
+func (c *compiler) cond(x ast.Expr) {
+	if c.expr(x) == ir.Double {
+		c.constant(doubleConst(0))
+		c.emit(DNE)
+	}
+}
+
+// expr pushes value of x, returns its type. Void calls push nothing.
+func (c *compiler) expr(x ast.Expr) ir.Type {
+	switch e := x.(type) {
+	case *ast.BasicLit:
+		k := literal(e)
+		c.constant(k)
+		return constType(k)
+	case *ast.Ident:
+		return c.ident(e)
+	case *ast.UnaryExpr:
+		typ := c.expr(e.RValue)
+		if e.Op.Type == token.PLUS {
+			return typ
+		}
+		if typ == ir.Double {
+			c.emit(DNEG)
+		} else {
+			c.emit(INEG)
+		}
+		return typ
+	case *ast.BinaryExpr:
+		return c.binaryExpr(e)
+	case *ast.CallExpr:
+		return c.call(e)
+	case *ast.AssignExpr:
+		v := c.variable(e.LValue)
+		c.convert(c.expr(e.RValue), v.typ)
+		c.emit(DUP)
+		c.emitArg(STORE, v.slot)
+		return v.typ
+	case *ast.ShortExpr:
+		// Value is the one before increment
+		v := c.variable(e.RValue)
+		c.emitArg(LOAD, v.slot)
+		c.emit(DUP)
+		if v.typ == ir.Double {
+			c.constant(doubleConst(1))
+		} else {
+			c.constant(intConst(1))
+		}
+		switch {
+		case v.typ == ir.Double && e.Op.Type == token.DEC:
+			c.emit(DSUB)
+		case v.typ == ir.Double:
+			c.emit(DADD)
+		case e.Op.Type == token.DEC:
+			c.emit(ISUB)
+		default:
+			c.emit(IADD)
+		}
+		c.emitArg(STORE, v.slot)
+		return v.typ
+	}
+	panic(fmt.Sprintf("Invalid expression: %#v", x))
+}
+
+func (c *compiler) ident(e *ast.Ident) ir.Type {
+	if v, exist := c.lookup(e.Name); exist {
+		c.emitArg(LOAD, v.slot)
+		return v.typ
+	}
+	if k, exist := c.consts[e.Name]; exist {
+		c.constant(k)
+		return constType(k)
+	}
+	if sig, exist := c.globals[e.Name]; exist && !sig.Func {
+		c.constant(Const{Kind: GlobalRef, Name: e.Name})
+		return sig.Result
+	}
+	panic("Undefined: " + e.Name)
+}
+
+// variable returns the variable x assigns to
+func (c *compiler) variable(x ast.Expr) local {
+	if id, ok := x.(*ast.Ident); ok {
+		if v, exist := c.lookup(id.Name); exist {
+			return v
+		}
+	}
+	panic(fmt.Sprintf("Cannot assign to %#v", x))
+}
+
+// Int and double opcodes of operators
+var binOps = map[token.Type][2]Op{
+	token.PLUS:   {IADD, DADD},
+	token.MINUS:  {ISUB, DSUB},
+	token.MULTI:  {IMUL, DMUL},
+	token.DIVIDE: {IDIV, DDIV},
+	token.EQ:     {IEQ, DEQ},
+	token.NEQ:    {INE, DNE},
+	token.LESS:   {ILT, DLT},
+	token.LEQ:    {ILE, DLE},
+	token.GRT:    {IGT, DGT},
+	token.GEQ:    {IGE, DGE},
+}
+
+// Operands are converted to double if either is. The left one is
+// converted before the right one is pushed, so its type is found first.
+func (c *compiler) binaryExpr(e *ast.BinaryExpr) ir.Type {
+	op, ok := binOps[e.Op.Type]
+	if !ok {
+		panic("Invalid operator: " + e.Op.Type.String())
+	}
+
+	typ := ir.Int
+	if c.typeOf(e.LValue) == ir.Double || c.typeOf(e.RValue) == ir.Double {
+		typ = ir.Double
+	}
+	c.convert(c.expr(e.LValue), typ)
+	c.convert(c.expr(e.RValue), typ)
+
+	if typ == ir.Double {
+		c.emit(op[1])
+	} else {
+		c.emit(op[0])
+	}
+	if op[0] >= IEQ && op[0] <= IGE {
+		return ir.Int
+	}
+	return typ
+}
+
+// typeOf returns type of x without compiling it
+func (c *compiler) typeOf(x ast.Expr) ir.Type {
+	switch e := x.(type) {
+	case *ast.BasicLit:
+		return constType(literal(e))
+	case *ast.Ident:
+		if v, exist := c.lookup(e.Name); exist {
+			return v.typ
+		}
+		if k, exist := c.consts[e.Name]; exist {
+			return constType(k)
+		}
+		if sig, exist := c.globals[e.Name]; exist && !sig.Func {
+			return sig.Result
+		}
+		panic("Undefined: " + e.Name)
+	case *ast.UnaryExpr:
+		return c.typeOf(e.RValue)
+	case *ast.BinaryExpr:
+		switch e.Op.Type {
+		case token.EQ, token.NEQ, token.LESS, token.LEQ, token.GRT, token.GEQ:
+			return ir.Int
+		}
+		if c.typeOf(e.LValue) == ir.Double || c.typeOf(e.RValue) == ir.Double {
+			return ir.Double
+		}
+		return ir.Int
+	case *ast.CallExpr:
+		return c.signature(e.Name.(*ast.Ident).Name).Result
+	case *ast.AssignExpr:
+		return c.variable(e.LValue).typ
+	case *ast.ShortExpr:
+		return c.variable(e.RValue).typ
+	}
+	panic(fmt.Sprintf("Invalid expression: %#v", x))
+}
+
+// convert converts the value on top of the stack, of type from, to typ
+func (c *compiler) convert(from, typ ir.Type) {
+	switch {
+	case from == typ:
+	case from == ir.Void:
+		panic("Void value used as " + typ.String())
+	case typ == ir.Double:
+		c.emit(I2D)
+	default:
+		c.emit(D2I)
+	}
+}
+
+func (c *compiler) call(e *ast.CallExpr) ir.Type {
+	name := e.Name.(*ast.Ident).Name
+	sig := c.signature(name)
+
+	if len(e.Params.List) != len(sig.Params) {
+		panic(fmt.Sprintf("Wrong number of arguments to %s: %d, want %d", name, len(e.Params.List), len(sig.Params)))
+	}
+	for i, param := range e.Params.List {
+		c.convert(c.expr(param), sig.Params[i])
+	}
+
+	c.emitArg(CALL, c.poolIndex(Const{Kind: FuncRef, Name: name}))
+	c.grow(-len(sig.Params))
+	if sig.Result != ir.Void {
+		c.grow(1)
+	}
+	return sig.Result
+}
+
+func (c *compiler) signature(name string) ir.Signature {
+	if d, exist := c.funcs[name]; exist {
+		sig := ir.Signature{Func: true, Result: ir.TypeOf(d.Type, d.TypeName)}
+		for _, param := range d.Params.List {
+			p := param.(*ast.VarDeclStmt)
+			sig.Params = append(sig.Params, ir.TypeOf(p.Type, p.TypeName))
+		}
+		return sig
+	}
+	if sig, exist := c.globals[name]; exist && sig.Func {
+		return sig
+	}
+	panic("Undefined function: " + name)
+}
+
+func literal(e *ast.BasicLit) Const {
+	switch e.Type {
+	case token.INT_LIT:
+		i, err := strconv.ParseInt(e.Value, 10, 64)
+		if err != nil {
+			panic("Invalid int literal: " + e.Value)
+		}
+		return intConst(int32(i))
+	case token.DOUBLE_LIT:
+		f, err := strconv.ParseFloat(e.Value, 64)
+		if err != nil {
+			panic("Invalid double literal: " + e.Value)
+		}
+		return doubleConst(f)
+	case token.TRUE:
+		return intConst(1)
+	}
+	return intConst(0) // token.FALSE
+}
+
+func intConst(i int32) Const      { return Const{Kind: IntConst, Value: IntValue(i)} }
+func doubleConst(f float64) Const { return Const{Kind: DoubleConst, Value: DoubleValue(f)} }
+
+func zeroConst(typ ir.Type) Const {
+	if typ == ir.Double {
+		return doubleConst(0)
+	}
+	return intConst(0)
+}
+
+func constType(k Const) ir.Type {
+	if k.Kind == DoubleConst {
+		return ir.Double
+	}
+	return ir.Int
+}
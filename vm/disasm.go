@@ -0,0 +1,42 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Disassemble lists constants of prog and instructions of its functions,
+// one per line at their offset:
+//
+//	func f(1) int locals 2 stack 3
+//	  0000  LOAD 0
+//	  0003  CONST 1   ; 2.5
+func Disassemble(prog *Program) string {
+	var buf bytes.Buffer
+	for _, g := range prog.Globals {
+		fmt.Fprintf(&buf, "const %s = %s\n", g.Name, g.Value)
+	}
+	for _, f := range prog.Funcs {
+		fmt.Fprintf(&buf, "func %s(%d) %s locals %d stack %d\n", f.Name, f.Params, f.Result, f.Locals, f.MaxStack)
+		for pc := 0; pc < len(f.Code); {
+			op := Op(f.Code[pc])
+			if !op.HasOperand() {
+				fmt.Fprintf(&buf, "  %04d  %s\n", pc, op)
+				pc++
+				continue
+			}
+			if pc+2 >= len(f.Code) {
+				fmt.Fprintf(&buf, "  %04d  %s ?\n", pc, op)
+				break
+			}
+			a := int(f.Code[pc+1])<<8 | int(f.Code[pc+2])
+			if (op == CONST || op == CALL) && a < len(f.Consts) {
+				fmt.Fprintf(&buf, "  %04d  %-9s ; %s\n", pc, fmt.Sprint(op, " ", a), f.Consts[a])
+			} else {
+				fmt.Fprintf(&buf, "  %04d  %s %d\n", pc, op, a)
+			}
+			pc += 3
+		}
+	}
+	return buf.String()
+}
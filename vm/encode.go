@@ -0,0 +1,179 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rabierre/compiler/ir"
+)
+
+// A file is units one after another, each:
+//
+//	magic "MCVM", version byte
+//	globals: count, then name and constant each
+//	functions: count, then name, result, params, locals, max stack,
+//	pool count and constants, code length and code
+//
+// Numbers are unsigned varints, strings a length and bytes, constants a
+// kind byte then a name for references or 8 bytes, little endian, of
+// the value.
+const (
+	magic         = "MCVM"
+	formatVersion = 1
+)
+
+// Encode returns prog as a unit of a file
+func Encode(prog *Program) []byte {
+	e := &encoder{}
+	e.buf.WriteString(magic)
+	e.buf.WriteByte(formatVersion)
+	e.uint(len(prog.Globals))
+	for _, g := range prog.Globals {
+		e.string(g.Name)
+		e.constant(g.Value)
+	}
+	e.uint(len(prog.Funcs))
+	for _, f := range prog.Funcs {
+		e.string(f.Name)
+		e.buf.WriteByte(byte(f.Result))
+		e.uint(f.Params)
+		e.uint(f.Locals)
+		e.uint(f.MaxStack)
+		e.uint(len(f.Consts))
+		for _, c := range f.Consts {
+			e.constant(c)
+		}
+		e.uint(len(f.Code))
+		e.buf.Write(f.Code)
+	}
+	return e.buf.Bytes()
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uint(n int) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutUvarint(b[:], uint64(n))])
+}
+
+func (e *encoder) string(s string) {
+	e.uint(len(s))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) constant(c Const) {
+	e.buf.WriteByte(byte(c.Kind))
+	switch c.Kind {
+	case FuncRef, GlobalRef:
+		e.string(c.Name)
+	default:
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(c.Value))
+		e.buf.Write(b[:])
+	}
+}
+
+// Decode reads the units of a file and links them
+func Decode(data []byte) (*Program, error) {
+	d := &decoder{r: bufio.NewReader(bytes.NewReader(data))}
+	var units []*Program
+	for {
+		if _, err := d.r.Peek(1); err == io.EOF {
+			break
+		}
+		unit, err := d.unit()
+		if err != nil {
+			return nil, fmt.Errorf("unit %d: %v", len(units), err)
+		}
+		units = append(units, unit)
+	}
+	if len(units) == 0 {
+		return nil, errors.New("no units")
+	}
+	return Link(units...)
+}
+
+var errFormat = errors.New("invalid format")
+
+type decoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (d *decoder) unit() (*Program, error) {
+	head := d.bytes(len(magic) + 1)
+	if d.err == nil && string(head[:len(magic)]) != magic {
+		return nil, errors.New("not a bytecode file")
+	}
+	if d.err == nil && head[len(magic)] != formatVersion {
+		return nil, fmt.Errorf("format version %d, want %d", head[len(magic)], formatVersion)
+	}
+
+	prog := &Program{}
+	for n := d.uint(); n > 0 && d.err == nil; n-- {
+		prog.Globals = append(prog.Globals, &Global{Name: d.string(), Value: d.constant()})
+	}
+	for n := d.uint(); n > 0 && d.err == nil; n-- {
+		f := &Function{Name: d.string()}
+		f.Result = ir.Type(d.bytes(1)[0])
+		f.Params, f.Locals, f.MaxStack = d.uint(), d.uint(), d.uint()
+		for k := d.uint(); k > 0 && d.err == nil; k-- {
+			f.Consts = append(f.Consts, d.constant())
+		}
+		f.Code = d.bytes(d.uint())
+		prog.Funcs = append(prog.Funcs, f)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return prog, nil
+}
+
+// Reads after an error return zero values, the error is kept
+func (d *decoder) uint() int {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil || n > 1<<24 {
+		d.err = errFormat
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes(n int) []byte {
+	b := make([]byte, n)
+	if d.err != nil {
+		return b
+	}
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.err = errFormat
+	}
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(d.uint()))
+}
+
+func (d *decoder) constant() Const {
+	c := Const{Kind: ConstKind(d.bytes(1)[0])}
+	switch c.Kind {
+	case IntConst, DoubleConst:
+		c.Value = Value(binary.LittleEndian.Uint64(d.bytes(8)))
+	case FuncRef, GlobalRef:
+		c.Name = d.string()
+	default:
+		if d.err == nil {
+			d.err = errFormat
+		}
+	}
+	return c
+}
@@ -0,0 +1,249 @@
+package vm
+
+import (
+	"fmt"
+)
+
+// MaxFrames limits the depth of calls
+const MaxFrames = 1 << 16
+
+// RuntimeError is an error of a running program, at offset PC of Func
+type RuntimeError struct {
+	Func string
+	PC   int
+	Msg  string
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("%s+%d: %s", e.Func, e.PC, e.Msg)
+}
+
+type frame struct {
+	fn   *Function
+	pc   int
+	base int // of locals, the operand stack is above them
+}
+
+// VM runs functions of a linked program
+type VM struct {
+	prog   *Program
+	stack  []Value
+	frames []frame
+	locals []Value // of the function Call returned from
+}
+
+func New(prog *Program) *VM {
+	if !prog.linked {
+		panic("vm: program is not linked")
+	}
+	return &VM{prog: prog}
+}
+
+// Call runs function name with args and returns its result, zero if it
+// returns none
+func (m *VM) Call(name string, args ...Value) (result Value, err error) {
+	fn := m.prog.Func(name)
+	if fn == nil {
+		return 0, fmt.Errorf("undefined function %s", name)
+	}
+	if len(args) != fn.Params {
+		return 0, fmt.Errorf("wrong number of arguments to %s: %d, want %d", name, len(args), fn.Params)
+	}
+
+	m.stack = append(m.stack[:0], args...)
+	m.frames = m.frames[:0]
+	defer func() {
+		if r := recover(); r != nil {
+			// Link checks code, but not that it keeps within MaxStack
+			if e, ok := r.(*RuntimeError); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("invalid code: %v", r)
+			}
+		}
+	}()
+	return m.run(fn), nil
+}
+
+// Locals returns values parameters and variables of the function the
+// last Call returned from had at its return, by slot. The next Call
+// reuses the slice.
+func (m *VM) Locals() []Value {
+	return m.locals
+}
+
+// enter makes a frame for fn over its arguments on top of the stack
+func (m *VM) enter(fn *Function, sp int) (base, top int) {
+	base = sp - fn.Params
+	top = base + fn.Locals
+	if need := top + fn.MaxStack; need > len(m.stack) {
+		stack := make([]Value, 2*need)
+		copy(stack, m.stack[:sp])
+		m.stack = stack
+	}
+	for i := sp; i < top; i++ {
+		m.stack[i] = 0
+	}
+	return base, top
+}
+
+// run is the dispatch loop. The frame of the running function is kept in
+// locals, frames of callers are pushed on call.
+func (m *VM) run(fn *Function) Value {
+	base, sp := m.enter(fn, len(m.stack))
+	stack := m.stack
+	code, consts := fn.Code, fn.Consts
+	pc, at := 0, 0
+
+	fail := func(msg string) {
+		panic(&RuntimeError{Func: fn.Name, PC: at, Msg: msg})
+	}
+
+	for {
+		at = pc
+		op := Op(code[pc])
+		pc++
+		var a int
+		if op.HasOperand() {
+			a = int(code[pc])<<8 | int(code[pc+1])
+			pc += 2
+		}
+
+		switch op {
+		case NOP:
+		case CONST:
+			stack[sp] = consts[a].Value
+			sp++
+		case LOAD:
+			stack[sp] = stack[base+a]
+			sp++
+		case STORE:
+			sp--
+			stack[base+a] = stack[sp]
+		case POP:
+			sp--
+		case DUP:
+			stack[sp] = stack[sp-1]
+			sp++
+
+		case IADD:
+			sp--
+			stack[sp-1] = IntValue(stack[sp-1].Int() + stack[sp].Int())
+		case ISUB:
+			sp--
+			stack[sp-1] = IntValue(stack[sp-1].Int() - stack[sp].Int())
+		case IMUL:
+			sp--
+			stack[sp-1] = IntValue(stack[sp-1].Int() * stack[sp].Int())
+		case IDIV:
+			sp--
+			if stack[sp].Int() == 0 {
+				fail("integer division by zero")
+			}
+			stack[sp-1] = IntValue(stack[sp-1].Int() / stack[sp].Int())
+		case INEG:
+			stack[sp-1] = IntValue(-stack[sp-1].Int())
+		case IEQ:
+			sp--
+			stack[sp-1] = boolValue(stack[sp-1].Int() == stack[sp].Int())
+		case INE:
+			sp--
+			stack[sp-1] = boolValue(stack[sp-1].Int() != stack[sp].Int())
+		case ILT:
+			sp--
+			stack[sp-1] = boolValue(stack[sp-1].Int() < stack[sp].Int())
+		case ILE:
+			sp--
+			stack[sp-1] = boolValue(stack[sp-1].Int() <= stack[sp].Int())
+		case IGT:
+			sp--
+			stack[sp-1] = boolValue(stack[sp-1].Int() > stack[sp].Int())
+		case IGE:
+			sp--
+			stack[sp-1] = boolValue(stack[sp-1].Int() >= stack[sp].Int())
+
+		case DADD:
+			sp--
+			stack[sp-1] = DoubleValue(stack[sp-1].Double() + stack[sp].Double())
+		case DSUB:
+			sp--
+			stack[sp-1] = DoubleValue(stack[sp-1].Double() - stack[sp].Double())
+		case DMUL:
+			sp--
+			stack[sp-1] = DoubleValue(stack[sp-1].Double() * stack[sp].Double())
+		case DDIV:
+			sp--
+			stack[sp-1] = DoubleValue(stack[sp-1].Double() / stack[sp].Double())
+		case DNEG:
+			stack[sp-1] = DoubleValue(-stack[sp-1].Double())
+		case DEQ:
+			sp--
+			stack[sp-1] = boolValue(stack[sp-1].Double() == stack[sp].Double())
+		case DNE:
+			sp--
+			stack[sp-1] = boolValue(stack[sp-1].Double() != stack[sp].Double())
+		case DLT:
+			sp--
+			stack[sp-1] = boolValue(stack[sp-1].Double() < stack[sp].Double())
+		case DLE:
+			sp--
+			stack[sp-1] = boolValue(stack[sp-1].Double() <= stack[sp].Double())
+		case DGT:
+			sp--
+			stack[sp-1] = boolValue(stack[sp-1].Double() > stack[sp].Double())
+		case DGE:
+			sp--
+			stack[sp-1] = boolValue(stack[sp-1].Double() >= stack[sp].Double())
+
+		case I2D:
+			stack[sp-1] = DoubleValue(float64(stack[sp-1].Int()))
+		case D2I:
+			stack[sp-1] = IntValue(int32(stack[sp-1].Double()))
+
+		case JMP:
+			pc = a
+		case JT:
+			sp--
+			if stack[sp] != 0 {
+				pc = a
+			}
+		case JF:
+			sp--
+			if stack[sp] == 0 {
+				pc = a
+			}
+
+		case CALL:
+			if len(m.frames) == MaxFrames {
+				fail("stack overflow")
+			}
+			m.frames = append(m.frames, frame{fn: fn, pc: pc, base: base})
+			fn = fn.calls[a]
+			base, sp = m.enter(fn, sp)
+			stack = m.stack
+			code, consts, pc = fn.Code, fn.Consts, 0
+
+		case RET, RETV:
+			var result Value
+			if op == RET {
+				result = stack[sp-1]
+			}
+			if len(m.frames) == 0 {
+				m.locals = append(m.locals[:0], stack[base:base+fn.Locals]...)
+				return result
+			}
+			sp = base
+			caller := m.frames[len(m.frames)-1]
+			m.frames = m.frames[:len(m.frames)-1]
+			if op == RET {
+				stack[sp] = result
+				sp++
+			}
+			fn, pc, base = caller.fn, caller.pc, caller.base
+			code, consts = fn.Code, fn.Consts
+
+		default:
+			fail(fmt.Sprintf("invalid opcode %d", op))
+		}
+	}
+}
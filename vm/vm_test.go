@@ -0,0 +1,72 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/rabierre/compiler/ir"
+	"github.com/stretchr/testify/assert"
+)
+
+// half(int n) double { return n / 2.0 } with the divisor from another unit
+func half() *Program {
+	return &Program{Funcs: []*Function{{
+		Name: "half", Result: ir.Double, Params: 1, Locals: 1, MaxStack: 2,
+		Consts: []Const{{Kind: GlobalRef, Name: "TWO"}},
+		Code:   []byte{byte(LOAD), 0, 0, byte(I2D), byte(CONST), 0, 0, byte(DDIV), byte(RET)},
+	}}}
+}
+
+// loop(int n) int { return loop(n) }
+func loop() *Program {
+	return &Program{Funcs: []*Function{{
+		Name: "loop", Result: ir.Int, Params: 1, Locals: 1, MaxStack: 1,
+		Consts: []Const{{Kind: FuncRef, Name: "loop"}},
+		Code:   []byte{byte(LOAD), 0, 0, byte(CALL), 0, 0, byte(RET)},
+	}}}
+}
+
+func TestEncode(t *testing.T) {
+	two := &Program{Globals: []*Global{{Name: "TWO", Value: doubleConst(2)}}}
+	data := append(Encode(half()), Encode(two)...)
+
+	prog, err := Decode(data)
+	assert.Nil(t, err)
+	assert.Equal(t, "const TWO = 2\n"+
+		"func half(1) double locals 1 stack 2\n"+
+		"  0000  LOAD 0\n"+
+		"  0003  I2D\n"+
+		"  0004  CONST 0   ; 2\n"+
+		"  0007  DDIV\n"+
+		"  0008  RET\n", Disassemble(prog))
+
+	result, err := New(prog).Call("half", IntValue(5))
+	assert.Nil(t, err)
+	assert.Equal(t, 2.5, result.Double())
+
+	_, err = Decode(data[:len(data)-3])
+	assert.EqualError(t, err, "unit 1: invalid format")
+	_, err = Decode([]byte("MCVM\x07"))
+	assert.EqualError(t, err, "unit 0: format version 7, want 1")
+}
+
+func TestLink(t *testing.T) {
+	_, err := Link(half())
+	assert.EqualError(t, err, "undefined TWO in half")
+
+	bad := loop()
+	bad.Funcs[0].Code[2] = 1
+	_, err = Link(bad)
+	assert.EqualError(t, err, "loop: LOAD at 0 of local 1")
+
+	bad = loop()
+	bad.Funcs[0].Code = bad.Funcs[0].Code[:6]
+	_, err = Link(bad)
+	assert.EqualError(t, err, "loop: code doesn't end with a return")
+}
+
+func TestStackOverflow(t *testing.T) {
+	prog, err := Link(loop())
+	assert.Nil(t, err)
+	_, err = New(prog).Call("loop", IntValue(1))
+	assert.EqualError(t, err, "loop+3: stack overflow")
+}
@@ -0,0 +1,316 @@
+// Package wasm emits WebAssembly text format from the IR. Ints are i32,
+// doubles f64 and every function is exported under its own name.
+//
+// Blocks are turned back into block, loop and if with the algorithm of
+// Ramsey, "Beyond Relooper" (2022). It needs a reducible control flow
+// graph, which lowering of for, if and switch always gives.
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rabierre/compiler/ir"
+)
+
+// Emit returns module fields of constants and functions of prog. The
+// caller wraps fields of every file in one module, so calls between
+// files need no imports.
+func Emit(prog *ir.Program) string {
+	var buf bytes.Buffer
+	for _, c := range prog.Consts {
+		fmt.Fprintf(&buf, "  (global $%s %s (%s))\n", c.Name, wasmType(c.Value.Typ), constant(c.Value))
+	}
+	for _, f := range prog.Funcs {
+		g := &gen{buf: &buf, prog: prog, f: f}
+		g.function()
+	}
+	return buf.String()
+}
+
+// Module wraps fields returned by Emit in a module
+func Module(fields string) string {
+	return "(module\n" + fields + ")\n"
+}
+
+type gen struct {
+	buf  *bytes.Buffer
+	prog *ir.Program
+	f    *ir.Func
+
+	dom   *ir.DomTree
+	rpo   map[*ir.Block]int
+	merge map[*ir.Block]bool // more than one forward edge comes in
+	loop  map[*ir.Block]bool // a back edge comes in
+
+	depth int
+}
+
+func (g *gen) line(format string, args ...interface{}) {
+	g.buf.WriteString(strings.Repeat("  ", g.depth))
+	fmt.Fprintf(g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+func (g *gen) function() {
+	g.depth = 1
+	head := fmt.Sprintf("(func $%s (export %q)", g.f.Name, g.f.Name)
+	for _, p := range g.f.Params {
+		head += fmt.Sprintf(" (param %s %s)", name(p), wasmType(p.Typ))
+	}
+	if g.f.Result != ir.Void {
+		head += fmt.Sprintf(" (result %s)", wasmType(g.f.Result))
+	}
+	g.line("%s", head)
+
+	g.depth++
+	for _, v := range g.f.Locals {
+		g.line("(local %s %s)", name(v), wasmType(v.Typ))
+	}
+	for _, b := range g.f.Blocks {
+		for _, instr := range b.Instrs {
+			if t, ok := ir.Defs(instr).(*ir.Temp); ok {
+				g.line("(local %s %s)", name(t), wasmType(t.Typ))
+			}
+		}
+	}
+
+	g.analyze()
+	g.tree(g.f.Blocks[0])
+	// Every path returned already, but the end of the body is still
+	// checked for a result
+	if g.f.Result != ir.Void {
+		g.line("unreachable")
+	}
+	g.depth--
+	g.line(")")
+}
+
+// analyze finds merge nodes and loop headers. An edge is backward if
+// it doesn't go forward in reverse postorder.
+func (g *gen) analyze() {
+	g.dom = ir.Dominators(g.f)
+	g.rpo = map[*ir.Block]int{}
+	for i, b := range g.dom.Order() {
+		g.rpo[b] = i
+	}
+	g.merge = map[*ir.Block]bool{}
+	g.loop = map[*ir.Block]bool{}
+	for _, b := range g.dom.Order() {
+		forward := 0
+		for _, p := range b.Preds {
+			if _, reachable := g.rpo[p]; !reachable {
+				continue
+			}
+			if g.rpo[p] < g.rpo[b] {
+				forward++
+			} else {
+				g.loop[b] = true
+			}
+		}
+		g.merge[b] = forward > 1
+	}
+}
+
+// tree emits x and the blocks it dominates. A loop header is wrapped
+// in a loop, continuing it is a branch to its start.
+func (g *gen) tree(x *ir.Block) {
+	var merges []*ir.Block
+	for _, c := range g.dom.Children(x) {
+		if g.merge[c] {
+			merges = append(merges, c)
+		}
+	}
+	sort.Slice(merges, func(i, j int) bool { return g.rpo[merges[i]] < g.rpo[merges[j]] })
+
+	if !g.loop[x] {
+		g.within(x, merges)
+		return
+	}
+	g.line("loop $loop%d", x.Index)
+	g.depth++
+	g.within(x, merges)
+	g.depth--
+	g.line("end")
+}
+
+// within emits x inside one block per merge node it dominates. Each
+// merge node follows the end of its block, so branching to it is
+// leaving the block. The last merge node in order gets the outermost.
+func (g *gen) within(x *ir.Block, merges []*ir.Block) {
+	if len(merges) == 0 {
+		g.block(x)
+		return
+	}
+	last := merges[len(merges)-1]
+	g.line("block $b%d", last.Index)
+	g.depth++
+	g.within(x, merges[:len(merges)-1])
+	g.depth--
+	g.line("end")
+	g.tree(last)
+}
+
+// branch goes from x to y: back to a loop, out of a block to a merge
+// node, or straight into y if x is its only way in
+func (g *gen) branch(x, y *ir.Block) {
+	switch {
+	case g.rpo[y] <= g.rpo[x]:
+		g.line("br $loop%d", y.Index)
+	case g.merge[y]:
+		g.line("br $b%d", y.Index)
+	default:
+		g.tree(y)
+	}
+}
+
+func (g *gen) block(b *ir.Block) {
+	for _, instr := range b.Instrs {
+		switch i := instr.(type) {
+		case *ir.Jump:
+			g.branch(b, i.Target)
+		case *ir.Branch:
+			if i.Then == i.Else {
+				g.branch(b, i.Then)
+				break
+			}
+			g.push(i.Cond)
+			g.line("if")
+			g.depth++
+			g.branch(b, i.Then)
+			g.depth--
+			g.line("else")
+			g.depth++
+			g.branch(b, i.Else)
+			g.depth--
+			g.line("end")
+		case *ir.Return:
+			if i.Value != nil {
+				g.push(i.Value)
+			}
+			g.line("return")
+		default:
+			g.instr(instr)
+		}
+	}
+}
+
+var intOps = map[ir.Op]string{
+	ir.Add: "i32.add", ir.Sub: "i32.sub", ir.Mul: "i32.mul", ir.Div: "i32.div_s",
+	ir.Eq: "i32.eq", ir.Ne: "i32.ne", ir.Lt: "i32.lt_s", ir.Le: "i32.le_s", ir.Gt: "i32.gt_s", ir.Ge: "i32.ge_s",
+}
+
+var doubleOps = map[ir.Op]string{
+	ir.Add: "f64.add", ir.Sub: "f64.sub", ir.Mul: "f64.mul", ir.Div: "f64.div",
+	ir.Eq: "f64.eq", ir.Ne: "f64.ne", ir.Lt: "f64.lt", ir.Le: "f64.le", ir.Gt: "f64.gt", ir.Ge: "f64.ge",
+}
+
+func (g *gen) instr(instr ir.Instr) {
+	switch i := instr.(type) {
+	case *ir.Copy:
+		g.push(i.Src)
+		g.set(i.Dst)
+	case *ir.BinOp:
+		g.push(i.X)
+		g.push(i.Y)
+		if i.X.Type() == ir.Double {
+			g.line("%s", doubleOps[i.Op])
+		} else {
+			g.line("%s", intOps[i.Op])
+		}
+		g.set(i.Dst)
+	case *ir.UnOp:
+		if i.X.Type() == ir.Double {
+			g.push(i.X)
+			g.line("f64.neg")
+		} else {
+			g.line("i32.const 0")
+			g.push(i.X)
+			g.line("i32.sub")
+		}
+		g.set(i.Dst)
+	case *ir.Convert:
+		g.push(i.X)
+		switch {
+		case i.X.Type() == i.Dst.Type():
+		case i.Dst.Type() == ir.Double:
+			g.line("f64.convert_i32_s")
+		default:
+			g.line("i32.trunc_f64_s")
+		}
+		g.set(i.Dst)
+	case *ir.Call:
+		for _, arg := range i.Args {
+			g.push(arg)
+		}
+		g.line("call $%s", i.Func)
+		switch {
+		case i.Dst != nil:
+			g.set(i.Dst)
+		case g.result(i.Func) != ir.Void:
+			g.line("drop")
+		}
+	default:
+		panic(fmt.Sprintf("Unexpected instruction: %s", instr))
+	}
+}
+
+// result returns result type of a function of the module
+func (g *gen) result(fn string) ir.Type {
+	if f := g.prog.Func(fn); f != nil {
+		return f.Result
+	}
+	if sig, ok := g.prog.Globals[fn]; ok {
+		return sig.Result
+	}
+	panic("Undefined function: " + fn)
+}
+
+func (g *gen) push(v ir.Value) {
+	switch x := v.(type) {
+	case *ir.Const:
+		g.line("%s", constant(x))
+	case *ir.Global:
+		g.line("global.get $%s", x.Name)
+	default:
+		g.line("local.get %s", name(v))
+	}
+}
+
+func (g *gen) set(v ir.Value) {
+	g.line("local.set %s", name(v))
+}
+
+// name of a local, temporaries have a prefix idents can't have
+func name(v ir.Value) string {
+	if t, ok := v.(*ir.Temp); ok {
+		return fmt.Sprintf("$_t%d", t.ID)
+	}
+	return "$" + v.String()
+}
+
+func wasmType(typ ir.Type) string {
+	if typ == ir.Double {
+		return "f64"
+	}
+	return "i32"
+}
+
+func constant(c *ir.Const) string {
+	if c.Typ != ir.Double {
+		return fmt.Sprintf("i32.const %d", c.I)
+	}
+	switch {
+	case math.IsNaN(c.F):
+		return "f64.const nan"
+	case math.IsInf(c.F, 1):
+		return "f64.const inf"
+	case math.IsInf(c.F, -1):
+		return "f64.const -inf"
+	}
+	return "f64.const " + strconv.FormatFloat(c.F, 'g', -1, 64)
+}
@@ -0,0 +1,63 @@
+package wasm
+
+import (
+	"testing"
+
+	"github.com/rabierre/compiler/ir"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	// func abs(int a) int { if (a < 0) { a = -a } return a }
+	a := &ir.Var{Name: "a", Typ: ir.Int}
+	f := &ir.Func{Name: "abs", Result: ir.Int, Params: []*ir.Var{a}}
+	entry, neg, done := f.NewBlock(), f.NewBlock(), f.NewBlock()
+	cond := f.NewTemp(ir.Int)
+	entry.Instrs = []ir.Instr{
+		&ir.BinOp{Dst: cond, Op: ir.Lt, X: a, Y: ir.IntConst(0)},
+		&ir.Branch{Cond: cond, Then: neg, Else: done},
+	}
+	neg.Instrs = []ir.Instr{
+		&ir.UnOp{Dst: a, Op: ir.Sub, X: a},
+		&ir.Jump{Target: done},
+	}
+	done.Instrs = []ir.Instr{&ir.Return{Value: a}}
+	f.ComputeCFG()
+
+	// done has two ways in, so it follows a block both branch out of
+	expect := "  (func $abs (export \"abs\") (param $a i32) (result i32)\n" +
+		"    (local $_t1 i32)\n" +
+		"    block $b2\n" +
+		"      local.get $a\n" +
+		"      i32.const 0\n" +
+		"      i32.lt_s\n" +
+		"      local.set $_t1\n" +
+		"      local.get $_t1\n" +
+		"      if\n" +
+		"        i32.const 0\n" +
+		"        local.get $a\n" +
+		"        i32.sub\n" +
+		"        local.set $a\n" +
+		"        br $b2\n" +
+		"      else\n" +
+		"        br $b2\n" +
+		"      end\n" +
+		"    end\n" +
+		"    local.get $a\n" +
+		"    return\n" +
+		"    unreachable\n" +
+		"  )\n"
+	assert.Equal(t, expect, Emit(&ir.Program{Funcs: []*ir.Func{f}}))
+}
+
+func TestCallResult(t *testing.T) {
+	// Results of calls nobody uses are dropped
+	f := &ir.Func{Name: "f", Result: ir.Void}
+	f.NewBlock().Instrs = []ir.Instr{
+		&ir.Call{Func: "g", Args: []ir.Value{ir.DoubleConst(1.5)}},
+		&ir.Return{},
+	}
+	f.ComputeCFG()
+	prog := &ir.Program{Funcs: []*ir.Func{f}, Globals: map[string]ir.Signature{"g": {Func: true, Result: ir.Int}}}
+	assert.Contains(t, Emit(prog), "    f64.const 1.5\n    call $g\n    drop\n    return\n  )\n")
+}